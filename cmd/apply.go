@@ -0,0 +1,103 @@
+package cmd
+
+import (
+	"cmp"
+	"os"
+	"time"
+
+	"github.com/metal-toolbox/vogelkop/internal/command"
+	"github.com/metal-toolbox/vogelkop/internal/lock"
+	"github.com/metal-toolbox/vogelkop/pkg/model"
+	"github.com/spf13/cobra"
+)
+
+const defaultJournalFile = "/run/vogelkop/journal.json"
+
+var applyCommand = &cobra.Command{
+	Use:   "apply",
+	Short: "Applies a storage layout",
+	Long:  "Applies a storage layout, or a layout template rendered against the discovered block devices. Partitions, arrays and filesystems that already match are left alone.",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, _ []string) {
+		ctx := command.NewContextWithLogger(cmd.Context(), logger)
+
+		var layout *model.StorageLayout
+
+		switch layoutFile, templateFile := GetString(cmd, "layout"), GetString(cmd, "template"); {
+		case layoutFile != "" && templateFile != "":
+			logger.Fatalw("--layout and --template are mutually exclusive", "err", model.InvalidArgumentError("--layout and --template"))
+		case layoutFile != "":
+			layout = readLayout(layoutFile)
+		case templateFile != "":
+			layout = renderTemplate(ctx, templateFile)
+		default:
+			logger.Fatalw("one of --layout or --template is required", "err", model.InvalidArgumentError("--layout or --template"))
+		}
+
+		for _, bd := range layout.BlockDevices {
+			setTargets(cmp.Or(bd.Selector, bd.File))
+		}
+
+		preflight(ctx, layout.Requirements())
+
+		locks, err := lock.Layout(layout)
+		if err != nil {
+			logger.Fatalw("failed to resolve block devices", "err", err, "layout", layout.Name)
+		}
+
+		lockDevices(ctx, locks...)
+		preflightHealth(ctx, locks, healthCheck(cmd))
+
+		journalFile := GetString(cmd, "journal")
+
+		journal, err := model.OpenJournal(journalFile, layout.Name)
+		if err != nil {
+			logger.Fatalw("failed to open journal", "err", err, "journal", journalFile)
+		}
+
+		if len(journal.Steps) > 0 {
+			logger.Infow("resuming from journal", "journal", journalFile, "steps", len(journal.Steps))
+		}
+
+		opts := &model.ApplyOptions{
+			Force:         GetBool(cmd, "force"),
+			AllowInUse:    GetBool(cmd, "i-know-what-im-doing"),
+			SettleTimeout: GetDuration(cmd, "settle-timeout"),
+			OnError:       GetString(cmd, "on-error"),
+			Journal:       journal,
+		}
+
+		result.Data = journal
+
+		if err := layout.Apply(ctx, opts); err != nil {
+			logger.Fatalw("failed to apply storage layout", "err", err, "layout", layout.Name, "journal", journalFile, "journal_status", journal.Status)
+		}
+	},
+}
+
+func init() {
+	applyCommand.PersistentFlags().String("layout", "", "Storage layout file")
+	applyCommand.PersistentFlags().String("template", "", "Layout template file, rendered against the discovered block devices")
+	applyCommand.PersistentFlags().Bool("force", false, "Replace partitions, arrays and filesystems that do not match the layout")
+	applyCommand.PersistentFlags().String("on-error", model.OnErrorStop, "What to do when a step fails: stop (leave the journal to resume from), rollback or continue")
+	applyCommand.PersistentFlags().String("journal", defaultJournalFile, "Journal of completed steps, resumed if an earlier run of the same layout did not finish")
+	applyCommand.PersistentFlags().Duration("settle-timeout", 30*time.Second, "Time to wait for partition device nodes to appear and udev to settle")
+	addHealthFlags(applyCommand, true)
+
+	rootCmd.AddCommand(applyCommand)
+}
+
+func readLayout(file string) *model.StorageLayout {
+	f, err := os.Open(file)
+	if err != nil {
+		logger.Fatalw("failed to open storage layout", "err", err, "layout", file)
+	}
+	defer f.Close()
+
+	layout, err := model.DecodeStorageLayout(f)
+	if err != nil {
+		logger.Fatalw("failed to parse storage layout", "err", err, "layout", file)
+	}
+
+	return layout
+}
@@ -0,0 +1,458 @@
+package cmd
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/metal-toolbox/vogelkop/pkg/model"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	defaultConfigFile = "/etc/vogelkop/config.yaml"
+	envPrefix         = "VOGELKOP_"
+)
+
+// Sources of a setting, from lowest to highest precedence.
+const (
+	sourceDefault = "default"
+	sourceConfig  = "config"
+	sourceEnv     = "env"
+	sourceFlag    = "flag"
+)
+
+// config is a parsed config file. Keys are flag names, or the names of
+// subcommands holding the settings of that subcommand:
+//
+//	lock-timeout: 5m
+//	raid:
+//	  raid-type: hardware
+//	disk:
+//	  wipe:
+//	    timeout: 10m
+type config map[string]any
+
+// setting is the effective value of a flag and where it came from.
+type setting struct {
+	Value  string `json:"value"`
+	Source string `json:"source"`
+	// From is the config key or environment variable that set the value.
+	From string `json:"from,omitempty"`
+}
+
+// configured are the settings applyConfig set flags to, so that they are
+// not mistaken for flags given on the command line afterwards.
+var configured = make(map[*pflag.Flag]*setting)
+
+var configCommand = &cobra.Command{
+	Use:   "config",
+	Short: "Works with the vogelkop configuration",
+	Long:  "Works with the vogelkop configuration",
+}
+
+var configShowCommand = &cobra.Command{
+	Use:   "show",
+	Short: "Prints the effective settings",
+	Long:  "Prints the effective value of every flag and whether it comes from its default, the config file, an environment variable or the command line. Without --command, each command shows the flags it defines and the ones set for it specifically.",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, _ []string) {
+		cfg, _, err := loadConfig()
+		if err != nil {
+			logger.Fatalw("failed to read config", "err", err)
+		}
+
+		commands := allCommands(rootCmd)
+		local := true
+
+		if path := GetString(cmd, "command"); path != "" {
+			target, _, findErr := rootCmd.Find(strings.Fields(path))
+			if findErr != nil || commandName(target) != strings.Join(strings.Fields(path), " ") {
+				logger.Fatalw("unknown command", "err", model.InvalidArgumentError("--command "+path), "command", path)
+			}
+
+			commands, local = []*cobra.Command{target}, false
+		}
+
+		settings := make(map[string]map[string]*setting)
+
+		for _, c := range commands {
+			s := resolveSettings(c, cfg, os.LookupEnv)
+
+			if local {
+				for name, value := range s {
+					if !definedOrSetAt(c, name, value) {
+						delete(s, name)
+					}
+				}
+			}
+
+			if len(s) > 0 {
+				settings[commandName(c)] = s
+			}
+		}
+
+		output(settings, func() {
+			switch format := GetString(cmd, "format"); format {
+			case "json":
+				printSettingsJSON(settings)
+			case "table":
+				printSettingsTable(settings)
+			default:
+				logger.Fatalw("invalid output format", "err", model.InvalidArgumentError("--format "+format), "format", format)
+			}
+		})
+	},
+}
+
+func init() {
+	rootCmd.PersistentFlags().String("config", defaultConfigFile, "Config file, also "+envPrefix+"CONFIG")
+
+	configShowCommand.PersistentFlags().String("command", "", "Show every setting of this command, such as \"disk wipe\"")
+	configShowCommand.PersistentFlags().String("format", "table", "Output format: table,json")
+
+	configCommand.AddCommand(configShowCommand)
+	rootCmd.AddCommand(configCommand)
+}
+
+// initConfig sets the flags of cmd that are not given on the command line
+// from environment variables and the config file. Invalid settings are
+// reported on stderr, as the logger is configured from them.
+func initConfig(cmd *cobra.Command) {
+	cfg, file, err := loadConfig()
+	if err == nil {
+		err = applyConfig(cmd, cfg, os.LookupEnv)
+	}
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "invalid configuration", file+":", err)
+		os.Exit(exitCodes[model.ErrorClassInvalidInput])
+	}
+}
+
+// loadConfig reads the config file given by --config, VOGELKOP_CONFIG or
+// the default. Only the default may be missing.
+func loadConfig() (cfg config, file string, err error) {
+	flag := rootCmd.PersistentFlags().Lookup("config")
+	file = flag.Value.String()
+
+	explicit := flag.Changed
+	if env, ok := os.LookupEnv(envPrefix + "CONFIG"); ok && !explicit {
+		file, explicit = env, true
+	}
+
+	if file == "" {
+		return
+	}
+
+	b, err := os.ReadFile(file)
+	if errors.Is(err, os.ErrNotExist) && !explicit {
+		return nil, file, nil
+	}
+
+	if err != nil {
+		return
+	}
+
+	if err = yaml.Unmarshal(b, &cfg); err != nil {
+		return nil, file, model.InvalidArgumentError(err.Error())
+	}
+
+	err = validateConfig(rootCmd, cfg, nil)
+
+	return
+}
+
+// validateConfig checks that every key of a config section is a flag or
+// subcommand of cmd, so that misspelt settings do not go unnoticed.
+func validateConfig(cmd *cobra.Command, section config, path []string) error {
+	for key, value := range section {
+		keyPath := append(slices.Clone(path), key)
+
+		if sub := subcommand(cmd, key); sub != nil {
+			if m, ok := asSection(value); ok {
+				if err := validateConfig(sub, m, keyPath); err != nil {
+					return err
+				}
+
+				continue
+			}
+		}
+
+		if slices.Contains(overrides, key) {
+			return model.InvalidArgumentError(strings.Join(keyPath, ".") + " turns off a safety check and can only be given on the command line")
+		}
+
+		if lookupFlag(cmd, key) == nil || !configurable(key) {
+			return model.InvalidArgumentError("unknown setting " + strings.Join(keyPath, "."))
+		}
+
+		if _, err := configValue(value); err != nil {
+			return model.InvalidArgumentError(strings.Join(keyPath, ".") + ": " + err.Error())
+		}
+	}
+
+	return nil
+}
+
+// applyConfig sets the flags of cmd to their effective settings.
+func applyConfig(cmd *cobra.Command, cfg config, lookupEnv func(string) (string, bool)) error {
+	for name, s := range resolveSettings(cmd, cfg, lookupEnv) {
+		if s.Source != sourceConfig && s.Source != sourceEnv {
+			continue
+		}
+
+		if err := cmd.Flags().Set(name, s.Value); err != nil {
+			return model.InvalidArgumentError(s.From + ": " + err.Error())
+		}
+
+		configured[cmd.Flags().Lookup(name)] = s
+	}
+
+	return nil
+}
+
+// resolveSettings returns the effective setting of every flag of cmd. Flags
+// given on the command line take precedence over environment variables,
+// which take precedence over the config file. Settings of a flag can be
+// given for the command that defines it and for every command below, where
+// the ones of a subcommand take precedence: disk.wipe.timeout over
+// disk.timeout, and VOGELKOP_DISK_WIPE_TIMEOUT over VOGELKOP_DISK_TIMEOUT.
+func resolveSettings(cmd *cobra.Command, cfg config, lookupEnv func(string) (string, bool)) map[string]*setting {
+	// levels are the commands from the root down to cmd.
+	var levels []*cobra.Command
+	for c := cmd; c != nil; c = c.Parent() {
+		levels = append([]*cobra.Command{c}, levels...)
+	}
+
+	settings := make(map[string]*setting)
+
+	resolve := func(f *pflag.Flag) {
+		if !configurable(f.Name) {
+			return
+		}
+
+		if s, ok := configured[f]; ok {
+			settings[f.Name] = s
+			return
+		}
+
+		s := &setting{Value: f.DefValue, Source: sourceDefault}
+		settings[f.Name] = s
+
+		if f.Changed {
+			s.Value, s.Source = f.Value.String(), sourceFlag
+			return
+		}
+
+		// keys are the config keys of the flag at each command it applies
+		// to, and values the config values found for them, if any.
+		var (
+			path   []string
+			keys   [][]string
+			values []any
+			found  []bool
+		)
+
+		section := cfg
+
+		for i, c := range levels {
+			if i > 0 {
+				path = append(path, c.Name())
+				section, _ = asSection(section[c.Name()])
+			}
+
+			if lookupFlag(c, f.Name) == f {
+				v, ok := section[f.Name]
+				keys = append(keys, append(slices.Clone(path), f.Name))
+				values, found = append(values, v), append(found, ok)
+			}
+		}
+
+		for i, key := range keys {
+			if value, err := configValue(values[i]); err == nil && found[i] {
+				s.Value, s.Source, s.From = value, sourceConfig, strings.Join(key, ".")
+			}
+		}
+
+		for _, key := range keys {
+			if v, ok := lookupEnv(envName(key)); ok {
+				s.Value, s.Source, s.From = v, sourceEnv, envName(key)
+			}
+		}
+	}
+
+	cmd.InheritedFlags().VisitAll(resolve)
+	cmd.LocalFlags().VisitAll(resolve)
+
+	return settings
+}
+
+// definedOrSetAt reports whether the flag is defined by cmd, or set in the
+// config or environment for cmd itself rather than a command above it.
+func definedOrSetAt(cmd *cobra.Command, name string, s *setting) bool {
+	if cmd.InheritedFlags().Lookup(name) == nil {
+		return true
+	}
+
+	var path []string
+	if cmd != rootCmd {
+		path = strings.Fields(commandName(cmd))
+	}
+
+	key := append(path, name)
+
+	return s.From == strings.Join(key, ".") || s.From == envName(key)
+}
+
+// overrides are the flags that turn off the checks guarding against
+// replacing data or modifying devices in use. They are only taken from the
+// command line, so that neither the config file nor a stray environment
+// variable turns the checks off for every command.
+var overrides = []string{"force", "i-know-what-im-doing", "allow-mismatched-members"}
+
+// configurable reports whether a flag can be set from the config file and
+// environment.
+func configurable(name string) bool {
+	return name != "config" && name != "help" && name != "version" && !slices.Contains(overrides, name)
+}
+
+// asSection returns v if it is a section of the config file. The YAML
+// decoder gives nested sections the type of the outermost one.
+func asSection(v any) (config, bool) {
+	switch m := v.(type) {
+	case config:
+		return m, true
+	case map[string]any:
+		return m, true
+	}
+
+	return nil, false
+}
+
+// configValue returns the flag value of a config value. Lists are encoded
+// as slice flags expect them.
+func configValue(v any) (string, error) {
+	switch v := v.(type) {
+	case config, map[string]any:
+		return "", errors.New("expected a value, not a section") // nolint:goerr113
+	case []any:
+		items := make([]string, 0, len(v))
+		for _, item := range v {
+			items = append(items, fmt.Sprint(item))
+		}
+
+		// Slice flags parse their value as a CSV record.
+		var b strings.Builder
+
+		w := csv.NewWriter(&b)
+		if err := w.Write(items); err != nil {
+			return "", err
+		}
+
+		w.Flush()
+
+		return strings.TrimSuffix(b.String(), "\n"), w.Error()
+	case nil:
+		return "", nil
+	default:
+		return fmt.Sprint(v), nil
+	}
+}
+
+// envName returns the environment variable of a flag of the command at
+// path: VOGELKOP_DISK_WIPE_TIMEOUT for --timeout of disk wipe.
+func envName(path []string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(strings.Join(path, "_"), "-", "_"))
+}
+
+func lookupFlag(cmd *cobra.Command, name string) *pflag.Flag {
+	if f := cmd.LocalFlags().Lookup(name); f != nil {
+		return f
+	}
+
+	return cmd.InheritedFlags().Lookup(name)
+}
+
+func subcommand(cmd *cobra.Command, name string) *cobra.Command {
+	for _, c := range cmd.Commands() {
+		if c.Name() == name {
+			return c
+		}
+	}
+
+	return nil
+}
+
+// commandName returns the command path without the name of the binary.
+func commandName(cmd *cobra.Command) string {
+	if cmd == rootCmd {
+		return rootCmd.Name()
+	}
+
+	return strings.TrimPrefix(cmd.CommandPath(), rootCmd.Name()+" ")
+}
+
+// allCommands returns cmd and the commands below it, except help.
+func allCommands(cmd *cobra.Command) []*cobra.Command {
+	commands := []*cobra.Command{cmd}
+
+	for _, c := range cmd.Commands() {
+		if c.Name() != "help" && c.Name() != "completion" {
+			commands = append(commands, allCommands(c)...)
+		}
+	}
+
+	return commands
+}
+
+func printSettingsJSON(settings map[string]map[string]*setting) {
+	out, err := json.MarshalIndent(settings, "", "  ")
+	if err != nil {
+		logger.Fatalw("failed to marshal settings", "err", err)
+	}
+
+	fmt.Println(string(out))
+}
+
+func printSettingsTable(settings map[string]map[string]*setting) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+
+	fmt.Fprintln(w, "COMMAND\tFLAG\tVALUE\tSOURCE")
+
+	commands := make([]string, 0, len(settings))
+	for c := range settings {
+		commands = append(commands, c)
+	}
+
+	slices.Sort(commands)
+
+	for _, c := range commands {
+		names := make([]string, 0, len(settings[c]))
+		for name := range settings[c] {
+			names = append(names, name)
+		}
+
+		slices.Sort(names)
+
+		for _, name := range names {
+			s := settings[c][name]
+
+			source := s.Source
+			if s.From != "" {
+				source += " (" + s.From + ")"
+			}
+
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", c, name, s.Value, source)
+		}
+	}
+
+	w.Flush()
+}
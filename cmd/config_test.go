@@ -0,0 +1,109 @@
+package cmd
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/metal-toolbox/vogelkop/pkg/model"
+	"gopkg.in/yaml.v3"
+)
+
+const testConfig = `
+lock-timeout: 1m
+log-level: warn
+raid:
+  raid-type: hardware
+disk:
+  timeout: 5m
+  wipe:
+    timeout: 10m
+partition:
+  format:
+    format: xfs
+    options: [-K, "-E stride=1,stripe_width=2"]
+`
+
+func TestResolveSettings(t *testing.T) {
+	var cfg config
+	if err := yaml.Unmarshal([]byte(testConfig), &cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := validateConfig(rootCmd, cfg, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	env := map[string]string{
+		"VOGELKOP_LOCK_TIMEOUT":      "2m",
+		"VOGELKOP_DISK_WIPE_OUTPUT":  "/tmp/wipe.json",
+		"VOGELKOP_OUTPUT":            "/tmp/other.json",
+		"VOGELKOP_DISK_LOG_LEVEL":    "debug",
+		"VOGELKOP_RAID_CREATE_FORCE": "true",
+	}
+	lookupEnv := func(name string) (string, bool) {
+		v, ok := env[name]
+		return v, ok
+	}
+
+	cases := []struct {
+		command []string
+		flag    string
+		want    setting
+	}{
+		{[]string{"disk", "wipe"}, "timeout", setting{"10m", sourceConfig, "disk.wipe.timeout"}},
+		{[]string{"disk", "list"}, "timeout", setting{"5m", sourceConfig, "disk.timeout"}},
+		{[]string{"disk", "wipe"}, "output", setting{"/tmp/wipe.json", sourceEnv, "VOGELKOP_DISK_WIPE_OUTPUT"}},
+		// output is not a flag of the root command.
+		{[]string{"export"}, "output", setting{"", sourceDefault, ""}},
+		{[]string{"disk", "wipe"}, "lock-timeout", setting{"2m", sourceEnv, "VOGELKOP_LOCK_TIMEOUT"}},
+		{[]string{"disk", "wipe"}, "log-level", setting{"debug", sourceEnv, "VOGELKOP_DISK_LOG_LEVEL"}},
+		{[]string{"raid", "delete"}, "log-level", setting{"warn", sourceConfig, "log-level"}},
+		{[]string{"raid", "create"}, "raid-type", setting{"hardware", sourceConfig, "raid.raid-type"}},
+		{[]string{"raid", "delete"}, "name", setting{"unknown", sourceDefault, ""}},
+		{[]string{"partition", "format"}, "format", setting{"xfs", sourceConfig, "partition.format.format"}},
+		{[]string{"partition", "format"}, "options", setting{`-K,"-E stride=1,stripe_width=2"`, sourceConfig, "partition.format.options"}},
+	}
+
+	for _, c := range cases {
+		cmd, _, err := rootCmd.Find(c.command)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		got := resolveSettings(cmd, cfg, lookupEnv)[c.flag]
+		if got == nil || *got != c.want {
+			t.Errorf("%v --%s: expected %+v, got %+v", c.command, c.flag, c.want, got)
+		}
+	}
+
+	// Overrides of safety checks are only taken from the command line.
+	cmd, _, err := rootCmd.Find([]string{"raid", "create"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := resolveSettings(cmd, cfg, lookupEnv)["force"]; got != nil {
+		t.Errorf("raid create --force: expected no setting, got %+v", got)
+	}
+}
+
+func TestValidateConfig(t *testing.T) {
+	for _, invalid := range []string{
+		"raid-typo: hardware",
+		"output: /tmp/result.json",
+		"raid:\n  create:\n    device: [sda]",
+		"config: /etc/other.yaml",
+		"log-level:\n  debug: true",
+		"i-know-what-im-doing: true",
+		"raid:\n  create:\n    force: true",
+	} {
+		var cfg config
+		if err := yaml.Unmarshal([]byte(invalid), &cfg); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := validateConfig(rootCmd, cfg, nil); !errors.Is(err, model.ErrInvalidArgument) {
+			t.Errorf("%q: expected invalid argument, got %v", invalid, err)
+		}
+	}
+}
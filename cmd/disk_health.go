@@ -0,0 +1,212 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/metal-toolbox/vogelkop/internal/command"
+	"github.com/metal-toolbox/vogelkop/internal/lock"
+	"github.com/metal-toolbox/vogelkop/pkg/model"
+	"github.com/spf13/cobra"
+)
+
+var diskHealthCommand = &cobra.Command{
+	Use:   "health [/dev/disk...]",
+	Short: "Checks the SMART and NVMe health of disks",
+	Long:  "Reads the SMART attributes or NVMe SMART log of disks given as device files or selectors, or of every disk, and fails with the device_unhealthy code if any is over the thresholds. Disks that report no health data, such as virtual disks, pass.",
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := command.NewContextWithLogger(cmd.Context(), logger)
+
+		disks := make([]string, 0, len(args))
+
+		for _, arg := range args {
+			file, err := model.ResolveSelector(arg)
+			if err != nil {
+				logger.Fatalw("failed to resolve disk", "err", err, "device", arg)
+			}
+
+			disks = append(disks, file)
+		}
+
+		if len(args) == 0 {
+			blockDevices, err := model.DiscoverBlockDevices()
+			if err != nil {
+				logger.Fatalw("failed to discover block devices", "err", err)
+			}
+
+			for _, bd := range blockDevices {
+				if bd.MediaType != "virtual" {
+					disks = append(disks, bd.File)
+				}
+			}
+		}
+
+		setTargets(disks...)
+
+		results, err := checkHealth(ctx, disks, healthThresholds(cmd))
+
+		output(results, func() {
+			switch format := GetString(cmd, "format"); format {
+			case "json":
+				printDiskHealthJSON(results)
+			case "table":
+				printDiskHealthTable(results)
+			default:
+				logger.Fatalw("invalid output format", "err", model.InvalidArgumentError("--format "+format), "format", format)
+			}
+		})
+
+		if err != nil {
+			logger.Fatalw("disks failed the health check", "err", err)
+		}
+	},
+}
+
+func init() {
+	diskHealthCommand.PersistentFlags().String("format", "table", "Output format: table,json")
+	addHealthFlags(diskHealthCommand, false)
+
+	diskCommand.AddCommand(diskHealthCommand)
+}
+
+// addHealthFlags adds the health thresholds to cmd and, for commands that
+// change disks, --check-health to check them first.
+func addHealthFlags(cmd *cobra.Command, optional bool) {
+	d := model.DefaultHealthThresholds()
+
+	if optional {
+		cmd.PersistentFlags().Bool("check-health", false, "Refuse disks whose SMART or NVMe health is over the thresholds, see disk health")
+	}
+
+	cmd.PersistentFlags().Uint("max-reallocated-sectors", uint(d.MaxReallocatedSectors), "Most reallocated sectors a healthy disk may have")
+	cmd.PersistentFlags().Uint("max-pending-sectors", uint(d.MaxPendingSectors), "Most sectors pending reallocation a healthy disk may have")
+	cmd.PersistentFlags().Uint("max-media-errors", uint(d.MaxMediaErrors), "Most uncorrectable media errors a healthy disk may have")
+	cmd.PersistentFlags().Uint("max-percentage-used", uint(d.MaxPercentageUsed), "Most of its rated endurance a healthy flash disk may have used, in percent")
+}
+
+func healthThresholds(cmd *cobra.Command) model.HealthThresholds {
+	return model.HealthThresholds{
+		MaxReallocatedSectors: uint64(GetUint(cmd, "max-reallocated-sectors")),
+		MaxPendingSectors:     uint64(GetUint(cmd, "max-pending-sectors")),
+		MaxMediaErrors:        uint64(GetUint(cmd, "max-media-errors")),
+		MaxPercentageUsed:     uint64(GetUint(cmd, "max-percentage-used")),
+	}
+}
+
+// checkHealth reads and evaluates the health of disks. The error joins the
+// errors of every disk that is unhealthy or could not be read.
+func checkHealth(ctx context.Context, disks []string, thresholds model.HealthThresholds) (results []*model.DiskHealth, err error) {
+	var errs []error
+
+	for _, disk := range disks {
+		h, readErr := model.ReadDiskHealth(ctx, disk)
+		if readErr != nil {
+			logger.Warnw("failed to read disk health", "err", readErr, "device", disk)
+			errs = append(errs, readErr)
+
+			continue
+		}
+
+		h.Evaluate(thresholds)
+
+		if !h.Healthy() {
+			logger.Warnw("disk is unhealthy", "device", disk, "problems", h.Problems)
+		}
+
+		results = append(results, h)
+		errs = append(errs, h.Err())
+	}
+
+	if results == nil {
+		results = []*model.DiskHealth{}
+	}
+
+	err = errors.Join(errs...)
+
+	return
+}
+
+// healthCheck returns the thresholds to check disks against before changing
+// them, or nil unless --check-health is given.
+func healthCheck(cmd *cobra.Command) *model.HealthThresholds {
+	if !GetBool(cmd, "check-health") {
+		return nil
+	}
+
+	t := healthThresholds(cmd)
+
+	return &t
+}
+
+// preflightHealth refuses to change disks that fail the health check, if
+// there are thresholds to check against. Disks are given by their lock
+// names, see the lock package; md arrays and RAID controllers are not disks
+// and skipped.
+func preflightHealth(ctx context.Context, names []string, thresholds *model.HealthThresholds) {
+	if thresholds == nil {
+		return
+	}
+
+	var disks []string
+
+	for _, name := range names {
+		switch {
+		case name == lock.HardwareRaid:
+			logger.Warnw("the health of disks behind a RAID controller is not checked")
+		case strings.HasPrefix(name, "md/"), filepath.IsAbs(name):
+		default:
+			disks = append(disks, filepath.Join("/dev", name))
+		}
+	}
+
+	if _, err := checkHealth(ctx, disks, *thresholds); err != nil {
+		logger.Fatalw("refusing to use disks that failed the health check", "err", err)
+	}
+}
+
+func printDiskHealthJSON(results []*model.DiskHealth) {
+	out, err := json.MarshalIndent(results, "", "  ")
+	if err != nil {
+		logger.Fatalw("failed to marshal disk health", "err", err)
+	}
+
+	fmt.Println(string(out))
+}
+
+func printDiskHealthTable(results []*model.DiskHealth) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+
+	fmt.Fprintln(w, "DEVICE\tPROTOCOL\tSTATUS\tREALLOCATED\tPENDING\tMEDIA ERRORS\tUSED%\tTEMP\tPROBLEMS")
+
+	value := func(v *uint64) string {
+		if v == nil {
+			return "-"
+		}
+
+		return strconv.FormatUint(*v, 10)
+	}
+
+	for _, h := range results {
+		status := "ok"
+
+		switch {
+		case !h.Healthy():
+			status = "unhealthy"
+		case !h.Supported:
+			status = "unsupported"
+		}
+
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n", h.Device, h.Protocol, status,
+			value(h.ReallocatedSectors), value(h.PendingSectors), value(h.MediaErrors), value(h.PercentageUsed), value(h.Temperature),
+			strings.Join(h.Problems, "; "))
+	}
+
+	w.Flush()
+}
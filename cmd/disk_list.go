@@ -0,0 +1,85 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/metal-toolbox/vogelkop/internal/command"
+	"github.com/metal-toolbox/vogelkop/pkg/model"
+	"github.com/spf13/cobra"
+)
+
+var diskListCommand = &cobra.Command{
+	Use:   "list",
+	Short: "Lists block devices",
+	Long:  "Lists block devices with their partitions, filesystems and holders as discovered from sysfs, udev and ironlib",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, _ []string) {
+		ctx := command.NewContextWithLogger(cmd.Context(), logger)
+
+		blockDevices, err := model.DiscoverBlockDevices()
+		if err != nil {
+			logger.Fatalw("failed to discover block devices", "err", err)
+		}
+
+		if GetBool(cmd, "ironlib") {
+			if err := model.EnrichBlockDevices(ctx, blockDevices); err != nil {
+				logger.Warnw("failed to collect ironlib inventory, showing sysfs data only", "err", err)
+			}
+		}
+
+		output(blockDevices, func() {
+			switch format := GetString(cmd, "format"); format {
+			case "json":
+				printBlockDevicesJSON(blockDevices)
+			case "table":
+				printBlockDevicesTable(blockDevices)
+			default:
+				logger.Fatalw("invalid output format", "err", model.InvalidArgumentError("--format "+format), "format", format)
+			}
+		})
+	},
+}
+
+func init() {
+	diskListCommand.PersistentFlags().String("format", "table", "Output format: table,json")
+	diskListCommand.PersistentFlags().Bool("ironlib", true, "Complete vendor, model, serial and firmware from the ironlib inventory")
+
+	diskCommand.AddCommand(diskListCommand)
+}
+
+func printBlockDevicesJSON(blockDevices []*model.BlockDevice) {
+	out, err := json.MarshalIndent(blockDevices, "", "  ")
+	if err != nil {
+		logger.Fatalw("failed to marshal block devices", "err", err)
+	}
+
+	fmt.Println(string(out))
+}
+
+func printBlockDevicesTable(blockDevices []*model.BlockDevice) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+
+	fmt.Fprintln(w, "DEVICE\tSIZE\tTYPE\tTRAN\tSECTOR\tMODEL/NAME\tSERIAL\tWWN\tTABLE\tFS\tHOLDERS")
+
+	for _, bd := range blockDevices {
+		sectors := strconv.FormatUint(bd.LogicalSectorSize, 10) + "/" + strconv.FormatUint(bd.PhysicalSectorSize, 10)
+
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			bd.File, model.FormatSize(bd.Size), bd.MediaType, bd.Transport, sectors,
+			bd.Model, bd.Serial, bd.WWN, bd.PartitionTableType, bd.FileSystem, strings.Join(bd.Holders, ","))
+
+		for _, p := range bd.Partitions {
+			fmt.Fprintf(w, "  %s\t%s\tpart\t\t\t%s\t\t\t\t%s\t%s\n",
+				p.BlockDevice.File, model.FormatSize(p.BlockDevice.Size), p.Name, p.FileSystem, strings.Join(p.BlockDevice.Holders, ","))
+		}
+	}
+
+	if err := w.Flush(); err != nil {
+		logger.Fatalw("failed to write block device table", "err", err)
+	}
+}
@@ -1,8 +1,10 @@
 package cmd
 
 import (
-	"context"
+	"time"
 
+	"github.com/metal-toolbox/vogelkop/internal/command"
+	"github.com/metal-toolbox/vogelkop/internal/lock"
 	"github.com/metal-toolbox/vogelkop/pkg/model"
 	"github.com/spf13/cobra"
 )
@@ -12,34 +14,83 @@ var diskPartitionCommand = &cobra.Command{
 	Short: "Partitions a disk with a GPT table",
 	Args:  cobra.NoArgs,
 	Run: func(cmd *cobra.Command, _ []string) {
-		ctx := context.Background()
+		ctx := command.NewContextWithLogger(cmd.Context(), logger)
+		preflight(ctx, model.PartitionRequirements())
 
 		partitions := GetStringSlice(cmd, "partitions")
 		device := GetString(cmd, "device")
+		setTargets(device)
+		lockDevices(ctx, lock.Device(device))
+		force := GetBool(cmd, "force")
+		guarded := false
+		changed := false
+
+		var bd *model.BlockDevice
+		var positions []uint
 
 		for _, partition := range partitions {
-			bd, err := model.NewBlockDevice(device)
+			var err error
+
+			bd, err = model.NewBlockDevice(device)
 			if err != nil {
 				logger.Fatalw("Failed to create BlockDevice", "err", err, "device", device)
 			}
 
 			p, err := model.NewPartitionFromDelimited(partition, bd)
 			if err != nil {
-				logger.Fatalw("Failed to parse delimited partition data", "delimited_string", partition)
+				logger.Fatalw("Failed to parse delimited partition data", "err", err, "delimited_string", partition)
+			}
+
+			positions = append(positions, p.Position)
+
+			exists, differences, err := p.Check(ctx)
+			if err != nil {
+				logger.Fatalw("failed to read partition table", "err", err, "partition", p)
+			}
+
+			if exists && len(differences) == 0 {
+				logger.Infow("partition already matches, nothing to do", "partition", p)
+				continue
+			}
+
+			if !guarded {
+				checkNotInUse(ctx, GetBool(cmd, "i-know-what-im-doing"), []*model.BlockDevice{bd}, nil)
+				guarded = true
 			}
 
-			if out, err := p.Create(ctx); err != nil {
+			out, created, err := p.Ensure(ctx, force)
+			if err != nil {
 				logger.Fatalw("failed to create partition", "err", err, "partition", p, "output", out)
 			}
+
+			changed = changed || created
+		}
+
+		result.Data = changeResult{Changed: changed}
+
+		if bd == nil {
+			return
+		}
+
+		if changed {
+			if err := bd.Rescan(ctx); err != nil {
+				logger.Fatalw("failed to re-read partition table", "err", err, "device", bd.File)
+			}
+		}
+
+		if err := bd.WaitForPartitions(ctx, positions, GetDuration(cmd, "settle-timeout")); err != nil {
+			logger.Fatalw("partitions did not become available", "err", err, "device", bd.File)
 		}
 	},
 }
 
 func init() {
-	diskPartitionCommand.PersistentFlags().String("device", "/dev/sda", "Device to be partitioned")
+	diskPartitionCommand.PersistentFlags().String("device", "/dev/sda", "Device file or selector (wwn:, serial:, by-id:, by-path:, model:&size:) to be partitioned")
 	markFlagAsRequired(diskPartitionCommand, "device")
 
 	diskPartitionCommand.PersistentFlags().StringSlice("partitions", []string{}, "Partition Definitions Name:Position:Size:Type")
+	diskPartitionCommand.PersistentFlags().Duration("settle-timeout", 30*time.Second, "Time to wait for partition device nodes to appear and udev to settle")
+	diskPartitionCommand.PersistentFlags().Bool("force", false, "Recreate existing partitions that do not match their definition")
 
 	diskCommand.AddCommand(diskPartitionCommand)
 
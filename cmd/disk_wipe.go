@@ -1,199 +1,57 @@
 package cmd
 
 import (
-	"cmp"
 	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"os"
-	"strings"
-	"sync"
 	"time"
 
-	common "github.com/metal-toolbox/bmc-common"
 	"github.com/metal-toolbox/ironlib"
 	"github.com/metal-toolbox/ironlib/actions"
-	"github.com/metal-toolbox/ironlib/utils"
-	"github.com/sirupsen/logrus"
+	"github.com/metal-toolbox/vogelkop/internal/command"
+	"github.com/metal-toolbox/vogelkop/internal/lock"
+	"github.com/metal-toolbox/vogelkop/internal/logging"
+	"github.com/metal-toolbox/vogelkop/pkg/model"
 	"github.com/spf13/cobra"
+	"go.uber.org/zap/zapcore"
 )
 
 var (
-	ErrDriveNotExist      = errors.New("drive does not exist")
-	ErrDriveWiperNotFound = errors.New("failed to find appropriate drive wiper")
+	ErrDriveNotExist      = model.ErrDriveNotExist
+	ErrDriveWiperNotFound = model.ErrDriveWiperNotFound
 )
 
-type wiperInfo struct {
-	Disk        string `json:"disk"`
-	Action      string `json:"action"`
-	Method      string `json:"method"`
-	ElapsedTime int    `json:"elapsed_time"`
-	Result      string `json:"result"`
-}
+// wiperInfo is the result file format of disk wipe.
+type wiperInfo = model.WipeResult
 
-func wipeDisks(ctx context.Context, drivesName []string, collector actions.DeviceManager, wipeResults []*wiperInfo, logger *logrus.Logger, logResultFilename string, verbose bool) {
-	inventory, err := collector.GetInventory(ctx, actions.WithDynamicCollection())
-	if err != nil {
-		logger.WithError(err).Fatal("exiting")
+func wipeDisks(ctx context.Context, drivesName []string, collector actions.DeviceManager, wipeResults []*wiperInfo, logResultFilename string, verbose bool) {
+	results, err := model.WipeDrives(ctx, drivesName, collector, verbose)
+	if results == nil && err != nil {
+		logger.Fatalw("failed to collect inventory", "err", err)
 	}
 
-	var hasFailure bool
-	var wg sync.WaitGroup
-	wg.Add(len(drivesName))
-	for _, driveName := range drivesName {
-		go func() {
-			defer wg.Done()
-			l := logger.WithField("drive", driveName)
-			wi := &wiperInfo{
-				Disk:   driveName,
-				Result: "success",
-			}
-			startTime := time.Now()
-			if err = wipeOneDisk(ctx, inventory, wi, verbose); err != nil {
-				hasFailure = true
-				wi.Result = "failure"
-				l.Errorf("failed to wipe disk %v: error %v", driveName, err)
-			} else {
-				l.Infof("wipe drive %v done", driveName)
-			}
-			wi.ElapsedTime = int(time.Since(startTime).Round(time.Second).Seconds())
-			wipeResults = append(wipeResults, wi)
-		}()
-	}
-	wg.Wait()
-	wipeResultsJSON, marshalErr := json.MarshalIndent(wipeResults, "", "  ") // pretty printing
-	if marshalErr != nil {
-		logger.Fatalf("Error marshaling %v to JSON: %v", wipeResults, marshalErr)
-	}
+	wipeResults = append(wipeResults, results...)
+	result.Data = wipeResults
+	wipeErr := model.WipeError(wipeResults)
 
 	if logResultFilename != "" {
-		file, err := os.Create(logResultFilename)
+		wipeResultsJSON, err := json.MarshalIndent(wipeResults, "", "  ") // pretty printing
 		if err != nil {
-			logger.Fatalf("failed to create %v: %v", logResultFilename, err)
-		}
-		defer file.Close()
-
-		if _, err := file.Write(wipeResultsJSON); err != nil {
-			logger.Fatalf("failed to write result to %v: %v", logResultFilename, err)
-		}
-
-		if hasFailure {
-			logger.Fatal(string(wipeResultsJSON))
-		}
-	}
-	logger.Info(string(wipeResultsJSON))
-}
-
-// nolint:gocyclo // easier to read in one big function I think
-func wipeOneDisk(ctx context.Context, inventory *common.Device, wi *wiperInfo, verbose bool) error {
-	var drive *common.Drive
-	for _, d := range inventory.Drives {
-		if d.LogicalName == wi.Disk {
-			drive = d
-			break
-		}
-	}
-
-	if drive == nil {
-		return ErrDriveNotExist
-	}
-
-	// Pick the most appropriate wipe based on the disk type and/or features supported
-	var wiper actions.DriveWiper
-	switch drive.Protocol {
-	case "nvme":
-		var ber bool
-		var cer bool
-		var cese bool
-		for _, cap := range drive.Capabilities {
-			switch cap.Name {
-			case "ber":
-				ber = cap.Enabled
-			case "cer":
-				cer = cap.Enabled
-			case "cese":
-				cese = cap.Enabled
-			}
-		}
-		switch {
-		case cer:
-			wi.Method = "sanitize"
-			wi.Action = "CryptoErase"
-		case ber:
-			wi.Method = "sanitize"
-			wi.Action = "BlockErase"
-		case cese:
-			wi.Method = "format"
-			wi.Action = "CryptographicErase"
-		default:
-			wi.Method = "format"
-			wi.Action = "UserDataErase"
-		}
-		wiper = utils.NewNvmeCmd(verbose)
-	case "sata", "sas":
-		// Lets figure out the drive capabilities in an easier format
-		var sanitize bool
-		var esee bool
-		var trim bool
-		var eseu bool
-		var bee bool
-		var cse bool
-		for _, cap := range drive.Capabilities {
-			switch {
-			case cap.Description == "encryption supports enhanced erase":
-				esee = cap.Enabled
-			case cap.Description == "SANITIZE feature":
-				sanitize = cap.Enabled
-			case strings.HasPrefix(cap.Description, "Data Set Management TRIM supported"):
-				trim = cap.Enabled
-			case cap.Description == "BLOCK ERASE EXT":
-				bee = cap.Enabled
-			case cap.Description == "CRYPTO SCRAMBLE EXT":
-				cse = cap.Enabled
-			case strings.HasPrefix(cap.Description, "erase time:"):
-				eseu = strings.Contains(cap.Description, "enhanced")
-			}
+			logger.Fatalw("failed to marshal wipe results", "err", err)
 		}
 
-		switch {
-		case sanitize || esee:
-			// It is better if ironlib util can export an API to provide cap info, or
-			// WipeDrive can return methods/actions it uses:
-			// https://github.com/metal-toolbox/ironlib/blob/main/utils/hdparm.go#L217-L237
-			switch {
-			case sanitize && cse:
-				wi.Method = "sanitize"
-				wi.Action = "sanitize-crypto-scramble"
-			case sanitize && bee:
-				wi.Method = "sanitize"
-				wi.Action = "sanitize-block-erase"
-			case esee && eseu:
-				wi.Method = "security-erase-enhanced"
-			}
-			// Drive supports Sanitize or Enhanced Erase, so we use hdparm
-			wiper = utils.NewHdparmCmd(verbose)
-		case trim:
-			// Drive supports TRIM, so we use blkdiscard
-			wi.Method = "blkdiscard"
-			wiper = utils.NewBlkdiscardCmd(verbose)
-		default:
-			// Drive does not support any preferred wipe method so we fall back to filling it up with zeros
-			wi.Method = "fillzero"
-			wiper = utils.NewFillZeroCmd(verbose)
+		if err := os.WriteFile(logResultFilename, wipeResultsJSON, 0o600); err != nil {
+			logger.Fatalw("failed to write wipe results", "err", err, "output", logResultFilename)
 		}
 	}
 
-	if wiper == nil {
-		return fmt.Errorf("capabilities: %v, protocol: %v: %w", drive.Capabilities, drive.Protocol, ErrDriveWiperNotFound)
+	if wipeErr != nil {
+		logger.Fatalw("failed to wipe drives", "err", wipeErr, "results", wipeResults)
 	}
 
-	wiperLogger := logrus.New()
-	wiperLogger.SetLevel(logrus.DebugLevel)
-	if err := wiper.WipeDrive(ctx, wiperLogger, drive); err != nil {
-		return fmt.Errorf("wiper.WipeDrive() failed to wipe drive: %w", err)
-	}
-	return nil
+	logger.Infow("wiped drives", "results", wipeResults)
 }
 
 // nolint:gocyclo // easier to read in one big function I think
@@ -201,6 +59,7 @@ func init() {
 	cmd := &cobra.Command{
 		Use:   "wipe /dev/disk,/dev/diska,...",
 		Short: "Wipes all data from disks(comma-separated)",
+		Long:  "Wipes all data from disks given as device files or selectors (wwn:, serial:, by-id:, by-path:, model:&size:)",
 		Args: func(_ *cobra.Command, args []string) error {
 			if len(args) < 1 {
 				return errors.New("requires at least one arg") // nolint:goerr113
@@ -211,67 +70,79 @@ func init() {
 		Run: func(cmd *cobra.Command, args []string) {
 			timeout, err := cmd.Flags().GetDuration("timeout")
 			if err != nil {
-				logger.With("error", err).Fatal("--timeout argument is invalid")
+				logger.Fatalw("--timeout argument is invalid", "err", model.InvalidArgumentError(fmt.Sprint(err)))
 			}
 
 			if timeout <= 0 {
-				logger.With("error", err).Fatal("--timeout should be positive")
-			}
-
-			verbose, err := cmd.Flags().GetBool("debug")
-			if err != nil {
-				logger.With("error", err).Fatal("--debug argument is invalid")
+				logger.Fatalw("--timeout should be positive", "err", model.InvalidArgumentError(timeout.String()))
 			}
 
 			logResultFilename, err := cmd.Flags().GetString("output")
 			if err != nil {
-				logger.With("error", err).Fatal("--output argument is invalid")
+				logger.Fatalw("--output argument is invalid", "err", model.InvalidArgumentError(fmt.Sprint(err)))
 			}
 
-			logger := logrus.New()
-			logger.Formatter = new(logrus.TextFormatter)
-			if verbose {
-				logger.SetLevel(logrus.TraceLevel)
-			}
+			// ironlib prints the output of the wipe tools when verbose.
+			verbose := logger.Level().Enabled(zapcore.DebugLevel)
 
-			ctx := cmp.Or(cmd.Context(), context.Background())
+			ctx := command.NewContextWithLogger(cmd.Context(), logger)
 			ctx, cancel := context.WithTimeout(ctx, timeout)
 			defer cancel()
 
+			setTargets(args...)
+
 			var wipeResults []*wiperInfo
 			var drivesName []string
 			drivesNameMap := make(map[string]struct{})
 			// is regex better?
 			for _, driveName := range args {
+				resolved, resolveErr := model.ResolveSelector(driveName)
+				if resolveErr != nil && model.IsSelector(driveName) {
+					// Ambiguous or unmatched selectors keep their reason.
+					logger.Warnw("failed to resolve drive selector", "err", resolveErr, "device", driveName)
+					wipeResults = append(wipeResults, model.FailedWipeResult(driveName, resolveErr))
+					continue
+				} else if resolveErr == nil {
+					driveName = resolved
+				}
+
 				_, err = os.Stat(driveName)
 				if err != nil {
 					// should we ignore errors and let inventory collector to handle errors like permission, I/O, os errors
 					// or handle file not exist error here is good enough?
-					logger.Warnf("invalid drive %v: %v", driveName, err)
-					wipeResults = append(wipeResults, &wiperInfo{
-						Disk:   driveName,
-						Result: "failure",
-					})
+					logger.Warnw("invalid drive", "err", err, "device", driveName)
+					wipeResults = append(wipeResults, model.FailedWipeResult(driveName, model.DeviceNotFoundError(driveName)))
 					continue
 				}
 				if _, exists := drivesNameMap[driveName]; exists {
-					logger.Warnf("duplicate drive input %v", driveName)
-					wipeResults = append(wipeResults, &wiperInfo{
-						Disk:   driveName,
-						Result: "failure",
-					})
+					logger.Warnw("duplicate drive", "device", driveName)
+					wipeResults = append(wipeResults, model.FailedWipeResult(driveName, model.DuplicateDeviceError(driveName)))
 					continue
 				}
 				drivesNameMap[driveName] = struct{}{}
 				drivesName = append(drivesName, driveName)
 			}
 
-			collector, err := ironlib.New(logger)
+			var locks []string
+			for _, driveName := range drivesName {
+				locks = lock.Append(locks, lock.Device(driveName))
+			}
+
+			lockDevices(ctx, locks...)
+
+			blockDevices, err := model.NewBlockDevices(drivesName...)
+			if err != nil {
+				logger.Fatalw("failed to resolve drives", "err", err)
+			}
+
+			checkNotInUse(ctx, GetBool(cmd, "i-know-what-im-doing"), blockDevices, nil)
+
+			collector, err := ironlib.New(logging.Logrus(logger))
 			if err != nil {
-				logger.WithError(err).Fatal("exiting")
+				logger.Fatalw("failed to set up ironlib", "err", err)
 			}
 
-			wipeDisks(ctx, drivesName, collector, wipeResults, logger, logResultFilename, verbose)
+			wipeDisks(ctx, drivesName, collector, wipeResults, logResultFilename, verbose)
 		},
 	}
 
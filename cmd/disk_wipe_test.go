@@ -18,7 +18,7 @@ import (
 	"github.com/metal-toolbox/ironlib/actions"
 	ilmodel "github.com/metal-toolbox/ironlib/model"
 	"github.com/metal-toolbox/vogelkop/internal/command"
-	"github.com/sirupsen/logrus"
+	"go.uber.org/zap/zaptest"
 )
 
 const testDiskSize = 128
@@ -284,9 +284,9 @@ func TestWiping(t *testing.T) {
 				}
 			}
 
-			logger := logrus.New()
+			logger = zaptest.NewLogger(t).Sugar()
 			var wipeResults []*wiperInfo
-			wipeDisks(ctx, wipeDrives, collector, wipeResults, logger, "", true)
+			wipeDisks(command.NewContextWithLogger(ctx, logger), wipeDrives, collector, wipeResults, "", true)
 			if err := verifyWipeSuccess(wipedDrives, unWipedDrives, fileContent); err != nil {
 				t.Errorf("failed to wipe drives: %v", err)
 			}
@@ -0,0 +1,172 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/bmc-toolbox/common"
+	"github.com/metal-toolbox/vogelkop/internal/command"
+	"github.com/metal-toolbox/vogelkop/pkg/model"
+	"github.com/spf13/cobra"
+)
+
+// doctorCheck is a checked requirement with the commands that need it.
+type doctorCheck struct {
+	model.RequirementCheck
+	Commands []string `json:"commands"`
+}
+
+var doctorCommand = &cobra.Command{
+	Use:   "doctor",
+	Short: "Checks the tools and kernel modules vogelkop needs",
+	Long:  "Checks that the external tools and kernel modules each command needs are available, and reports their versions. Optional requirements are only needed for some devices or layouts. Exits with the tool_missing code if anything else is missing.",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, _ []string) {
+		ctx := command.NewContextWithLogger(cmd.Context(), logger)
+
+		var layout *model.StorageLayout
+		if layoutFile := GetString(cmd, "layout"); layoutFile != "" {
+			layout = readLayout(layoutFile)
+		}
+
+		checks, err := doctor(ctx, layout)
+
+		output(checks, func() {
+			switch format := GetString(cmd, "format"); format {
+			case "json":
+				printDoctorChecksJSON(checks)
+			case "table":
+				printDoctorChecksTable(checks)
+			default:
+				logger.Fatalw("invalid output format", "err", model.InvalidArgumentError("--format "+format), "format", format)
+			}
+		})
+
+		if err != nil {
+			logger.Fatalw("doctor found missing requirements", "err", err)
+		}
+	},
+}
+
+func init() {
+	doctorCommand.PersistentFlags().String("format", "table", "Output format: table,json")
+	doctorCommand.PersistentFlags().String("layout", "", "Check what applying this storage layout needs instead of every kind of layout")
+
+	rootCmd.AddCommand(doctorCommand)
+}
+
+// commandRequirements returns the requirements of every command that
+// changes the system. Without a layout, apply and rollback may need
+// everything a layout can use, but only partitioning and ext4 are required.
+func commandRequirements(layout *model.StorageLayout) map[string]model.Requirements {
+	linuxRaid := model.RaidRequirements(common.SlugRAIDImplLinuxSoftware, "")
+	optionalLayout := optional(model.EncryptionRequirements().Merge(model.LVMRequirements(), model.FormatRequirements("swap"), linuxRaid, model.NVMeRequirements()))
+	applyRequirements := model.PartitionRequirements().Merge(model.FormatRequirements("ext4"), optionalLayout)
+
+	if layout != nil {
+		applyRequirements = layout.Requirements()
+	}
+
+	return map[string]model.Requirements{
+		"apply":            applyRequirements,
+		"disk health":      model.HealthRequirements(),
+		"disk partition":   model.PartitionRequirements(),
+		"disk wipe":        model.WipeRequirements(),
+		"nvme format":      model.NVMeRequirements(),
+		"nvme namespace":   model.NVMeRequirements(),
+		"partition format": model.FormatRequirements("ext4"),
+		"raid create":      linuxRaid,
+		"raid delete":      linuxRaid,
+		"raid foreign":     model.PhysicalDiskRequirements(),
+		"raid pd":          model.PhysicalDiskRequirements(),
+		"rollback":         optional(model.PartitionRequirements().Merge(linuxRaid, model.EncryptionRequirements(), model.LVMRequirements(), model.NVMeRequirements())),
+	}
+}
+
+func optional(r model.Requirements) (o model.Requirements) {
+	for _, req := range r {
+		req.Optional = true
+		o = append(o, req)
+	}
+
+	return
+}
+
+// doctor checks the requirements of every command, each requirement once.
+func doctor(ctx context.Context, layout *model.StorageLayout) (checks []*doctorCheck, err error) {
+	byCommand := commandRequirements(layout)
+
+	commands := make([]string, 0, len(byCommand))
+	for c := range byCommand {
+		commands = append(commands, c)
+	}
+
+	slices.Sort(commands)
+
+	var all model.Requirements
+
+	for _, c := range commands {
+		all = all.Merge(byCommand[c])
+	}
+
+	results, err := all.Check(ctx, true)
+
+	for _, r := range results {
+		check := &doctorCheck{RequirementCheck: r, Commands: []string{}}
+
+		for _, c := range commands {
+			if slices.ContainsFunc(byCommand[c], func(req model.Requirement) bool { return req.Kind == r.Kind && req.Name == r.Name }) {
+				check.Commands = append(check.Commands, c)
+			}
+		}
+
+		checks = append(checks, check)
+	}
+
+	return
+}
+
+// preflight fails a command whose requirements are missing before it
+// changes anything.
+func preflight(ctx context.Context, requirements model.Requirements) {
+	if err := requirements.Preflight(ctx); err != nil {
+		logger.Fatalw("required tools or kernel modules are missing, see vogelkop doctor", "err", err)
+	}
+}
+
+func printDoctorChecksJSON(checks []*doctorCheck) {
+	out, err := json.MarshalIndent(checks, "", "  ")
+	if err != nil {
+		logger.Fatalw("failed to marshal checks", "err", err)
+	}
+
+	fmt.Println(string(out))
+}
+
+func printDoctorChecksTable(checks []*doctorCheck) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+
+	fmt.Fprintln(w, "KIND\tNAME\tSTATUS\tVERSION\tCOMMANDS")
+
+	for _, c := range checks {
+		status := "missing"
+
+		switch {
+		case c.Found && c.State != "":
+			status = c.State
+		case c.Found:
+			status = "ok"
+		case c.Optional:
+			status = "missing (optional)"
+		}
+
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", c.Kind, c.Name, status, c.Version, strings.Join(c.Commands, ","))
+	}
+
+	w.Flush()
+}
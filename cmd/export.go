@@ -0,0 +1,53 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/metal-toolbox/vogelkop/internal/command"
+	"github.com/metal-toolbox/vogelkop/pkg/model"
+	"github.com/spf13/cobra"
+)
+
+var exportCommand = &cobra.Command{
+	Use:   "export",
+	Short: "Exports the current storage configuration as a storage layout",
+	Long:  "Reads partitions, software RAID arrays, LUKS volumes, LVM and filesystems from the running system and prints them as a storage layout that apply reproduces. Key files of encrypted volumes have to be added before applying the layout.",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, _ []string) {
+		ctx := command.NewContextWithLogger(cmd.Context(), logger)
+
+		layout, err := model.Export(ctx)
+		if err != nil {
+			logger.Fatalw("failed to export storage layout", "err", err)
+		}
+
+		for _, p := range layout.Validate() {
+			logger.Warnw("exported layout needs attention before it can be applied", "pointer", p.Pointer, "problem", p.Message)
+		}
+
+		out, err := json.MarshalIndent(layout, "", "  ")
+		if err != nil {
+			logger.Fatalw("failed to marshal storage layout", "err", err)
+		}
+
+		if file := GetString(cmd, "output"); file != "" {
+			if err := os.WriteFile(file, append(out, '\n'), 0o600); err != nil {
+				logger.Fatalw("failed to write storage layout", "err", err, "output", file)
+			}
+
+			result.Data = layout
+
+			return
+		}
+
+		output(layout, func() { fmt.Println(string(out)) })
+	},
+}
+
+func init() {
+	exportCommand.PersistentFlags().String("output", "", "Write the layout to this file instead of stdout")
+
+	rootCmd.AddCommand(exportCommand)
+}
@@ -0,0 +1,168 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/metal-toolbox/vogelkop/internal/audit"
+	"github.com/metal-toolbox/vogelkop/pkg/model"
+	"github.com/spf13/cobra"
+)
+
+var (
+	auditLog   *audit.Log
+	auditEntry *audit.Entry
+)
+
+var historyCommand = &cobra.Command{
+	Use:   "history",
+	Short: "Shows the audit log of operations that changed devices",
+	Long:  "Shows the operations recorded in the audit log, oldest first. --since and --until take an RFC 3339 time, a date or a duration before now such as 24h.",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, _ []string) {
+		filter := audit.Filter{
+			Device:    GetString(cmd, "device"),
+			Operation: GetString(cmd, "operation"),
+		}
+
+		now := time.Now()
+
+		for flag, t := range map[string]*time.Time{"since": &filter.Since, "until": &filter.Until} {
+			if v := GetString(cmd, flag); v != "" {
+				var err error
+				if *t, err = audit.ParseTime(v, now); err != nil {
+					logger.Fatalw("invalid --"+flag, "err", err, flag, v)
+				}
+			}
+		}
+
+		file := auditLogPath()
+
+		entries, err := audit.Read(file, filter)
+		if err != nil {
+			logger.Fatalw("failed to read audit log", "err", err, "audit_log", file)
+		}
+
+		if entries == nil {
+			entries = []*audit.Entry{}
+		}
+
+		output(entries, func() {
+			switch format := GetString(cmd, "format"); format {
+			case "json":
+				printHistoryJSON(entries)
+			case "table":
+				printHistoryTable(entries)
+			default:
+				logger.Fatalw("invalid output format", "err", model.InvalidArgumentError("--format "+format), "format", format)
+			}
+		})
+	},
+}
+
+func init() {
+	rootCmd.PersistentFlags().String("audit-log", audit.DefaultPath, "Append a record of every operation that changes devices to this file, empty to disable")
+
+	historyCommand.PersistentFlags().String("device", "", "Only show operations on this device: lock name, device file, serial, WWN, serial:<serial> or wwn:<wwn>")
+	historyCommand.PersistentFlags().String("operation", "", "Only show this operation, such as \"disk wipe\"")
+	historyCommand.PersistentFlags().String("since", "", "Only show operations started at or after this time")
+	historyCommand.PersistentFlags().String("until", "", "Only show operations started at or before this time")
+	historyCommand.PersistentFlags().String("format", "table", "Output format: table,json")
+
+	rootCmd.AddCommand(historyCommand)
+}
+
+func auditLogPath() string {
+	file, err := rootCmd.PersistentFlags().GetString("audit-log")
+	if err != nil {
+		logger.Panicw("Error processing audit-log parameter.", "error", err)
+	}
+
+	return file
+}
+
+// auditDevices records that the command is about to change the devices with
+// the given lock names. The audit log is opened on the first call, so that a
+// command that cannot record what it does fails before changing anything.
+func auditDevices(names []string) {
+	file := auditLogPath()
+	if file == "" {
+		return
+	}
+
+	if auditLog == nil {
+		l, err := audit.Open(file)
+		if err != nil {
+			logger.Fatalw("failed to open audit log", "err", err, "audit_log", file)
+		}
+
+		auditLog = l
+		auditEntry = audit.NewEntry(result.Operation, strings.Join(os.Args, " "))
+		auditEntry.Time = result.Started
+	}
+
+	auditEntry.Devices = append(auditEntry.Devices, audit.Identify(names)...)
+}
+
+// writeAudit appends the outcome of a command that changed devices to the
+// audit log.
+func writeAudit(err error) {
+	if auditLog == nil {
+		return
+	}
+
+	auditEntry.Targets = result.Targets
+	auditEntry.Finish(err, result.Indeterminate)
+
+	if writeErr := auditLog.Write(auditEntry); writeErr != nil {
+		logger.Errorw("failed to write audit log", "err", writeErr, "audit_log", auditLogPath())
+	}
+
+	auditLog.Close()
+	auditLog = nil
+}
+
+func printHistoryJSON(entries []*audit.Entry) {
+	out, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		logger.Fatalw("failed to marshal audit log entries", "err", err)
+	}
+
+	fmt.Println(string(out))
+}
+
+func printHistoryTable(entries []*audit.Entry) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+
+	fmt.Fprintln(w, "TIME\tOPERATION\tUSER\tDEVICES\tRESULT\tCOMMAND")
+
+	for _, e := range entries {
+		devices := make([]string, 0, len(e.Devices))
+		for _, d := range e.Devices {
+			if d.Serial != "" {
+				devices = append(devices, d.Name+"("+d.Serial+")")
+				continue
+			}
+
+			devices = append(devices, d.Name)
+		}
+
+		user := e.User
+		if e.SudoUser != "" {
+			user = e.SudoUser + "(" + e.User + ")"
+		}
+
+		status := "ok"
+		if !e.Success {
+			status = e.ErrorClass
+		}
+
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", e.Time.Local().Format(time.DateTime), e.Operation, user, strings.Join(devices, ","), status, e.Command)
+	}
+
+	w.Flush()
+}
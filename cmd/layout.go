@@ -0,0 +1,15 @@
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+)
+
+var layoutCmd = &cobra.Command{
+	Use:   "layout",
+	Short: "Works with storage layouts and layout templates",
+	Long:  "Works with storage layouts and layout templates",
+}
+
+func init() {
+	rootCmd.AddCommand(layoutCmd)
+}
@@ -0,0 +1,70 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/metal-toolbox/vogelkop/internal/command"
+	"github.com/metal-toolbox/vogelkop/pkg/model"
+	"github.com/spf13/cobra"
+)
+
+var layoutRenderCommand = &cobra.Command{
+	Use:   "render",
+	Short: "Renders a layout template against the discovered block devices",
+	Long:  "Renders a layout template into a concrete storage layout for the block devices of this system and prints it as JSON",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, _ []string) {
+		ctx := command.NewContextWithLogger(cmd.Context(), logger)
+
+		layout := renderTemplate(ctx, GetString(cmd, "template"))
+		setTargets(GetString(cmd, "template"))
+
+		out, err := json.MarshalIndent(layout, "", "  ")
+		if err != nil {
+			logger.Fatalw("failed to marshal storage layout", "err", err)
+		}
+
+		output(layout, func() { fmt.Println(string(out)) })
+	},
+}
+
+func init() {
+	layoutRenderCommand.PersistentFlags().String("template", "", "Layout template file")
+	markFlagAsRequired(layoutRenderCommand, "template")
+
+	layoutCmd.AddCommand(layoutRenderCommand)
+}
+
+// renderTemplate reads the layout template in file and renders it against
+// the discovered block devices.
+func renderTemplate(ctx context.Context, file string) *model.StorageLayout {
+	f, err := os.Open(file)
+	if err != nil {
+		logger.Fatalw("failed to open layout template", "err", err, "template", file)
+	}
+	defer f.Close()
+
+	template, err := model.DecodeLayoutTemplate(f)
+	if err != nil {
+		logger.Fatalw("failed to parse layout template", "err", err, "template", file)
+	}
+
+	blockDevices, err := model.DiscoverBlockDevices()
+	if err != nil {
+		logger.Fatalw("failed to discover block devices", "err", err)
+	}
+
+	if err := model.EnrichBlockDevices(ctx, blockDevices); err != nil {
+		logger.Warnw("failed to collect ironlib inventory, matching on sysfs data only", "err", err)
+	}
+
+	layout, err := template.Render(blockDevices)
+	if err != nil {
+		logger.Fatalw("failed to render layout template", "err", err, "template", file)
+	}
+
+	return layout
+}
@@ -0,0 +1,35 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/metal-toolbox/vogelkop/pkg/model"
+	"github.com/spf13/cobra"
+)
+
+var layoutSchemaCommand = &cobra.Command{
+	Use:   "schema",
+	Short: "Prints the JSON Schema of storage layouts",
+	Long:  "Prints the JSON Schema of storage layout documents, or of layout templates with --template. The schemas are generated from the Go types and published in the schema directory of the repository.",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, _ []string) {
+		schema := model.StorageLayoutSchema()
+		if GetBool(cmd, "template") {
+			schema = model.LayoutTemplateSchema()
+		}
+
+		out, err := json.MarshalIndent(schema, "", "  ")
+		if err != nil {
+			logger.Fatalw("failed to marshal schema", "err", err)
+		}
+
+		output(schema, func() { fmt.Println(string(out)) })
+	},
+}
+
+func init() {
+	layoutSchemaCommand.PersistentFlags().Bool("template", false, "Print the layout template schema instead")
+
+	layoutCmd.AddCommand(layoutSchemaCommand)
+}
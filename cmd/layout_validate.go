@@ -0,0 +1,49 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/metal-toolbox/vogelkop/pkg/model"
+	"github.com/spf13/cobra"
+)
+
+var layoutValidateCommand = &cobra.Command{
+	Use:   "validate",
+	Short: "Validates a storage layout",
+	Long:  "Checks a storage layout against the storage layout JSON Schema and for problems such as duplicate partition positions, RAID arrays without enough members or colliding mount points. Every problem is printed with the JSON pointer of its location.",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, _ []string) {
+		file := GetString(cmd, "layout")
+		setTargets(file)
+
+		data, err := os.ReadFile(file)
+		if err != nil {
+			logger.Fatalw("failed to read storage layout", "err", err, "layout", file)
+		}
+
+		_, problems, err := model.ValidateLayoutDocument(data)
+		if err != nil {
+			logger.Fatalw("failed to parse storage layout", "err", err, "layout", file)
+		}
+
+		output(problems, func() {
+			for _, p := range problems {
+				fmt.Println(p)
+			}
+		})
+
+		if len(problems) > 0 {
+			logger.Fatalw("storage layout is invalid", "err", model.InvalidLayoutError(problems), "layout", file, "problems", len(problems))
+		}
+
+		logger.Infow("storage layout is valid", "layout", file)
+	},
+}
+
+func init() {
+	layoutValidateCommand.PersistentFlags().String("layout", "", "Storage layout file")
+	markFlagAsRequired(layoutValidateCommand, "layout")
+
+	layoutCmd.AddCommand(layoutValidateCommand)
+}
@@ -0,0 +1,52 @@
+package cmd
+
+import (
+	"context"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/metal-toolbox/vogelkop/internal/lock"
+)
+
+// heldLocks are the device locks of the running command. They are released
+// when it finishes, or by the kernel when the process exits.
+var heldLocks []*lock.Lock
+
+func init() {
+	rootCmd.PersistentFlags().Duration("lock-timeout", 0, "Time to wait for devices locked by another vogelkop run before failing")
+}
+
+func lockTimeout() time.Duration {
+	d, err := rootCmd.PersistentFlags().GetDuration("lock-timeout")
+	if err != nil {
+		logger.Panicw("Error processing lock-timeout parameter.", "error", err)
+	}
+
+	return d
+}
+
+// lockDevices takes the locks of the given lock names, see the lock package,
+// or fails the command naming the process holding them. Every command that
+// changes devices locks them first, so this is also where they are recorded
+// for the audit log.
+func lockDevices(ctx context.Context, names ...string) {
+	auditDevices(names)
+
+	l, err := lock.Acquire(ctx, names, lockTimeout(), strings.Join(os.Args, " "))
+	if err != nil {
+		logger.Fatalw("failed to lock devices", "err", err, "devices", names)
+	}
+
+	logger.Debugw("locked devices", "devices", names)
+
+	heldLocks = append(heldLocks, l)
+}
+
+func releaseLocks() {
+	for _, l := range heldLocks {
+		l.Release()
+	}
+
+	heldLocks = nil
+}
@@ -0,0 +1,52 @@
+package cmd
+
+import (
+	"context"
+
+	"github.com/metal-toolbox/vogelkop/internal/lock"
+	"github.com/metal-toolbox/vogelkop/pkg/model"
+	"github.com/spf13/cobra"
+)
+
+var nvmeCommand = &cobra.Command{
+	Use:   "nvme",
+	Short: "Manages NVMe namespaces and LBA formats",
+}
+
+func init() {
+	rootCmd.AddCommand(nvmeCommand)
+}
+
+// lockNVMeController resolves an NVMe controller given as a device file or
+// selector of it or one of its namespaces, locks it and reads it.
+func lockNVMeController(ctx context.Context, device string) *model.NVMeController {
+	preflight(ctx, model.NVMeRequirements())
+
+	file, err := model.NVMeControllerFile(device)
+	if err != nil {
+		logger.Fatalw("failed to resolve nvme controller", "err", err, "device", device)
+	}
+
+	lockDevices(ctx, lock.NVMeController(file)...)
+
+	c, err := model.ReadNVMeController(ctx, file)
+	if err != nil {
+		logger.Fatalw("failed to read nvme controller", "err", err, "controller", file)
+	}
+
+	return c
+}
+
+// checkNamespacesNotInUse refuses to change namespaces whose block devices
+// are in use, see checkNotInUse.
+func checkNamespacesNotInUse(ctx context.Context, override bool, namespaces ...*model.NVMeNamespace) {
+	var devices []*model.BlockDevice
+
+	for _, ns := range namespaces {
+		if ns.File != "" {
+			devices = append(devices, &model.BlockDevice{ControllerPhysicalDeviceID: -1, File: ns.File})
+		}
+	}
+
+	checkNotInUse(ctx, override, devices, nil)
+}
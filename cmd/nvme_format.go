@@ -0,0 +1,83 @@
+package cmd
+
+import (
+	"path/filepath"
+
+	"github.com/metal-toolbox/vogelkop/internal/command"
+	"github.com/metal-toolbox/vogelkop/pkg/model"
+	"github.com/spf13/cobra"
+)
+
+var nvmeFormatCommand = &cobra.Command{
+	Use:   "format /dev/nvmeXnY",
+	Short: "Formats an NVMe namespace with another LBA format",
+	Long:  "Formats an attached NVMe namespace, given as a device file or selector, with the LBA format --lbaf or the best performing one of --block-size, such as 4096 for 4Kn. All data on the namespace is lost. The LBA formats a namespace supports are shown by nvme namespace list --format json.",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := command.NewContextWithLogger(cmd.Context(), logger)
+		setTargets(args[0])
+
+		file, err := model.ResolveSelector(args[0])
+		if err != nil {
+			logger.Fatalw("failed to resolve namespace", "err", err, "device", args[0])
+		}
+
+		if resolved, err := filepath.EvalSymlinks(file); err == nil {
+			file = resolved
+		}
+
+		c := lockNVMeController(ctx, file)
+
+		var ns *model.NVMeNamespace
+
+		for _, candidate := range c.Namespaces {
+			if candidate.File == file {
+				ns = candidate
+			}
+		}
+
+		if ns == nil {
+			logger.Fatalw("device is not an attached nvme namespace", "err", model.DeviceNotFoundError(file), "device", file)
+		}
+
+		lbaf := lbaFormat(cmd, ns)
+
+		checkNamespacesNotInUse(ctx, GetBool(cmd, "i-know-what-im-doing"), ns)
+
+		if err := model.FormatNVMeNamespace(ctx, ns, lbaf, GetUint(cmd, "ses")); err != nil {
+			logger.Fatalw("failed to format nvme namespace", "err", err, "device", file, "lbaf", lbaf)
+		}
+
+		logger.Infow("nvme namespace formatted", "device", file, "lbaf", lbaf)
+	},
+}
+
+func init() {
+	nvmeFormatCommand.PersistentFlags().Int("lbaf", -1, "Index of the LBA format")
+	nvmeFormatCommand.PersistentFlags().Uint("block-size", 0, "Logical block size in bytes, selecting the best performing LBA format without metadata")
+	nvmeFormatCommand.PersistentFlags().Uint("ses", 0, "Secure erase setting: 0 none, 1 user data erase, 2 cryptographic erase")
+
+	nvmeCommand.AddCommand(nvmeFormatCommand)
+}
+
+// lbaFormat returns the LBA format index given by --lbaf, or the best one
+// for --block-size.
+func lbaFormat(cmd *cobra.Command, ns *model.NVMeNamespace) uint {
+	lbaf, blockSize := GetInt(cmd, "lbaf"), GetUint(cmd, "block-size")
+
+	switch {
+	case lbaf >= 0 && blockSize > 0:
+		logger.Fatalw("--lbaf and --block-size are mutually exclusive", "err", model.InvalidArgumentError("--lbaf and --block-size"))
+	case lbaf >= 0:
+		return uint(lbaf)
+	case blockSize == 0:
+		logger.Fatalw("one of --lbaf or --block-size is required", "err", model.InvalidArgumentError("--lbaf or --block-size"))
+	}
+
+	index, ok := ns.FormatFor(uint64(blockSize))
+	if !ok {
+		logger.Fatalw("namespace has no LBA format of the block size", "err", model.InvalidArgumentError("--block-size"), "device", ns.File, "formats", ns.Formats)
+	}
+
+	return index
+}
@@ -0,0 +1,262 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/metal-toolbox/vogelkop/internal/command"
+	"github.com/metal-toolbox/vogelkop/pkg/model"
+	"github.com/spf13/cobra"
+)
+
+var nvmeNamespaceCommand = &cobra.Command{
+	Use:   "namespace",
+	Short: "Manages the namespaces of NVMe controllers",
+	Long:  "Lists, creates, deletes, attaches and detaches the namespaces of NVMe controllers. Controllers are given as their device file, such as /dev/nvme0, or a device file or selector of one of their namespaces.",
+}
+
+var nvmeNamespaceListCommand = &cobra.Command{
+	Use:   "list",
+	Short: "Lists NVMe controllers and their namespaces",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, _ []string) {
+		ctx := command.NewContextWithLogger(cmd.Context(), logger)
+
+		preflight(ctx, model.NVMeRequirements())
+
+		files, err := model.ListNVMeControllers()
+		if err != nil {
+			logger.Fatalw("failed to list nvme controllers", "err", err)
+		}
+
+		if device := GetString(cmd, "controller"); device != "" {
+			file, err := model.NVMeControllerFile(device)
+			if err != nil {
+				logger.Fatalw("failed to resolve nvme controller", "err", err, "device", device)
+			}
+
+			files = []string{file}
+		}
+
+		controllers := []*model.NVMeController{}
+
+		for _, file := range files {
+			c, err := model.ReadNVMeController(ctx, file)
+			if err != nil {
+				logger.Fatalw("failed to read nvme controller", "err", err, "controller", file)
+			}
+
+			controllers = append(controllers, c)
+		}
+
+		output(controllers, func() {
+			switch format := GetString(cmd, "format"); format {
+			case "json":
+				printNVMeControllersJSON(controllers)
+			case "table":
+				printNVMeControllersTable(controllers)
+			default:
+				logger.Fatalw("invalid output format", "err", model.InvalidArgumentError("--format "+format), "format", format)
+			}
+		})
+	},
+}
+
+var nvmeNamespaceCreateCommand = &cobra.Command{
+	Use:   "create",
+	Short: "Creates and attaches an NVMe namespace",
+	Long:  "Allocates a namespace of --size, or of all unallocated capacity, and attaches it to the controller so that its block device appears.",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, _ []string) {
+		ctx := command.NewContextWithLogger(cmd.Context(), logger)
+
+		var size uint64
+
+		if s := GetString(cmd, "size"); s != "" {
+			var err error
+
+			if size, err = model.ParseSize(s); err != nil {
+				logger.Fatalw("--size argument is invalid", "err", err, "size", s)
+			}
+		}
+
+		setTargets(GetString(cmd, "controller"))
+
+		c := lockNVMeController(ctx, GetString(cmd, "controller"))
+
+		id, err := c.CreateNamespace(ctx, size, uint64(GetUint(cmd, "block-size")))
+		if err != nil {
+			logger.Fatalw("failed to create nvme namespace", "err", err, "controller", c.File)
+		}
+
+		logger.Infow("nvme namespace created", "controller", c.File, "namespace", id)
+
+		result.Data = map[string]uint{"namespace_id": id}
+
+		if !GetBool(cmd, "attach") {
+			return
+		}
+
+		if err := c.AttachNamespace(ctx, id); err != nil {
+			logger.Fatalw("failed to attach nvme namespace", "err", err, "controller", c.File, "namespace", id)
+		}
+	},
+}
+
+var nvmeNamespaceDeleteCommand = &cobra.Command{
+	Use:   "delete",
+	Short: "Deletes an NVMe namespace and all data on it",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, _ []string) {
+		ctx := command.NewContextWithLogger(cmd.Context(), logger)
+		setTargets(GetString(cmd, "controller"))
+
+		c := lockNVMeController(ctx, GetString(cmd, "controller"))
+		ns := namespaceOf(c, GetUint(cmd, "namespace-id"))
+
+		checkNamespacesNotInUse(ctx, GetBool(cmd, "i-know-what-im-doing"), ns)
+
+		if err := c.DeleteNamespace(ctx, ns.ID); err != nil {
+			logger.Fatalw("failed to delete nvme namespace", "err", err, "controller", c.File, "namespace", ns.ID)
+		}
+
+		logger.Infow("nvme namespace deleted", "controller", c.File, "namespace", ns.ID)
+	},
+}
+
+var nvmeNamespaceAttachCommand = &cobra.Command{
+	Use:   "attach",
+	Short: "Attaches an NVMe namespace to its controller",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, _ []string) {
+		ctx := command.NewContextWithLogger(cmd.Context(), logger)
+		setTargets(GetString(cmd, "controller"))
+
+		c := lockNVMeController(ctx, GetString(cmd, "controller"))
+		ns := namespaceOf(c, GetUint(cmd, "namespace-id"))
+
+		if ns.Attached {
+			logger.Infow("nvme namespace is already attached", "controller", c.File, "namespace", ns.ID, "device", ns.File)
+			return
+		}
+
+		if err := c.AttachNamespace(ctx, ns.ID); err != nil {
+			logger.Fatalw("failed to attach nvme namespace", "err", err, "controller", c.File, "namespace", ns.ID)
+		}
+	},
+}
+
+var nvmeNamespaceDetachCommand = &cobra.Command{
+	Use:   "detach",
+	Short: "Detaches an NVMe namespace from its controller, keeping its data",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, _ []string) {
+		ctx := command.NewContextWithLogger(cmd.Context(), logger)
+		setTargets(GetString(cmd, "controller"))
+
+		c := lockNVMeController(ctx, GetString(cmd, "controller"))
+		ns := namespaceOf(c, GetUint(cmd, "namespace-id"))
+
+		if !ns.Attached {
+			logger.Infow("nvme namespace is already detached", "controller", c.File, "namespace", ns.ID)
+			return
+		}
+
+		checkNamespacesNotInUse(ctx, GetBool(cmd, "i-know-what-im-doing"), ns)
+
+		if err := c.DetachNamespace(ctx, ns.ID); err != nil {
+			logger.Fatalw("failed to detach nvme namespace", "err", err, "controller", c.File, "namespace", ns.ID)
+		}
+	},
+}
+
+var nvmeNamespaceResetCommand = &cobra.Command{
+	Use:   "reset",
+	Short: "Replaces all NVMe namespaces of a controller with a single full-size one",
+	Long:  "Deletes every namespace of the controller and creates a single namespace of its full capacity with the largest block size it supports, the way drives ship. All data on the controller is lost.",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, _ []string) {
+		ctx := command.NewContextWithLogger(cmd.Context(), logger)
+		setTargets(GetString(cmd, "controller"))
+
+		c := lockNVMeController(ctx, GetString(cmd, "controller"))
+
+		checkNamespacesNotInUse(ctx, GetBool(cmd, "i-know-what-im-doing"), c.Namespaces...)
+
+		if err := c.Reset(ctx); err != nil {
+			logger.Fatalw("failed to reset nvme namespaces", "err", err, "controller", c.File)
+		}
+	},
+}
+
+func init() {
+	nvmeNamespaceListCommand.PersistentFlags().String("controller", "", "NVMe controller, or a device file or selector of one of its namespaces (default all)")
+	nvmeNamespaceListCommand.PersistentFlags().String("format", "table", "Output format: table,json")
+
+	nvmeNamespaceCreateCommand.PersistentFlags().String("size", "", "Size of the namespace, such as 800G (default all unallocated capacity)")
+	nvmeNamespaceCreateCommand.PersistentFlags().Uint("block-size", 0, "Logical block size in bytes, such as 512 or 4096 (default the largest supported)")
+	nvmeNamespaceCreateCommand.PersistentFlags().Bool("attach", true, "Attach the namespace to the controller")
+
+	for _, c := range []*cobra.Command{nvmeNamespaceCreateCommand, nvmeNamespaceDeleteCommand, nvmeNamespaceAttachCommand, nvmeNamespaceDetachCommand, nvmeNamespaceResetCommand} {
+		c.PersistentFlags().String("controller", "", "NVMe controller, or a device file or selector of one of its namespaces")
+		markFlagAsRequired(c, "controller")
+	}
+
+	for _, c := range []*cobra.Command{nvmeNamespaceDeleteCommand, nvmeNamespaceAttachCommand, nvmeNamespaceDetachCommand} {
+		c.PersistentFlags().Uint("namespace-id", 0, "ID of the namespace")
+		markFlagAsRequired(c, "namespace-id")
+	}
+
+	nvmeNamespaceCommand.AddCommand(nvmeNamespaceListCommand, nvmeNamespaceCreateCommand, nvmeNamespaceDeleteCommand,
+		nvmeNamespaceAttachCommand, nvmeNamespaceDetachCommand, nvmeNamespaceResetCommand)
+	nvmeCommand.AddCommand(nvmeNamespaceCommand)
+}
+
+// namespaceOf returns the namespace of the controller with the given ID.
+func namespaceOf(c *model.NVMeController, id uint) *model.NVMeNamespace {
+	ns, err := c.Namespace(id)
+	if err != nil {
+		logger.Fatalw("no such nvme namespace", "err", err, "controller", c.File, "namespace", id)
+	}
+
+	return ns
+}
+
+func printNVMeControllersJSON(controllers []*model.NVMeController) {
+	out, err := json.MarshalIndent(controllers, "", "  ")
+	if err != nil {
+		logger.Fatalw("failed to marshal nvme controllers", "err", err)
+	}
+
+	fmt.Println(string(out))
+}
+
+func printNVMeControllersTable(controllers []*model.NVMeController) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+
+	fmt.Fprintln(w, "CONTROLLER\tNSID\tDEVICE\tSIZE\tBLOCK SIZE\tLBAF\tATTACHED\tUNALLOCATED")
+
+	for _, c := range controllers {
+		unallocated := "-"
+		if c.NamespaceManagement {
+			unallocated = model.FormatSize(c.UnallocatedCapacity)
+		}
+
+		if len(c.Namespaces) == 0 {
+			fmt.Fprintf(w, "%s\t-\t-\t-\t-\t-\t-\t%s\n", c.File, unallocated)
+		}
+
+		for _, ns := range c.Namespaces {
+			device := ns.File
+			if device == "" {
+				device = "-"
+			}
+
+			fmt.Fprintf(w, "%s\t%d\t%s\t%s\t%d\t%d\t%t\t%s\n", c.File, ns.ID, device, model.FormatSize(ns.Size), ns.BlockSize, ns.LBAFormat, ns.Attached, unallocated)
+		}
+	}
+
+	w.Flush()
+}
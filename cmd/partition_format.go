@@ -1,8 +1,8 @@
 package cmd
 
 import (
-	"context"
-
+	"github.com/metal-toolbox/vogelkop/internal/command"
+	"github.com/metal-toolbox/vogelkop/internal/lock"
 	"github.com/metal-toolbox/vogelkop/pkg/model"
 	"github.com/spf13/cobra"
 )
@@ -12,16 +12,18 @@ var partitionFormatCommand = &cobra.Command{
 	Short: "Formats a partition",
 	Long:  "Formats a partition with your choice of filesystem",
 	Run: func(cmd *cobra.Command, _ []string) {
-		ctx := context.Background()
+		ctx := command.NewContextWithLogger(cmd.Context(), logger)
 
 		if GetString(cmd, "device") == "" && GetString(cmd, "filesystem-device") == "" {
-			logger.Fatal("Either --device or --filesystem-device are required.")
+			logger.Fatalw("Either --device or --filesystem-device are required.", "err", model.InvalidArgumentError("--device or --filesystem-device"))
 		}
 
 		if GetString(cmd, "device") != "" && GetUint(cmd, "partition") == 0 {
-			logger.Fatal("When using the --device parameter, the --partition number must be specified.")
+			logger.Fatalw("When using the --device parameter, the --partition number must be specified.", "err", model.InvalidArgumentError("--partition"))
 		}
 
+		preflight(ctx, model.FormatRequirements(GetString(cmd, "format")))
+
 		pPosition := GetUint(cmd, "partition")
 		filesystemDevice := GetString(cmd, "filesystem-device")
 
@@ -33,24 +35,59 @@ var partitionFormatCommand = &cobra.Command{
 		}
 
 		if filesystemDevice != "" {
+			file, err := model.ResolveSelector(filesystemDevice)
+			if err != nil {
+				logger.Fatalw("failed to resolve filesystem device", "err", err, "device", filesystemDevice)
+			}
+
 			partition.BlockDevice = &model.BlockDevice{
-				File: filesystemDevice,
+				File: file,
 			}
 		} else {
+			device, err := model.ResolveSelector(GetString(cmd, "device"))
+			if err != nil {
+				logger.Fatalw("failed to resolve device", "err", err, "device", GetString(cmd, "device"))
+			}
+
+			file, err := partition.GetBlockDevice(device)
+			if err != nil {
+				logger.Fatalw("failed to determine partition device", "err", err, "device", device, "partition", pPosition)
+			}
+
 			partition.BlockDevice = &model.BlockDevice{
-				File: partition.GetBlockDevice(GetString(cmd, "device")),
+				File: file,
 			}
 		}
 
-		if _, err := partition.Format(ctx); err != nil {
-			logger.Fatalw("failed to format partition", "err", err, "partition", partition)
+		setTargets(partition.BlockDevice.File)
+		lockDevices(ctx, lock.Device(partition.BlockDevice.File))
+
+		current, err := partition.CurrentFileSystem(ctx)
+		if err != nil {
+			logger.Fatalw("failed to detect existing filesystem", "err", err, "partition", partition)
+		}
+
+		if current == partition.FileSystem {
+			logger.Infow("filesystem already matches, nothing to do", "partition", partition)
+			result.Data = changeResult{}
+
+			return
 		}
+
+		checkNotInUse(ctx, GetBool(cmd, "i-know-what-im-doing"), []*model.BlockDevice{partition.BlockDevice}, nil)
+
+		out, changed, err := partition.EnsureFormat(ctx, GetBool(cmd, "force"))
+		if err != nil {
+			logger.Fatalw("failed to format partition", "err", err, "partition", partition, "output", out)
+		}
+
+		result.Data = changeResult{Changed: changed}
 	},
 }
 
 func init() {
-	partitionFormatCommand.PersistentFlags().String("device", "", "Block device")
-	partitionFormatCommand.PersistentFlags().String("filesystem-device", "", "Filesystem Block device")
+	partitionFormatCommand.PersistentFlags().String("device", "", "Block device file or selector (wwn:, serial:, by-id:, by-path:, model:&size:)")
+	partitionFormatCommand.PersistentFlags().String("filesystem-device", "", "Filesystem Block device file or selector")
 
 	partitionFormatCommand.PersistentFlags().Uint("partition", 0, "Partition number")
 
@@ -59,6 +96,7 @@ func init() {
 
 	partitionFormatCommand.PersistentFlags().String("mount-point", "/", "Filesystem mount point")
 	partitionFormatCommand.PersistentFlags().StringSlice("options", []string{}, "Filesystem creation options")
+	partitionFormatCommand.PersistentFlags().Bool("force", false, "Reformat a partition that already holds a different filesystem")
 	partitionCommand.AddCommand(partitionFormatCommand)
 
 	deprecated := *partitionFormatCommand
@@ -2,10 +2,12 @@ package cmd
 
 import (
 	"context"
+	"path/filepath"
 	"strconv"
 
 	"github.com/bmc-toolbox/common"
 	"github.com/metal-toolbox/vogelkop/internal/command"
+	"github.com/metal-toolbox/vogelkop/internal/lock"
 	"github.com/metal-toolbox/vogelkop/pkg/model"
 	"github.com/spf13/cobra"
 )
@@ -17,36 +19,87 @@ var createRaidCmd = &cobra.Command{
 	Run: func(cmd *cobra.Command, _ []string) {
 		ctx := command.NewContextWithLogger(cmd.Context(), logger)
 		raidType := GetString(cmd, "raid-type")
-		createArray(ctx, GetString(cmd, "name"), raidType, GetString(cmd, "raid-level"), GetStringSlice(cmd, "devices"))
+		setTargets(GetString(cmd, "name"))
+		setTargets(GetStringSlice(cmd, "devices")...)
+		createArray(ctx, GetString(cmd, "name"), raidType, GetString(cmd, "raid-level"), GetStringSlice(cmd, "devices"),
+			GetBool(cmd, "force"), GetBool(cmd, "i-know-what-im-doing"), healthCheck(cmd),
+			uint64(GetUint(cmd, "size-tolerance")), GetBool(cmd, "allow-mismatched-members"), virtualDiskOptions(cmd))
 	},
 }
 
 func init() {
-	createRaidCmd.PersistentFlags().StringSlice("devices", []string{}, "List of underlying physical block devices.")
+	createRaidCmd.PersistentFlags().StringSlice("devices", []string{}, "List of underlying physical block devices, as device files or selectors (wwn:, serial:, by-id:, by-path:, model:&size:).")
 	markFlagAsRequired(createRaidCmd, "devices")
 	createRaidCmd.PersistentFlags().String("raid-level", "1", "RAID Level")
 	markFlagAsRequired(createRaidCmd, "raid-level")
 	createRaidCmd.PersistentFlags().String("name", "unknown", "RAID Volume Name")
 	markFlagAsRequired(createRaidCmd, "name")
+	createRaidCmd.PersistentFlags().Bool("force", false, "Recreate an existing array, or reuse member devices, that do not match the definition")
+	createRaidCmd.PersistentFlags().Uint("size-tolerance", model.DefaultMemberSizeTolerance, "How much the members of a software RAID array may differ in size, in percent of the largest")
+	createRaidCmd.PersistentFlags().Bool("allow-mismatched-members", false, "Only warn about software RAID members with different sector sizes or sizes")
+	createRaidCmd.PersistentFlags().String("stripe-size", "", "Stripe size of a hardware RAID virtual disk, such as 64K (default the controller's)")
+	createRaidCmd.PersistentFlags().String("read-cache", "", "Read cache policy of a hardware RAID virtual disk: read-ahead,no-read-ahead")
+	createRaidCmd.PersistentFlags().String("write-cache", "", "Write cache policy of a hardware RAID virtual disk: write-back,write-through,always-write-back")
+	createRaidCmd.PersistentFlags().String("disk-cache", "", "Drive cache setting of a hardware RAID virtual disk: on,off,default")
+	createRaidCmd.PersistentFlags().String("init", "", "Initialisation of a hardware RAID virtual disk: none,fast,full,background")
+	addHealthFlags(createRaidCmd, true)
 
 	raidCmd.AddCommand(createRaidCmd)
 }
 
-func createArray(ctx context.Context, arrayName, raidType, raidLevel string, arrayDevices []string) {
+func createArray(ctx context.Context, arrayName, raidType, raidLevel string, arrayDevices []string, force, override bool, health *model.HealthThresholds,
+	sizeTolerance uint64, allowMismatch bool, options *model.VirtualDiskOptions,
+) {
 	if raidType == "" {
 		raidType = common.SlugRAIDImplLinuxSoftware
 	}
 
+	if options != nil && raidType != common.SlugRAIDImplHardware {
+		logger.Fatalw("virtual disk options only apply to hardware raid arrays", "err", model.InvalidArgumentError("virtual disk options with --raid-type "+raidType))
+	}
+
+	preflight(ctx, model.RaidRequirements(raidType, raidLevel))
+
 	raidArray := model.RaidArray{
-		Name:  arrayName,
-		Level: raidLevel,
+		Name:        arrayName,
+		Level:       raidLevel,
+		VirtualDisk: options,
 	}
 
+	locks := lock.RaidArray(raidType, arrayName, arrayDevices)
+	lockDevices(ctx, locks...)
+	preflightHealth(ctx, locks, health)
+
 	raidArray.Devices = processDevices(arrayDevices, raidType)
 
-	if err := raidArray.Create(ctx, raidType); err != nil {
+	exists, differences, err := raidArray.Check(ctx, raidType)
+	if err != nil {
+		logger.Fatalw("failed to inspect existing raid arrays", "err", err, "array", raidArray)
+	}
+
+	if exists && len(differences) == 0 {
+		logger.Infow("raid array already matches, nothing to do", "array", raidArray)
+		result.Data = changeResult{}
+
+		return
+	}
+
+	if raidType == common.SlugRAIDImplLinuxSoftware {
+		checkArrayMembers(arrayName, raidArray.Devices, sizeTolerance, allowMismatch)
+
+		// Members of the array being replaced are expected to be held by it.
+		arrayFile, _ := filepath.EvalSymlinks("/dev/md/" + arrayName)
+		checkNotInUse(ctx, override, raidArray.Devices, func(u model.Usage) bool {
+			return u.Kind == "md" && arrayFile != "" && u.Holder == filepath.Base(arrayFile)
+		})
+	}
+
+	changed, err := raidArray.Ensure(ctx, raidType, force)
+	if err != nil {
 		logger.Fatalw("failed to create raid array", "err", err, "array", raidArray)
 	}
+
+	result.Data = changeResult{Changed: changed}
 }
 
 func processDevices(arrayDevices []string, raidType string) []*model.BlockDevice {
@@ -94,3 +147,46 @@ func processDevicesHardware(arrayDevices []string) []*model.BlockDevice {
 
 	return blockDevices
 }
+
+// checkArrayMembers refuses to build a software RAID array from members that
+// differ in sector size, or in size by more than tolerance percent, unless
+// allowed, in which case the differences are only logged.
+func checkArrayMembers(arrayName string, members []*model.BlockDevice, tolerance uint64, allow bool) {
+	differences, err := model.CheckArrayMembers(members, tolerance)
+	if err != nil {
+		logger.Fatalw("failed to read the geometry of raid array members", "err", err, "array", arrayName)
+	}
+
+	if len(differences) == 0 {
+		return
+	}
+
+	err = model.MismatchedMembersError(arrayName, differences)
+	if !allow {
+		logger.Fatalw("refusing to build a raid array from mismatched members, pass --allow-mismatched-members to override", "err", err, "array", arrayName)
+	}
+
+	logger.Warnw("building a raid array from mismatched members", "err", err, "array", arrayName)
+}
+
+// virtualDiskOptions returns the hardware RAID virtual disk options given on
+// the command line, or nil if none are.
+func virtualDiskOptions(cmd *cobra.Command) *model.VirtualDiskOptions {
+	options := &model.VirtualDiskOptions{
+		StripeSize: GetString(cmd, "stripe-size"),
+		ReadCache:  GetString(cmd, "read-cache"),
+		WriteCache: GetString(cmd, "write-cache"),
+		DiskCache:  GetString(cmd, "disk-cache"),
+		Init:       GetString(cmd, "init"),
+	}
+
+	if *options == (model.VirtualDiskOptions{}) {
+		return nil
+	}
+
+	for field, problem := range options.Problems() {
+		logger.Fatalw("invalid virtual disk option", "err", model.InvalidArgumentError(problem), "option", field)
+	}
+
+	return options
+}
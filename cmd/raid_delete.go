@@ -5,6 +5,7 @@ import (
 	"strconv"
 
 	"github.com/metal-toolbox/vogelkop/internal/command"
+	"github.com/metal-toolbox/vogelkop/internal/lock"
 	"github.com/metal-toolbox/vogelkop/pkg/model"
 	"github.com/spf13/cobra"
 )
@@ -16,6 +17,7 @@ var deleteRaidCmd = &cobra.Command{
 	Run: func(cmd *cobra.Command, _ []string) {
 		ctx := command.NewContextWithLogger(cmd.Context(), logger)
 		raidType := GetString(cmd, "raid-type")
+		setTargets(GetString(cmd, "name"))
 		deleteArray(ctx, raidType, GetString(cmd, "name"))
 	},
 }
@@ -28,8 +30,12 @@ func init() {
 }
 
 func deleteArray(ctx context.Context, raidType, arrayName string) {
+	preflight(ctx, model.RaidRequirements(raidType, ""))
+	lockDevices(ctx, lock.RaidArray(raidType, arrayName, nil)...)
+
 	raidArray := model.RaidArray{
-		Name: arrayName,
+		Name:                    arrayName,
+		ControllerVirtualDiskID: -1,
 	}
 
 	// If arrayName is actually an integer, populate that as the ControllerVirtualDiskID
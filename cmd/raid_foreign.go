@@ -0,0 +1,45 @@
+package cmd
+
+import (
+	"github.com/metal-toolbox/vogelkop/pkg/model"
+	"github.com/spf13/cobra"
+)
+
+var raidForeignCmd = &cobra.Command{
+	Use:   "foreign",
+	Short: "Clears or imports foreign configurations of hardware RAID controllers",
+	Long:  "Physical disks moved from another controller, or left over from an earlier install, carry its virtual disks as a foreign configuration that keeps them from being reused until it is cleared or imported.",
+}
+
+var raidForeignClearCmd = &cobra.Command{
+	Use:   "clear",
+	Short: "Deletes all foreign configurations and the data of their virtual disks",
+	Long:  "Deletes every foreign configuration of the MegaRAID controller and the data of their virtual disks. Hosts with more than one MegaRAID controller are refused.",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, _ []string) {
+		ctx := lockPhysicalDisks(cmd)
+
+		if err := model.ClearForeignConfig(ctx); err != nil {
+			logger.Fatalw("failed to clear foreign configuration", "err", err)
+		}
+	},
+}
+
+var raidForeignImportCmd = &cobra.Command{
+	Use:   "import",
+	Short: "Imports all foreign configurations",
+	Long:  "Imports every foreign configuration of the MegaRAID controller. Hosts with more than one MegaRAID controller are refused.",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, _ []string) {
+		ctx := lockPhysicalDisks(cmd)
+
+		if err := model.ImportForeignConfig(ctx); err != nil {
+			logger.Fatalw("failed to import foreign configuration", "err", err)
+		}
+	},
+}
+
+func init() {
+	raidForeignCmd.AddCommand(raidForeignClearCmd, raidForeignImportCmd)
+	raidCmd.AddCommand(raidForeignCmd)
+}
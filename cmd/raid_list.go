@@ -41,11 +41,13 @@ func listVirtualDisks(ctx context.Context, raidType string) {
 		logger.Fatalw("failed to list virtual disks", "err", err, "raidType", raidType)
 	}
 
-	fmt.Println("id,name,raid-type")
+	output(virtualDisks, func() {
+		fmt.Println("id,name,raid-type")
 
-	for _, vd := range virtualDisks {
-		fmt.Printf("%s,%s,%s\n", vd.ID, vd.Name, vd.RaidType)
-	}
+		for _, vd := range virtualDisks {
+			fmt.Printf("%s,%s,%s\n", vd.ID, vd.Name, vd.RaidType)
+		}
+	})
 }
 
 func listPhysicalDisks(ctx context.Context, raidType string) {
@@ -54,9 +56,11 @@ func listPhysicalDisks(ctx context.Context, raidType string) {
 		logger.Fatalw("failed to list physical disks", "err", err, "raidType", raidType)
 	}
 
-	fmt.Println("storage-controller-drive-id,drive-type,serial")
+	output(physicalDisks, func() {
+		fmt.Println("storage-controller-drive-id,drive-type,serial")
 
-	for _, pd := range physicalDisks {
-		fmt.Printf("%d,%s,%s\n", pd.StorageControllerDriveID, pd.Type, pd.Serial)
-	}
+		for _, pd := range physicalDisks {
+			fmt.Printf("%d,%s,%s\n", pd.StorageControllerDriveID, pd.Type, pd.Serial)
+		}
+	})
 }
@@ -0,0 +1,117 @@
+package cmd
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/metal-toolbox/vogelkop/internal/command"
+	"github.com/metal-toolbox/vogelkop/internal/lock"
+	"github.com/metal-toolbox/vogelkop/pkg/model"
+	"github.com/spf13/cobra"
+)
+
+var raidPhysicalDiskCmd = &cobra.Command{
+	Use:   "pd",
+	Short: "Manages the physical disks of hardware RAID controllers",
+	Long:  "Converts physical disks of hardware RAID controllers between JBOD and unconfigured good and assigns hot spares. Physical disks are given by their controller ID, as raid list --raid-type hardware --object-type pd shows it.",
+}
+
+var raidPhysicalDiskSetStateCmd = &cobra.Command{
+	Use:   "set-state",
+	Short: "Converts a physical disk to JBOD or unconfigured good",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, _ []string) {
+		setTargets(strconv.Itoa(GetInt(cmd, "id")))
+		id, state := GetInt(cmd, "id"), GetString(cmd, "state")
+		ctx := command.NewContextWithLogger(cmd.Context(), logger)
+
+		preflight(ctx, model.PhysicalDiskRequirements())
+
+		// Converting a JBOD disk hides its block device from the operating
+		// system, which must not be using it.
+		var bd *model.BlockDevice
+
+		if state == model.PhysicalDiskUnconfiguredGood {
+			var err error
+			if bd, err = model.PhysicalDiskBlockDevice(ctx, id); err != nil {
+				logger.Fatalw("failed to find the block device of the physical disk", "err", err, "id", id)
+			}
+		}
+
+		if bd == nil {
+			lockDevices(ctx, lock.HardwareRaid)
+		} else {
+			setTargets(bd.File)
+			lockDevices(ctx, lock.HardwareRaid, lock.Device(bd.File))
+			checkNotInUse(ctx, GetBool(cmd, "i-know-what-im-doing"), []*model.BlockDevice{bd}, nil)
+		}
+
+		changed, err := model.SetPhysicalDiskState(ctx, id, state, GetBool(cmd, "force"))
+		if err != nil {
+			logger.Fatalw("failed to set physical disk state", "err", err, "id", id, "state", state)
+		}
+
+		result.Data = changeResult{Changed: changed}
+	},
+}
+
+var raidPhysicalDiskHotSpareCmd = &cobra.Command{
+	Use:   "hotspare",
+	Short: "Assigns or removes a hot spare",
+	Long:  "Makes an unconfigured good physical disk a global hot spare, or a dedicated one of the virtual disks given by --arrays. With --remove the hot spare becomes unconfigured good again.",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, _ []string) {
+		setTargets(strconv.Itoa(GetInt(cmd, "id")))
+		ctx := lockPhysicalDisks(cmd)
+		id, arrays := GetInt(cmd, "id"), GetStringSlice(cmd, "arrays")
+
+		var (
+			changed bool
+			err     error
+		)
+
+		if GetBool(cmd, "remove") {
+			if len(arrays) > 0 {
+				logger.Fatalw("--remove and --arrays are mutually exclusive", "err", model.InvalidArgumentError("--remove and --arrays"))
+			}
+
+			changed, err = model.RemoveHotSpare(ctx, id)
+		} else {
+			changed, err = model.AddHotSpare(ctx, id, arrays)
+		}
+
+		if err != nil {
+			logger.Fatalw("failed to change hot spare", "err", err, "id", id, "arrays", arrays)
+		}
+
+		result.Data = changeResult{Changed: changed}
+	},
+}
+
+func init() {
+	raidPhysicalDiskSetStateCmd.PersistentFlags().String("state", "", "State of the physical disk: jbod,unconfigured-good")
+	markFlagAsRequired(raidPhysicalDiskSetStateCmd, "state")
+	raidPhysicalDiskSetStateCmd.PersistentFlags().Bool("force", false, "Convert JBOD disks that carry partitions or filesystems")
+
+	raidPhysicalDiskHotSpareCmd.PersistentFlags().StringSlice("arrays", []string{}, "Names of the virtual disks a dedicated hot spare serves (default a global hot spare)")
+	raidPhysicalDiskHotSpareCmd.PersistentFlags().Bool("remove", false, "Remove the hot spare")
+
+	for _, c := range []*cobra.Command{raidPhysicalDiskSetStateCmd, raidPhysicalDiskHotSpareCmd} {
+		c.PersistentFlags().Int("id", -1, "Controller ID of the physical disk")
+		markFlagAsRequired(c, "id")
+	}
+
+	raidPhysicalDiskCmd.AddCommand(raidPhysicalDiskSetStateCmd, raidPhysicalDiskHotSpareCmd)
+	raidCmd.AddCommand(raidPhysicalDiskCmd)
+}
+
+// lockPhysicalDisks checks the requirements of managing physical disks and
+// locks the configuration of the RAID controller.
+func lockPhysicalDisks(cmd *cobra.Command) context.Context {
+	ctx := command.NewContextWithLogger(cmd.Context(), logger)
+
+	preflight(ctx, model.PhysicalDiskRequirements())
+	lockDevices(ctx, lock.HardwareRaid)
+
+	return ctx
+}
@@ -0,0 +1,195 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/metal-toolbox/vogelkop/internal/command"
+	"github.com/metal-toolbox/vogelkop/pkg/model"
+	"github.com/spf13/cobra"
+	"go.uber.org/zap/zapcore"
+)
+
+// commandResult is the machine-readable result every command writes with
+// --json or --result-file.
+type commandResult struct {
+	Operation string   `json:"operation"`
+	Targets   []string `json:"targets"`
+	Success   bool     `json:"success"`
+	// ErrorClass groups errors for callers deciding what to do next, see
+	// model.ErrorClass.
+	ErrorClass string    `json:"error_class,omitempty"`
+	Error      string    `json:"error,omitempty"`
+	Started    time.Time `json:"started"`
+	// Duration is in milliseconds.
+	Duration int64                `json:"duration_ms"`
+	Commands []*command.Execution `json:"commands"`
+	// Indeterminate lists the devices an interrupted command may have left
+	// half changed.
+	Indeterminate []string `json:"indeterminate,omitempty"`
+	// Data is what the command would otherwise print, or the details of
+	// what it did.
+	Data any `json:"data,omitempty"`
+}
+
+// changeResult is the result data of commands that converge a single object.
+type changeResult struct {
+	Changed bool `json:"changed"`
+}
+
+var (
+	result     = &commandResult{}
+	recorder   = &command.Recorder{}
+	resultOnce sync.Once
+)
+
+func init() {
+	rootCmd.PersistentFlags().Bool("json", false, "Print a JSON result envelope on stdout instead of the command's regular output")
+	rootCmd.PersistentFlags().String("result-file", "", "Write a JSON result envelope to this file")
+
+	rootCmd.PersistentPreRun = func(cmd *cobra.Command, _ []string) {
+		// The logger is configured from the settings of the command.
+		initConfig(cmd)
+		initLogging()
+
+		result.Operation = strings.TrimPrefix(cmd.CommandPath(), rootCmd.Name()+" ")
+		result.Started = time.Now().UTC()
+
+		cmd.SetContext(command.NewContextWithRecorder(cmd.Context(), recorder))
+	}
+
+	rootCmd.PersistentPostRun = func(_ *cobra.Command, _ []string) {
+		releaseLocks()
+		writeResult(nil)
+	}
+}
+
+// jsonOutput reports whether the regular output of a command is replaced by
+// the result envelope.
+func jsonOutput() bool {
+	b, err := rootCmd.PersistentFlags().GetBool("json")
+	return err == nil && b
+}
+
+// setTargets records the devices, arrays or files a command works on.
+func setTargets(targets ...string) {
+	result.Targets = append(result.Targets, targets...)
+}
+
+// output records data in the result envelope and, unless --json is given,
+// prints it the regular way.
+func output(data any, print func()) {
+	result.Data = data
+
+	if !jsonOutput() {
+		print()
+	}
+}
+
+// writeResult completes the result envelope with the outcome of the command
+// and writes it. Only the first call writes, so a failure reported while
+// writing the result does not produce a second envelope.
+func writeResult(err error) {
+	resultOnce.Do(func() {
+		result.Success = err == nil
+		result.Duration = time.Since(result.Started).Milliseconds()
+		result.Commands = recorder.Executions()
+		result.Indeterminate = recorder.Indeterminate()
+
+		if len(result.Indeterminate) > 0 {
+			logger.Warnw("devices may have been left in an indeterminate state", "devices", result.Indeterminate)
+		}
+
+		if err != nil {
+			result.Error = err.Error()
+			result.ErrorClass = model.ErrorClass(err)
+		}
+
+		if result.Targets == nil {
+			result.Targets = []string{}
+		}
+
+		if result.Commands == nil {
+			result.Commands = []*command.Execution{}
+		}
+
+		writeAudit(err)
+
+		out, marshalErr := json.MarshalIndent(result, "", "  ")
+		if marshalErr != nil {
+			logger.Errorw("failed to marshal result", "err", marshalErr)
+			return
+		}
+
+		if file, _ := rootCmd.PersistentFlags().GetString("result-file"); file != "" {
+			if writeErr := os.WriteFile(file, append(out, '\n'), 0o600); writeErr != nil {
+				logger.Errorw("failed to write result file", "err", writeErr, "result_file", file)
+			}
+		}
+
+		if jsonOutput() {
+			fmt.Println(string(out))
+		}
+	})
+}
+
+// exitCodes are the documented process exit codes of each error class.
+var exitCodes = map[string]int{
+	model.ErrorClassInternal:        1,
+	model.ErrorClassInvalidInput:    2,
+	model.ErrorClassDeviceNotFound:  3,
+	model.ErrorClassDeviceInUse:     4,
+	model.ErrorClassStateConflict:   5,
+	model.ErrorClassToolMissing:     6,
+	model.ErrorClassToolFailed:      7,
+	model.ErrorClassTimeout:         8,
+	model.ErrorClassPartialSuccess:  9,
+	model.ErrorClassDeviceUnhealthy: 10,
+	// 128 + SIGINT, like a shell reports a command killed by Ctrl-C.
+	model.ErrorClassInterrupted: 130,
+}
+
+// exitCode returns the process exit code for err.
+func exitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+
+	return exitCodes[model.ErrorClass(err)]
+}
+
+// fatalError builds the error of a fatal log entry from its message and its
+// err field, if it has one.
+func fatalError(message string, err error) error {
+	if err == nil {
+		return errors.New(message) // nolint:goerr113
+	}
+
+	return fmt.Errorf("%s: %w", message, err)
+}
+
+// resultFatalHook writes the result envelope of a command that fails through
+// logger.Fatalw before exiting.
+type resultFatalHook struct{}
+
+func (resultFatalHook) OnWrite(ce *zapcore.CheckedEntry, fields []zapcore.Field) {
+	var err error
+
+	for _, f := range fields {
+		if fieldErr, ok := f.Interface.(error); ok && f.Type == zapcore.ErrorType {
+			err = fieldErr
+		}
+	}
+
+	err = fatalError(ce.Message, err)
+
+	writeResult(err)
+	os.Exit(exitCode(err))
+}
+
+var _ zapcore.CheckWriteHook = resultFatalHook{}
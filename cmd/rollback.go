@@ -0,0 +1,47 @@
+package cmd
+
+import (
+	"github.com/metal-toolbox/vogelkop/internal/command"
+	"github.com/metal-toolbox/vogelkop/internal/lock"
+	"github.com/metal-toolbox/vogelkop/pkg/model"
+	"github.com/spf13/cobra"
+)
+
+var rollbackCommand = &cobra.Command{
+	Use:   "rollback",
+	Short: "Rolls back the changes recorded in an apply journal",
+	Long:  "Undoes the steps an earlier apply recorded in its journal, in reverse order: filesystems are wiped, logical volumes and volume groups removed, encrypted volumes closed, arrays stopped and partitions deleted.",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, _ []string) {
+		ctx := command.NewContextWithLogger(cmd.Context(), logger)
+		file := GetString(cmd, "journal")
+		setTargets(file)
+
+		journal, err := model.LoadJournal(file)
+		if err != nil {
+			logger.Fatalw("failed to read journal", "err", err, "journal", file)
+		}
+
+		result.Data = journal
+
+		if journal.Status == model.JournalRolledBack {
+			logger.Infow("journal was already rolled back", "journal", file)
+			return
+		}
+
+		preflight(ctx, journal.Requirements())
+		lockDevices(ctx, lock.Journal(journal)...)
+
+		if err := journal.Rollback(ctx); err != nil {
+			logger.Fatalw("failed to roll back", "err", err, "journal", file)
+		}
+
+		logger.Infow("rolled back", "journal", file, "layout", journal.Layout)
+	},
+}
+
+func init() {
+	rollbackCommand.PersistentFlags().String("journal", defaultJournalFile, "Journal written by apply")
+
+	rootCmd.AddCommand(rollbackCommand)
+}
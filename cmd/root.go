@@ -1,7 +1,17 @@
 package cmd
 
 import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"slices"
+	"syscall"
+	"time"
+
+	"github.com/metal-toolbox/vogelkop/internal/logging"
 	version "github.com/metal-toolbox/vogelkop/internal/version"
+	"github.com/metal-toolbox/vogelkop/pkg/model"
 	"github.com/spf13/cobra"
 	"go.uber.org/zap"
 )
@@ -17,22 +27,31 @@ var (
 )
 
 func init() {
-	cobra.OnInitialize(initLogging)
-	rootCmd.PersistentFlags().Bool("debug", false, "Debug Mode")
+	rootCmd.PersistentFlags().Bool("debug", false, "Debug Mode, short for --log-level debug --log-format console")
+	rootCmd.PersistentFlags().String("log-format", logging.FormatJSON, "Log format: json,console")
+	rootCmd.PersistentFlags().String("log-level", "info", "Log level: debug,info,warn,error")
+	rootCmd.PersistentFlags().Bool("i-know-what-im-doing", false, "Modify devices even if they are mounted, active swap or held by md, dm or LVM")
 }
 
 func initLogging() {
-	cfg := zap.NewProductionConfig()
-	cfg.Level = zap.NewAtomicLevelAt(zap.InfoLevel)
-
-	if b, err := rootCmd.Flags().GetBool("debug"); err == nil && b {
-		cfg = zap.NewDevelopmentConfig()
-		cfg.Level = zap.NewAtomicLevelAt(zap.DebugLevel)
+	flags := rootCmd.PersistentFlags()
+	format, _ := flags.GetString("log-format")
+	level, _ := flags.GetString("log-level")
+
+	if b, err := flags.GetBool("debug"); err == nil && b {
+		if !flags.Changed("log-format") {
+			format = logging.FormatConsole
+		}
+
+		if !flags.Changed("log-level") {
+			level = "debug"
+		}
 	}
 
-	l, err := cfg.Build()
+	l, err := logging.New(format, level, zap.WithFatalHook(resultFatalHook{}))
 	if err != nil {
-		panic(err)
+		fmt.Fprintln(os.Stderr, "invalid logging flags:", err)
+		os.Exit(exitCodes[model.ErrorClassInvalidInput])
 	}
 
 	logger = l.Sugar().With("app", version.Name(), "version", version.Version())
@@ -48,8 +67,32 @@ func loggerSync() {
 	}
 }
 
+// Execute runs the command line. Usage errors exit with the code of
+// invalid input, failing commands with the code of their error class.
+// SIGINT or SIGTERM cancel the context of the command, which stops the tools
+// it runs and makes it fail as interrupted. A second signal exits at once.
 func Execute() {
-	cobra.CheckErr(rootCmd.Execute())
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+
+	go func() {
+		sig := <-signals
+		// The next signal gets the default behaviour and ends the process.
+		signal.Stop(signals)
+
+		if logger != nil {
+			logger.Warnw("interrupted, cancelling running operations; signal again to exit immediately", "signal", sig.String())
+		}
+
+		cancel()
+	}()
+
+	if err := rootCmd.ExecuteContext(ctx); err != nil {
+		os.Exit(exitCodes[model.ErrorClassInvalidInput])
+	}
 }
 
 func GetString(cmd *cobra.Command, key string) (v string) {
@@ -70,6 +113,15 @@ func GetUint(cmd *cobra.Command, key string) (v uint) {
 	return
 }
 
+func GetInt(cmd *cobra.Command, key string) (v int) {
+	v, err := cmd.Flags().GetInt(key)
+	if err != nil {
+		logger.Panicw("Error processing "+key+" parameter.", "error", err)
+	}
+
+	return
+}
+
 func GetStringSlice(cmd *cobra.Command, key string) (v []string) {
 	v, err := cmd.Flags().GetStringSlice(key)
 	if err != nil {
@@ -88,8 +140,44 @@ func GetBool(cmd *cobra.Command, key string) (v bool) {
 	return
 }
 
+func GetDuration(cmd *cobra.Command, key string) (v time.Duration) {
+	v, err := cmd.Flags().GetDuration(key)
+	if err != nil {
+		logger.Panicw("Error processing "+key+" parameter.", "error", err)
+	}
+
+	return
+}
+
 func markFlagAsRequired(cmd *cobra.Command, flagName string) {
 	if err := cmd.MarkPersistentFlagRequired(flagName); err != nil {
 		logger.Panicw("failed to mark flag as persistent", "err", err)
 	}
 }
+
+// checkNotInUse refuses to continue if any of the devices is in use, unless
+// override is set in which case the usage is only logged. Usages for which
+// ignore returns true are expected by the caller and skipped.
+func checkNotInUse(ctx context.Context, override bool, devices []*model.BlockDevice, ignore func(model.Usage) bool) {
+	for _, bd := range devices {
+		usages, err := bd.InUse(ctx)
+		if err != nil {
+			logger.Fatalw("failed to determine whether device is in use", "err", err, "device", bd.File)
+		}
+
+		usages = slices.DeleteFunc(usages, func(u model.Usage) bool {
+			return ignore != nil && ignore(u)
+		})
+
+		if len(usages) == 0 {
+			continue
+		}
+
+		err = model.DeviceInUseError(bd.File, usages)
+		if !override {
+			logger.Fatalw("refusing to modify a device that is in use, pass --i-know-what-im-doing to override", "err", err, "device", bd.File)
+		}
+
+		logger.Warnw("modifying a device that is in use", "err", err, "device", bd.File)
+	}
+}
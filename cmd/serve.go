@@ -0,0 +1,49 @@
+package cmd
+
+import (
+	"strconv"
+
+	"github.com/metal-toolbox/vogelkop/internal/agent"
+	"github.com/metal-toolbox/vogelkop/pkg/model"
+	"github.com/spf13/cobra"
+)
+
+var serveCommand = &cobra.Command{
+	Use:   "serve",
+	Short: "Serves storage operations over HTTP",
+	Long:  "Serves disk listing, layout apply, wipe and raid operations as an HTTP API. Changes run as jobs with pollable status and streamed logs, and jobs on the same device run one after the other.",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, _ []string) {
+		ctx := cmd.Context()
+		listen := GetString(cmd, "listen")
+
+		if history := GetInt(cmd, "job-history"); history < 0 {
+			logger.Fatalw("invalid job history", "err", model.InvalidArgumentError("--job-history "+strconv.Itoa(history)))
+		}
+
+		listener, err := agent.Listen(listen)
+		if err != nil {
+			logger.Fatalw("failed to listen", "err", err, "listen", listen)
+		}
+
+		logger.Infow("serving", "listen", listen)
+
+		server := agent.New(logger)
+		server.LockTimeout = lockTimeout()
+		server.AuditLog = auditLogPath()
+		server.JournalDir = GetString(cmd, "journal-dir")
+		server.JobHistory = GetInt(cmd, "job-history")
+
+		if err := server.Serve(ctx, listener); err != nil {
+			logger.Fatalw("failed to serve", "err", err, "listen", listen)
+		}
+	},
+}
+
+func init() {
+	serveCommand.PersistentFlags().String("listen", "unix:///run/vogelkop.sock", "Address to serve on: unix:///path/to.sock or a loopback host:port. The API has no authentication and anyone who can reach it can wipe the disks, so other TCP addresses are refused")
+	serveCommand.PersistentFlags().Int("job-history", agent.DefaultJobHistory, "Number of finished jobs kept for GET /v1/jobs, older ones are forgotten")
+	serveCommand.PersistentFlags().String("journal-dir", agent.DefaultJournalDir, "Directory apply jobs keep their journals in, one file per layout name")
+
+	rootCmd.AddCommand(serveCommand)
+}
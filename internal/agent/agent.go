@@ -0,0 +1,197 @@
+// Package agent serves the vogelkop operations over HTTP, so that
+// provisioning systems can drive the storage of a host without running the
+// CLI over SSH and parsing its logs. Operations that change the system run as
+// jobs which can be polled and whose logs can be streamed. Jobs touching the
+// same device run one after the other.
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/metal-toolbox/vogelkop/pkg/model"
+	"go.uber.org/zap"
+)
+
+const unixScheme = "unix://"
+
+// Defaults of a new Server.
+const (
+	DefaultJournalDir = "/run/vogelkop/journals"
+	DefaultJobHistory = 100
+)
+
+// Server keeps the jobs of a running agent.
+type Server struct {
+	// LockTimeout is how long a job waits for devices locked by other
+	// vogelkop processes before it fails.
+	LockTimeout time.Duration
+	// AuditLog is the audit log jobs are recorded in, none if empty.
+	AuditLog string
+	// JournalDir holds the journals of apply jobs that ask for one, each
+	// named after its layout.
+	JournalDir string
+	// JobHistory is how many finished jobs are kept, the oldest are
+	// forgotten first.
+	JobHistory int
+
+	logger *zap.SugaredLogger
+	locks  *deviceLocks
+
+	mu sync.Mutex
+	// ctx is what jobs run with, the context of Serve once it is called.
+	ctx  context.Context
+	jobs map[string]*Job
+	// order lists job IDs in the order the jobs were submitted.
+	order []string
+	// running tracks the jobs that have not finished yet.
+	running sync.WaitGroup
+}
+
+// New returns a Server logging to logger.
+func New(logger *zap.SugaredLogger) *Server {
+	return &Server{
+		JournalDir: DefaultJournalDir,
+		JobHistory: DefaultJobHistory,
+		logger:     logger,
+		locks:      newDeviceLocks(),
+		ctx:        context.Background(),
+		jobs:       make(map[string]*Job),
+	}
+}
+
+// Listen opens the listener for an address of the form unix:///path/to.sock
+// or host:port. The API has no authentication and wipes disks, so TCP
+// addresses must be on the loopback interface; the unix socket is only
+// accessible to its owner. A socket file left behind by an earlier agent is
+// removed.
+func Listen(address string) (net.Listener, error) {
+	path, isUnix := strings.CutPrefix(address, unixScheme)
+	if !isUnix {
+		if !loopback(address) {
+			return nil, model.InvalidArgumentError("listen address " + address + " is not on the loopback interface")
+		}
+
+		return net.Listen("tcp", address)
+	}
+
+	if fi, err := os.Lstat(path); err == nil && fi.Mode()&fs.ModeSocket != 0 {
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.Chmod(path, 0o600); err != nil {
+		l.Close()
+		return nil, err
+	}
+
+	return l, nil
+}
+
+// loopback reports whether a host:port address only listens on the loopback
+// interface. An empty host listens on every interface.
+func loopback(address string) bool {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return false
+	}
+
+	if host == "localhost" {
+		return true
+	}
+
+	ip := net.ParseIP(host)
+
+	return ip != nil && ip.IsLoopback()
+}
+
+// Serve handles requests on l until ctx is cancelled. Jobs run with ctx, so
+// cancelling it interrupts them the way a signal interrupts the CLI: the
+// tools they run are stopped, the devices they may have left half changed
+// are reported and they are recorded as failed. Serve returns once they
+// have finished.
+func (s *Server) Serve(ctx context.Context, l net.Listener) (err error) {
+	s.mu.Lock()
+	s.ctx = ctx
+	s.mu.Unlock()
+
+	srv := &http.Server{
+		Handler:           s.Handler(),
+		ReadHeaderTimeout: 10 * time.Second,
+		// Requests, including streamed job logs, end with the agent.
+		BaseContext: func(net.Listener) context.Context { return ctx },
+	}
+
+	go func() {
+		<-ctx.Done()
+
+		shutdownCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 10*time.Second)
+		defer cancel()
+
+		if shutdownErr := srv.Shutdown(shutdownCtx); shutdownErr != nil {
+			s.logger.Warnw("failed to shut down http server", "err", shutdownErr)
+		}
+	}()
+
+	if err = srv.Serve(l); errors.Is(err, http.ErrServerClosed) {
+		err = nil
+	}
+
+	s.logger.Infow("waiting for interrupted jobs to finish")
+	s.running.Wait()
+
+	return
+}
+
+// Handler returns the HTTP API of the agent:
+//
+//	GET    /v1/disks                   block devices, see model.DiscoverBlockDevices
+//	GET    /v1/raid/arrays             virtual disks of ?raid_type=
+//	GET    /v1/raid/physical-disks     physical disks of ?raid_type=
+//	POST   /v1/raid/arrays             create a raid array (job)
+//	DELETE /v1/raid/arrays/{name}      delete a raid array of ?raid_type= (job)
+//	POST   /v1/apply                   apply a layout or template (job)
+//	POST   /v1/wipe                    wipe disks (job)
+//	GET    /v1/jobs                    all jobs
+//	GET    /v1/jobs/{id}               one job
+//	GET    /v1/jobs/{id}/logs          job log as JSON lines, ?follow=true streams
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("GET /v1/disks", s.listDisks)
+	mux.HandleFunc("GET /v1/raid/arrays", s.listVirtualDisks)
+	mux.HandleFunc("GET /v1/raid/physical-disks", s.listPhysicalDisks)
+	mux.HandleFunc("POST /v1/raid/arrays", s.createRaidArray)
+	mux.HandleFunc("DELETE /v1/raid/arrays/{name}", s.deleteRaidArray)
+	mux.HandleFunc("POST /v1/apply", s.apply)
+	mux.HandleFunc("POST /v1/wipe", s.wipe)
+	mux.HandleFunc("GET /v1/jobs", s.listJobs)
+	mux.HandleFunc("GET /v1/jobs/{id}", s.getJob)
+	mux.HandleFunc("GET /v1/jobs/{id}/logs", s.jobLogs)
+
+	return mux
+}
+
+// recovered turns a panic of an operation into an error, so that a bug in
+// one job does not take down the agent and every other job with it.
+func recovered(v any) error {
+	if v == nil {
+		return nil
+	}
+
+	return fmt.Errorf("operation panicked: %v", v) // nolint:goerr113
+}
@@ -0,0 +1,345 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/metal-toolbox/vogelkop/internal/audit"
+	"github.com/metal-toolbox/vogelkop/internal/command"
+	"github.com/metal-toolbox/vogelkop/internal/lock"
+	"github.com/metal-toolbox/vogelkop/pkg/model"
+	"go.uber.org/zap"
+)
+
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "vogelkop-locks")
+	if err != nil {
+		panic(err)
+	}
+
+	lock.Dir = dir
+	code := m.Run()
+
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+func waitForJob(t *testing.T, s *Server, id string) Job {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+
+	for time.Now().Before(deadline) {
+		if j, _ := s.job(id); j.Status == JobSucceeded || j.Status == JobFailed {
+			return j
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	t.Fatalf("job %s did not finish", id)
+
+	return Job{}
+}
+
+func TestJobLifecycle(t *testing.T) {
+	s := New(zap.NewNop().Sugar())
+	s.AuditLog = filepath.Join(t.TempDir(), "audit.jsonl")
+	srv := httptest.NewServer(s.Handler())
+	defer srv.Close()
+
+	release := make(chan struct{})
+
+	submitted := s.submit("test", []string{"sda"}, func(ctx context.Context) (any, error) {
+		<-release
+		command.LoggerValueFromContext(ctx).Infow("partitioned", "device", "/dev/sda")
+
+		return map[string]int{"changes": 2}, nil
+	})
+
+	if submitted.Status != JobQueued || submitted.ID == "" {
+		t.Fatalf("unexpected submitted job %+v", submitted)
+	}
+
+	logs, err := http.Get(srv.URL + "/v1/jobs/" + submitted.ID + "/logs?follow=true")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer logs.Body.Close()
+
+	close(release)
+
+	// Following returns once the job has finished.
+	body, err := io.ReadAll(logs.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{`"msg":"job started"`, `"msg":"partitioned"`, `"msg":"job succeeded"`} {
+		if !strings.Contains(string(body), want) {
+			t.Errorf("log is missing %s:\n%s", want, body)
+		}
+	}
+
+	resp, err := http.Get(srv.URL + "/v1/jobs/" + submitted.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	var job struct {
+		Job
+		Result map[string]int `json:"result"`
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(&job); err != nil {
+		t.Fatal(err)
+	}
+
+	if job.Status != JobSucceeded || job.Started == nil || job.Finished == nil || job.Result["changes"] != 2 {
+		t.Errorf("unexpected finished job %+v", job)
+	}
+
+	failed := s.submit("test", nil, func(context.Context) (any, error) { return nil, errors.New("sgdisk failed") })
+	if j := waitForJob(t, s, failed.ID); j.Status != JobFailed || j.Error != "sgdisk failed" {
+		t.Errorf("unexpected failed job %+v", j)
+	}
+
+	panicked := s.submit("test", nil, func(context.Context) (any, error) { panic("boom") })
+	if j := waitForJob(t, s, panicked.ID); j.Status != JobFailed || !strings.Contains(j.Error, "boom") {
+		t.Errorf("unexpected panicked job %+v", j)
+	}
+
+	if resp, err := http.Get(srv.URL + "/v1/jobs/unknown"); err != nil || resp.StatusCode != http.StatusNotFound {
+		t.Errorf("unknown job: %v %v", resp, err)
+	}
+
+	entries, err := audit.Read(s.AuditLog, audit.Filter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(entries) != 3 || !entries[0].Success || entries[0].Devices[0].Name != "sda" || entries[1].Success || entries[1].Error != "sgdisk failed" {
+		t.Errorf("unexpected audit log entries %+v", entries)
+	}
+}
+
+func TestJobsOnSameDeviceAreSerialised(t *testing.T) {
+	s := New(zap.NewNop().Sugar())
+
+	var (
+		mu      sync.Mutex
+		running = make(map[string]int)
+		overlap []string
+	)
+
+	op := func(devices ...string) operation {
+		return func(context.Context) (any, error) {
+			mu.Lock()
+			for _, d := range devices {
+				if running[d]++; running[d] > 1 {
+					overlap = append(overlap, d)
+				}
+			}
+			mu.Unlock()
+
+			time.Sleep(20 * time.Millisecond)
+
+			mu.Lock()
+			for _, d := range devices {
+				running[d]--
+			}
+			mu.Unlock()
+
+			return nil, nil
+		}
+	}
+
+	jobs := []Job{
+		s.submit("wipe", []string{"sda"}, op("sda")),
+		s.submit("apply", []string{"sda", "sdb"}, op("sda", "sdb")),
+		s.submit("wipe", []string{"sdb"}, op("sdb")),
+		s.submit("wipe", []string{"sda"}, op("sda")),
+	}
+
+	for _, j := range jobs {
+		waitForJob(t, s, j.ID)
+	}
+
+	if len(overlap) > 0 {
+		t.Errorf("jobs ran concurrently on %v", overlap)
+	}
+
+	s.locks.mu.Lock()
+	defer s.locks.mu.Unlock()
+
+	if len(s.locks.holders) != 0 {
+		t.Errorf("locks were not released: %v", s.locks.holders)
+	}
+}
+
+func TestServeInterruptsJobs(t *testing.T) {
+	s := New(zap.NewNop().Sugar())
+	s.AuditLog = filepath.Join(t.TempDir(), "audit.jsonl")
+
+	l, err := Listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error)
+
+	go func() {
+		served <- s.Serve(ctx, l)
+	}()
+
+	// Jobs run with the context of Serve once it has been called.
+	for s.jobContext() != ctx {
+		time.Sleep(time.Millisecond)
+	}
+
+	started := make(chan struct{})
+	job := s.submit("wipe", []string{"sdy"}, func(ctx context.Context) (any, error) {
+		close(started)
+		<-ctx.Done()
+		command.RecorderValueFromContext(ctx).MarkIndeterminate("/dev/sdy")
+
+		return nil, ctx.Err()
+	})
+
+	<-started
+	cancel()
+
+	if err := <-served; err != nil {
+		t.Fatalf("Serve returned error: %v", err)
+	}
+
+	j, _ := s.job(job.ID)
+	if j.Status != JobFailed || j.ErrorClass != model.ErrorClassInterrupted || len(j.Indeterminate) != 1 || j.Indeterminate[0] != "/dev/sdy" {
+		t.Errorf("interrupted job = %+v, want failed as interrupted with /dev/sdy indeterminate", j)
+	}
+}
+
+func TestHandlerRejectsInvalidRequests(t *testing.T) {
+	srv := httptest.NewServer(New(zap.NewNop().Sugar()).Handler())
+	defer srv.Close()
+
+	for _, tc := range []struct {
+		path, body string
+		problems   bool
+	}{
+		{"/v1/apply", `{}`, false},
+		{"/v1/apply", `{"layout": {"name": "x"}, "on_error": "retry"}`, false},
+		{"/v1/apply", `{"layout": {"block_devices": [{"partitions": [{"position": 0}]}]}}`, true},
+		{"/v1/apply", `{"layout": {}, "settle_timeout": "soon"}`, false},
+		{"/v1/apply", `{"layout": {"name": "../../etc/cron.d/x"}, "journal": true}`, false},
+		{"/v1/apply", `{"layout": {"name": "x"}, "journal": "/etc/passwd"}`, false},
+		{"/v1/wipe", `{"devices": []}`, false},
+		{"/v1/raid/arrays", `{"name": "root", "level": "1", "raid_type": "hardware", "devices": ["sda"]}`, false},
+		{"/v1/raid/arrays", `{"name": "root", "level": "7", "devices": ["/dev/null", "/dev/zero"]}`, true},
+		{"/v1/raid/arrays", `{"unknown": true}`, false},
+	} {
+		resp, err := http.Post(srv.URL+tc.path, "application/json", strings.NewReader(tc.body))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var body errorResponse
+
+		err = json.NewDecoder(resp.Body).Decode(&body)
+		resp.Body.Close()
+
+		if err != nil || resp.StatusCode != http.StatusBadRequest || body.Error == "" || (len(body.Problems) > 0) != tc.problems {
+			t.Errorf("%s %s: got %d %+v %v", tc.path, tc.body, resp.StatusCode, body, err)
+		}
+	}
+}
+
+func TestJournalFile(t *testing.T) {
+	s := New(zap.NewNop().Sugar())
+
+	if file, err := s.journalFile("compute"); err != nil || file != filepath.Join(DefaultJournalDir, "compute.json") {
+		t.Errorf("journalFile(compute) = %s, %v", file, err)
+	}
+
+	for _, layout := range []string{"", ".", "..", "../x", "a/b", `a\b`} {
+		if file, err := s.journalFile(layout); !errors.Is(err, model.ErrInvalidArgument) {
+			t.Errorf("journalFile(%q) = %s, %v, want %v", layout, file, err, model.ErrInvalidArgument)
+		}
+	}
+}
+
+func TestListenOnlyOnLoopback(t *testing.T) {
+	for _, address := range []string{"127.0.0.1:0", "[::1]:0", "localhost:0"} {
+		l, err := Listen(address)
+		if err != nil {
+			// Hosts without IPv6 cannot listen on ::1.
+			t.Logf("Listen(%s) = %v", address, err)
+			continue
+		}
+
+		l.Close()
+	}
+
+	for _, address := range []string{":0", "0.0.0.0:0", "192.0.2.1:0", "example.com:0"} {
+		if l, err := Listen(address); !errors.Is(err, model.ErrInvalidArgument) {
+			if l != nil {
+				l.Close()
+			}
+
+			t.Errorf("Listen(%s) = %v, want %v", address, err, model.ErrInvalidArgument)
+		}
+	}
+}
+
+func TestFinishedJobsArePruned(t *testing.T) {
+	s := New(zap.NewNop().Sugar())
+	s.JobHistory = 2
+
+	var ids []string
+
+	for range 4 {
+		job := s.submit("wipe", []string{"sdz"}, func(context.Context) (any, error) {
+			return nil, nil
+		})
+		waitForJob(t, s, job.ID)
+
+		ids = append(ids, job.ID)
+	}
+
+	var kept []string
+	for _, j := range s.allJobs() {
+		kept = append(kept, j.ID)
+	}
+
+	if want := ids[2:]; !slices.Equal(kept, want) {
+		t.Errorf("kept jobs %v, want %v", kept, want)
+	}
+}
+
+func TestListenRemovesStaleSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "vogelkop.sock")
+
+	for range 2 {
+		l, err := Listen("unix://" + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Leave the socket file behind like an agent that was killed.
+		l.(interface{ SetUnlinkOnClose(bool) }).SetUnlinkOnClose(false)
+		l.Close()
+	}
+}
@@ -0,0 +1,523 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bmc-toolbox/common"
+	"github.com/metal-toolbox/ironlib"
+	"github.com/metal-toolbox/vogelkop/internal/command"
+	"github.com/metal-toolbox/vogelkop/internal/lock"
+	"github.com/metal-toolbox/vogelkop/internal/logging"
+	"github.com/metal-toolbox/vogelkop/pkg/model"
+)
+
+const (
+	defaultSettleTimeout = 30 * time.Second
+	defaultWipeTimeout   = time.Minute
+)
+
+// errorResponse is the body of every failed request.
+type errorResponse struct {
+	Error      string                `json:"error"`
+	ErrorClass string                `json:"error_class"`
+	Problems   []model.LayoutProblem `json:"problems,omitempty"`
+}
+
+type applyRequest struct {
+	// Layout is a StorageLayout document, Template a LayoutTemplate
+	// document rendered against the discovered block devices.
+	Layout        json.RawMessage `json:"layout"`
+	Template      json.RawMessage `json:"template"`
+	Force         bool            `json:"force"`
+	AllowInUse    bool            `json:"allow_in_use"`
+	OnError       string          `json:"on_error"`
+	SettleTimeout string          `json:"settle_timeout"`
+	// Journal keeps the journal in a file named after the layout in the
+	// JournalDir of the agent, so that a later job applying the same layout
+	// resumes from it. Otherwise the journal is kept in memory only.
+	Journal bool `json:"journal"`
+}
+
+type wipeRequest struct {
+	Devices    []string `json:"devices"`
+	Timeout    string   `json:"timeout"`
+	AllowInUse bool     `json:"allow_in_use"`
+}
+
+type createRaidArrayRequest struct {
+	Name     string `json:"name"`
+	Level    string `json:"level"`
+	RaidType string `json:"raid_type"`
+	// Devices are device files or selectors for linuxsw arrays and
+	// controller physical device IDs for hardware arrays.
+	Devices    []string `json:"devices"`
+	Force      bool     `json:"force"`
+	AllowInUse bool     `json:"allow_in_use"`
+	// VirtualDisk holds the options of hardware arrays.
+	VirtualDisk *model.VirtualDiskOptions `json:"virtual_disk"`
+}
+
+func (s *Server) listDisks(w http.ResponseWriter, r *http.Request) {
+	blockDevices, err := model.DiscoverBlockDevices()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	// Like disk list, the ironlib inventory is collected unless ?ironlib=false.
+	if enrich, err := strconv.ParseBool(r.URL.Query().Get("ironlib")); enrich || err != nil {
+		if err := model.EnrichBlockDevices(s.requestContext(r), blockDevices); err != nil {
+			s.logger.Warnw("failed to collect ironlib inventory, returning sysfs data only", "err", err)
+		}
+	}
+
+	writeJSON(w, http.StatusOK, blockDevices)
+}
+
+func (s *Server) listVirtualDisks(w http.ResponseWriter, r *http.Request) {
+	virtualDisks, err := model.ListVirtualDisks(s.requestContext(r), raidType(r))
+	if err != nil {
+		writeError(w, statusOf(err), err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, virtualDisks)
+}
+
+func (s *Server) listPhysicalDisks(w http.ResponseWriter, r *http.Request) {
+	physicalDisks, err := model.ListPhysicalDisks(s.requestContext(r), raidType(r))
+	if err != nil {
+		writeError(w, statusOf(err), err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, physicalDisks)
+}
+
+func (s *Server) apply(w http.ResponseWriter, r *http.Request) {
+	var req applyRequest
+	if !readJSON(w, r, &req) {
+		return
+	}
+
+	switch req.OnError {
+	case "", model.OnErrorStop, model.OnErrorRollback, model.OnErrorContinue:
+	default:
+		writeError(w, http.StatusBadRequest, model.InvalidOnErrorPolicyError(req.OnError))
+		return
+	}
+
+	settleTimeout, ok := parseDuration(w, "settle_timeout", req.SettleTimeout, defaultSettleTimeout)
+	if !ok {
+		return
+	}
+
+	var layout *model.StorageLayout
+
+	switch {
+	case len(req.Layout) > 0 && len(req.Template) > 0:
+		writeError(w, http.StatusBadRequest, model.InvalidArgumentError("layout and template are mutually exclusive"))
+		return
+	case len(req.Layout) > 0:
+		var (
+			problems []model.LayoutProblem
+			err      error
+		)
+
+		layout, problems, err = model.ValidateLayoutDocument(req.Layout)
+		if err == nil && len(problems) > 0 {
+			err = model.InvalidLayoutError(problems)
+		}
+
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, errorResponse{Error: err.Error(), ErrorClass: model.ErrorClass(err), Problems: problems})
+			return
+		}
+	case len(req.Template) > 0:
+		template, err := model.DecodeLayoutTemplate(bytes.NewReader(req.Template))
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		if layout, err = s.renderTemplate(r, template); err != nil {
+			writeError(w, statusOf(err), err)
+			return
+		}
+	default:
+		writeError(w, http.StatusBadRequest, model.InvalidArgumentError("one of layout or template is required"))
+		return
+	}
+
+	var journalFile string
+
+	if req.Journal {
+		var err error
+		if journalFile, err = s.journalFile(layout.Name); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+	}
+
+	locks, err := lock.Layout(layout)
+	if err != nil {
+		writeError(w, statusOf(err), err)
+		return
+	}
+
+	job := s.submit("apply", locks, func(ctx context.Context) (any, error) {
+		if err := layout.Requirements().Preflight(ctx); err != nil {
+			return nil, err
+		}
+
+		if journalFile != "" {
+			if err := os.MkdirAll(s.JournalDir, 0o700); err != nil {
+				return nil, err
+			}
+		}
+
+		journal, err := model.OpenJournal(journalFile, layout.Name)
+		if err != nil {
+			return nil, err
+		}
+
+		err = layout.Apply(ctx, &model.ApplyOptions{
+			Force:         req.Force,
+			AllowInUse:    req.AllowInUse,
+			SettleTimeout: settleTimeout,
+			OnError:       req.OnError,
+			Journal:       journal,
+		})
+
+		return journal, err
+	})
+
+	writeJob(w, job)
+}
+
+// journalFile returns the file in the JournalDir the journal of the layout is
+// kept in. Clients only name the layout, which must be a plain file name, so
+// that they cannot make the agent write anywhere else.
+func (s *Server) journalFile(layout string) (string, error) {
+	if layout == "" || layout == "." || layout == ".." || strings.ContainsAny(layout, `/\`) {
+		return "", model.InvalidArgumentError("layout name " + strconv.Quote(layout) + " cannot name a journal file")
+	}
+
+	return filepath.Join(s.JournalDir, layout+".json"), nil
+}
+
+// renderTemplate renders a template against the discovered block devices,
+// the way vogelkop apply --template does.
+func (s *Server) renderTemplate(r *http.Request, template *model.LayoutTemplate) (*model.StorageLayout, error) {
+	blockDevices, err := model.DiscoverBlockDevices()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := model.EnrichBlockDevices(s.requestContext(r), blockDevices); err != nil {
+		s.logger.Warnw("failed to collect ironlib inventory, matching on sysfs data only", "err", err)
+	}
+
+	return template.Render(blockDevices)
+}
+
+func (s *Server) wipe(w http.ResponseWriter, r *http.Request) {
+	var req wipeRequest
+	if !readJSON(w, r, &req) {
+		return
+	}
+
+	timeout, ok := parseDuration(w, "timeout", req.Timeout, defaultWipeTimeout)
+	if !ok {
+		return
+	}
+
+	if len(req.Devices) == 0 {
+		writeError(w, http.StatusBadRequest, model.InvalidArgumentError("at least one device is required"))
+		return
+	}
+
+	blockDevices, err := model.NewBlockDevices(req.Devices...)
+	if err != nil {
+		writeError(w, statusOf(err), err)
+		return
+	}
+
+	var (
+		files []string
+		locks []string
+	)
+
+	for _, bd := range blockDevices {
+		if slices.Contains(files, bd.File) {
+			writeError(w, http.StatusBadRequest, model.DuplicateDeviceError(bd.File))
+			return
+		}
+
+		files = append(files, bd.File)
+		locks = lock.Append(locks, lock.Device(bd.File))
+	}
+
+	job := s.submit("wipe", locks, func(ctx context.Context) (any, error) {
+		if !req.AllowInUse {
+			for _, bd := range blockDevices {
+				if err := bd.CheckNotInUse(ctx); err != nil {
+					return nil, err
+				}
+			}
+		}
+
+		ctx, cancel := context.WithTimeout(ctx, timeout)
+		defer cancel()
+
+		collector, err := ironlib.New(logging.Logrus(command.LoggerValueFromContext(ctx)))
+		if err != nil {
+			return nil, err
+		}
+
+		return model.WipeDrives(ctx, files, collector, false)
+	})
+
+	writeJob(w, job)
+}
+
+func (s *Server) createRaidArray(w http.ResponseWriter, r *http.Request) {
+	var req createRaidArrayRequest
+	if !readJSON(w, r, &req) {
+		return
+	}
+
+	array := &model.RaidArray{
+		Name:                    req.Name,
+		Level:                   req.Level,
+		RaidType:                req.RaidType,
+		ControllerVirtualDiskID: -1,
+		VirtualDisk:             req.VirtualDisk,
+	}
+
+	var locks []string
+
+	switch array.GetRaidType() {
+	case common.SlugRAIDImplLinuxSoftware:
+		locks = lock.SoftwareRaid(req.Name)
+
+		for _, d := range req.Devices {
+			array.Devices = append(array.Devices, &model.BlockDevice{ControllerPhysicalDeviceID: -1, File: d})
+			locks = lock.Append(locks, lock.Device(d))
+		}
+	case common.SlugRAIDImplHardware:
+		locks = []string{lock.HardwareRaid}
+
+		for _, d := range req.Devices {
+			id, err := strconv.Atoi(d)
+			if err != nil {
+				writeError(w, http.StatusBadRequest, model.InvalidArgumentError("hardware raid device "+d+" is not a physical device id"))
+				return
+			}
+
+			array.Devices = append(array.Devices, &model.BlockDevice{ControllerPhysicalDeviceID: id})
+		}
+	default:
+		writeError(w, http.StatusBadRequest, model.InvalidRaidTypeError(req.RaidType))
+		return
+	}
+
+	// A single array layout gets the validation and journaling of Apply.
+	layout := &model.StorageLayout{Name: "raid-" + req.Name, RaidArrays: []*model.RaidArray{array}}
+	if problems := layout.Validate(); len(problems) > 0 {
+		err := model.InvalidLayoutError(problems)
+		writeJSON(w, http.StatusBadRequest, errorResponse{Error: err.Error(), ErrorClass: model.ErrorClass(err), Problems: problems})
+		return
+	}
+
+	job := s.submit("raid-create", locks, func(ctx context.Context) (any, error) {
+		if err := layout.Requirements().Preflight(ctx); err != nil {
+			return nil, err
+		}
+
+		journal := &model.Journal{Layout: layout.Name, Status: model.JournalRunning, Started: time.Now().UTC()}
+
+		err := layout.Apply(ctx, &model.ApplyOptions{
+			Force:      req.Force,
+			AllowInUse: req.AllowInUse,
+			Journal:    journal,
+		})
+
+		return journal, err
+	})
+
+	writeJob(w, job)
+}
+
+func (s *Server) deleteRaidArray(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	raidType := raidType(r)
+
+	array := &model.RaidArray{Name: name}
+
+	// Hardware arrays may be addressed by their virtual disk ID.
+	if id, err := strconv.Atoi(name); err == nil {
+		array.ControllerVirtualDiskID = id
+	}
+
+	var locks []string
+
+	switch raidType {
+	case common.SlugRAIDImplLinuxSoftware:
+		locks = lock.SoftwareRaid(name)
+	case common.SlugRAIDImplHardware:
+		locks = []string{lock.HardwareRaid}
+	default:
+		writeError(w, http.StatusBadRequest, model.InvalidRaidTypeError(raidType))
+		return
+	}
+
+	job := s.submit("raid-delete", locks, func(ctx context.Context) (any, error) {
+		if err := model.RaidRequirements(raidType, "").Preflight(ctx); err != nil {
+			return nil, err
+		}
+
+		out, err := array.Delete(ctx, raidType)
+		if out != "" {
+			command.LoggerValueFromContext(ctx).Infow("raid array deleted", "array", name, "output", out)
+		}
+
+		return nil, err
+	})
+
+	writeJob(w, job)
+}
+
+func (s *Server) listJobs(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, http.StatusOK, s.allJobs())
+}
+
+func (s *Server) getJob(w http.ResponseWriter, r *http.Request) {
+	job, ok := s.job(r.PathValue("id"))
+	if !ok {
+		writeError(w, http.StatusNotFound, errors.New("no such job")) // nolint:goerr113
+		return
+	}
+
+	writeJSON(w, http.StatusOK, job)
+}
+
+// jobLogs writes the log of a job as JSON lines. With follow set, the log is
+// streamed until the job finishes or the client goes away.
+func (s *Server) jobLogs(w http.ResponseWriter, r *http.Request) {
+	job, ok := s.job(r.PathValue("id"))
+	if !ok {
+		writeError(w, http.StatusNotFound, errors.New("no such job")) // nolint:goerr113
+		return
+	}
+
+	follow, _ := strconv.ParseBool(r.URL.Query().Get("follow"))
+	rc := http.NewResponseController(w)
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+
+	for offset := 0; ; {
+		data, complete, changed := job.log.next(offset)
+		if _, err := w.Write(data); err != nil {
+			return
+		}
+
+		offset += len(data)
+
+		if !follow || complete {
+			return
+		}
+
+		if err := rc.Flush(); err != nil {
+			return
+		}
+
+		select {
+		case <-changed:
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// requestContext returns the context of a request carrying the agent logger.
+func (s *Server) requestContext(r *http.Request) context.Context {
+	return command.NewContextWithLogger(r.Context(), s.logger)
+}
+
+func raidType(r *http.Request) string {
+	if t := r.URL.Query().Get("raid_type"); t != "" {
+		return t
+	}
+
+	return common.SlugRAIDImplLinuxSoftware
+}
+
+// statusOf maps errors to HTTP status codes by their model.ErrorClass.
+func statusOf(err error) int {
+	switch model.ErrorClass(err) {
+	case model.ErrorClassInvalidInput, model.ErrorClassDeviceNotFound:
+		return http.StatusBadRequest
+	case model.ErrorClassDeviceInUse, model.ErrorClassStateConflict:
+		return http.StatusConflict
+	case model.ErrorClassTimeout:
+		return http.StatusGatewayTimeout
+	}
+
+	return http.StatusInternalServerError
+}
+
+func parseDuration(w http.ResponseWriter, field, value string, fallback time.Duration) (time.Duration, bool) {
+	if value == "" {
+		return fallback, true
+	}
+
+	d, err := time.ParseDuration(value)
+	if err != nil || d <= 0 {
+		writeError(w, http.StatusBadRequest, model.InvalidArgumentError(field+" must be a positive duration, got "+value))
+		return 0, false
+	}
+
+	return d, true
+}
+
+func readJSON(w http.ResponseWriter, r *http.Request, v any) bool {
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+
+	if err := decoder.Decode(v); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return false
+	}
+
+	return true
+}
+
+func writeJob(w http.ResponseWriter, job Job) {
+	w.Header().Set("Location", "/v1/jobs/"+job.ID)
+	writeJSON(w, http.StatusAccepted, job)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, errorResponse{Error: err.Error(), ErrorClass: model.ErrorClass(err)})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	// The status line is out already, there is nothing left to report a
+	// failed write to.
+	_ = json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,313 @@
+package agent
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+	"time"
+
+	"github.com/metal-toolbox/vogelkop/internal/audit"
+	"github.com/metal-toolbox/vogelkop/internal/command"
+	"github.com/metal-toolbox/vogelkop/internal/lock"
+	"github.com/metal-toolbox/vogelkop/pkg/model"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// Statuses of a Job.
+const (
+	JobQueued    = "queued"
+	JobRunning   = "running"
+	JobSucceeded = "succeeded"
+	JobFailed    = "failed"
+)
+
+// Job is an operation submitted to the agent. A job stays queued until it
+// holds the locks of all its devices.
+type Job struct {
+	ID        string `json:"id"`
+	Operation string `json:"operation"`
+	// Devices are the lock names of the devices the job changes, usually
+	// the kernel names of whole disks.
+	Devices []string `json:"devices"`
+	Status  string   `json:"status"`
+	Error   string   `json:"error,omitempty"`
+	// ErrorClass is the model.ErrorClass of Error.
+	ErrorClass string `json:"error_class,omitempty"`
+	// Indeterminate lists the devices an operation that was interrupted, by
+	// a timeout or by the agent shutting down, may have left half changed.
+	Indeterminate []string   `json:"indeterminate,omitempty"`
+	Created       time.Time  `json:"created"`
+	Started       *time.Time `json:"started,omitempty"`
+	Finished      *time.Time `json:"finished,omitempty"`
+	Result        any        `json:"result,omitempty"`
+
+	log *jobLog
+}
+
+// operation does the work of a job. The result is reported even if the
+// operation fails.
+type operation func(ctx context.Context) (result any, err error)
+
+// submit queues an operation and returns a snapshot of its job.
+func (s *Server) submit(name string, devices []string, op operation) Job {
+	j := &Job{
+		ID:        newJobID(),
+		Operation: name,
+		Devices:   devices,
+		Status:    JobQueued,
+		Created:   time.Now().UTC(),
+		log:       newJobLog(),
+	}
+
+	s.mu.Lock()
+	s.jobs[j.ID] = j
+	s.order = append(s.order, j.ID)
+	snapshot := *j
+	s.mu.Unlock()
+
+	s.running.Add(1)
+
+	go s.run(j, op)
+
+	return snapshot
+}
+
+func (s *Server) run(j *Job, op operation) {
+	defer s.running.Done()
+	defer j.log.close()
+
+	log := s.jobLogger(j)
+	recorder := &command.Recorder{}
+	ctx := command.NewContextWithLogger(s.jobContext(), log)
+	ctx = command.NewContextWithRecorder(ctx, recorder)
+
+	log.Infow("job queued", "devices", j.Devices)
+
+	s.locks.acquire(j.ID, j.Devices, func(device, holder string) {
+		log.Infow("waiting for device", "device", device, "held_by", holder)
+	})
+
+	s.update(j, func() {
+		now := time.Now().UTC()
+		j.Status = JobRunning
+		j.Started = &now
+	})
+
+	log.Infow("job started")
+
+	description := "vogelkop serve job " + j.ID + " " + j.Operation
+	entry := audit.NewEntry(j.Operation, description)
+	entry.Targets = j.Devices
+
+	var auditLog *audit.Log
+
+	result, err := func() (result any, err error) {
+		defer func() {
+			if panicErr := recovered(recover()); panicErr != nil {
+				err = panicErr
+			}
+		}()
+
+		// Like the CLI, a job that cannot be recorded does not run.
+		if s.AuditLog != "" {
+			if auditLog, err = audit.Open(s.AuditLog); err != nil {
+				return nil, err
+			}
+
+			entry.Devices = audit.Identify(j.Devices)
+		}
+
+		fileLock, err := lock.Acquire(ctx, j.Devices, s.LockTimeout, description)
+		if err != nil {
+			return nil, err
+		}
+		defer fileLock.Release()
+
+		return op(ctx)
+	}()
+
+	// Devices are free again, and the job is recorded, by the time the job is
+	// seen finished.
+	s.locks.release(j.Devices)
+
+	indeterminate := recorder.Indeterminate()
+
+	if auditLog != nil {
+		entry.Finish(err, indeterminate)
+
+		if auditErr := auditLog.Write(entry); auditErr != nil {
+			log.Errorw("failed to write audit log", "err", auditErr, "audit_log", s.AuditLog)
+		}
+
+		auditLog.Close()
+	}
+
+	s.update(j, func() {
+		now := time.Now().UTC()
+		j.Finished = &now
+		j.Result = result
+		j.Status = JobSucceeded
+
+		if err != nil {
+			j.Status = JobFailed
+			j.Error = err.Error()
+			j.ErrorClass = model.ErrorClass(err)
+		}
+
+		j.Indeterminate = indeterminate
+
+		s.prune()
+	})
+
+	if len(indeterminate) > 0 {
+		log.Warnw("devices may have been left in an indeterminate state", "devices", indeterminate)
+	}
+
+	if err != nil {
+		log.Errorw("job failed", "err", err, "indeterminate", indeterminate)
+	} else {
+		log.Infow("job succeeded")
+	}
+
+	if syncErr := log.Sync(); syncErr != nil {
+		s.logger.Debugw("job logger failed to sync", "err", syncErr, "job", j.ID)
+	}
+}
+
+// jobContext returns the context new jobs run with.
+func (s *Server) jobContext() context.Context {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.ctx
+}
+
+// jobLogger returns a logger writing to the agent log and to the log of the
+// job, which is what GET /v1/jobs/{id}/logs returns.
+func (s *Server) jobLogger(j *Job) *zap.SugaredLogger {
+	encoder := zapcore.NewJSONEncoder(zap.NewProductionEncoderConfig())
+	core := zapcore.NewTee(
+		s.logger.Desugar().Core(),
+		zapcore.NewCore(encoder, zapcore.AddSync(j.log), zapcore.DebugLevel),
+	)
+
+	return zap.New(core).Sugar().With("job", j.ID, "operation", j.Operation)
+}
+
+// update changes a job while holding the server lock.
+func (s *Server) update(j *Job, change func()) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	change()
+}
+
+// prune forgets the oldest finished jobs beyond the JobHistory, so that a
+// long running agent does not keep every job. The caller holds the server
+// lock.
+func (s *Server) prune() {
+	finished := 0
+
+	for _, id := range s.order {
+		if s.jobs[id].Finished != nil {
+			finished++
+		}
+	}
+
+	kept := s.order[:0]
+
+	for _, id := range s.order {
+		if s.jobs[id].Finished != nil && finished > s.JobHistory {
+			delete(s.jobs, id)
+			finished--
+
+			continue
+		}
+
+		kept = append(kept, id)
+	}
+
+	s.order = kept
+}
+
+// job returns a snapshot of the job with the given ID.
+func (s *Server) job(id string) (j Job, ok bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	found, ok := s.jobs[id]
+	if ok {
+		j = *found
+	}
+
+	return
+}
+
+// allJobs returns snapshots of all jobs in the order they were submitted.
+func (s *Server) allJobs() []Job {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	jobs := make([]Job, 0, len(s.order))
+	for _, id := range s.order {
+		jobs = append(jobs, *s.jobs[id])
+	}
+
+	return jobs
+}
+
+func newJobID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+
+	return hex.EncodeToString(b)
+}
+
+// jobLog keeps the log lines of a job and wakes up readers following it.
+type jobLog struct {
+	mu      sync.Mutex
+	data    []byte
+	closed  bool
+	changed chan struct{}
+}
+
+func newJobLog() *jobLog {
+	return &jobLog{changed: make(chan struct{})}
+}
+
+func (l *jobLog) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.data = append(l.data, p...)
+	l.notify()
+
+	return len(p), nil
+}
+
+// close marks the log complete once the job has finished.
+func (l *jobLog) close() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.closed = true
+	l.notify()
+}
+
+// next returns the log from offset on, whether the log is complete and a
+// channel that is closed when the log changes.
+func (l *jobLog) next(offset int) (data []byte, complete bool, changed <-chan struct{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.data[offset:], l.closed, l.changed
+}
+
+func (l *jobLog) notify() {
+	close(l.changed)
+	l.changed = make(chan struct{})
+}
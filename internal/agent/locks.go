@@ -0,0 +1,77 @@
+package agent
+
+import (
+	"sync"
+)
+
+// deviceLocks hands out exclusive access to devices among the jobs of the
+// agent. A job takes all of its devices at once or none of them, so two jobs
+// never deadlock each other. Jobs then take the lock files of their devices
+// as well, see lock.Acquire, to exclude other vogelkop processes.
+type deviceLocks struct {
+	mu      sync.Mutex
+	holders map[string]string
+	changed chan struct{}
+}
+
+func newDeviceLocks() *deviceLocks {
+	return &deviceLocks{
+		holders: make(map[string]string),
+		changed: make(chan struct{}),
+	}
+}
+
+// acquire blocks until none of the devices is held by another job and then
+// takes all of them for owner. waiting is called whenever the job starts
+// waiting for a different device or holder.
+func (l *deviceLocks) acquire(owner string, devices []string, waiting func(device, holder string)) {
+	var lastDevice, lastHolder string
+
+	for {
+		l.mu.Lock()
+
+		device, holder := l.heldBy(devices)
+		if holder == "" {
+			for _, d := range devices {
+				l.holders[d] = owner
+			}
+
+			l.mu.Unlock()
+
+			return
+		}
+
+		changed := l.changed
+		l.mu.Unlock()
+
+		if device != lastDevice || holder != lastHolder {
+			waiting(device, holder)
+			lastDevice, lastHolder = device, holder
+		}
+
+		<-changed
+	}
+}
+
+// heldBy returns the first of the devices that is held and its holder.
+func (l *deviceLocks) heldBy(devices []string) (device, holder string) {
+	for _, d := range devices {
+		if h, ok := l.holders[d]; ok {
+			return d, h
+		}
+	}
+
+	return "", ""
+}
+
+func (l *deviceLocks) release(devices []string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for _, d := range devices {
+		delete(l.holders, d)
+	}
+
+	close(l.changed)
+	l.changed = make(chan struct{})
+}
@@ -0,0 +1,286 @@
+// Package audit keeps a durable local record of every operation vogelkop
+// performs that changes devices. The audit log is an append-only file of
+// JSON lines, one Entry per operation, that survives across runs and is
+// shared by the CLI and the agent.
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/metal-toolbox/vogelkop/pkg/model"
+	"golang.org/x/sys/unix"
+)
+
+// DefaultPath is where the audit log is kept unless configured otherwise.
+const DefaultPath = "/var/log/vogelkop/audit.jsonl"
+
+// Device identifies a changed device independently of its kernel name,
+// which may differ after a reboot.
+type Device struct {
+	// Name is the lock name of the device, see the lock package.
+	Name   string `json:"name"`
+	File   string `json:"file,omitempty"`
+	Serial string `json:"serial,omitempty"`
+	WWN    string `json:"wwn,omitempty"`
+	Model  string `json:"model,omitempty"`
+}
+
+// Entry is the record of one operation.
+type Entry struct {
+	// Time is when the operation started.
+	Time time.Time `json:"time"`
+	Host string    `json:"host"`
+	User string    `json:"user"`
+	UID  int       `json:"uid"`
+	// SudoUser is the user who ran vogelkop through sudo, if any.
+	SudoUser string `json:"sudo_user,omitempty"`
+	PID      int    `json:"pid"`
+	// Command is the command line, or the agent job for operations run by
+	// the agent.
+	Command   string   `json:"command"`
+	Operation string   `json:"operation"`
+	Targets   []string `json:"targets"`
+	Devices   []Device `json:"devices"`
+	Success   bool     `json:"success"`
+	// ErrorClass is the model.ErrorClass of Error.
+	ErrorClass string `json:"error_class,omitempty"`
+	Error      string `json:"error,omitempty"`
+	// Indeterminate lists the devices an interrupted operation may have
+	// left half changed.
+	Indeterminate []string `json:"indeterminate,omitempty"`
+	// Duration is in milliseconds.
+	Duration int64 `json:"duration_ms"`
+}
+
+// NewEntry returns an Entry for an operation starting now, describing the
+// host and the user running it.
+func NewEntry(operation, command string) *Entry {
+	e := &Entry{
+		Time:      time.Now().UTC(),
+		UID:       os.Getuid(),
+		SudoUser:  os.Getenv("SUDO_USER"),
+		PID:       os.Getpid(),
+		Command:   command,
+		Operation: operation,
+		Targets:   []string{},
+		Devices:   []Device{},
+	}
+
+	e.Host, _ = os.Hostname()
+
+	e.User = strconv.Itoa(e.UID)
+	if u, err := user.LookupId(e.User); err == nil {
+		e.User = u.Username
+	}
+
+	return e
+}
+
+// Finish records the outcome of the operation.
+func (e *Entry) Finish(err error, indeterminate []string) {
+	e.Success = err == nil
+	e.Duration = time.Since(e.Time).Milliseconds()
+	e.Indeterminate = indeterminate
+
+	if err != nil {
+		e.Error = err.Error()
+		e.ErrorClass = model.ErrorClass(err)
+	}
+}
+
+// Identify looks up the identity of devices given by their lock names.
+// Names that are not disks, such as md arrays, are recorded as they are.
+func Identify(names []string) (devices []Device) {
+	blockDevices, _ := model.DiscoverBlockDevices()
+
+	for _, name := range names {
+		d := Device{Name: name}
+
+		file := name
+		if !filepath.IsAbs(file) {
+			file = filepath.Join("/dev", name)
+		}
+
+		for _, bd := range blockDevices {
+			if bd.File == file {
+				d.File, d.Serial, d.WWN, d.Model = bd.File, bd.Serial, bd.WWN, bd.Model
+			}
+		}
+
+		devices = append(devices, d)
+	}
+
+	return
+}
+
+// Log is an audit log opened for appending.
+type Log struct {
+	f *os.File
+}
+
+// Open opens the audit log at file for appending, creating it and its
+// directory if needed. Operations open the log before they change anything,
+// so that an unwritable log stops them instead of going unrecorded.
+func Open(file string) (*Log, error) {
+	if err := os.MkdirAll(filepath.Dir(file), 0o700); err != nil {
+		return nil, err
+	}
+
+	f, err := os.OpenFile(file, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Log{f: f}, nil
+}
+
+// Write appends an entry and syncs it to disk. Entries of concurrent
+// processes are kept apart by holding a lock on the file while writing.
+func (l *Log) Write(e *Entry) error {
+	line, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	if err := unix.Flock(int(l.f.Fd()), unix.LOCK_EX); err != nil {
+		return err
+	}
+
+	defer unix.Flock(int(l.f.Fd()), unix.LOCK_UN) // nolint:errcheck // closing the file unlocks it too
+
+	if _, err := l.f.Write(append(line, '\n')); err != nil {
+		return err
+	}
+
+	return l.f.Sync()
+}
+
+// Close closes the audit log. It is safe to call on a nil Log.
+func (l *Log) Close() error {
+	if l == nil {
+		return nil
+	}
+
+	return l.f.Close()
+}
+
+// Filter selects audit log entries. Empty fields match every entry.
+type Filter struct {
+	// Device matches the lock name, device file, serial or WWN of a changed
+	// device, or a target of the operation. serial:<serial> and wwn:<wwn>
+	// only match the serial or WWN.
+	Device    string
+	Operation string
+	Since     time.Time
+	Until     time.Time
+}
+
+// Match reports whether the entry is selected by the filter.
+func (f *Filter) Match(e *Entry) bool {
+	if f.Operation != "" && f.Operation != e.Operation {
+		return false
+	}
+
+	if !f.Since.IsZero() && e.Time.Before(f.Since) {
+		return false
+	}
+
+	if !f.Until.IsZero() && e.Time.After(f.Until) {
+		return false
+	}
+
+	return f.Device == "" || f.matchesDevice(e)
+}
+
+func (f *Filter) matchesDevice(e *Entry) bool {
+	key, value, _ := strings.Cut(f.Device, ":")
+
+	for _, d := range e.Devices {
+		switch key {
+		case model.SelectorSerial:
+			if d.Serial != "" && strings.EqualFold(d.Serial, value) {
+				return true
+			}
+		case model.SelectorWWN:
+			if d.WWN != "" && strings.EqualFold(strings.TrimPrefix(d.WWN, "0x"), strings.TrimPrefix(value, "0x")) {
+				return true
+			}
+		default:
+			for _, id := range []string{d.Name, d.File, d.Serial, d.WWN} {
+				if id != "" && id == f.Device {
+					return true
+				}
+			}
+		}
+	}
+
+	for _, t := range e.Targets {
+		if t == f.Device {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Read returns the entries of the audit log at file that match the filter,
+// oldest first. A log that does not exist yet has no entries.
+func Read(file string, f Filter) (entries []*Entry, err error) {
+	r, err := os.Open(file)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+
+	if err != nil {
+		return
+	}
+	defer r.Close()
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 16*1024*1024)
+
+	for line := 1; scanner.Scan(); line++ {
+		if len(strings.TrimSpace(scanner.Text())) == 0 {
+			continue
+		}
+
+		e := &Entry{}
+		if err = json.Unmarshal(scanner.Bytes(), e); err != nil {
+			return nil, model.InvalidArgumentError(file + " line " + strconv.Itoa(line) + ": " + err.Error())
+		}
+
+		if f.Match(e) {
+			entries = append(entries, e)
+		}
+	}
+
+	err = scanner.Err()
+
+	return
+}
+
+// ParseTime parses the time of a Filter: an RFC 3339 time, a date, or a
+// duration meaning that long before now.
+func ParseTime(s string, now time.Time) (t time.Time, err error) {
+	if d, durationErr := time.ParseDuration(s); durationErr == nil {
+		return now.Add(-d), nil
+	}
+
+	for _, layout := range []string{time.RFC3339, time.DateOnly} {
+		if t, err = time.Parse(layout, s); err == nil {
+			return
+		}
+	}
+
+	err = model.InvalidArgumentError("time " + s)
+
+	return
+}
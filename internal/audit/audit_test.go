@@ -0,0 +1,111 @@
+package audit
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/metal-toolbox/vogelkop/pkg/model"
+)
+
+func TestLog(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "vogelkop", "audit.jsonl")
+
+	if entries, err := Read(file, Filter{}); err != nil || entries != nil {
+		t.Fatalf("expected no entries before the log exists, got %v %v", entries, err)
+	}
+
+	start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	entries := []*Entry{
+		{Time: start, Operation: "disk wipe", Targets: []string{"serial:S1"}, Devices: []Device{{Name: "sda", File: "/dev/sda", Serial: "S1", WWN: "0x5000c500a1b2c3d4"}}},
+		{Time: start.Add(time.Hour), Operation: "raid create", Devices: []Device{{Name: "md/root"}, {Name: "sdb", Serial: "S2"}}},
+		{Time: start.Add(2 * time.Hour), Operation: "disk partition", Targets: []string{"/dev/sda"}, Devices: []Device{{Name: "sda", Serial: "S1"}}},
+	}
+
+	entries[1].Finish(model.DeviceNotFoundError("/dev/sdb"), nil)
+
+	// Each run opens the log anew and appends to it.
+	for _, e := range entries {
+		l, err := Open(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := l.Write(e); err != nil {
+			t.Fatal(err)
+		}
+
+		l.Close()
+	}
+
+	if fi, err := os.Stat(file); err != nil || fi.Mode().Perm() != 0o600 {
+		t.Errorf("unexpected audit log file %v %v", fi, err)
+	}
+
+	cases := []struct {
+		filter Filter
+		want   []string
+	}{
+		{Filter{}, []string{"disk wipe", "raid create", "disk partition"}},
+		{Filter{Device: "sda"}, []string{"disk wipe", "disk partition"}},
+		{Filter{Device: "/dev/sda"}, []string{"disk wipe", "disk partition"}},
+		{Filter{Device: "serial:s2"}, []string{"raid create"}},
+		{Filter{Device: "wwn:5000C500A1B2C3D4"}, []string{"disk wipe"}},
+		{Filter{Device: "md/root"}, []string{"raid create"}},
+		{Filter{Device: "S1", Since: start.Add(time.Minute)}, []string{"disk partition"}},
+		{Filter{Until: start.Add(time.Hour)}, []string{"disk wipe", "raid create"}},
+		{Filter{Operation: "raid create"}, []string{"raid create"}},
+		{Filter{Device: "sdc"}, nil},
+	}
+
+	for _, c := range cases {
+		found, err := Read(file, c.filter)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var operations []string
+		for _, e := range found {
+			operations = append(operations, e.Operation)
+		}
+
+		if len(operations) != len(c.want) {
+			t.Errorf("filter %+v: expected %v, got %v", c.filter, c.want, operations)
+			continue
+		}
+
+		for i := range operations {
+			if operations[i] != c.want[i] {
+				t.Errorf("filter %+v: expected %v, got %v", c.filter, c.want, operations)
+				break
+			}
+		}
+	}
+
+	found, _ := Read(file, Filter{Operation: "raid create"})
+	if len(found) != 1 || found[0].Success || found[0].ErrorClass != model.ErrorClassDeviceNotFound {
+		t.Errorf("unexpected failed entry %+v", found)
+	}
+}
+
+func TestParseTime(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	cases := map[string]time.Time{
+		"24h":                  now.Add(-24 * time.Hour),
+		"2024-04-30":           time.Date(2024, 4, 30, 0, 0, 0, 0, time.UTC),
+		"2024-04-30T08:00:00Z": time.Date(2024, 4, 30, 8, 0, 0, 0, time.UTC),
+	}
+
+	for s, want := range cases {
+		if got, err := ParseTime(s, now); err != nil || !got.Equal(want) {
+			t.Errorf("%s: expected %s, got %s %v", s, want, got, err)
+		}
+	}
+
+	if _, err := ParseTime("yesterday", now); !errors.Is(err, model.ErrInvalidArgument) {
+		t.Errorf("expected invalid argument, got %v", err)
+	}
+}
@@ -1,45 +1,294 @@
 package command
 
 import (
+	"bytes"
 	"context"
 	"errors"
 	"fmt"
-	"os"
+	"io"
 	"os/exec"
+	"slices"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
 
-	zaphook "github.com/Sytten/logrus-zap-hook"
-	"github.com/sirupsen/logrus"
 	"go.uber.org/zap"
 )
 
-var ErrFailedExecution = errors.New("failed execution")
+// cancelGracePeriod is how long a command gets to exit after it was sent
+// SIGTERM because its context was cancelled, before it is killed.
+var cancelGracePeriod = 10 * time.Second
+
+var (
+	ErrFailedExecution = errors.New("failed execution")
+	ErrToolMissing     = errors.New("tool missing")
+)
 
 func FailedExecutionError(cmdPath, errMsg string) error {
 	return fmt.Errorf("FailedExecution %w : %s \"%s\"", ErrFailedExecution, cmdPath, errMsg)
 }
 
+func ToolMissingError(cmdName string, err error) error {
+	return fmt.Errorf("ToolMissing %w : %s (%w)", ErrToolMissing, cmdName, err)
+}
+
+// ExecutionError is returned by Call when a command fails. It matches
+// ErrFailedExecution and keeps the exit status and stderr of the command
+// apart from the message. A command killed because the context expired also
+// matches the error of the context.
+type ExecutionError struct {
+	Command string
+	// ExitCode is -1 if the command was killed by a signal.
+	ExitCode int
+	Stderr   string
+	Err      error
+}
+
+func (e *ExecutionError) Error() string {
+	return FailedExecutionError(e.Command, e.Err.Error()).Error()
+}
+
+func (e *ExecutionError) Unwrap() []error {
+	return []error{ErrFailedExecution, e.Err}
+}
+
+// Call runs an external command and returns its combined stdout and stderr.
+// The output is logged line by line at debug level to the logger of the
+// context while the command runs, and the call is added to the Recorder of
+// the context, if there is one. When the context is cancelled the command
+// gets SIGTERM, and SIGKILL if it has not exited after a grace period.
 func Call(ctx context.Context, cmdName string, cmdOptions ...string) (out string, err error) {
+	_, out, err = run(ctx, nil, cmdName, cmdOptions...)
+	return
+}
+
+// CallWithStdin is Call with stdin fed to the command, for tools that ask
+// for confirmation.
+func CallWithStdin(ctx context.Context, stdin io.Reader, cmdName string, cmdOptions ...string) (out string, err error) {
+	_, out, err = run(ctx, stdin, cmdName, cmdOptions...)
+	return
+}
+
+// Output is Call returning only the stdout of the command, for output that
+// is parsed and must not be mixed with warnings on stderr.
+func Output(ctx context.Context, cmdName string, cmdOptions ...string) (stdout string, err error) {
+	execution, _, err := run(ctx, nil, cmdName, cmdOptions...)
+	stdout = execution.Stdout
+
+	return
+}
+
+func run(ctx context.Context, stdin io.Reader, cmdName string, cmdOptions ...string) (execution *Execution, out string, err error) {
+	execution = &Execution{Command: cmdName, Args: cmdOptions, ExitCode: -1}
+	started := time.Now()
+
+	defer func() {
+		execution.Duration = time.Since(started).Milliseconds()
+		if err != nil {
+			execution.Error = err.Error()
+		}
+
+		RecorderValueFromContext(ctx).record(execution)
+	}()
+
 	cmdPath, err := exec.LookPath(cmdName)
 	if err != nil {
+		err = ToolMissingError(cmdName, err)
 		return
 	}
 
+	var (
+		combined       lockedBuffer
+		stdout, stderr bytes.Buffer
+	)
+
+	logger := LoggerValueFromContext(ctx)
+	stdoutLines := &lineLogger{logger: logger, command: cmdName, stream: "stdout"}
+	stderrLines := &lineLogger{logger: logger, command: cmdName, stream: "stderr"}
+
 	cmd := exec.CommandContext(ctx, cmdPath, cmdOptions...)
+	cmd.Cancel = func() error { return cmd.Process.Signal(syscall.SIGTERM) }
+	cmd.WaitDelay = cancelGracePeriod
+	cmd.Stdin = stdin
+	cmd.Stdout = io.MultiWriter(&combined, &stdout, stdoutLines)
+	cmd.Stderr = io.MultiWriter(&combined, &stderr, stderrLines)
 
-	outB, err := cmd.CombinedOutput()
-	out = string(outB)
+	err = cmd.Run()
+	out = combined.String()
+
+	stdoutLines.flush()
+	stderrLines.flush()
+
+	execution.Command = cmdPath
+	execution.ExitCode = cmd.ProcessState.ExitCode()
+	execution.Stdout = stdout.String()
+	execution.Stderr = stderr.String()
 
 	if err != nil {
-		err = FailedExecutionError(cmdPath, err.Error())
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			err = fmt.Errorf("%w: %w", err, ctxErr)
+			// A command that never started left nothing half done.
+			execution.Interrupted = cmd.ProcessState != nil
+		}
+
+		err = &ExecutionError{Command: cmdPath, ExitCode: execution.ExitCode, Stderr: execution.Stderr, Err: err}
+
 		return
 	}
 
 	return
 }
 
+// lineLogger logs what is written to it line by line at debug level. A
+// trailing partial line is logged by flush.
+type lineLogger struct {
+	logger  *zap.SugaredLogger
+	command string
+	stream  string
+	partial []byte
+}
+
+func (l *lineLogger) Write(p []byte) (int, error) {
+	if l.logger == nil {
+		return len(p), nil
+	}
+
+	l.partial = append(l.partial, p...)
+
+	for {
+		i := bytes.IndexByte(l.partial, '\n')
+		if i < 0 {
+			break
+		}
+
+		l.log(string(l.partial[:i]))
+		l.partial = l.partial[i+1:]
+	}
+
+	return len(p), nil
+}
+
+func (l *lineLogger) flush() {
+	if len(l.partial) > 0 {
+		l.log(string(l.partial))
+		l.partial = nil
+	}
+}
+
+func (l *lineLogger) log(line string) {
+	l.logger.Debugw(strings.TrimRight(line, "\r"), "command", l.command, "stream", l.stream)
+}
+
+// lockedBuffer is a bytes.Buffer that stdout and stderr can be copied into
+// concurrently.
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.buf.String()
+}
+
+// Execution is a call of an external command.
+type Execution struct {
+	Command string   `json:"command"`
+	Args    []string `json:"args"`
+	// ExitCode is -1 if the command could not be started or was killed.
+	ExitCode int    `json:"exit_code"`
+	Stdout   string `json:"stdout"`
+	Stderr   string `json:"stderr"`
+	// Duration is in milliseconds.
+	Duration int64  `json:"duration_ms"`
+	Error    string `json:"error,omitempty"`
+	// Interrupted is set if the command was stopped because its context
+	// was cancelled or timed out.
+	Interrupted bool `json:"interrupted,omitempty"`
+}
+
+// Recorder collects the external commands called with a context, and the
+// devices operations left in an indeterminate state.
+type Recorder struct {
+	mu            sync.Mutex
+	executions    []*Execution
+	indeterminate []string
+}
+
+func (r *Recorder) record(e *Execution) {
+	if r == nil {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.executions = append(r.executions, e)
+}
+
+// Executions returns the commands recorded so far.
+func (r *Recorder) Executions() []*Execution {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return slices.Clone(r.executions)
+}
+
+// MarkIndeterminate records devices an interrupted operation that does not
+// go through Call, such as an ironlib wipe, left in an unknown state.
+func (r *Recorder) MarkIndeterminate(devices ...string) {
+	if r == nil {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.indeterminate = append(r.indeterminate, devices...)
+}
+
+// Indeterminate returns the devices that interrupted operations may have
+// left half changed: the marked ones and the device files interrupted
+// commands were working on.
+func (r *Recorder) Indeterminate() (devices []string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	devices = slices.Clone(r.indeterminate)
+
+	for _, e := range r.executions {
+		if !e.Interrupted {
+			continue
+		}
+
+		for _, arg := range e.Args {
+			if strings.HasPrefix(arg, "/dev/") {
+				devices = append(devices, arg)
+			}
+		}
+	}
+
+	slices.Sort(devices)
+
+	return slices.Compact(devices)
+}
+
 type contextKey string
 
-var contextLoggerKey = contextKey("logger")
+var (
+	contextLoggerKey   = contextKey("logger")
+	contextRecorderKey = contextKey("recorder")
+)
 
 func NewContextWithLogger(existingCtx context.Context, l *zap.SugaredLogger) context.Context {
 	ctx := context.WithValue(existingCtx, contextLoggerKey, l)
@@ -51,20 +300,11 @@ func LoggerValueFromContext(ctx context.Context) *zap.SugaredLogger {
 	return logger
 }
 
-// ZapToLogrus takes a context and converts the zap.SugaredLogger available
-// within the context as "logger" to a logrus logger and returns it.
-func ZapToLogrus(ctx context.Context) (ll *logrus.Logger, err error) {
-	ll = logrus.New()
-	ll.ReportCaller = true
-	ll.SetOutput(os.Stderr)
-	sugaredLogger := LoggerValueFromContext(ctx)
-	zapLogger := sugaredLogger.Desugar()
-	hook, err := zaphook.NewZapHook(zapLogger)
-	if err != nil {
-		return nil, err
-	}
-
-	ll.Hooks.Add(hook)
+func NewContextWithRecorder(existingCtx context.Context, r *Recorder) context.Context {
+	return context.WithValue(existingCtx, contextRecorderKey, r)
+}
 
-	return
+func RecorderValueFromContext(ctx context.Context) *Recorder {
+	r, _ := ctx.Value(contextRecorderKey).(*Recorder)
+	return r
 }
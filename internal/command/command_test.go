@@ -0,0 +1,128 @@
+package command
+
+import (
+	"context"
+	"errors"
+	"os/exec"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestCallRecordsExecutions(t *testing.T) {
+	recorder := &Recorder{}
+	ctx := NewContextWithRecorder(context.Background(), recorder)
+
+	out, err := Call(ctx, "sh", "-c", "echo out; echo err >&2")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Stdout and stderr are read concurrently, so only their lines keep their order.
+	if len(out) != len("out\nerr\n") || !strings.Contains(out, "out\n") || !strings.Contains(out, "err\n") {
+		t.Errorf("combined output is %q", out)
+	}
+
+	_, err = Call(ctx, "sh", "-c", "echo broken >&2; exit 3")
+
+	var execErr *ExecutionError
+	if !errors.Is(err, ErrFailedExecution) || !errors.As(err, &execErr) || execErr.ExitCode != 3 || execErr.Stderr != "broken\n" {
+		t.Errorf("expected failed execution with exit status and stderr, got %#v", err)
+	}
+
+	_, err = Call(ctx, "vogelkop-does-not-exist")
+	if !errors.Is(err, ErrToolMissing) || !errors.Is(err, exec.ErrNotFound) {
+		t.Errorf("expected missing tool, got %v", err)
+	}
+
+	timeoutCtx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+
+	if _, err = Call(timeoutCtx, "sleep", "5"); !errors.Is(err, context.DeadlineExceeded) || !errors.Is(err, ErrFailedExecution) {
+		t.Errorf("expected timeout, got %v", err)
+	}
+
+	executions := recorder.Executions()
+	if len(executions) != 4 {
+		t.Fatalf("expected 4 executions, got %d", len(executions))
+	}
+
+	if e := executions[0]; e.ExitCode != 0 || e.Stdout != "out\n" || e.Stderr != "err\n" || e.Error != "" || len(e.Args) != 2 {
+		t.Errorf("unexpected successful execution %+v", e)
+	}
+
+	if e := executions[1]; e.ExitCode != 3 || e.Stderr != "broken\n" || e.Error == "" {
+		t.Errorf("unexpected failed execution %+v", e)
+	}
+
+	if e := executions[2]; e.ExitCode != -1 || e.Command != "vogelkop-does-not-exist" || e.Error == "" {
+		t.Errorf("unexpected missing command execution %+v", e)
+	}
+
+	// Calls without a recorder are not recorded anywhere.
+	if _, err := Call(context.Background(), "true"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCallStreamsOutputAndTakesStdin(t *testing.T) {
+	core, logs := observer.New(zapcore.DebugLevel)
+	ctx := NewContextWithLogger(context.Background(), zap.New(core).Sugar())
+
+	out, err := CallWithStdin(ctx, strings.NewReader("y\n"), "sh", "-c", "read answer; echo \"answer $answer\"; echo warning >&2; printf partial")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(out, "answer y\n") || !strings.Contains(out, "warning\n") {
+		t.Errorf("combined output is %q", out)
+	}
+
+	lines := map[string]string{}
+	for _, e := range logs.All() {
+		lines[e.Message] = e.ContextMap()["stream"].(string)
+	}
+
+	if lines["answer y"] != "stdout" || lines["warning"] != "stderr" || lines["partial"] != "stdout" || len(lines) != 3 {
+		t.Errorf("unexpected logged lines %v", lines)
+	}
+
+	stdout, err := Output(ctx, "sh", "-c", "echo data; echo warning >&2")
+	if err != nil || stdout != "data\n" {
+		t.Errorf("expected only stdout, got %q %v", stdout, err)
+	}
+}
+
+func TestCallCancelled(t *testing.T) {
+	recorder := &Recorder{}
+	ctx, cancel := context.WithCancel(NewContextWithRecorder(context.Background(), recorder))
+
+	time.AfterFunc(100*time.Millisecond, cancel)
+
+	// The script is stopped with SIGTERM first and may clean up.
+	out, err := Call(ctx, "sh", "-c", "trap 'echo stopping; kill $!; exit 1' TERM; sleep 5 & wait", "sh", "/dev/vogelkop-test")
+	if !errors.Is(err, context.Canceled) || !strings.Contains(out, "stopping") {
+		t.Errorf("expected graceful cancellation, got %q %v", out, err)
+	}
+
+	// Commands are not started once the context is cancelled.
+	if _, err := Call(ctx, "true", "/dev/vogelkop-other"); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected cancelled call, got %v", err)
+	}
+
+	executions := recorder.Executions()
+	if len(executions) != 2 || !executions[0].Interrupted || executions[1].Interrupted {
+		t.Errorf("unexpected executions %+v", executions)
+	}
+
+	recorder.MarkIndeterminate("/dev/sdb")
+
+	if devices := recorder.Indeterminate(); !slices.Equal(devices, []string{"/dev/sdb", "/dev/vogelkop-test"}) {
+		t.Errorf("unexpected indeterminate devices %v", devices)
+	}
+}
@@ -0,0 +1,303 @@
+// Package lock provides advisory per-device locks, so that concurrent
+// vogelkop processes, or jobs of the agent, never change the same device at
+// the same time. Locks are flock(2) locks on files under Dir, one per device,
+// and are released by the kernel if the holder dies.
+package lock
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/bmc-toolbox/common"
+	"github.com/metal-toolbox/vogelkop/pkg/model"
+	"golang.org/x/sys/unix"
+)
+
+// HardwareRaid is the lock name of the configuration of the RAID
+// controller.
+const HardwareRaid = "raid-controller"
+
+// Dir holds the lock files. The flock on the block device itself is not
+// used, as udev does not process a device while it is held, and operations
+// wait for udev to create partition device nodes.
+var Dir = "/run/vogelkop/locks"
+
+var sysfsRoot = "/sys"
+
+// pollInterval is how often a held lock is retried until the timeout.
+var pollInterval = 100 * time.Millisecond
+
+// Holder describes the process holding a lock. It is written into the lock
+// file so that operations waiting for the lock can report it.
+type Holder struct {
+	PID     int       `json:"pid"`
+	Command string    `json:"command"`
+	Started time.Time `json:"started"`
+}
+
+func (h *Holder) String() string {
+	return fmt.Sprintf("pid %d (%s) since %s", h.PID, h.Command, h.Started.Format(time.RFC3339))
+}
+
+// Lock is a set of held device locks.
+type Lock struct {
+	files []*os.File
+}
+
+// Acquire takes the locks of all names for the command described, waiting
+// up to timeout for locks held by others. The locks are taken in sorted
+// order so that processes waiting for each other cannot deadlock. If a lock
+// is still held after the timeout, none are taken and the error is a
+// model.DeviceLockedError naming the holder.
+func Acquire(ctx context.Context, names []string, timeout time.Duration, command string) (l *Lock, err error) {
+	if err = os.MkdirAll(Dir, 0o700); err != nil {
+		return
+	}
+
+	holder, err := json.Marshal(&Holder{PID: os.Getpid(), Command: command, Started: time.Now().UTC()})
+	if err != nil {
+		return
+	}
+
+	names = slices.Clone(names)
+	slices.Sort(names)
+
+	deadline := time.Now().Add(timeout)
+	l = &Lock{}
+
+	for _, name := range slices.Compact(names) {
+		var f *os.File
+
+		if f, err = lockFile(ctx, name, deadline); err != nil {
+			l.Release()
+			return nil, err
+		}
+
+		l.files = append(l.files, f)
+
+		if err = f.Truncate(0); err == nil {
+			_, err = f.WriteAt(holder, 0)
+		}
+
+		if err != nil {
+			l.Release()
+			return nil, err
+		}
+	}
+
+	return
+}
+
+// lockFile opens and locks the lock file of name, retrying until deadline.
+func lockFile(ctx context.Context, name string, deadline time.Time) (*os.File, error) {
+	// md/<name> and the like become md!<name>, as in sysfs.
+	file := filepath.Join(Dir, strings.ReplaceAll(name, "/", "!")+".lock")
+
+	f, err := os.OpenFile(file, os.O_RDWR|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		err = unix.Flock(int(f.Fd()), unix.LOCK_EX|unix.LOCK_NB)
+		if err == nil {
+			return f, nil
+		}
+
+		if !errors.Is(err, unix.EWOULDBLOCK) {
+			f.Close()
+			return nil, err
+		}
+
+		if !time.Now().Before(deadline) {
+			f.Close()
+			return nil, model.DeviceLockedError(name, holderOf(file))
+		}
+
+		select {
+		case <-ctx.Done():
+			f.Close()
+			return nil, ctx.Err()
+		case <-time.After(pollInterval):
+		}
+	}
+}
+
+// holderOf describes the holder recorded in a lock file.
+func holderOf(file string) string {
+	var h Holder
+
+	b, err := os.ReadFile(file)
+	if err != nil || json.Unmarshal(b, &h) != nil {
+		return "another process"
+	}
+
+	return h.String()
+}
+
+// Release releases all locks. It is safe to call on a nil Lock.
+func (l *Lock) Release() {
+	if l == nil {
+		return
+	}
+
+	for _, f := range l.files {
+		// Closing the last descriptor of the file releases the flock.
+		f.Close()
+	}
+
+	l.files = nil
+}
+
+// Device returns the name a device file or selector is locked under: the
+// kernel name of the disk it is, or the disk the partition belongs to.
+// Devices that cannot be resolved are locked under the name given.
+func Device(device string) string {
+	file, err := model.ResolveSelector(device)
+	if err != nil {
+		return device
+	}
+
+	if resolved, err := filepath.EvalSymlinks(file); err == nil {
+		file = resolved
+	}
+
+	name := filepath.Base(file)
+
+	if _, err := os.Stat(filepath.Join(sysfsRoot, "class", "block", name, "partition")); err == nil {
+		if disk, err := filepath.EvalSymlinks(filepath.Join(sysfsRoot, "class", "block", name)); err == nil {
+			return filepath.Base(filepath.Dir(disk))
+		}
+	}
+
+	return name
+}
+
+// SoftwareRaid returns the lock names of a software RAID array and, if it is
+// running, the disks of its members.
+func SoftwareRaid(name string) (names []string) {
+	names = append(names, "md/"+name)
+
+	file, err := filepath.EvalSymlinks(filepath.Join("/dev/md", name))
+	if err != nil {
+		return
+	}
+
+	slaves, _ := os.ReadDir(filepath.Join(sysfsRoot, "class", "block", filepath.Base(file), "slaves"))
+	for _, slave := range slaves {
+		names = Append(names, Device(filepath.Join("/dev", slave.Name())))
+	}
+
+	return
+}
+
+// RaidArray returns the lock names of a RAID array of the given type and its
+// member devices.
+func RaidArray(raidType, name string, members []string) (names []string) {
+	if raidType == common.SlugRAIDImplHardware {
+		return []string{HardwareRaid}
+	}
+
+	names = SoftwareRaid(name)
+	for _, m := range members {
+		names = Append(names, Device(m))
+	}
+
+	return
+}
+
+// NVMeController returns the lock names of an NVMe controller and the disks
+// of its attached namespaces.
+func NVMeController(file string) (names []string) {
+	controller := filepath.Base(file)
+	names = append(names, controller)
+
+	entries, _ := os.ReadDir(filepath.Join(sysfsRoot, "class", "nvme", controller))
+	for _, e := range entries {
+		if strings.HasPrefix(e.Name(), controller+"n") {
+			names = Append(names, e.Name())
+		}
+	}
+
+	return
+}
+
+// Layout returns the lock names of everything a layout changes.
+func Layout(layout *model.StorageLayout) (names []string, err error) {
+	for _, n := range layout.NVMeNamespaces {
+		var file string
+
+		if file, err = model.NVMeControllerFile(n.Controller); err != nil {
+			return
+		}
+
+		for _, name := range NVMeController(file) {
+			names = Append(names, name)
+		}
+	}
+
+	for _, bd := range layout.BlockDevices {
+		resolved := *bd
+		if err = resolved.Resolve(); err != nil {
+			return
+		}
+
+		names = Append(names, Device(resolved.File))
+	}
+
+	for _, a := range layout.RaidArrays {
+		var members []string
+		for _, d := range a.Devices {
+			members = append(members, d.File)
+		}
+
+		for _, name := range RaidArray(a.GetRaidType(), a.Name, members) {
+			names = Append(names, name)
+		}
+	}
+
+	return
+}
+
+// Journal returns the lock names of everything rolling back a journal
+// changes.
+func Journal(j *model.Journal) (names []string) {
+	for _, s := range j.Steps {
+		switch s.Kind {
+		case model.StepRaidArray:
+			for _, name := range RaidArray(s.RaidType, s.Name, s.Members) {
+				names = Append(names, name)
+			}
+
+			continue
+		case model.StepNVMeNamespaces:
+			for _, name := range NVMeController(s.Device) {
+				names = Append(names, name)
+			}
+
+			continue
+		}
+
+		if s.Device != "" {
+			names = Append(names, Device(s.Device))
+		}
+	}
+
+	return
+}
+
+// Append adds a lock name unless it is already listed.
+func Append(names []string, name string) []string {
+	if slices.Contains(names, name) {
+		return names
+	}
+
+	return append(names, name)
+}
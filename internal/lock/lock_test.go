@@ -0,0 +1,92 @@
+package lock
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bmc-toolbox/common"
+	"github.com/metal-toolbox/vogelkop/pkg/model"
+)
+
+func TestAcquire(t *testing.T) {
+	Dir = t.TempDir()
+	ctx := context.Background()
+
+	held, err := Acquire(ctx, []string{"sda", "md/root", "sda"}, 0, "vogelkop apply --layout a.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// flock locks of separate open files exclude each other within one
+	// process as well.
+	_, err = Acquire(ctx, []string{"sdb", "md/root"}, 0, "vogelkop disk wipe")
+	if !errors.Is(err, model.ErrDeviceLocked) || model.ErrorClass(err) != model.ErrorClassDeviceInUse {
+		t.Fatalf("expected locked device, got %v", err)
+	}
+
+	for _, want := range []string{"md/root", "pid " + strconv.Itoa(os.Getpid()), "vogelkop apply --layout a.json"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q does not mention %q", err, want)
+		}
+	}
+
+	// sdb was released again when md/root could not be taken.
+	other, err := Acquire(ctx, []string{"sdb"}, 0, "vogelkop disk wipe")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	other.Release()
+
+	time.AfterFunc(200*time.Millisecond, held.Release)
+
+	waited, err := Acquire(ctx, []string{"sda"}, 5*time.Second, "vogelkop disk partition")
+	if err != nil {
+		t.Fatalf("expected the lock after waiting, got %v", err)
+	}
+	defer waited.Release()
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+
+	if _, err := Acquire(cancelled, []string{"sda"}, time.Minute, "vogelkop disk wipe"); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected cancelled wait, got %v", err)
+	}
+}
+
+func TestJournalLocks(t *testing.T) {
+	j := &model.Journal{Steps: []*model.JournalStep{
+		{Kind: model.StepPartition, Device: "/dev/vogelkop-sda"},
+		{Kind: model.StepRaidArray, Name: "root", RaidType: common.SlugRAIDImplLinuxSoftware, Members: []string{"/dev/vogelkop-sda", "/dev/vogelkop-sdb"}},
+		{Kind: model.StepRaidArray, Name: "0", RaidType: common.SlugRAIDImplHardware},
+	}}
+
+	if names := Journal(j); !slices.Equal(names, []string{"/dev/vogelkop-sda", "md/root", "/dev/vogelkop-sdb", HardwareRaid}) {
+		t.Errorf("unexpected lock names %v", names)
+	}
+}
+
+func TestNVMeControllerLocks(t *testing.T) {
+	root := t.TempDir()
+	defer func(saved string) { sysfsRoot = saved }(sysfsRoot)
+	sysfsRoot = root
+
+	for _, dir := range []string{"nvme0n1", "nvme0n2", "ng0n1", "power"} {
+		if err := os.MkdirAll(filepath.Join(root, "class", "nvme", "nvme0", dir), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	j := &model.Journal{Steps: []*model.JournalStep{{Kind: model.StepNVMeNamespaces, Device: "/dev/nvme0"}}}
+
+	if names := Journal(j); !slices.Equal(names, []string{"nvme0", "nvme0n1", "nvme0n2"}) {
+		t.Errorf("unexpected lock names %v", names)
+	}
+}
@@ -0,0 +1,113 @@
+package logging
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/sirupsen/logrus"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// Formats of New.
+const (
+	FormatJSON    = "json"
+	FormatConsole = "console"
+)
+
+var ErrInvalidFormat = errors.New("invalid log format")
+
+func InvalidFormatError(format string) error {
+	return fmt.Errorf("InvalidFormat %w : %s", ErrInvalidFormat, format)
+}
+
+// New builds the logger every command shares. Format is FormatJSON or
+// FormatConsole and level is debug, info, warn or error.
+func New(format, level string, opts ...zap.Option) (*zap.Logger, error) {
+	var cfg zap.Config
+
+	switch format {
+	case FormatJSON:
+		cfg = zap.NewProductionConfig()
+	case FormatConsole:
+		cfg = zap.NewDevelopmentConfig()
+	default:
+		return nil, InvalidFormatError(format)
+	}
+
+	l, err := zap.ParseAtomicLevel(level)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg.Level = l
+	// Development loggers panic on DPanic and add stack traces to warnings.
+	cfg.Development = false
+
+	return cfg.Build(opts...)
+}
+
+// Logrus returns a logrus logger for libraries that need one, such as
+// ironlib. It writes nothing itself: every entry goes to l with its fields,
+// so that library logs share the format, level and fields of l.
+func Logrus(l *zap.SugaredLogger) *logrus.Logger {
+	ll := logrus.New()
+	ll.SetOutput(io.Discard)
+	ll.SetFormatter(discardFormatter{})
+	ll.SetLevel(logrusLevel(l.Level()))
+	ll.AddHook(&zapHook{logger: l.WithOptions(zap.WithCaller(false))})
+
+	return ll
+}
+
+// logrusLevel maps a zap level to the logrus level that lets the same
+// entries through. Libraries log command output at trace level, which zap
+// has no level for, so it is shown at debug.
+func logrusLevel(level zapcore.Level) logrus.Level {
+	switch {
+	case level <= zapcore.DebugLevel:
+		return logrus.TraceLevel
+	case level == zapcore.InfoLevel:
+		return logrus.InfoLevel
+	case level == zapcore.WarnLevel:
+		return logrus.WarnLevel
+	default:
+		return logrus.ErrorLevel
+	}
+}
+
+type discardFormatter struct{}
+
+func (discardFormatter) Format(*logrus.Entry) ([]byte, error) {
+	return nil, nil
+}
+
+type zapHook struct {
+	logger *zap.SugaredLogger
+}
+
+func (h *zapHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+func (h *zapHook) Fire(e *logrus.Entry) error {
+	fields := make([]any, 0, 2*len(e.Data))
+	for k, v := range e.Data {
+		fields = append(fields, k, v)
+	}
+
+	switch e.Level {
+	case logrus.TraceLevel, logrus.DebugLevel:
+		h.logger.Debugw(e.Message, fields...)
+	case logrus.InfoLevel:
+		h.logger.Infow(e.Message, fields...)
+	case logrus.WarnLevel:
+		h.logger.Warnw(e.Message, fields...)
+	default:
+		// Fatal and panic entries are left to logrus to act on.
+		h.logger.Errorw(e.Message, fields...)
+	}
+
+	return nil
+}
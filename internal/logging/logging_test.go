@@ -0,0 +1,59 @@
+package logging
+
+import (
+	"errors"
+	"testing"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestNew(t *testing.T) {
+	for _, format := range []string{FormatJSON, FormatConsole} {
+		l, err := New(format, "warn")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if l.Level() != zapcore.WarnLevel {
+			t.Errorf("%s logger has level %s", format, l.Level())
+		}
+	}
+
+	if _, err := New("logfmt", "info"); !errors.Is(err, ErrInvalidFormat) {
+		t.Errorf("expected invalid format, got %v", err)
+	}
+
+	if _, err := New(FormatJSON, "loud"); err == nil {
+		t.Error("expected invalid level")
+	}
+}
+
+func TestLogrus(t *testing.T) {
+	core, logs := observer.New(zapcore.DebugLevel)
+	ll := Logrus(zap.New(core).Sugar().With("device", "/dev/sda"))
+
+	ll.WithField("util", "hdparm").Trace("command output")
+	ll.WithError(errors.New("busy")).Error("wipe failed")
+
+	entries := logs.All()
+	if len(entries) != 2 {
+		t.Fatalf("expected 2 entries, got %d", len(entries))
+	}
+
+	if e := entries[0]; e.Level != zapcore.DebugLevel || e.Message != "command output" || e.ContextMap()["util"] != "hdparm" || e.ContextMap()["device"] != "/dev/sda" {
+		t.Errorf("unexpected trace entry %+v", e)
+	}
+
+	if e := entries[1]; e.Level != zapcore.ErrorLevel || e.ContextMap()["error"] != "busy" {
+		t.Errorf("unexpected error entry %+v %v", e, e.ContextMap())
+	}
+
+	core, logs = observer.New(zapcore.InfoLevel)
+	Logrus(zap.New(core).Sugar()).Debug("hidden")
+
+	if logs.Len() != 0 {
+		t.Errorf("debug entry passed an info logger")
+	}
+}
@@ -0,0 +1,480 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"os"
+	"time"
+
+	"github.com/bmc-toolbox/common"
+)
+
+// Policies for ApplyOptions.OnError.
+const (
+	// OnErrorStop stops at the first failure and leaves the journal behind,
+	// so that the next run resumes where this one stopped.
+	OnErrorStop = "stop"
+	// OnErrorRollback undoes every completed step in reverse order.
+	OnErrorRollback = "rollback"
+	// OnErrorContinue carries on with the remaining steps and reports all
+	// failures at the end.
+	OnErrorContinue = "continue"
+)
+
+// ApplyOptions control how a StorageLayout is applied.
+type ApplyOptions struct {
+	// Force replaces partitions, arrays and filesystems that exist but do
+	// not match the layout.
+	Force bool
+	// AllowInUse permits modifying devices that are in use.
+	AllowInUse bool
+	// SettleTimeout bounds the wait for partition device nodes.
+	SettleTimeout time.Duration
+	// OnError is OnErrorStop (the default), OnErrorRollback or
+	// OnErrorContinue.
+	OnError string
+	// Journal records the completed steps. A journal kept in memory is used
+	// if none is given.
+	Journal *Journal
+}
+
+// applier carries the state of one StorageLayout.Apply run.
+type applier struct {
+	layout  *StorageLayout
+	opts    *ApplyOptions
+	journal *Journal
+	errs    []error
+}
+
+// Apply converges the system towards the StorageLayout. NVMe namespaces
+// are created first, then hardware RAID arrays, the partitions of every block device,
+// software RAID arrays, encrypted volumes, LVM volume groups and finally
+// filesystems. Objects that already match the layout are left alone, see
+// Partition.Ensure and RaidArray.Ensure. Every change is recorded in the
+// journal and opts.OnError decides what happens when a step fails.
+// Nothing is changed if the layout fails Validate.
+func (l *StorageLayout) Apply(ctx context.Context, opts *ApplyOptions) (err error) {
+	if problems := l.Validate(); len(problems) > 0 {
+		return InvalidLayoutError(problems)
+	}
+
+	switch opts.OnError {
+	case "", OnErrorStop, OnErrorRollback, OnErrorContinue:
+	default:
+		return InvalidOnErrorPolicyError(opts.OnError)
+	}
+
+	a := &applier{layout: l, opts: opts, journal: opts.Journal}
+	if a.journal == nil {
+		a.journal = &Journal{Layout: l.Name, Status: JournalRunning, Started: time.Now().UTC()}
+	}
+
+	a.run(ctx)
+
+	log := contextLogger(ctx)
+	err = errors.Join(a.errs...)
+
+	switch {
+	case err == nil:
+		log.Infow("storage layout applied", "layout", l.Name, "changes", len(a.journal.Steps))
+		err = a.journal.finish(JournalComplete)
+	case opts.OnError == OnErrorRollback:
+		log.Warnw("applying storage layout failed, rolling back", "layout", l.Name, "err", err)
+
+		if rollbackErr := a.journal.Rollback(ctx); rollbackErr != nil {
+			err = errors.Join(err, rollbackErr)
+		}
+	default:
+		if done := a.journal.done(); opts.OnError == OnErrorContinue && done > 0 {
+			err = PartialSuccessError(done, len(a.errs), err)
+		}
+
+		if finishErr := a.journal.finish(JournalFailed); finishErr != nil {
+			err = errors.Join(err, finishErr)
+		}
+	}
+
+	return
+}
+
+// failed records a failure. It returns whether Apply has to stop.
+func (a *applier) failed(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	a.errs = append(a.errs, err)
+
+	return a.opts.OnError != OnErrorContinue
+}
+
+// record journals a step that changed the system or failed trying to.
+func (a *applier) record(step *JournalStep, changed bool, err error) error {
+	if !changed && err == nil {
+		return nil
+	}
+
+	if journalErr := a.journal.record(step, err); journalErr != nil {
+		return errors.Join(err, journalErr)
+	}
+
+	return err
+}
+
+func (a *applier) run(ctx context.Context) {
+	l := a.layout
+
+	for _, n := range l.NVMeNamespaces {
+		if a.failed(a.nvmeNamespaces(ctx, n)) {
+			return
+		}
+	}
+
+	for _, array := range l.RaidArrays {
+		if array.GetRaidType() == common.SlugRAIDImplHardware && a.failed(a.raidArray(ctx, array)) {
+			return
+		}
+	}
+
+	for _, bd := range l.BlockDevices {
+		if a.failed(a.partitions(ctx, bd)) {
+			return
+		}
+	}
+
+	for _, array := range l.RaidArrays {
+		if array.GetRaidType() == common.SlugRAIDImplLinuxSoftware && a.failed(a.raidArray(ctx, array)) {
+			return
+		}
+	}
+
+	for _, e := range l.EncryptedVolumes {
+		if a.failed(a.encryptedVolume(ctx, e)) {
+			return
+		}
+	}
+
+	for _, g := range l.VolumeGroups {
+		if a.failed(a.volumeGroup(ctx, g)) {
+			return
+		}
+	}
+
+	for _, bd := range l.BlockDevices {
+		for _, p := range bd.Partitions {
+			if p.FileSystem == "" {
+				continue
+			}
+
+			file, err := p.GetBlockDevice(bd.File)
+			if err == nil {
+				err = a.fileSystem(ctx, file, p.FileSystem, p.FileSystemOptions)
+			}
+
+			if a.failed(err) {
+				return
+			}
+		}
+	}
+
+	for _, fs := range l.FileSystems {
+		file, err := l.DeviceFile(fs.Name)
+		if err == nil {
+			err = a.fileSystem(ctx, file, fs.Format, fs.Options)
+		}
+
+		if a.failed(err) {
+			return
+		}
+	}
+}
+
+// nvmeNamespaces divides an NVMe controller into namespaces, checking
+// first that none of the namespaces it replaces is in use.
+func (a *applier) nvmeNamespaces(ctx context.Context, n *NVMeNamespaceLayout) (err error) {
+	c, differences, err := n.Check(ctx)
+	if err != nil || len(differences) == 0 {
+		return
+	}
+
+	step := &JournalStep{Kind: StepNVMeNamespaces, Device: c.File}
+
+	if !a.opts.AllowInUse {
+		for _, ns := range c.Namespaces {
+			if ns.File == "" {
+				continue
+			}
+
+			if err = (&BlockDevice{File: ns.File}).CheckNotInUse(ctx); err != nil {
+				return a.record(step, false, err)
+			}
+		}
+	}
+
+	changed, err := n.ensure(ctx, c, differences, a.opts.Force)
+	if err = a.record(step, changed, err); err == nil && changed {
+		contextLogger(ctx).Infow("nvme namespaces created", "controller", c.File, "namespaces", len(n.Namespaces))
+	}
+
+	return
+}
+
+// partitions creates the partitions of the BlockDevice and waits for their
+// device nodes to appear. With OnErrorContinue a failed partition is recorded
+// and the remaining partitions are still created.
+func (a *applier) partitions(ctx context.Context, b *BlockDevice) (err error) {
+	if err = b.Resolve(); err != nil {
+		return
+	}
+
+	if !b.Validate() {
+		return BlockDeviceFailedValidationError(b)
+	}
+
+	guarded := a.opts.AllowInUse
+	changed := false
+
+	var positions []uint
+
+	for _, p := range b.Partitions {
+		created, partitionErr := a.partition(ctx, b, p, &guarded)
+		if partitionErr != nil {
+			if a.opts.OnError != OnErrorContinue {
+				return partitionErr
+			}
+
+			a.errs = append(a.errs, partitionErr)
+
+			continue
+		}
+
+		positions = append(positions, p.Position)
+		changed = changed || created
+	}
+
+	if len(positions) == 0 {
+		return
+	}
+
+	if changed {
+		if err = b.Rescan(ctx); err != nil {
+			return
+		}
+	}
+
+	return b.WaitForPartitions(ctx, positions, a.opts.SettleTimeout)
+}
+
+// partition creates one partition, checking once per device that it is not
+// in use before the first change.
+func (a *applier) partition(ctx context.Context, b *BlockDevice, lp *Partition, guarded *bool) (created bool, err error) {
+	// Work on a copy so the layout does not end up referencing itself.
+	p := *lp
+	p.BlockDevice = b
+
+	exists, differences, err := p.Check(ctx)
+	if err != nil || (exists && len(differences) == 0) {
+		return
+	}
+
+	step := &JournalStep{Kind: StepPartition, Device: b.File, Position: p.Position, Name: p.Name}
+
+	if !*guarded {
+		if err = b.CheckNotInUse(ctx); err != nil {
+			return false, a.record(step, false, err)
+		}
+
+		*guarded = true
+	}
+
+	_, created, err = p.Ensure(ctx, a.opts.Force)
+	if err = a.record(step, created, err); err == nil && created {
+		contextLogger(ctx).Infow("partition created", "partition", p.description())
+	}
+
+	return
+}
+
+// raidArray creates a hardware RAID array, or a software RAID array from its
+// explicit devices and the partitions named after its PartitionName.
+func (a *applier) raidArray(ctx context.Context, array *RaidArray) (err error) {
+	raidType := array.GetRaidType()
+	ensured := *array
+
+	if raidType == common.SlugRAIDImplLinuxSoftware {
+		if ensured.Devices, err = a.layout.ArrayMembers(array); err != nil {
+			return
+		}
+	}
+
+	exists, differences, err := ensured.Check(ctx, raidType)
+	if err != nil || (exists && len(differences) == 0) {
+		return
+	}
+
+	step := &JournalStep{Kind: StepRaidArray, Name: array.Name, RaidType: raidType}
+
+	if raidType == common.SlugRAIDImplLinuxSoftware {
+		step.Device = "/dev/md/" + array.Name
+
+		for _, bd := range ensured.Devices {
+			step.Members = append(step.Members, bd.File)
+		}
+
+		if !a.opts.AllowInUse {
+			if err = checkArrayMembersNotInUse(ctx, array.Name, ensured.Devices); err != nil {
+				return a.record(step, false, err)
+			}
+		}
+
+		if mismatches, geometryErr := CheckArrayMembers(ensured.Devices, DefaultMemberSizeTolerance); geometryErr == nil && len(mismatches) > 0 {
+			contextLogger(ctx).Warnw("raid array members are mismatched", "err", MismatchedMembersError(array.Name, mismatches))
+		}
+	}
+
+	changed, err := ensured.Ensure(ctx, raidType, a.opts.Force)
+	if err == nil && changed && raidType == common.SlugRAIDImplHardware {
+		// Rollback destroys the virtual disk by its controller ID.
+		if vd, findErr := ensured.findVirtualDisk(ctx); findErr == nil && vd != nil {
+			step.VirtualDiskID = vd.ID
+		}
+	}
+
+	if err = a.record(step, changed, err); err == nil && changed {
+		contextLogger(ctx).Infow("raid array created", "array", ensured.description())
+	}
+
+	return
+}
+
+// checkArrayMembersNotInUse makes sure the members of a software RAID array
+// are unused, apart from belonging to the array itself.
+func checkArrayMembersNotInUse(ctx context.Context, name string, members []*BlockDevice) error {
+	arrayName, _ := kernelName("/dev/md/" + name)
+
+	for _, bd := range members {
+		usages, err := bd.InUse(ctx)
+		if err != nil {
+			return err
+		}
+
+		for _, u := range usages {
+			if u.Kind != "md" || u.Holder != arrayName {
+				return DeviceInUseError(bd.File, usages)
+			}
+		}
+	}
+
+	return nil
+}
+
+// encryptedVolume formats and opens an encrypted volume.
+func (a *applier) encryptedVolume(ctx context.Context, e *EncryptedVolume) (err error) {
+	file, err := a.layout.DeviceFile(e.Device)
+	if err != nil {
+		return
+	}
+
+	if _, statErr := os.Stat(e.MappedFile()); statErr == nil {
+		return
+	}
+
+	step := &JournalStep{Kind: StepEncryptedVolume, Name: e.Name, Device: file}
+
+	if !a.opts.AllowInUse {
+		if err = (&BlockDevice{File: file}).CheckNotInUse(ctx); err != nil {
+			return a.record(step, false, err)
+		}
+	}
+
+	changed, err := e.Ensure(ctx, file, a.opts.Force)
+	if err = a.record(step, changed, err); err == nil && changed {
+		contextLogger(ctx).Infow("encrypted volume opened", "name", e.Name, "device", file)
+	}
+
+	return
+}
+
+// volumeGroup creates a volume group and its logical volumes.
+func (a *applier) volumeGroup(ctx context.Context, g *VolumeGroup) (err error) {
+	var pvFiles []string
+
+	for _, name := range g.PhysicalVolumes {
+		var file string
+
+		if file, err = a.layout.DeviceFile(name); err != nil {
+			return
+		}
+
+		pvFiles = append(pvFiles, file)
+	}
+
+	step := &JournalStep{Kind: StepVolumeGroup, Name: g.Name, Members: pvFiles}
+
+	if _, exists, pvErr := g.physicalVolumes(ctx); pvErr == nil && !exists && !a.opts.AllowInUse {
+		for _, file := range pvFiles {
+			if err = (&BlockDevice{File: file}).CheckNotInUse(ctx); err != nil {
+				return a.record(step, false, err)
+			}
+		}
+	}
+
+	changed, err := g.Ensure(ctx, pvFiles, a.opts.Force)
+	if err = a.record(step, changed, err); err != nil {
+		return
+	}
+
+	if changed {
+		contextLogger(ctx).Infow("volume group created", "name", g.Name, "physical_volumes", pvFiles)
+	}
+
+	for _, lv := range g.LogicalVolumes {
+		step := &JournalStep{Kind: StepLogicalVolume, Name: g.Name + "/" + lv.Name, Device: g.LogicalVolumeFile(lv)}
+
+		changed, err = g.EnsureLogicalVolume(ctx, lv)
+		if err = a.record(step, changed, err); err != nil {
+			if a.opts.OnError != OnErrorContinue {
+				return
+			}
+
+			a.errs = append(a.errs, err)
+
+			continue
+		}
+
+		if changed {
+			contextLogger(ctx).Infow("logical volume created", "name", step.Name)
+		}
+	}
+
+	return nil
+}
+
+// fileSystem creates a filesystem on a device file.
+func (a *applier) fileSystem(ctx context.Context, file, format string, options []string) (err error) {
+	p := &Partition{
+		FileSystem:        format,
+		FileSystemOptions: options,
+		BlockDevice:       &BlockDevice{ControllerPhysicalDeviceID: -1, File: file},
+	}
+
+	current, err := p.CurrentFileSystem(ctx)
+	if err != nil || current == format {
+		return
+	}
+
+	step := &JournalStep{Kind: StepFileSystem, Name: format, Device: file}
+
+	if !a.opts.AllowInUse {
+		if err = p.BlockDevice.CheckNotInUse(ctx); err != nil {
+			return a.record(step, false, err)
+		}
+	}
+
+	_, changed, err := p.EnsureFormat(ctx, a.opts.Force)
+	if err = a.record(step, changed, err); err == nil && changed {
+		contextLogger(ctx).Infow("filesystem created", "device", file, "format", format)
+	}
+
+	return
+}
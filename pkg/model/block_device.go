@@ -6,18 +6,49 @@ import (
 )
 
 type BlockDevice struct {
-	WWN                        string       `json:"wwn"`
+	WWN      string `json:"wwn"`
+	Serial   string `json:"serial"`
+	Vendor   string `json:"vendor"`
+	Model    string `json:"model"`
+	Firmware string `json:"firmware"`
+	Size     uint64 `json:"size"`
+	// MediaType is one of hdd, ssd, nvme or virtual.
+	MediaType          string `json:"media_type"`
+	Rotational         bool   `json:"rotational"`
+	Transport          string `json:"transport"`
+	LogicalSectorSize  uint64 `json:"logical_sector_size"`
+	PhysicalSectorSize uint64 `json:"physical_sector_size"`
+	// OptimalIOSize is the I/O size the device prefers, such as the stripe
+	// width of a RAID array, or 0 if it reports none.
+	OptimalIOSize uint64 `json:"optimal_io_size"`
+	// AlignmentOffset is how many bytes the start of the device is off its
+	// physical sector boundary, which is not 0 on a few 512e disks.
+	AlignmentOffset    uint64 `json:"alignment_offset"`
+	PartitionTableType string `json:"partition_table_type"`
+	// FileSystem is the signature found directly on the device, if any.
+	FileSystem string `json:"file_system"`
+	// Holders are the kernel names of devices stacked on top of the device.
+	Holders []string `json:"holders"`
+	// Selector identifies the device independently of its kernel name, see
+	// ResolveSelector. It takes precedence over File when set.
+	Selector                   string       `json:"selector"`
 	File                       string       `json:"file"`
 	ControllerPhysicalDeviceID int          `json:"controller_physical_device_id"`
 	Partitions                 []*Partition `json:"partitions"`
 }
 
+// NewBlockDevice returns a BlockDevice for a device file or a selector (see
+// ResolveSelector), resolved to the kernel device file it currently refers to.
 func NewBlockDevice(file string) (bd *BlockDevice, err error) {
 	bd = &BlockDevice{
 		ControllerPhysicalDeviceID: -1,
 		File:                       file,
 	}
 
+	if err = bd.Resolve(); err != nil {
+		return
+	}
+
 	if !bd.Validate() {
 		err = BlockDeviceFailedValidationError(bd)
 		return
@@ -40,11 +71,11 @@ func NewBlockDeviceFromPhysicalDeviceID(physicalDeviceID int) (bd *BlockDevice,
 }
 
 // NewBlockDevices returns a slice of BlockDevice(s) for the supplied
-// slice of strings listing device files.
+// slice of strings listing device files or selectors.
 func NewBlockDevices(devices ...string) (blockDevices []*BlockDevice, err error) {
 	for _, dev := range devices {
 		bd, bdErr := NewBlockDevice(dev)
-		if err != nil {
+		if bdErr != nil {
 			return blockDevices, bdErr
 		}
 
@@ -59,7 +90,7 @@ func NewBlockDevices(devices ...string) (blockDevices []*BlockDevice, err error)
 func NewBlockDevicesFromPhysicalDeviceIDs(devices ...int) (blockDevices []*BlockDevice, err error) {
 	for _, dev := range devices {
 		bd, bdErr := NewBlockDeviceFromPhysicalDeviceID(dev)
-		if err != nil {
+		if bdErr != nil {
 			return blockDevices, bdErr
 		}
 
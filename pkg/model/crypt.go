@@ -0,0 +1,78 @@
+package model
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+
+	"github.com/metal-toolbox/vogelkop/internal/command"
+)
+
+// EncryptedVolume is a LUKS encrypted device, opened as /dev/mapper/<Name>.
+// Device names a raid array or partition of the StorageLayout, or is a device
+// file.
+type EncryptedVolume struct {
+	Name   string `json:"name"`
+	Device string `json:"device"`
+	// Type is luks2 (the default) or luks1.
+	Type string `json:"type"`
+	// KeyFile holds the passphrase. It is only needed to create or open the
+	// volume and is never exported.
+	KeyFile string `json:"key_file"`
+	UUID    string `json:"uuid"`
+}
+
+// GetType returns the LUKS version of the volume, defaulting to luks2.
+func (e *EncryptedVolume) GetType() string {
+	if e.Type == "" {
+		return "luks2"
+	}
+
+	return e.Type
+}
+
+// MappedFile returns the device file of the opened volume.
+func (e *EncryptedVolume) MappedFile() string {
+	return filepath.Join(devRoot, "mapper", e.Name)
+}
+
+// Ensure formats the device file with LUKS unless it already is a LUKS device
+// and opens it unless it is already open. Another signature on the device is
+// reported as a StateConflictError unless force is set.
+// It returns whether anything changed and an error.
+func (e *EncryptedVolume) Ensure(ctx context.Context, file string, force bool) (changed bool, err error) {
+	if _, statErr := os.Stat(e.MappedFile()); statErr == nil {
+		return
+	}
+
+	if e.KeyFile == "" {
+		err = MissingKeyFileError(e.Name)
+		return
+	}
+
+	current, err := (&Partition{BlockDevice: &BlockDevice{File: file}}).CurrentFileSystem(ctx)
+	if err != nil {
+		return
+	}
+
+	switch {
+	case current == "crypto_LUKS":
+	case current != "" && !force:
+		err = StateConflictError("encrypted volume "+e.Name, []Difference{{Field: "device " + file, Want: "crypto_LUKS", Have: current}})
+		return
+	default:
+		args := []string{"luksFormat", "--batch-mode", "--type", e.GetType(), "--key-file", e.KeyFile}
+		if e.UUID != "" {
+			args = append(args, "--uuid", e.UUID)
+		}
+
+		if _, err = command.Call(ctx, "cryptsetup", append(args, file)...); err != nil {
+			return
+		}
+	}
+
+	_, err = command.Call(ctx, "cryptsetup", "open", "--key-file", e.KeyFile, file, e.Name)
+	changed = err == nil
+
+	return
+}
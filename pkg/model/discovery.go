@@ -0,0 +1,226 @@
+package model
+
+import (
+	"bufio"
+	"cmp"
+	"context"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+var (
+	devRoot  = "/dev"
+	udevRoot = "/run/udev"
+)
+
+// DiscoverBlockDevices returns a BlockDevice for every whole disk known to
+// the kernel, as listed in /sys/block. Devices without any capacity, such as
+// unbound loop devices or empty card readers, are skipped.
+func DiscoverBlockDevices() (blockDevices []*BlockDevice, err error) {
+	entries, err := os.ReadDir(filepath.Join(sysfsRoot, "block"))
+	if err != nil {
+		return
+	}
+
+	for _, e := range entries {
+		bd := discoverBlockDevice(e.Name())
+		if bd.Size == 0 {
+			continue
+		}
+
+		blockDevices = append(blockDevices, bd)
+	}
+
+	return
+}
+
+// discoverBlockDevice fills in a BlockDevice for the disk with the given
+// kernel name from sysfs and the udev database.
+func discoverBlockDevice(name string) *BlockDevice {
+	props := udevProperties(name)
+	attribute := func(path ...string) string {
+		return readSysfsAttribute(filepath.Join(append([]string{"block", name}, path...)...))
+	}
+
+	bd := &BlockDevice{
+		ControllerPhysicalDeviceID: -1,
+		File:                       filepath.Join(devRoot, name),
+		Vendor:                     firstNonEmpty(attribute("device", "vendor"), props["ID_VENDOR"]),
+		Model:                      firstNonEmpty(attribute("device", "model"), strings.ReplaceAll(props["ID_MODEL"], "_", " ")),
+		Serial:                     firstNonEmpty(props["ID_SERIAL_SHORT"], attribute("device", "serial")),
+		WWN:                        firstNonEmpty(props["ID_WWN"], attribute("wwid"), attribute("device", "wwid")),
+		Firmware:                   firstNonEmpty(attribute("device", "firmware_rev"), attribute("device", "rev"), props["ID_REVISION"]),
+		Rotational:                 attribute("queue", "rotational") == "1",
+		Transport:                  blockDeviceTransport(name, props),
+		LogicalSectorSize:          parseSysfsUint(attribute("queue", "logical_block_size")),
+		PhysicalSectorSize:         parseSysfsUint(attribute("queue", "physical_block_size")),
+		OptimalIOSize:              parseSysfsUint(attribute("queue", "optimal_io_size")),
+		AlignmentOffset:            parseSysfsUint(attribute("alignment_offset")),
+		PartitionTableType:         props["ID_PART_TABLE_TYPE"],
+		FileSystem:                 props["ID_FS_TYPE"],
+		Holders:                    sysfsHolders(name),
+	}
+
+	// The size attribute is always expressed in 512 byte sectors.
+	bd.Size = parseSysfsUint(attribute("size")) * 512
+
+	switch {
+	case bd.Transport == "nvme":
+		bd.MediaType = "nvme"
+	case bd.Transport == "virtual":
+		bd.MediaType = "virtual"
+	case bd.Rotational:
+		bd.MediaType = "hdd"
+	default:
+		bd.MediaType = "ssd"
+	}
+
+	for _, child := range kernelPartitions(name) {
+		bd.Partitions = append(bd.Partitions, discoverPartition(child, bd))
+	}
+
+	return bd
+}
+
+// discoverPartition returns a Partition for the partition with the given
+// kernel name. Its BlockDevice describes the partition device itself.
+func discoverPartition(name string, parent *BlockDevice) *Partition {
+	props := udevProperties(name)
+	size := parseSysfsUint(readSysfsAttribute(filepath.Join("class", "block", name, "size"))) * 512
+
+	return &Partition{
+		Position:   uint(parseSysfsUint(readSysfsAttribute(filepath.Join("class", "block", name, "partition")))),
+		Name:       props["ID_PART_ENTRY_NAME"],
+		Type:       strings.ToUpper(props["ID_PART_ENTRY_TYPE"]),
+		Size:       partitionSizeSpec(size, parent.LogicalSectorSize),
+		FileSystem: props["ID_FS_TYPE"],
+		UUID:       props["ID_FS_UUID"],
+		BlockDevice: &BlockDevice{
+			ControllerPhysicalDeviceID: -1,
+			File:                       filepath.Join(devRoot, name),
+			Size:                       size,
+			FileSystem:                 props["ID_FS_TYPE"],
+			Holders:                    sysfsHolders(name),
+		},
+	}
+}
+
+// partitionSizeSpec returns an sgdisk size specification for a partition of
+// the given size, in MiB where that is exact and in sectors otherwise.
+func partitionSizeSpec(size, sectorSize uint64) string {
+	if size%MiB == 0 {
+		return "+" + strconv.FormatUint(size/MiB, 10) + "M"
+	}
+
+	return "+" + strconv.FormatUint(size/cmp.Or(sectorSize, 512), 10)
+}
+
+// blockDeviceTransport derives how a disk is attached from its position in
+// the sysfs device tree, falling back to the bus udev reports.
+func blockDeviceTransport(name string, props map[string]string) string {
+	target, _ := filepath.EvalSymlinks(filepath.Join(sysfsRoot, "block", name))
+
+	switch {
+	case strings.HasPrefix(name, "nvme"), strings.Contains(target, "/nvme/"):
+		return "nvme"
+	case strings.Contains(target, "/virtual/"):
+		return "virtual"
+	case strings.Contains(target, "/usb"):
+		return "usb"
+	case strings.Contains(target, "/virtio"):
+		return "virtio"
+	case strings.Contains(target, "/mmc_host/"):
+		return "mmc"
+	case strings.Contains(target, "/end_device-"), strings.Contains(target, "/expander-"):
+		return "sas"
+	case strings.Contains(target, "/ata"):
+		return "sata"
+	}
+
+	return props["ID_BUS"]
+}
+
+// sysfsHolders returns the kernel names of the devices holding the device
+// with the given kernel name.
+func sysfsHolders(name string) (holders []string) {
+	entries, _ := os.ReadDir(filepath.Join(sysfsRoot, "class", "block", name, "holders"))
+	for _, e := range entries {
+		holders = append(holders, e.Name())
+	}
+
+	return
+}
+
+func parseSysfsUint(value string) uint64 {
+	v, _ := strconv.ParseUint(value, 10, 64)
+	return v
+}
+
+// EnrichBlockDevices adds the vendor, model, serial, WWN and firmware
+// reported by the ironlib hardware inventory where sysfs and udev did not
+// provide them.
+func EnrichBlockDevices(ctx context.Context, blockDevices []*BlockDevice) (err error) {
+	hardware, err := getIronlibInventory(ctx)
+	if err != nil {
+		return
+	}
+
+	for _, drive := range hardware.Drives {
+		for _, bd := range blockDevices {
+			if drive.LogicalName != bd.File {
+				continue
+			}
+
+			bd.Vendor = firstNonEmpty(bd.Vendor, drive.Vendor)
+			bd.Model = firstNonEmpty(bd.Model, drive.Model)
+			bd.Serial = firstNonEmpty(bd.Serial, drive.Serial)
+			bd.WWN = firstNonEmpty(bd.WWN, drive.WWN)
+
+			if drive.Firmware != nil {
+				bd.Firmware = firstNonEmpty(bd.Firmware, drive.Firmware.Installed)
+			}
+		}
+	}
+
+	return
+}
+
+// udevProperties returns the properties (E: lines) udev recorded for the
+// block device with the given kernel name.
+func udevProperties(name string) map[string]string {
+	props := make(map[string]string)
+
+	devNumber := readSysfsAttribute(filepath.Join("class", "block", name, "dev"))
+	if devNumber == "" {
+		return props
+	}
+
+	f, err := os.Open(filepath.Join(udevRoot, "data", "b"+devNumber))
+	if err != nil {
+		return props
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if kv, found := strings.CutPrefix(scanner.Text(), "E:"); found {
+			if key, value, ok := strings.Cut(kv, "="); ok {
+				props[key] = value
+			}
+		}
+	}
+
+	return props
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v = strings.TrimSpace(v); v != "" {
+			return v
+		}
+	}
+
+	return ""
+}
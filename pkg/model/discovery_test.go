@@ -0,0 +1,88 @@
+package model
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+// fakeDisks creates a fake sysfs, udev database and /dev tree with two
+// identical SSDs and one NVMe drive, and points the package at it.
+func fakeDisks(t *testing.T) string {
+	t.Helper()
+
+	root := t.TempDir()
+	fakeTree(t, root, map[string]string{
+		"sys/block/sda/size":                     "937703088\n",
+		"sys/block/sda/device/model":             "SAMSUNG MZ7LH480\n",
+		"sys/block/sda/queue/rotational":         "0\n",
+		"sys/block/sda/queue/logical_block_size": "512\n",
+		"sys/class/block/sda/sda1/partition":     "1\n",
+		"sys/class/block/sda1/partition":         "1\n",
+		"sys/class/block/sda1/size":              "1048576\n",
+		"sys/class/block/sda1/dev":               "8:1\n",
+		"sys/class/block/sda1/holders/md127/":    "",
+		"run/udev/data/b8:1":                     "E:ID_PART_ENTRY_NAME=ROOT\nE:ID_FS_TYPE=linux_raid_member\n",
+		"sys/class/block/sda/dev":                "8:0\n",
+		"sys/block/sdb/size":                     "937703088\n",
+		"sys/block/sdb/device/model":             "SAMSUNG MZ7LH480\n",
+		"sys/block/sdb/queue/rotational":         "1\n",
+		"sys/class/block/sdb/dev":                "8:16\n",
+		"sys/block/nvme0n1/size":                 "3750748848\n",
+		"sys/block/nvme0n1/wwid":                 "eui.0025388b91b2c3d4\n",
+		"sys/block/nvme0n1/device/model":         "SAMSUNG MZQL21T9HCJR-00A07\n",
+		"sys/block/nvme0n1/device/serial":        "S64GNE0R123456\n",
+		"sys/class/block/nvme0n1/dev":            "259:0\n",
+		"sys/block/loop0/size":                   "0\n",
+		"run/udev/data/b8:0":                     "S:disk/by-id/wwn-0x5002538e40a1b2c3\nE:ID_SERIAL_SHORT=S45PNA0M100001\nE:ID_WWN=0x5002538e40a1b2c3\n",
+		"run/udev/data/b8:16":                    "E:ID_SERIAL_SHORT=S45PNA0M100002\nE:ID_WWN=0x5002538e40a1b2c4\n",
+		"dev/sda":                                "",
+		"dev/sdb":                                "",
+		"dev/nvme0n1":                            "",
+		"dev/disk/by-id/wwn-0x5002538e40a1b2c3":  "->../../sda",
+	})
+
+	oldSysfs, oldUdev, oldDev := sysfsRoot, udevRoot, devRoot
+	sysfsRoot, udevRoot, devRoot = filepath.Join(root, "sys"), filepath.Join(root, "run", "udev"), filepath.Join(root, "dev")
+
+	t.Cleanup(func() {
+		sysfsRoot, udevRoot, devRoot = oldSysfs, oldUdev, oldDev
+	})
+
+	return root
+}
+
+func TestDiscoverBlockDevices(t *testing.T) {
+	root := fakeDisks(t)
+
+	blockDevices, err := DiscoverBlockDevices()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(blockDevices) != 3 {
+		t.Fatalf("discovered %d devices, want 3", len(blockDevices))
+	}
+
+	nvme := blockDevices[0]
+	if nvme.File != filepath.Join(root, "dev", "nvme0n1") || nvme.Serial != "S64GNE0R123456" || nvme.WWN != "eui.0025388b91b2c3d4" {
+		t.Errorf("unexpected nvme device: %+v", nvme)
+	}
+
+	sda := blockDevices[1]
+	if sda.Serial != "S45PNA0M100001" || sda.Size != 937703088*512 || sda.MediaType != "ssd" || sda.LogicalSectorSize != 512 {
+		t.Errorf("unexpected sda device: %+v", sda)
+	}
+
+	if len(sda.Partitions) != 1 {
+		t.Fatalf("sda has %d partitions, want 1", len(sda.Partitions))
+	}
+
+	if p := sda.Partitions[0]; p.Name != "ROOT" || p.Size != "+512M" || p.FileSystem != "linux_raid_member" ||
+		p.BlockDevice.File != filepath.Join(root, "dev", "sda1") || len(p.BlockDevice.Holders) != 1 {
+		t.Errorf("unexpected sda1 partition: %+v %+v", p, p.BlockDevice)
+	}
+
+	if sdb := blockDevices[2]; sdb.MediaType != "hdd" {
+		t.Errorf("sdb media type = %s, want hdd", sdb.MediaType)
+	}
+}
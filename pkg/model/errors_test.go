@@ -0,0 +1,56 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"testing"
+
+	"github.com/metal-toolbox/vogelkop/internal/command"
+)
+
+func TestErrorClass(t *testing.T) {
+	for _, tc := range []struct {
+		err  error
+		want string
+	}{
+		{InvalidSizeError("12Q"), ErrorClassInvalidInput},
+		{InvalidLayoutError([]LayoutProblem{{Pointer: "/name", Message: "required"}}), ErrorClassInvalidInput},
+		{DeviceNotFoundError("serial=abc"), ErrorClassDeviceNotFound},
+		{DeviceInUseError("/dev/sda", nil), ErrorClassDeviceInUse},
+		{StateConflictError("/dev/md/root", nil), ErrorClassStateConflict},
+		{UnfinishedJournalError("/run/vogelkop/journal.json", "compute", JournalFailed), ErrorClassStateConflict},
+		{errors.Join(UnhealthyDeviceError("/dev/sda", []string{"media errors 3 over 0"}), FailedHealthReadError("/dev/sdb", errors.New("eof"))), ErrorClassDeviceUnhealthy},
+		{NoNamespaceManagementError("/dev/nvme0"), ErrorClassInvalidInput},
+		{UnsupportedOptionError("Marvell 88SE9230", "write cache write-back", nil), ErrorClassInvalidInput},
+		{FailedControllerCommandError("/c0/fall import", "Import Foreign Configuration Failed."), ErrorClassToolFailed},
+		{command.ToolMissingError("mdadm", exec.ErrNotFound), ErrorClassToolMissing},
+		{&command.ExecutionError{Command: "sgdisk", ExitCode: 4, Err: errors.New("exit status 4")}, ErrorClassToolFailed},
+		{fmt.Errorf("%w: %w", command.FailedExecutionError("sgdisk", "killed"), context.DeadlineExceeded), ErrorClassTimeout},
+		{PartialSuccessError(1, 1, DeviceInUseError("/dev/sdb", nil)), ErrorClassPartialSuccess},
+		{fmt.Errorf("%w: %w", command.FailedExecutionError("mkfs.ext4", "signal: terminated"), context.Canceled), ErrorClassInterrupted},
+		{errors.New("unexpected"), ErrorClassInternal},
+	} {
+		if got := ErrorClass(tc.err); got != tc.want {
+			t.Errorf("%v: got %s, want %s", tc.err, got, tc.want)
+		}
+	}
+}
+
+func TestWipeError(t *testing.T) {
+	ok := &WipeResult{Disk: "/dev/sda", Result: "success"}
+	failed := FailedWipeResult("/dev/sdb", DeviceNotFoundError("/dev/sdb"))
+
+	if err := WipeError([]*WipeResult{ok}); err != nil {
+		t.Errorf("unexpected error %v", err)
+	}
+
+	if err := WipeError([]*WipeResult{failed}); ErrorClass(err) != ErrorClassDeviceNotFound {
+		t.Errorf("expected device not found, got %v", err)
+	}
+
+	if err := WipeError([]*WipeResult{ok, failed}); ErrorClass(err) != ErrorClassPartialSuccess || !errors.Is(err, ErrDeviceNotFound) {
+		t.Errorf("expected partial success, got %v", err)
+	}
+}
@@ -0,0 +1,368 @@
+package model
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/metal-toolbox/vogelkop/internal/command"
+)
+
+// signatures that make a device part of a larger device rather than carry a
+// filesystem of its own.
+var containerSignatures = []string{"linux_raid_member", "crypto_LUKS", "LVM2_member", "isw_raid_member", "ddf_raid_member"}
+
+// exporter builds a StorageLayout from the running system.
+type exporter struct {
+	layout *StorageLayout
+	mounts map[string][]string
+	// references maps kernel names to the names the layout uses for them.
+	references map[string]string
+}
+
+// Export describes the storage of the running system as a StorageLayout that
+// Apply reproduces: GPT partitions of every disk, software RAID arrays, LUKS
+// volumes, LVM volume groups and the filesystems on top of them, including
+// their UUIDs and current mount points. Hardware RAID virtual disks are
+// exported as the disks the operating system sees. Key files of encrypted
+// volumes are not known and have to be added before the layout is applied.
+func Export(ctx context.Context) (layout *StorageLayout, err error) {
+	blockDevices, err := DiscoverBlockDevices()
+	if err != nil {
+		return
+	}
+
+	mounts, err := readMounts()
+	if err != nil {
+		return
+	}
+
+	hostname, _ := os.Hostname()
+
+	e := &exporter{
+		layout:     &StorageLayout{Name: hostname},
+		mounts:     mounts,
+		references: make(map[string]string),
+	}
+
+	var virtual []*BlockDevice
+
+	for _, bd := range blockDevices {
+		if bd.MediaType == "virtual" {
+			virtual = append(virtual, bd)
+		} else {
+			e.exportDisk(bd)
+		}
+	}
+
+	e.namePartitions()
+
+	// Stacked devices are exported once the devices below them have a name.
+	for pending := virtual; len(pending) > 0; {
+		var next []*BlockDevice
+
+		for _, bd := range pending {
+			if !e.exportStacked(ctx, bd) {
+				next = append(next, bd)
+			}
+		}
+
+		if len(next) == len(pending) {
+			break
+		}
+
+		pending = next
+	}
+
+	return e.layout, nil
+}
+
+// exportDisk adds a disk and its partitions, or only the filesystem of a disk
+// without partitions.
+func (e *exporter) exportDisk(bd *BlockDevice) {
+	name := filepath.Base(bd.File)
+	e.references[name] = bd.File
+
+	if len(bd.Partitions) == 0 {
+		e.exportFileSystem(name, bd.File)
+		return
+	}
+
+	exported := &BlockDevice{
+		ControllerPhysicalDeviceID: -1,
+		File:                       bd.File,
+		WWN:                        bd.WWN,
+		Serial:                     bd.Serial,
+		Model:                      bd.Model,
+		Size:                       bd.Size,
+	}
+
+	switch {
+	case bd.WWN != "":
+		exported.Selector = SelectorWWN + ":" + bd.WWN
+	case bd.Serial != "":
+		exported.Selector = SelectorSerial + ":" + bd.Serial
+	}
+
+	for _, p := range bd.Partitions {
+		partitionName := filepath.Base(p.BlockDevice.File)
+		exportedPartition := &Partition{
+			Position: p.Position,
+			Name:     p.Name,
+			Size:     p.Size,
+			Type:     p.Type,
+		}
+
+		if fsType := p.FileSystem; fsType != "" && !slices.Contains(containerSignatures, fsType) {
+			exportedPartition.FileSystem = fsType
+			exportedPartition.UUID = p.UUID
+			exportedPartition.MountPoint = e.mountPoint(partitionName)
+		}
+
+		exported.Partitions = append(exported.Partitions, exportedPartition)
+		e.references[partitionName] = p.BlockDevice.File
+	}
+
+	slices.SortFunc(exported.Partitions, func(a, b *Partition) int { return int(a.Position) - int(b.Position) })
+
+	e.layout.BlockDevices = append(e.layout.BlockDevices, exported)
+}
+
+// namePartitions refers to partitions by their name where that is unique in
+// the layout. Other partitions keep being referred to by device file.
+func (e *exporter) namePartitions() {
+	for _, bd := range e.layout.BlockDevices {
+		for _, p := range bd.Partitions {
+			if p.Name == "" || e.partitionNameCount(p.Name) != 1 {
+				continue
+			}
+
+			if file, err := p.GetBlockDevice(bd.File); err == nil {
+				e.references[filepath.Base(file)] = p.Name
+			}
+		}
+	}
+}
+
+// exportFileSystem adds the filesystem on a device that is not a partition,
+// if there is one.
+func (e *exporter) exportFileSystem(name, reference string) {
+	props := udevProperties(name)
+
+	fsType := props["ID_FS_TYPE"]
+	if fsType == "" || slices.Contains(containerSignatures, fsType) {
+		return
+	}
+
+	e.layout.FileSystems = append(e.layout.FileSystems, &FileSystem{
+		Name:       reference,
+		Format:     fsType,
+		UUID:       props["ID_FS_UUID"],
+		MountPoint: e.mountPoint(name),
+	})
+}
+
+// exportStacked adds an md array, LUKS volume or LVM logical volume. It returns
+// false if a device below it has not been exported yet.
+func (e *exporter) exportStacked(ctx context.Context, bd *BlockDevice) bool {
+	name := filepath.Base(bd.File)
+
+	var slaves []string
+
+	entries, _ := os.ReadDir(filepath.Join(sysfsRoot, "class", "block", name, "slaves"))
+	for _, entry := range entries {
+		if _, ok := e.references[entry.Name()]; !ok {
+			return false
+		}
+
+		slaves = append(slaves, entry.Name())
+	}
+
+	switch dmUUID := readSysfsAttribute(filepath.Join("class", "block", name, "dm", "uuid")); {
+	case strings.HasPrefix(name, "md"):
+		e.exportRaidArray(name, slaves)
+	case strings.HasPrefix(dmUUID, "CRYPT-LUKS"):
+		e.exportEncryptedVolume(name, dmUUID, slaves)
+	case strings.HasPrefix(dmUUID, "LVM-"):
+		e.exportLogicalVolume(ctx, name, bd.Size, slaves)
+	}
+
+	return true
+}
+
+func (e *exporter) exportRaidArray(name string, slaves []string) {
+	props := udevProperties(name)
+
+	arrayName := firstNonEmpty(props["MD_DEVNAME"], name)
+	if _, host, found := strings.Cut(arrayName, ":"); found {
+		arrayName = host
+	}
+
+	array := &RaidArray{
+		Name:                    arrayName,
+		Level:                   normalizeRaidLevel(firstNonEmpty(readSysfsAttribute(filepath.Join("class", "block", name, "md", "level")), props["MD_LEVEL"])),
+		ControllerVirtualDiskID: -1,
+	}
+
+	if partitionName := e.commonPartitionName(slaves); partitionName != "" {
+		array.PartitionName = partitionName
+	} else {
+		for _, slave := range slaves {
+			array.Devices = append(array.Devices, &BlockDevice{ControllerPhysicalDeviceID: -1, File: filepath.Join(devRoot, slave)})
+		}
+	}
+
+	e.layout.RaidArrays = append(e.layout.RaidArrays, array)
+	e.references[name] = arrayName
+	e.exportFileSystem(name, arrayName)
+}
+
+func (e *exporter) exportEncryptedVolume(name, dmUUID string, slaves []string) {
+	if len(slaves) != 1 {
+		return
+	}
+
+	mappingName := readSysfsAttribute(filepath.Join("class", "block", name, "dm", "name"))
+
+	volume := &EncryptedVolume{
+		Name:   mappingName,
+		Device: e.references[slaves[0]],
+		Type:   "luks2",
+		UUID:   udevProperties(slaves[0])["ID_FS_UUID"],
+	}
+
+	if strings.HasPrefix(dmUUID, "CRYPT-LUKS1-") {
+		volume.Type = "luks1"
+	}
+
+	e.layout.EncryptedVolumes = append(e.layout.EncryptedVolumes, volume)
+	e.references[name] = mappingName
+	e.exportFileSystem(name, mappingName)
+}
+
+func (e *exporter) exportLogicalVolume(ctx context.Context, name string, size uint64, slaves []string) {
+	props := udevProperties(name)
+
+	vgName, lvName := props["DM_VG_NAME"], props["DM_LV_NAME"]
+	if vgName == "" || lvName == "" {
+		return
+	}
+
+	var g *VolumeGroup
+
+	for _, existing := range e.layout.VolumeGroups {
+		if existing.Name == vgName {
+			g = existing
+		}
+	}
+
+	if g == nil {
+		g = &VolumeGroup{Name: vgName, PhysicalVolumes: e.volumeGroupPhysicalVolumes(ctx, vgName)}
+		e.layout.VolumeGroups = append(e.layout.VolumeGroups, g)
+	}
+
+	for _, slave := range slaves {
+		if pv := e.references[slave]; !slices.Contains(g.PhysicalVolumes, pv) {
+			g.PhysicalVolumes = append(g.PhysicalVolumes, pv)
+		}
+	}
+
+	// Logical volumes are multiples of the extent size, which is at least
+	// one MiB unless configured otherwise.
+	lvSize := strconv.FormatUint(size/KiB, 10) + "K"
+	if size%MiB == 0 {
+		lvSize = strconv.FormatUint(size/MiB, 10) + "M"
+	}
+
+	g.LogicalVolumes = append(g.LogicalVolumes, &LogicalVolume{Name: lvName, Size: lvSize})
+
+	reference := vgName + "/" + lvName
+	e.references[name] = reference
+	e.exportFileSystem(name, reference)
+}
+
+// volumeGroupPhysicalVolumes returns the physical volumes of a volume group as
+// reported by pvs, which includes those no logical volume uses yet. Nothing
+// is returned if the LVM tools are not installed.
+func (e *exporter) volumeGroupPhysicalVolumes(ctx context.Context, vgName string) (pvs []string) {
+	if _, err := exec.LookPath("pvs"); err != nil {
+		return
+	}
+
+	out, err := command.Output(ctx, "pvs", "--noheadings", "-o", "pv_name,vg_name")
+	if err != nil {
+		return
+	}
+
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 2 || fields[1] != vgName {
+			continue
+		}
+
+		name, err := kernelName(fields[0])
+		if err != nil {
+			continue
+		}
+
+		if reference, ok := e.references[name]; ok {
+			pvs = append(pvs, reference)
+		}
+	}
+
+	return
+}
+
+// commonPartitionName returns the name shared by all given partitions if no
+// other partition of the layout carries it. Arrays built from such partitions
+// are exported with a PartitionName, the way layout templates render them.
+func (e *exporter) commonPartitionName(kernelNames []string) (name string) {
+	members := 0
+
+	for _, bd := range e.layout.BlockDevices {
+		for _, p := range bd.Partitions {
+			file, err := p.GetBlockDevice(bd.File)
+			if err != nil || !slices.Contains(kernelNames, filepath.Base(file)) {
+				continue
+			}
+
+			if p.Name == "" || (name != "" && p.Name != name) {
+				return ""
+			}
+
+			name = p.Name
+			members++
+		}
+	}
+
+	if members != len(kernelNames) || e.partitionNameCount(name) != members {
+		return ""
+	}
+
+	return name
+}
+
+func (e *exporter) partitionNameCount(name string) (n int) {
+	for _, bd := range e.layout.BlockDevices {
+		for _, p := range bd.Partitions {
+			if p.Name == name {
+				n++
+			}
+		}
+	}
+
+	return
+}
+
+// mountPoint returns the first mount point of a device, if any.
+func (e *exporter) mountPoint(name string) string {
+	if mountPoints := e.mounts[name]; len(mountPoints) > 0 {
+		return mountPoints[0]
+	}
+
+	return ""
+}
@@ -0,0 +1,154 @@
+package model
+
+import (
+	"context"
+	"encoding/json"
+	"path/filepath"
+	"testing"
+)
+
+func TestExport(t *testing.T) {
+	root := t.TempDir()
+	fakeTree(t, root, map[string]string{
+		"sys/block/sda/size":                 "937703088\n",
+		"sys/class/block/sda/dev":            "8:0\n",
+		"sys/class/block/sda/sda1/partition": "1\n",
+		"sys/class/block/sda/sda2/partition": "2\n",
+		"sys/class/block/sda1/partition":     "1\n",
+		"sys/class/block/sda1/size":          "8192\n",
+		"sys/class/block/sda1/dev":           "8:1\n",
+		"sys/class/block/sda2/partition":     "2\n",
+		"sys/class/block/sda2/size":          "937694896\n",
+		"sys/class/block/sda2/dev":           "8:2\n",
+		"run/udev/data/b8:0":                 "E:ID_WWN=0x5002538e40a1b2c3\n",
+		"run/udev/data/b8:1":                 "E:ID_PART_ENTRY_NAME=BIOS\nE:ID_PART_ENTRY_TYPE=21686148-6449-6e6f-744e-656564454649\n",
+		"run/udev/data/b8:2":                 "E:ID_PART_ENTRY_NAME=ROOT\nE:ID_FS_TYPE=linux_raid_member\n",
+
+		"sys/block/sdb/size":                 "937703088\n",
+		"sys/class/block/sdb/dev":            "8:16\n",
+		"sys/class/block/sdb/sdb1/partition": "1\n",
+		"sys/class/block/sdb/sdb2/partition": "2\n",
+		"sys/class/block/sdb1/partition":     "1\n",
+		"sys/class/block/sdb1/size":          "8192\n",
+		"sys/class/block/sdb1/dev":           "8:17\n",
+		"sys/class/block/sdb2/partition":     "2\n",
+		"sys/class/block/sdb2/size":          "937694896\n",
+		"sys/class/block/sdb2/dev":           "8:18\n",
+		"run/udev/data/b8:16":                "E:ID_SERIAL_SHORT=S45PNA0M100002\n",
+		"run/udev/data/b8:17":                "E:ID_PART_ENTRY_NAME=BIOS\n",
+		"run/udev/data/b8:18":                "E:ID_PART_ENTRY_NAME=ROOT\nE:ID_FS_TYPE=linux_raid_member\n",
+
+		"sys/block/nvme0n1/size":                      "3750748848\n",
+		"sys/class/block/nvme0n1/dev":                 "259:0\n",
+		"sys/class/block/nvme0n1/nvme0n1p1/partition": "1\n",
+		"sys/class/block/nvme0n1p1/partition":         "1\n",
+		"sys/class/block/nvme0n1p1/size":              "3750748160\n",
+		"sys/class/block/nvme0n1p1/dev":               "259:1\n",
+		"run/udev/data/b259:1":                        "E:ID_PART_ENTRY_NAME=DATA\nE:ID_FS_TYPE=crypto_LUKS\nE:ID_FS_UUID=0b7e3a4c-1111-4c6a-9d2e-3f1f0a6b5c01\n",
+
+		"sys/devices/virtual/block/md127/size":        "937563136\n",
+		"sys/devices/virtual/block/md127/dev":         "9:127\n",
+		"sys/devices/virtual/block/md127/md/level":    "raid1\n",
+		"sys/devices/virtual/block/md127/slaves/sda2": "",
+		"sys/devices/virtual/block/md127/slaves/sdb2": "",
+		"sys/block/md127":                             "->../devices/virtual/block/md127",
+		"sys/class/block/md127":                       "->../../devices/virtual/block/md127",
+		"run/udev/data/b9:127":                        "E:MD_DEVNAME=ROOT\nE:ID_FS_TYPE=ext4\nE:ID_FS_UUID=7d2c1c2e-2222-4b1e-8a51-5d0f6c0e9a02\n",
+
+		"sys/devices/virtual/block/dm-0/size":             "3750715392\n",
+		"sys/devices/virtual/block/dm-0/dev":              "253:0\n",
+		"sys/devices/virtual/block/dm-0/dm/uuid":          "CRYPT-LUKS2-0b7e3a4c11114c6a9d2e3f1f0a6b5c01-cryptdata\n",
+		"sys/devices/virtual/block/dm-0/dm/name":          "cryptdata\n",
+		"sys/devices/virtual/block/dm-0/slaves/nvme0n1p1": "",
+		"sys/block/dm-0":       "->../devices/virtual/block/dm-0",
+		"sys/class/block/dm-0": "->../../devices/virtual/block/dm-0",
+		"run/udev/data/b253:0": "E:ID_FS_TYPE=LVM2_member\n",
+
+		"sys/devices/virtual/block/dm-1/size":        "2147483648\n",
+		"sys/devices/virtual/block/dm-1/dev":         "253:1\n",
+		"sys/devices/virtual/block/dm-1/dm/uuid":     "LVM-Zm9vYmFy\n",
+		"sys/devices/virtual/block/dm-1/dm/name":     "vg0-data\n",
+		"sys/devices/virtual/block/dm-1/slaves/dm-0": "",
+		"sys/block/dm-1":                             "->../devices/virtual/block/dm-1",
+		"sys/class/block/dm-1":                       "->../../devices/virtual/block/dm-1",
+		"run/udev/data/b253:1":                       "E:DM_VG_NAME=vg0\nE:DM_LV_NAME=data\nE:ID_FS_TYPE=xfs\n",
+
+		"sys/dev/block/9:127": "->../../devices/virtual/block/md127",
+		"sys/dev/block/253:1": "->../../devices/virtual/block/dm-1",
+		"proc/self/mountinfo": "22 1 9:127 / / rw,relatime - ext4 /dev/md127 rw\n" +
+			"23 22 253:1 / /srv rw,relatime - xfs /dev/mapper/vg0-data rw\n",
+		"dev/sda":       "",
+		"dev/sdb":       "",
+		"dev/nvme0n1":   "",
+		"dev/md127":     "",
+		"dev/dm-0":      "",
+		"dev/dm-1":      "",
+		"dev/md/ROOT":   "->../md127",
+		"dev/sda2":      "",
+		"dev/sdb2":      "",
+		"dev/nvme0n1p1": "",
+	})
+
+	oldSysfs, oldProcfs, oldUdev, oldDev := sysfsRoot, procfsRoot, udevRoot, devRoot
+	sysfsRoot, procfsRoot = filepath.Join(root, "sys"), filepath.Join(root, "proc")
+	udevRoot, devRoot = filepath.Join(root, "run", "udev"), filepath.Join(root, "dev")
+
+	t.Cleanup(func() {
+		sysfsRoot, procfsRoot, udevRoot, devRoot = oldSysfs, oldProcfs, oldUdev, oldDev
+	})
+
+	layout, err := Export(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(layout.BlockDevices) != 3 {
+		t.Fatalf("exported %d block devices, want 3", len(layout.BlockDevices))
+	}
+
+	if sda := layout.BlockDevices[1]; sda.Selector != "wwn:0x5002538e40a1b2c3" || len(sda.Partitions) != 2 ||
+		sda.Partitions[0].Size != "+4M" || sda.Partitions[0].Type != "21686148-6449-6E6F-744E-656564454649" {
+		t.Errorf("unexpected sda export: %+v %+v", sda, sda.Partitions[0])
+	}
+
+	if len(layout.RaidArrays) != 1 || layout.RaidArrays[0].Name != "ROOT" || layout.RaidArrays[0].Level != "1" ||
+		layout.RaidArrays[0].PartitionName != "ROOT" || len(layout.RaidArrays[0].Devices) != 0 {
+		t.Errorf("unexpected raid arrays: %+v", layout.RaidArrays)
+	}
+
+	if len(layout.EncryptedVolumes) != 1 || *layout.EncryptedVolumes[0] != (EncryptedVolume{
+		Name: "cryptdata", Device: "DATA", Type: "luks2", UUID: "0b7e3a4c-1111-4c6a-9d2e-3f1f0a6b5c01",
+	}) {
+		t.Errorf("unexpected encrypted volumes: %+v", layout.EncryptedVolumes)
+	}
+
+	if len(layout.VolumeGroups) != 1 || layout.VolumeGroups[0].Name != "vg0" ||
+		len(layout.VolumeGroups[0].PhysicalVolumes) != 1 || layout.VolumeGroups[0].PhysicalVolumes[0] != "cryptdata" ||
+		len(layout.VolumeGroups[0].LogicalVolumes) != 1 || *layout.VolumeGroups[0].LogicalVolumes[0] != (LogicalVolume{Name: "data", Size: "1048576M"}) {
+		t.Errorf("unexpected volume groups: %+v", layout.VolumeGroups)
+	}
+
+	want := []FileSystem{
+		{Name: "vg0/data", Format: "xfs", MountPoint: "/srv"},
+		{Name: "ROOT", Format: "ext4", UUID: "7d2c1c2e-2222-4b1e-8a51-5d0f6c0e9a02", MountPoint: "/"},
+	}
+
+	if len(layout.FileSystems) != len(want) {
+		t.Fatalf("exported %d filesystems, want %d", len(layout.FileSystems), len(want))
+	}
+
+	for i, fs := range layout.FileSystems {
+		if fs.Name != want[i].Name || fs.Format != want[i].Format || fs.UUID != want[i].UUID || fs.MountPoint != want[i].MountPoint {
+			t.Errorf("filesystem %d = %+v, want %+v", i, fs, want[i])
+		}
+	}
+
+	document, err := json.Marshal(layout)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, problems, err := ValidateLayoutDocument(document); err != nil || len(problems) > 0 {
+		t.Errorf("exported layout does not round-trip: %v %v", err, problems)
+	}
+}
@@ -0,0 +1,124 @@
+package model
+
+import (
+	"cmp"
+	"fmt"
+	"path/filepath"
+	"strconv"
+)
+
+// DefaultMemberSizeTolerance is how much the members of a RAID array may
+// differ in size, in percent of the largest, before CheckArrayMembers
+// reports them.
+const DefaultMemberSizeTolerance = 1
+
+// minimumPartitionAlignment is the alignment of partitions on disks that
+// report no larger optimal I/O size, as parted and sgdisk use by default.
+const minimumPartitionAlignment = MiB
+
+// ReadGeometry fills in the size, sector sizes, optimal I/O size and
+// alignment offset of the BlockDevice from sysfs. Partitions report the
+// sector and I/O sizes of the disk they are on.
+func (b *BlockDevice) ReadGeometry() (err error) {
+	name, err := kernelName(b.File)
+	if err != nil {
+		return
+	}
+
+	attribute := func(path ...string) string {
+		return readSysfsAttribute(filepath.Join(append([]string{"class", "block", name}, path...)...))
+	}
+
+	if attribute("size") == "" {
+		return DeviceNotFoundError(b.File)
+	}
+
+	// The size attribute is always expressed in 512 byte sectors.
+	b.Size = parseSysfsUint(attribute("size")) * 512
+	b.AlignmentOffset = parseSysfsUint(attribute("alignment_offset"))
+
+	disk := name
+	if attribute("partition") != "" {
+		if resolved, linkErr := filepath.EvalSymlinks(filepath.Join(sysfsRoot, "class", "block", name)); linkErr == nil {
+			disk = filepath.Base(filepath.Dir(resolved))
+		}
+	}
+
+	queue := func(attribute string) uint64 {
+		return parseSysfsUint(readSysfsAttribute(filepath.Join("class", "block", disk, "queue", attribute)))
+	}
+
+	b.LogicalSectorSize = queue("logical_block_size")
+	b.PhysicalSectorSize = queue("physical_block_size")
+	b.OptimalIOSize = queue("optimal_io_size")
+
+	return
+}
+
+// PartitionAlignment returns the boundary partitions on the BlockDevice are
+// aligned to, in bytes: the optimal I/O size it reports, but at least 1MiB.
+func (b *BlockDevice) PartitionAlignment() uint64 {
+	return max(minimumPartitionAlignment, b.OptimalIOSize)
+}
+
+// alignmentSectors returns the partition alignment of the disk in logical
+// sectors, as sgdisk takes it, reading the geometry from sysfs. Disks whose
+// geometry cannot be read are aligned to 1MiB of 512 byte sectors.
+func alignmentSectors(file string) (sectors uint64, offset uint64) {
+	b := &BlockDevice{File: file}
+	if err := b.ReadGeometry(); err != nil {
+		return minimumPartitionAlignment / 512, 0
+	}
+
+	return b.PartitionAlignment() / cmp.Or(b.LogicalSectorSize, 512), b.AlignmentOffset
+}
+
+// CheckArrayMembers compares the geometry of the members of a RAID array
+// with that of the first one. Members with other logical or physical sector
+// sizes, or whose size differs by more than tolerance percent, are returned
+// as differences. md uses the largest logical sector size of its members and
+// the capacity of the smallest one, so a mismatched member either misaligns
+// what is on it or wastes capacity on all others.
+func CheckArrayMembers(members []*BlockDevice, tolerance uint64) (differences []Difference, err error) {
+	for _, m := range members {
+		if err = m.ReadGeometry(); err != nil {
+			return
+		}
+	}
+
+	return compareArrayMembers(members, tolerance), nil
+}
+
+func compareArrayMembers(members []*BlockDevice, tolerance uint64) (differences []Difference) {
+	if len(members) < 2 {
+		return
+	}
+
+	first := members[0]
+
+	compare := func(m *BlockDevice, field string, want, have uint64) {
+		if want != have {
+			differences = append(differences, Difference{Field: field + " of " + m.File, Want: strconv.FormatUint(want, 10), Have: strconv.FormatUint(have, 10)})
+		}
+	}
+
+	largest := first.Size
+	for _, m := range members {
+		largest = max(largest, m.Size)
+	}
+
+	for _, m := range members[1:] {
+		compare(m, "logical sector size", first.LogicalSectorSize, m.LogicalSectorSize)
+		compare(m, "physical sector size", first.PhysicalSectorSize, m.PhysicalSectorSize)
+
+		if delta := max(first.Size, m.Size) - min(first.Size, m.Size); delta*100 > largest*tolerance {
+			differences = append(differences, Difference{
+				Field: fmt.Sprintf("size of %s (within %d%%)", m.File, tolerance),
+				Want:  FormatSize(first.Size),
+				Have:  FormatSize(m.Size),
+			})
+		}
+	}
+
+	return
+}
@@ -0,0 +1,132 @@
+package model
+
+import (
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestReadGeometry(t *testing.T) {
+	root := t.TempDir()
+	fakeTree(t, root, map[string]string{
+		"sys/devices/pci0/host0/block/sda/size":                      "7814037168\n",
+		"sys/devices/pci0/host0/block/sda/alignment_offset":          "0\n",
+		"sys/devices/pci0/host0/block/sda/queue/logical_block_size":  "512\n",
+		"sys/devices/pci0/host0/block/sda/queue/physical_block_size": "4096\n",
+		"sys/devices/pci0/host0/block/sda/queue/optimal_io_size":     "0\n",
+		"sys/devices/pci0/host0/block/sda/sda2/size":                 "2048000\n",
+		"sys/devices/pci0/host0/block/sda/sda2/partition":            "2\n",
+		"sys/devices/pci0/host0/block/sda/sda2/alignment_offset":     "3584\n",
+		"sys/devices/virtual/block/md0/size":                         "1048576\n",
+		"sys/devices/virtual/block/md0/queue/logical_block_size":     "4096\n",
+		"sys/devices/virtual/block/md0/queue/physical_block_size":    "4096\n",
+		"sys/devices/virtual/block/md0/queue/optimal_io_size":        "4194304\n",
+		"sys/class/block/sda":                                        "->../../devices/pci0/host0/block/sda",
+		"sys/class/block/sda2":                                       "->../../devices/pci0/host0/block/sda/sda2",
+		"sys/class/block/md0":                                        "->../../devices/virtual/block/md0",
+		"dev/sda":                                                    "",
+		"dev/sda2":                                                   "",
+		"dev/md0":                                                    "",
+		"dev/sdz":                                                    "",
+	})
+
+	oldSysfs, oldDev := sysfsRoot, devRoot
+	sysfsRoot, devRoot = filepath.Join(root, "sys"), filepath.Join(root, "dev")
+
+	t.Cleanup(func() {
+		sysfsRoot, devRoot = oldSysfs, oldDev
+	})
+
+	dev := func(name string) string { return filepath.Join(root, "dev", name) }
+
+	tests := []struct {
+		name      string
+		want      BlockDevice
+		alignment uint64
+	}{
+		{
+			name:      "sda",
+			want:      BlockDevice{Size: 7814037168 * 512, LogicalSectorSize: 512, PhysicalSectorSize: 4096},
+			alignment: 2048,
+		},
+		{
+			name:      "sda2",
+			want:      BlockDevice{Size: 2048000 * 512, LogicalSectorSize: 512, PhysicalSectorSize: 4096, AlignmentOffset: 3584},
+			alignment: 2048,
+		},
+		{
+			name:      "md0",
+			want:      BlockDevice{Size: 1048576 * 512, LogicalSectorSize: 4096, PhysicalSectorSize: 4096, OptimalIOSize: 4 * MiB},
+			alignment: 1024,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			b := &BlockDevice{File: dev(tc.name)}
+			if err := b.ReadGeometry(); err != nil {
+				t.Fatalf("ReadGeometry returned error: %v", err)
+			}
+
+			if b.Size != tc.want.Size || b.LogicalSectorSize != tc.want.LogicalSectorSize || b.PhysicalSectorSize != tc.want.PhysicalSectorSize ||
+				b.OptimalIOSize != tc.want.OptimalIOSize || b.AlignmentOffset != tc.want.AlignmentOffset {
+				t.Errorf("ReadGeometry = %+v, want %+v", b, tc.want)
+			}
+
+			if sectors, offset := alignmentSectors(dev(tc.name)); sectors != tc.alignment || offset != tc.want.AlignmentOffset {
+				t.Errorf("alignmentSectors = %d, %d, want %d, %d", sectors, offset, tc.alignment, tc.want.AlignmentOffset)
+			}
+		})
+	}
+
+	if err := (&BlockDevice{File: dev("sdz")}).ReadGeometry(); err == nil {
+		t.Error("ReadGeometry of a device unknown to sysfs returned no error")
+	}
+
+	if sectors, _ := alignmentSectors(dev("sdz")); sectors != 2048 {
+		t.Errorf("alignmentSectors of a device unknown to sysfs = %d, want 2048", sectors)
+	}
+}
+
+func TestCompareArrayMembers(t *testing.T) {
+	disk := func(file string, logical, physical, size uint64) *BlockDevice {
+		return &BlockDevice{File: file, LogicalSectorSize: logical, PhysicalSectorSize: physical, Size: size}
+	}
+
+	tests := []struct {
+		name    string
+		members []*BlockDevice
+		want    []string
+	}{
+		{
+			name:    "matching",
+			members: []*BlockDevice{disk("/dev/sda", 512, 4096, 960*GiB), disk("/dev/sdb", 512, 4096, 959*GiB)},
+		},
+		{
+			name:    "single member",
+			members: []*BlockDevice{disk("/dev/sda", 512, 512, GiB)},
+		},
+		{
+			name: "mixed 512e and 4Kn",
+			members: []*BlockDevice{
+				disk("/dev/sda", 512, 4096, 960*GiB),
+				disk("/dev/sdb", 4096, 4096, 960*GiB),
+				disk("/dev/sdc", 512, 512, 480*GiB),
+			},
+			want: []string{"logical sector size of /dev/sdb", "physical sector size of /dev/sdc", "size of /dev/sdc (within 1%)"},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var fields []string
+			for _, d := range compareArrayMembers(tc.members, DefaultMemberSizeTolerance) {
+				fields = append(fields, d.Field)
+			}
+
+			if !slices.Equal(fields, tc.want) {
+				t.Errorf("compareArrayMembers = %v, want %v", fields, tc.want)
+			}
+		})
+	}
+}
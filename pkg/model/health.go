@@ -0,0 +1,289 @@
+package model
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/metal-toolbox/vogelkop/internal/command"
+)
+
+// Sources of DiskHealth.
+const (
+	HealthSourceSmartctl = "smartctl"
+	HealthSourceNVMe     = "nvme"
+)
+
+// smartctlFatalExitBits are the bits of the smartctl exit status that mean
+// no data was read. The other bits report problems found on the device.
+const smartctlFatalExitBits = 0x3
+
+// ATA SMART attributes read by ReadDiskHealth.
+const (
+	ataReallocatedSectors = 5
+	ataReportedUncorrect  = 187
+	ataPendingSectors     = 197
+)
+
+// HealthThresholds are the worst values a disk may report and still be
+// provisioned onto.
+type HealthThresholds struct {
+	MaxReallocatedSectors uint64 `json:"max_reallocated_sectors"`
+	MaxPendingSectors     uint64 `json:"max_pending_sectors"`
+	MaxMediaErrors        uint64 `json:"max_media_errors"`
+	// MaxPercentageUsed is the share of its rated endurance a flash disk
+	// may have used up.
+	MaxPercentageUsed uint64 `json:"max_percentage_used"`
+}
+
+// DefaultHealthThresholds returns the thresholds used unless configured
+// otherwise.
+func DefaultHealthThresholds() HealthThresholds {
+	return HealthThresholds{
+		MaxReallocatedSectors: 10,
+		MaxPendingSectors:     0,
+		MaxMediaErrors:        0,
+		MaxPercentageUsed:     90,
+	}
+}
+
+// DiskHealth is what a disk reports about its health. Values the disk does
+// not report are nil.
+type DiskHealth struct {
+	Device string `json:"device"`
+	// Protocol is ata, scsi or nvme.
+	Protocol string `json:"protocol,omitempty"`
+	// Source is HealthSourceSmartctl or HealthSourceNVMe.
+	Source string `json:"source"`
+	// Supported is false for disks that report no health data at all, such
+	// as virtual disks.
+	Supported bool `json:"supported"`
+	// SmartPassed is the overall health self-assessment of the disk.
+	SmartPassed        *bool   `json:"smart_passed,omitempty"`
+	ReallocatedSectors *uint64 `json:"reallocated_sectors,omitempty"`
+	PendingSectors     *uint64 `json:"pending_sectors,omitempty"`
+	// MediaErrors are uncorrectable errors: NVMe media and data integrity
+	// errors, ATA reported uncorrectable errors or SCSI uncorrected read and
+	// write errors.
+	MediaErrors    *uint64 `json:"media_errors,omitempty"`
+	PercentageUsed *uint64 `json:"percentage_used,omitempty"`
+	// CriticalWarning is the NVMe critical warning bit field.
+	CriticalWarning *uint64 `json:"critical_warning,omitempty"`
+	// Temperature is in degrees Celsius.
+	Temperature  *uint64 `json:"temperature,omitempty"`
+	PowerOnHours *uint64 `json:"power_on_hours,omitempty"`
+	// Problems are the thresholds the disk is over, see Evaluate.
+	Problems []string `json:"problems"`
+}
+
+// Healthy reports whether Evaluate found no problems.
+func (h *DiskHealth) Healthy() bool {
+	return len(h.Problems) == 0
+}
+
+// Evaluate records every threshold the disk is over in Problems.
+func (h *DiskHealth) Evaluate(t HealthThresholds) {
+	h.Problems = []string{}
+
+	if h.SmartPassed != nil && !*h.SmartPassed {
+		h.Problems = append(h.Problems, "SMART overall health self-assessment failed")
+	}
+
+	if h.CriticalWarning != nil && *h.CriticalWarning != 0 {
+		h.Problems = append(h.Problems, fmt.Sprintf("critical warning 0x%02x", *h.CriticalWarning))
+	}
+
+	over := func(name string, value *uint64, limit uint64) {
+		if value != nil && *value > limit {
+			h.Problems = append(h.Problems, fmt.Sprintf("%s %d over %d", name, *value, limit))
+		}
+	}
+
+	over("reallocated sectors", h.ReallocatedSectors, t.MaxReallocatedSectors)
+	over("pending sectors", h.PendingSectors, t.MaxPendingSectors)
+	over("media errors", h.MediaErrors, t.MaxMediaErrors)
+	over("percentage used", h.PercentageUsed, t.MaxPercentageUsed)
+}
+
+// Err returns an UnhealthyDeviceError if Evaluate found problems.
+func (h *DiskHealth) Err() error {
+	if h.Healthy() {
+		return nil
+	}
+
+	return UnhealthyDeviceError(h.Device, h.Problems)
+}
+
+// HealthRequirements are the requirements of reading the health of disks.
+// NVMe disks are read with nvme-cli if smartctl is missing.
+func HealthRequirements() Requirements {
+	return tools(true, "smartctl", "nvme")
+}
+
+// ReadDiskHealth reads the SMART data or NVMe SMART log of a disk with
+// smartctl or, for NVMe disks on systems without smartctl, nvme-cli.
+func ReadDiskHealth(ctx context.Context, file string) (h *DiskHealth, err error) {
+	if _, lookErr := exec.LookPath("smartctl"); lookErr != nil && strings.HasPrefix(filepath.Base(file), "nvme") {
+		out, err := command.Output(ctx, "nvme", "smart-log", "--output-format=json", file)
+		if err != nil {
+			return nil, err
+		}
+
+		return parseNVMeSmartLog(file, []byte(out))
+	}
+
+	out, err := command.Output(ctx, "smartctl", "--json", "--all", file)
+	if err != nil {
+		var execErr *command.ExecutionError
+		if !errors.As(err, &execErr) || execErr.ExitCode < 0 || execErr.ExitCode&smartctlFatalExitBits != 0 {
+			return nil, err
+		}
+	}
+
+	return parseSmartctl(file, []byte(out))
+}
+
+// smartctlOutput is the part of smartctl --json --all read by ReadDiskHealth.
+type smartctlOutput struct {
+	Device struct {
+		Protocol string `json:"protocol"`
+	} `json:"device"`
+	SmartStatus *struct {
+		Passed bool `json:"passed"`
+	} `json:"smart_status"`
+	ATASmartAttributes *struct {
+		Table []struct {
+			ID  int `json:"id"`
+			Raw struct {
+				Value uint64 `json:"value"`
+			} `json:"raw"`
+		} `json:"table"`
+	} `json:"ata_smart_attributes"`
+	NVMeSmartLog *struct {
+		CriticalWarning uint64 `json:"critical_warning"`
+		PercentageUsed  uint64 `json:"percentage_used"`
+		MediaErrors     uint64 `json:"media_errors"`
+	} `json:"nvme_smart_health_information_log"`
+	SCSIGrownDefectList *uint64 `json:"scsi_grown_defect_list"`
+	SCSIErrorCounterLog *struct {
+		Read struct {
+			TotalUncorrectedErrors uint64 `json:"total_uncorrected_errors"`
+		} `json:"read"`
+		Write struct {
+			TotalUncorrectedErrors uint64 `json:"total_uncorrected_errors"`
+		} `json:"write"`
+	} `json:"scsi_error_counter_log"`
+	SCSIPercentageUsed *uint64 `json:"scsi_percentage_used_endurance_indicator"`
+	Temperature        *struct {
+		Current uint64 `json:"current"`
+	} `json:"temperature"`
+	PowerOnTime *struct {
+		Hours uint64 `json:"hours"`
+	} `json:"power_on_time"`
+}
+
+func parseSmartctl(file string, out []byte) (h *DiskHealth, err error) {
+	var s smartctlOutput
+	if err = json.Unmarshal(out, &s); err != nil {
+		return nil, FailedHealthReadError(file, err)
+	}
+
+	h = &DiskHealth{Device: file, Source: HealthSourceSmartctl, Protocol: strings.ToLower(s.Device.Protocol), Problems: []string{}}
+
+	if s.SmartStatus != nil {
+		h.SmartPassed = &s.SmartStatus.Passed
+	}
+
+	if s.ATASmartAttributes != nil {
+		for _, a := range s.ATASmartAttributes.Table {
+			value := a.Raw.Value
+
+			switch a.ID {
+			case ataReallocatedSectors:
+				h.ReallocatedSectors = &value
+			case ataPendingSectors:
+				h.PendingSectors = &value
+			case ataReportedUncorrect:
+				h.MediaErrors = &value
+			}
+		}
+	}
+
+	if l := s.NVMeSmartLog; l != nil {
+		h.CriticalWarning, h.PercentageUsed, h.MediaErrors = &l.CriticalWarning, &l.PercentageUsed, &l.MediaErrors
+	}
+
+	h.ReallocatedSectors = firstNonNil(h.ReallocatedSectors, s.SCSIGrownDefectList)
+	h.PercentageUsed = firstNonNil(h.PercentageUsed, s.SCSIPercentageUsed)
+
+	if l := s.SCSIErrorCounterLog; l != nil {
+		uncorrected := l.Read.TotalUncorrectedErrors + l.Write.TotalUncorrectedErrors
+		h.MediaErrors = &uncorrected
+	}
+
+	if s.Temperature != nil {
+		h.Temperature = &s.Temperature.Current
+	}
+
+	if s.PowerOnTime != nil {
+		h.PowerOnHours = &s.PowerOnTime.Hours
+	}
+
+	h.Supported = h.SmartPassed != nil || h.ReallocatedSectors != nil || h.MediaErrors != nil || h.CriticalWarning != nil
+
+	return
+}
+
+// parseNVMeSmartLog parses nvme smart-log --output-format=json. Versions
+// of nvme-cli differ in the names of some fields.
+func parseNVMeSmartLog(file string, out []byte) (h *DiskHealth, err error) {
+	var l map[string]any
+	if err = json.Unmarshal(out, &l); err != nil {
+		return nil, FailedHealthReadError(file, err)
+	}
+
+	field := func(names ...string) *uint64 {
+		for _, name := range names {
+			if v, ok := l[name].(float64); ok && v >= 0 {
+				u := uint64(v)
+				return &u
+			}
+		}
+
+		return nil
+	}
+
+	h = &DiskHealth{
+		Device:          file,
+		Protocol:        "nvme",
+		Source:          HealthSourceNVMe,
+		Supported:       true,
+		CriticalWarning: field("critical_warning"),
+		PercentageUsed:  field("percent_used", "percentage_used"),
+		MediaErrors:     field("media_errors"),
+		PowerOnHours:    field("power_on_hours"),
+		Problems:        []string{},
+	}
+
+	// nvme-cli reports the temperature in Kelvin.
+	if kelvin := field("temperature"); kelvin != nil && *kelvin >= 273 {
+		celsius := *kelvin - 273
+		h.Temperature = &celsius
+	}
+
+	return
+}
+
+func firstNonNil(values ...*uint64) *uint64 {
+	for _, v := range values {
+		if v != nil {
+			return v
+		}
+	}
+
+	return nil
+}
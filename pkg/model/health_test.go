@@ -0,0 +1,125 @@
+package model
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+const (
+	smartctlATA = `{
+  "device": {"name": "/dev/sda", "type": "sat", "protocol": "ATA"},
+  "smart_status": {"passed": true},
+  "ata_smart_attributes": {"revision": 16, "table": [
+    {"id": 5, "name": "Reallocated_Sector_Ct", "raw": {"value": 12, "string": "12"}},
+    {"id": 9, "name": "Power_On_Hours", "raw": {"value": 20456, "string": "20456"}},
+    {"id": 187, "name": "Reported_Uncorrect", "raw": {"value": 0, "string": "0"}},
+    {"id": 197, "name": "Current_Pending_Sector", "raw": {"value": 2, "string": "2"}}
+  ]},
+  "power_on_time": {"hours": 20456},
+  "temperature": {"current": 31}
+}`
+
+	smartctlNVMe = `{
+  "device": {"name": "/dev/nvme0", "type": "nvme", "protocol": "NVMe"},
+  "smart_status": {"passed": false, "nvme": {"value": 4}},
+  "nvme_smart_health_information_log": {
+    "critical_warning": 4, "temperature": 40, "available_spare": 100, "available_spare_threshold": 10,
+    "percentage_used": 3, "media_errors": 0, "num_err_log_entries": 12, "power_on_hours": 812
+  },
+  "power_on_time": {"hours": 812},
+  "temperature": {"current": 40}
+}`
+
+	smartctlSCSI = `{
+  "device": {"name": "/dev/sdc", "type": "scsi", "protocol": "SCSI"},
+  "smart_status": {"passed": true},
+  "scsi_grown_defect_list": 0,
+  "scsi_percentage_used_endurance_indicator": 95,
+  "scsi_error_counter_log": {"read": {"total_uncorrected_errors": 1}, "write": {"total_uncorrected_errors": 2}},
+  "temperature": {"current": 28}
+}`
+
+	smartctlVirtual = `{
+  "device": {"name": "/dev/vda", "type": "scsi", "protocol": "SCSI"},
+  "smartctl": {"exit_status": 4}
+}`
+
+	nvmeSmartLog = `{
+  "critical_warning": 0,
+  "temperature": 313,
+  "avail_spare": 100,
+  "spare_thresh": 10,
+  "percent_used": 97,
+  "media_errors": 1,
+  "power_on_hours": 40000
+}`
+)
+
+func TestParseDiskHealth(t *testing.T) {
+	u := func(v uint64) *uint64 { return &v }
+
+	cases := []struct {
+		name     string
+		parse    func(string, []byte) (*DiskHealth, error)
+		out      string
+		want     DiskHealth
+		problems []string
+	}{
+		{
+			"ata", parseSmartctl, smartctlATA,
+			DiskHealth{Protocol: "ata", Source: HealthSourceSmartctl, Supported: true, ReallocatedSectors: u(12), PendingSectors: u(2), MediaErrors: u(0), Temperature: u(31), PowerOnHours: u(20456)},
+			[]string{"reallocated sectors 12 over 10", "pending sectors 2 over 0"},
+		},
+		{
+			"nvme", parseSmartctl, smartctlNVMe,
+			DiskHealth{Protocol: "nvme", Source: HealthSourceSmartctl, Supported: true, CriticalWarning: u(4), PercentageUsed: u(3), MediaErrors: u(0), Temperature: u(40), PowerOnHours: u(812)},
+			[]string{"SMART overall health self-assessment failed", "critical warning 0x04"},
+		},
+		{
+			"scsi", parseSmartctl, smartctlSCSI,
+			DiskHealth{Protocol: "scsi", Source: HealthSourceSmartctl, Supported: true, ReallocatedSectors: u(0), MediaErrors: u(3), PercentageUsed: u(95), Temperature: u(28)},
+			[]string{"media errors 3 over 0", "percentage used 95 over 90"},
+		},
+		{
+			"virtual", parseSmartctl, smartctlVirtual,
+			DiskHealth{Protocol: "scsi", Source: HealthSourceSmartctl},
+			[]string{},
+		},
+		{
+			"nvme-cli", parseNVMeSmartLog, nvmeSmartLog,
+			DiskHealth{Protocol: "nvme", Source: HealthSourceNVMe, Supported: true, CriticalWarning: u(0), PercentageUsed: u(97), MediaErrors: u(1), Temperature: u(40), PowerOnHours: u(40000)},
+			[]string{"media errors 1 over 0", "percentage used 97 over 90"},
+		},
+	}
+
+	for _, c := range cases {
+		h, err := c.parse("/dev/test", []byte(c.out))
+		if err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+
+		// Only SmartPassed is compared through Problems.
+		h.SmartPassed = nil
+		c.want.Device, c.want.Problems = "/dev/test", []string{}
+
+		if !reflect.DeepEqual(*h, c.want) {
+			t.Errorf("%s: expected %+v, got %+v", c.name, c.want, *h)
+		}
+
+		h, _ = c.parse("/dev/test", []byte(c.out))
+		h.Evaluate(DefaultHealthThresholds())
+
+		if !reflect.DeepEqual(h.Problems, c.problems) {
+			t.Errorf("%s: expected problems %q, got %q", c.name, c.problems, h.Problems)
+		}
+
+		if err := h.Err(); (err != nil) != (len(c.problems) > 0) || err != nil && !errors.Is(err, ErrUnhealthyDevice) {
+			t.Errorf("%s: unexpected error %v", c.name, err)
+		}
+	}
+
+	if _, err := parseSmartctl("/dev/test", []byte("Smartctl open device: /dev/test failed")); !errors.Is(err, ErrFailedHealthRead) {
+		t.Errorf("expected failed health read, got %v", err)
+	}
+}
@@ -0,0 +1,263 @@
+package model
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/bmc-toolbox/common"
+	"github.com/metal-toolbox/vogelkop/internal/command"
+)
+
+// Kinds of JournalStep.
+const (
+	StepPartition       = "partition"
+	StepRaidArray       = "raid_array"
+	StepEncryptedVolume = "encrypted_volume"
+	StepVolumeGroup     = "volume_group"
+	StepLogicalVolume   = "logical_volume"
+	StepFileSystem      = "file_system"
+	StepNVMeNamespaces  = "nvme_namespaces"
+)
+
+// Statuses of a JournalStep.
+const (
+	StepDone           = "done"
+	StepFailed         = "failed"
+	StepRolledBack     = "rolled_back"
+	StepRollbackFailed = "rollback_failed"
+)
+
+// Statuses of a Journal.
+const (
+	JournalRunning    = "running"
+	JournalComplete   = "complete"
+	JournalFailed     = "failed"
+	JournalRolledBack = "rolled_back"
+)
+
+// Journal records the changes StorageLayout.Apply made, so that a failed run
+// can be rolled back or resumed. Steps that found the system already matching
+// the layout are not recorded.
+type Journal struct {
+	Layout  string         `json:"layout"`
+	Status  string         `json:"status"`
+	Started time.Time      `json:"started"`
+	Steps   []*JournalStep `json:"steps"`
+
+	path string
+}
+
+// JournalStep is one change made by Apply, with what is needed to undo it.
+type JournalStep struct {
+	Kind string `json:"kind"`
+	// Device is the disk of a partition, the device an encrypted volume or
+	// filesystem was created on, the device file of a raid array or the
+	// controller of NVMe namespaces.
+	Device   string   `json:"device,omitempty"`
+	Position uint     `json:"position,omitempty"`
+	Name     string   `json:"name,omitempty"`
+	RaidType string   `json:"raid_type,omitempty"`
+	Members  []string `json:"members,omitempty"`
+	// VirtualDiskID is the controller ID of a hardware raid array.
+	VirtualDiskID string    `json:"virtual_disk_id,omitempty"`
+	Status        string    `json:"status"`
+	Error         string    `json:"error,omitempty"`
+	Time          time.Time `json:"time"`
+}
+
+// OpenJournal returns the journal stored at path. An unfinished journal of the
+// same layout is resumed and a finished one is replaced by a new journal. An
+// unfinished journal of another layout is still needed to roll that layout
+// back and fails with an UnfinishedJournalError. An empty path keeps the
+// journal in memory only.
+func OpenJournal(path, layout string) (j *Journal, err error) {
+	if path != "" {
+		j, err = LoadJournal(path)
+
+		switch {
+		case errors.Is(err, os.ErrNotExist):
+		case err != nil:
+			return nil, err
+		case j.Status != JournalRunning && j.Status != JournalFailed:
+		case j.Layout == layout:
+			j.Status = JournalRunning
+			return j, j.save()
+		default:
+			return nil, UnfinishedJournalError(path, j.Layout, j.Status)
+		}
+	}
+
+	j = &Journal{Layout: layout, Status: JournalRunning, Started: time.Now().UTC(), path: path}
+
+	return j, j.save()
+}
+
+// LoadJournal reads the journal stored at path.
+func LoadJournal(path string) (j *Journal, err error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return
+	}
+
+	j = &Journal{path: path}
+	err = json.Unmarshal(data, j)
+
+	return
+}
+
+// record appends a step and saves the journal.
+func (j *Journal) record(step *JournalStep, stepErr error) error {
+	step.Status = StepDone
+	step.Time = time.Now().UTC()
+
+	if stepErr != nil {
+		step.Status = StepFailed
+		step.Error = stepErr.Error()
+	}
+
+	j.Steps = append(j.Steps, step)
+
+	return j.save()
+}
+
+// done returns the number of completed steps.
+func (j *Journal) done() (n int) {
+	for _, step := range j.Steps {
+		if step.Status == StepDone {
+			n++
+		}
+	}
+
+	return
+}
+
+// finish sets the status of the journal and saves it.
+func (j *Journal) finish(status string) error {
+	j.Status = status
+	return j.save()
+}
+
+// save writes the journal to its path, replacing the previous version
+// atomically so that a crash never leaves a truncated journal behind.
+func (j *Journal) save() (err error) {
+	if j.path == "" {
+		return
+	}
+
+	data, err := json.MarshalIndent(j, "", "  ")
+	if err != nil {
+		return
+	}
+
+	if err = os.MkdirAll(filepath.Dir(j.path), 0o755); err != nil {
+		return
+	}
+
+	tmp := j.path + ".tmp"
+	if err = os.WriteFile(tmp, append(data, '\n'), 0o600); err != nil {
+		return
+	}
+
+	return os.Rename(tmp, j.path)
+}
+
+// Rollback undoes the completed steps in reverse order: filesystems are
+// wiped, logical volumes and volume groups removed, encrypted volumes closed
+// and wiped, arrays stopped, partitions deleted and NVMe controllers reset
+// to a single namespace. Rollback carries on past
+// steps that cannot be undone and reports them all at the end.
+func (j *Journal) Rollback(ctx context.Context) (err error) {
+	log := contextLogger(ctx)
+
+	var errs []error
+
+	for i := len(j.Steps) - 1; i >= 0; i-- {
+		step := j.Steps[i]
+		if step.Status != StepDone && step.Status != StepRollbackFailed {
+			continue
+		}
+
+		if undoErr := step.Undo(ctx); undoErr != nil {
+			log.Errorw("failed to roll back step", "kind", step.Kind, "name", step.Name, "device", step.Device, "err", undoErr)
+
+			step.Status = StepRollbackFailed
+			step.Error = undoErr.Error()
+			errs = append(errs, undoErr)
+		} else {
+			log.Infow("rolled back step", "kind", step.Kind, "name", step.Name, "device", step.Device)
+
+			step.Status = StepRolledBack
+			step.Error = ""
+		}
+
+		if saveErr := j.save(); saveErr != nil {
+			errs = append(errs, saveErr)
+		}
+	}
+
+	status := JournalRolledBack
+	if len(errs) > 0 {
+		status = JournalFailed
+	}
+
+	if finishErr := j.finish(status); finishErr != nil {
+		errs = append(errs, finishErr)
+	}
+
+	return errors.Join(errs...)
+}
+
+// Undo reverts the change the step made.
+func (s *JournalStep) Undo(ctx context.Context) (err error) {
+	switch s.Kind {
+	case StepPartition:
+		bd := &BlockDevice{ControllerPhysicalDeviceID: -1, File: s.Device}
+		if _, err = (&Partition{Position: s.Position, BlockDevice: bd}).Delete(ctx); err != nil {
+			return
+		}
+
+		return bd.Rescan(ctx)
+	case StepRaidArray:
+		array := &RaidArray{Name: s.Name, ControllerVirtualDiskID: -1}
+		if s.RaidType == common.SlugRAIDImplHardware {
+			if id, atoiErr := strconv.Atoi(s.VirtualDiskID); atoiErr == nil {
+				array.ControllerVirtualDiskID = id
+			}
+
+			return array.DeleteHardware(ctx)
+		}
+
+		if _, err = array.DeleteLinux(ctx); err != nil {
+			return
+		}
+
+		_, err = command.Call(ctx, "mdadm", append([]string{"--zero-superblock"}, s.Members...)...)
+	case StepEncryptedVolume:
+		if _, err = command.Call(ctx, "cryptsetup", "close", s.Name); err != nil {
+			return
+		}
+
+		_, err = command.Call(ctx, "wipefs", "-a", s.Device)
+	case StepVolumeGroup:
+		if _, err = command.Call(ctx, "vgremove", "--force", s.Name); err != nil {
+			return
+		}
+
+		_, err = command.Call(ctx, "pvremove", append([]string{"--yes"}, s.Members...)...)
+	case StepLogicalVolume:
+		_, err = command.Call(ctx, "lvremove", "--force", s.Name)
+	case StepFileSystem:
+		_, err = command.Call(ctx, "wipefs", "-a", s.Device)
+	case StepNVMeNamespaces:
+		// The namespaces that were replaced cannot be restored, so the
+		// controller goes back to how drives ship.
+		err = resetNVMeNamespaces(ctx, s.Device)
+	}
+
+	return
+}
@@ -0,0 +1,88 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+func TestOpenJournal(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "journal.json")
+
+	j, err := OpenJournal(path, "compute")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := j.record(&JournalStep{Kind: StepPartition, Device: "/dev/sda", Position: 1}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := j.record(&JournalStep{Kind: StepPartition, Device: "/dev/sda", Position: 2}, errors.New("sgdisk failed")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := j.finish(JournalFailed); err != nil {
+		t.Fatal(err)
+	}
+
+	resumed, err := OpenJournal(path, "compute")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if resumed.Status != JournalRunning || len(resumed.Steps) != 2 ||
+		resumed.Steps[0].Status != StepDone || resumed.Steps[1].Status != StepFailed || resumed.Steps[1].Error != "sgdisk failed" {
+		t.Errorf("failed journal was not resumed: %+v", resumed)
+	}
+
+	if _, err := OpenJournal(path, "storage"); !errors.Is(err, ErrUnfinishedJournal) {
+		t.Errorf("OpenJournal of another layout with an unfinished journal = %v, want %v", err, ErrUnfinishedJournal)
+	}
+
+	if err := resumed.finish(JournalComplete); err != nil {
+		t.Fatal(err)
+	}
+
+	if fresh, err := OpenJournal(path, "storage"); err != nil || len(fresh.Steps) != 0 || fresh.Status != JournalRunning {
+		t.Errorf("complete journal was resumed: %+v %v", fresh, err)
+	}
+}
+
+func TestJournalRollback(t *testing.T) {
+	t.Setenv("PATH", t.TempDir())
+
+	path := filepath.Join(t.TempDir(), "journal.json")
+
+	j, err := OpenJournal(path, "compute")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	j.Steps = []*JournalStep{
+		{Kind: StepPartition, Device: "/dev/sda", Position: 1, Status: StepDone},
+		{Kind: StepLogicalVolume, Name: "vg0/data", Status: StepFailed},
+	}
+
+	err = j.Rollback(context.Background())
+	if err == nil {
+		t.Fatal("Rollback without sgdisk succeeded")
+	}
+
+	loaded, err := LoadJournal(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if loaded.Status != JournalFailed || loaded.Steps[0].Status != StepRollbackFailed || loaded.Steps[1].Status != StepFailed {
+		t.Errorf("unexpected journal after rollback: %+v %+v %+v", loaded, loaded.Steps[0], loaded.Steps[1])
+	}
+}
+
+func TestApplyInvalidOnErrorPolicy(t *testing.T) {
+	err := (&StorageLayout{}).Apply(context.Background(), &ApplyOptions{OnError: "retry"})
+	if !errors.Is(err, ErrInvalidOnErrorPolicy) {
+		t.Errorf("Apply returned %v, want %v", err, ErrInvalidOnErrorPolicy)
+	}
+}
@@ -0,0 +1,126 @@
+package model
+
+import (
+	"context"
+	"io"
+	"path/filepath"
+	"strings"
+
+	"github.com/bmc-toolbox/common"
+	"github.com/metal-toolbox/vogelkop/internal/command"
+	"go.uber.org/zap"
+)
+
+// DecodeStorageLayout reads a JSON encoded StorageLayout and validates it,
+// see ValidateLayoutDocument.
+func DecodeStorageLayout(r io.Reader) (layout *StorageLayout, err error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return
+	}
+
+	layout, problems, err := ValidateLayoutDocument(data)
+	if err == nil && len(problems) > 0 {
+		err = InvalidLayoutError(problems)
+	}
+
+	return
+}
+
+// ArrayMembers returns the block devices making up a software RAID array:
+// its explicit Devices followed by every partition in the layout named after
+// its PartitionName. Partition devices are resolved through
+// Partition.GetBlockDevice and therefore only exist once partitioned.
+func (l *StorageLayout) ArrayMembers(a *RaidArray) (members []*BlockDevice, err error) {
+	for _, d := range a.Devices {
+		if err = d.Resolve(); err != nil {
+			return
+		}
+
+		members = append(members, d)
+	}
+
+	if a.PartitionName == "" {
+		return
+	}
+
+	for _, bd := range l.BlockDevices {
+		for _, p := range bd.Partitions {
+			if p.Name != a.PartitionName {
+				continue
+			}
+
+			var file string
+
+			if file, err = p.GetBlockDevice(bd.File); err != nil {
+				return
+			}
+
+			members = append(members, &BlockDevice{ControllerPhysicalDeviceID: -1, File: file})
+		}
+	}
+
+	return
+}
+
+// DeviceFile returns the device file a name used in the layout refers to.
+// Names are device files, software RAID arrays, encrypted volumes, logical
+// volumes as <vg>/<lv> or otherwise the single partition of that name.
+func (l *StorageLayout) DeviceFile(name string) (file string, err error) {
+	if strings.HasPrefix(name, "/") {
+		return name, nil
+	}
+
+	for _, a := range l.RaidArrays {
+		if a.Name == name && a.GetRaidType() == common.SlugRAIDImplLinuxSoftware {
+			return filepath.Join("/dev/md", a.Name), nil
+		}
+	}
+
+	for _, e := range l.EncryptedVolumes {
+		if e.Name == name {
+			return e.MappedFile(), nil
+		}
+	}
+
+	if vgName, lvName, found := strings.Cut(name, "/"); found {
+		for _, g := range l.VolumeGroups {
+			for _, lv := range g.LogicalVolumes {
+				if g.Name == vgName && lv.Name == lvName {
+					return g.LogicalVolumeFile(lv), nil
+				}
+			}
+		}
+	}
+
+	var matches []string
+
+	for _, bd := range l.BlockDevices {
+		for _, p := range bd.Partitions {
+			if p.Name != name {
+				continue
+			}
+
+			if file, err = p.GetBlockDevice(bd.File); err != nil {
+				return
+			}
+
+			matches = append(matches, file)
+		}
+	}
+
+	if len(matches) != 1 {
+		return "", UnresolvedNameError(name, matches)
+	}
+
+	return matches[0], nil
+}
+
+// contextLogger returns the logger stored in the context, or a no-op logger.
+func contextLogger(ctx context.Context) *zap.SugaredLogger {
+	if l := command.LoggerValueFromContext(ctx); l != nil {
+		return l
+	}
+
+	return zap.NewNop().Sugar()
+}
@@ -0,0 +1,118 @@
+package model
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/metal-toolbox/vogelkop/internal/command"
+)
+
+// VolumeGroup is an LVM volume group. PhysicalVolumes name raid arrays,
+// encrypted volumes or partitions of the StorageLayout, or are device files.
+type VolumeGroup struct {
+	Name            string           `json:"name"`
+	PhysicalVolumes []string         `json:"physical_volumes"`
+	LogicalVolumes  []*LogicalVolume `json:"logical_volumes"`
+}
+
+// LogicalVolume is an LVM logical volume, referred to as <vg>/<lv> by the
+// rest of the StorageLayout. A Size of "0" or "100%FREE" takes the space left
+// in the volume group.
+type LogicalVolume struct {
+	Name string `json:"name"`
+	Size string `json:"size"`
+}
+
+// LogicalVolumeFile returns the device file of a logical volume.
+func (g *VolumeGroup) LogicalVolumeFile(lv *LogicalVolume) string {
+	return filepath.Join(devRoot, g.Name, lv.Name)
+}
+
+// Ensure creates the volume group from the given physical volume device files
+// unless it exists. An existing volume group with different physical volumes
+// is reported as a StateConflictError; volume groups are never recreated, not
+// even with force. Force does allow pvcreate to overwrite other signatures on
+// the physical volumes.
+// It returns whether anything changed and an error.
+func (g *VolumeGroup) Ensure(ctx context.Context, pvFiles []string, force bool) (changed bool, err error) {
+	have, exists, err := g.physicalVolumes(ctx)
+	if err != nil {
+		return
+	}
+
+	if exists {
+		var want []string
+		for _, f := range pvFiles {
+			want = append(want, resolveDeviceFile(f))
+		}
+
+		slices.Sort(want)
+
+		if !slices.Equal(want, have) {
+			err = StateConflictError("volume group "+g.Name, []Difference{{Field: "physical_volumes", Want: strings.Join(want, ","), Have: strings.Join(have, ",")}})
+		}
+
+		return
+	}
+
+	pvcreate := []string{"--yes"}
+	if force {
+		pvcreate = append(pvcreate, "-ff")
+	}
+
+	if _, err = command.Call(ctx, "pvcreate", append(pvcreate, pvFiles...)...); err != nil {
+		return
+	}
+
+	_, err = command.Call(ctx, "vgcreate", append([]string{g.Name}, pvFiles...)...)
+	changed = err == nil
+
+	return
+}
+
+// EnsureLogicalVolume creates the logical volume in the volume group unless
+// it exists. Existing logical volumes are not resized.
+// It returns whether anything changed and an error.
+func (g *VolumeGroup) EnsureLogicalVolume(ctx context.Context, lv *LogicalVolume) (changed bool, err error) {
+	if _, statErr := os.Stat(g.LogicalVolumeFile(lv)); statErr == nil {
+		return
+	}
+
+	args := []string{"--yes", "-n", lv.Name}
+
+	switch lv.Size {
+	case "", "0", "100%FREE":
+		args = append(args, "-l", "100%FREE")
+	default:
+		args = append(args, "-L", strings.TrimPrefix(lv.Size, "+"))
+	}
+
+	_, err = command.Call(ctx, "lvcreate", append(args, g.Name)...)
+	changed = err == nil
+
+	return
+}
+
+// physicalVolumes returns the sorted device files of the physical volumes of
+// the volume group and whether the volume group exists.
+func (g *VolumeGroup) physicalVolumes(ctx context.Context) (pvFiles []string, exists bool, err error) {
+	out, err := command.Output(ctx, "pvs", "--noheadings", "-o", "pv_name,vg_name")
+	if err != nil {
+		return
+	}
+
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 2 && fields[1] == g.Name {
+			pvFiles = append(pvFiles, resolveDeviceFile(fields[0]))
+		}
+	}
+
+	slices.Sort(pvFiles)
+	exists = len(pvFiles) > 0
+
+	return
+}
@@ -1,8 +1,12 @@
 package model
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"strings"
+
+	"github.com/metal-toolbox/vogelkop/internal/command"
 )
 
 type StorageLayout struct {
@@ -10,6 +14,13 @@ type StorageLayout struct {
 	RaidArrays   []*RaidArray   `json:"raid_arrays"`
 	BlockDevices []*BlockDevice `json:"block_devices"`
 	FileSystems  []*FileSystem  `json:"file_systems"`
+	// EncryptedVolumes are opened after RAID arrays are assembled and
+	// VolumeGroups are created on top of them.
+	EncryptedVolumes []*EncryptedVolume `json:"encrypted_volumes"`
+	VolumeGroups     []*VolumeGroup     `json:"volume_groups"`
+	// NVMeNamespaces divide NVMe controllers into namespaces before
+	// anything else is applied.
+	NVMeNamespaces []*NVMeNamespaceLayout `json:"nvme_namespaces,omitempty"`
 }
 
 type FileSystem struct {
@@ -28,6 +39,36 @@ var (
 	ErrInvalidRaidObjectType       = errors.New("invalid raid object type")
 	ErrInvalidDelimitedPartition   = errors.New("invalid delimited partition string")
 	ErrVirtualDiskNotFound         = errors.New("virtual disk not found")
+	ErrInvalidSize                 = errors.New("invalid size")
+	ErrStateConflict               = errors.New("current state conflicts with specification")
+	ErrDeviceInUse                 = errors.New("device in use")
+	ErrDeviceNotFound              = errors.New("device not found")
+	ErrAmbiguousSelector           = errors.New("selector matches more than one device")
+	ErrInvalidSelector             = errors.New("invalid device selector")
+	ErrPartitionsNotReady          = errors.New("partition device nodes did not appear")
+	ErrUnresolvedName              = errors.New("name does not refer to exactly one device of the layout")
+	ErrMissingKeyFile              = errors.New("encrypted volume needs a key file")
+	ErrInvalidOnErrorPolicy        = errors.New("invalid on-error policy")
+	ErrTemplateNotSatisfied        = errors.New("not enough block devices match template")
+	ErrInvalidTemplate             = errors.New("invalid layout template")
+	ErrInvalidLayout               = errors.New("invalid storage layout")
+	ErrDriveNotExist               = errors.New("drive does not exist")
+	ErrDriveWiperNotFound          = errors.New("failed to find appropriate drive wiper")
+	ErrDuplicateDevice             = errors.New("device is listed more than once")
+	ErrInvalidArgument             = errors.New("invalid argument")
+	ErrPartialSuccess              = errors.New("partially succeeded")
+	ErrMissingRequirements         = errors.New("required tools or kernel modules are missing")
+	ErrDeviceLocked                = errors.New("device is locked by another operation")
+	ErrUnhealthyDevice             = errors.New("device health is over thresholds")
+	ErrFailedHealthRead            = errors.New("failed to read device health")
+	ErrNotNVMe                     = errors.New("device is not an NVMe controller or namespace")
+	ErrNoNamespaceManagement       = errors.New("NVMe controller does not support namespace management")
+	ErrFailedNVMeRead              = errors.New("failed to read NVMe identify data")
+	ErrMismatchedMembers           = errors.New("raid array members differ in sector size or size")
+	ErrUnsupportedOption           = errors.New("raid controller does not support the virtual disk option")
+	ErrUnsupportedController       = errors.New("raid controller is not supported")
+	ErrFailedControllerCommand     = errors.New("raid controller command failed")
+	ErrUnfinishedJournal           = errors.New("journal of another layout is unfinished")
 )
 
 func BlockDeviceFailedValidationError(bd *BlockDevice) error {
@@ -57,3 +98,216 @@ func InvalidDelimitedPartitionError(delimitedString string) error {
 func VirtualDiskNotFoundError(a *RaidArray) error {
 	return fmt.Errorf("VirtualDiskNotFound %w : %v", ErrVirtualDiskNotFound, a)
 }
+
+func InvalidSizeError(size string) error {
+	return fmt.Errorf("InvalidSize %w : %s", ErrInvalidSize, size)
+}
+
+func StateConflictError(object string, differences []Difference) error {
+	diffs := make([]string, 0, len(differences))
+	for _, d := range differences {
+		diffs = append(diffs, d.String())
+	}
+
+	return fmt.Errorf("StateConflict %w : %s (%s)", ErrStateConflict, object, strings.Join(diffs, "; "))
+}
+
+func DeviceInUseError(file string, usages []Usage) error {
+	reasons := make([]string, 0, len(usages))
+	for _, u := range usages {
+		reasons = append(reasons, u.String())
+	}
+
+	return fmt.Errorf("DeviceInUse %w : %s (%s)", ErrDeviceInUse, file, strings.Join(reasons, "; "))
+}
+
+func DeviceNotFoundError(selector string) error {
+	return fmt.Errorf("DeviceNotFound %w : %s", ErrDeviceNotFound, selector)
+}
+
+func AmbiguousSelectorError(selector string, matches []string) error {
+	return fmt.Errorf("AmbiguousSelector %w : %s matches %s", ErrAmbiguousSelector, selector, strings.Join(matches, ","))
+}
+
+func InvalidSelectorError(selector string) error {
+	return fmt.Errorf("InvalidSelector %w : %s", ErrInvalidSelector, selector)
+}
+
+func PartitionsNotReadyError(file string, missing []string) error {
+	return fmt.Errorf("PartitionsNotReady %w : %s (%s)", ErrPartitionsNotReady, file, strings.Join(missing, ","))
+}
+
+func UnresolvedNameError(name string, matches []string) error {
+	return fmt.Errorf("UnresolvedName %w : %s (%s)", ErrUnresolvedName, name, strings.Join(matches, ","))
+}
+
+func MissingKeyFileError(name string) error {
+	return fmt.Errorf("MissingKeyFile %w : %s", ErrMissingKeyFile, name)
+}
+
+func TemplateNotSatisfiedError(group string, want, have int) error {
+	return fmt.Errorf("TemplateNotSatisfied %w : device group %s needs %d, found %d", ErrTemplateNotSatisfied, group, want, have)
+}
+
+func InvalidTemplateError(reason string) error {
+	return fmt.Errorf("InvalidTemplate %w : %s", ErrInvalidTemplate, reason)
+}
+
+func InvalidLayoutError(problems []LayoutProblem) error {
+	messages := make([]string, 0, len(problems))
+	for _, p := range problems {
+		messages = append(messages, p.String())
+	}
+
+	return fmt.Errorf("InvalidLayout %w : %s", ErrInvalidLayout, strings.Join(messages, "; "))
+}
+
+func InvalidOnErrorPolicyError(policy string) error {
+	return fmt.Errorf("InvalidOnErrorPolicy %w : %s", ErrInvalidOnErrorPolicy, policy)
+}
+
+func DuplicateDeviceError(file string) error {
+	return fmt.Errorf("DuplicateDevice %w : %s", ErrDuplicateDevice, file)
+}
+
+func InvalidArgumentError(reason string) error {
+	return fmt.Errorf("InvalidArgument %w : %s", ErrInvalidArgument, reason)
+}
+
+func PartialSuccessError(succeeded, failed int, err error) error {
+	return fmt.Errorf("PartialSuccess %w : %d succeeded, %d failed: %w", ErrPartialSuccess, succeeded, failed, err)
+}
+
+func MissingRequirementsError(missing []string) error {
+	return fmt.Errorf("MissingRequirements %w : %s", ErrMissingRequirements, strings.Join(missing, ", "))
+}
+
+func DeviceLockedError(device, holder string) error {
+	return fmt.Errorf("DeviceLocked %w : %s held by %s", ErrDeviceLocked, device, holder)
+}
+
+func FailedHealthReadError(device string, err error) error {
+	return fmt.Errorf("FailedHealthRead %w : %s: %w", ErrFailedHealthRead, device, err)
+}
+
+func UnhealthyDeviceError(device string, problems []string) error {
+	return fmt.Errorf("UnhealthyDevice %w : %s (%s)", ErrUnhealthyDevice, device, strings.Join(problems, "; "))
+}
+
+func NotNVMeError(device string) error {
+	return fmt.Errorf("NotNVMe %w : %s", ErrNotNVMe, device)
+}
+
+func NoNamespaceManagementError(controller string) error {
+	return fmt.Errorf("NoNamespaceManagement %w : %s", ErrNoNamespaceManagement, controller)
+}
+
+func FailedNVMeReadError(device string, err error) error {
+	return fmt.Errorf("FailedNVMeRead %w : %s: %w", ErrFailedNVMeRead, device, err)
+}
+
+func MismatchedMembersError(array string, differences []Difference) error {
+	diffs := make([]string, 0, len(differences))
+	for _, d := range differences {
+		diffs = append(diffs, d.String())
+	}
+
+	return fmt.Errorf("MismatchedMembers %w : %s (%s)", ErrMismatchedMembers, array, strings.Join(diffs, "; "))
+}
+
+func UnsupportedOptionError(controller, option string, supported []string) error {
+	if len(supported) == 0 {
+		supported = []string{"none"}
+	}
+
+	return fmt.Errorf("UnsupportedOption %w : %s on %s (supported: %s)", ErrUnsupportedOption, option, controller, strings.Join(supported, ", "))
+}
+
+func UnsupportedControllerError(controller string) error {
+	return fmt.Errorf("UnsupportedController %w : %s", ErrUnsupportedController, controller)
+}
+
+func UnfinishedJournalError(path, layout, status string) error {
+	return fmt.Errorf("UnfinishedJournal %w : %s is %s for layout %s, roll it back or remove it first", ErrUnfinishedJournal, path, status, layout)
+}
+
+func FailedControllerCommandError(command, reason string) error {
+	return fmt.Errorf("FailedControllerCommand %w : %s: %s", ErrFailedControllerCommand, command, reason)
+}
+
+// Classes of errors, see ErrorClass.
+const (
+	ErrorClassInvalidInput    = "invalid_input"
+	ErrorClassDeviceNotFound  = "device_not_found"
+	ErrorClassDeviceInUse     = "device_in_use"
+	ErrorClassStateConflict   = "state_conflict"
+	ErrorClassDeviceUnhealthy = "device_unhealthy"
+	ErrorClassToolMissing     = "tool_missing"
+	ErrorClassToolFailed      = "tool_failed"
+	ErrorClassTimeout         = "timeout"
+	ErrorClassPartialSuccess  = "partial_success"
+	ErrorClassInterrupted     = "interrupted"
+	ErrorClassInternal        = "internal"
+)
+
+// ErrorClass returns what kind of failure err is, so that callers can tell
+// a layout or arguments to fix from a busy device, a timeout worth retrying
+// or a tool reporting broken hardware. An error matching several classes
+// gets the first of interrupted, partial_success, timeout, tool_missing,
+// device_in_use, state_conflict, device_unhealthy, device_not_found,
+// invalid_input and tool_failed.
+func ErrorClass(err error) string {
+	switch {
+	case err == nil:
+		return ""
+	case errors.Is(err, context.Canceled):
+		return ErrorClassInterrupted
+	case errors.Is(err, ErrPartialSuccess):
+		return ErrorClassPartialSuccess
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(err, ErrPartitionsNotReady):
+		return ErrorClassTimeout
+	case errors.Is(err, command.ErrToolMissing), errors.Is(err, ErrMissingRequirements):
+		return ErrorClassToolMissing
+	case errors.Is(err, ErrDeviceInUse), errors.Is(err, ErrDeviceLocked):
+		return ErrorClassDeviceInUse
+	case errors.Is(err, ErrStateConflict), errors.Is(err, ErrUnfinishedJournal):
+		return ErrorClassStateConflict
+	case errors.Is(err, ErrUnhealthyDevice):
+		return ErrorClassDeviceUnhealthy
+	case errors.Is(err, ErrDeviceNotFound),
+		errors.Is(err, ErrAmbiguousSelector),
+		errors.Is(err, ErrDriveNotExist),
+		errors.Is(err, ErrVirtualDiskNotFound),
+		errors.Is(err, ErrBlockDeviceFailedValidation),
+		errors.Is(err, ErrArrayDeviceFailedValidation):
+		return ErrorClassDeviceNotFound
+	case errors.Is(err, ErrInvalidArgument),
+		errors.Is(err, ErrInvalidRaidType),
+		errors.Is(err, ErrInvalidRaidObjectType),
+		errors.Is(err, ErrInvalidDelimitedPartition),
+		errors.Is(err, ErrInvalidSize),
+		errors.Is(err, ErrInvalidSelector),
+		errors.Is(err, ErrDuplicateDevice),
+		errors.Is(err, ErrUnresolvedName),
+		errors.Is(err, ErrMissingKeyFile),
+		errors.Is(err, ErrInvalidOnErrorPolicy),
+		errors.Is(err, ErrTemplateNotSatisfied),
+		errors.Is(err, ErrInvalidTemplate),
+		errors.Is(err, ErrInvalidLayout),
+		errors.Is(err, ErrNotNVMe),
+		errors.Is(err, ErrMismatchedMembers),
+		errors.Is(err, ErrUnsupportedOption),
+		errors.Is(err, ErrUnsupportedController),
+		errors.Is(err, ErrNoNamespaceManagement):
+		return ErrorClassInvalidInput
+	case errors.Is(err, command.ErrFailedExecution),
+		errors.Is(err, ErrFailedPartitioning),
+		errors.Is(err, ErrDriveWiperNotFound),
+		errors.Is(err, ErrFailedHealthRead),
+		errors.Is(err, ErrFailedNVMeRead),
+		errors.Is(err, ErrFailedControllerCommand):
+		return ErrorClassToolFailed
+	}
+
+	return ErrorClassInternal
+}
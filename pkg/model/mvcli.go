@@ -0,0 +1,79 @@
+package model
+
+import (
+	"bufio"
+	"context"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/metal-toolbox/ironlib/utils"
+	"github.com/metal-toolbox/vogelkop/internal/command"
+)
+
+// mvcliVirtualDisk is what mvcli shows of a virtual disk beyond the name and
+// level ironlib reads.
+type mvcliVirtualDisk struct {
+	ID string
+	// Members are the IDs of the physical disks.
+	Members []int
+	// StripeSize is in bytes, 0 if mvcli did not show it.
+	StripeSize uint64
+}
+
+// mvcliUtility returns the mvcli binary, which ironlib's environment
+// variable overrides.
+func mvcliUtility() string {
+	if utility := os.Getenv(utils.EnvMvcliUtility); utility != "" {
+		return utility
+	}
+
+	return "mvcli"
+}
+
+// readMvcliVirtualDisks reads the virtual disks of the Marvell controller.
+func readMvcliVirtualDisks(ctx context.Context) ([]*mvcliVirtualDisk, error) {
+	out, err := command.Output(ctx, mvcliUtility(), "info", "-o", "vd")
+	if err != nil {
+		return nil, err
+	}
+
+	return parseMvcliVirtualDisks(out), nil
+}
+
+// parseMvcliVirtualDisks parses mvcli info -o vd, which lists every virtual
+// disk as lines of "key: value" starting with its id.
+func parseMvcliVirtualDisks(out string) (virtualDisks []*mvcliVirtualDisk) {
+	var vd *mvcliVirtualDisk
+
+	scanner := bufio.NewScanner(strings.NewReader(out))
+	for scanner.Scan() {
+		key, value, found := strings.Cut(scanner.Text(), ":")
+		if !found {
+			continue
+		}
+
+		key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+
+		switch key {
+		case "id":
+			vd = &mvcliVirtualDisk{ID: value}
+			virtualDisks = append(virtualDisks, vd)
+		case "Stripe size":
+			// mvcli shows the stripe size in KiB.
+			if size, err := strconv.ParseUint(value, 10, 64); err == nil && vd != nil {
+				vd.StripeSize = size * KiB
+			}
+		case "PD RAID setup":
+			if vd != nil {
+				for _, field := range strings.Fields(value) {
+					if id, err := strconv.Atoi(field); err == nil {
+						vd.Members = append(vd.Members, id)
+					}
+				}
+			}
+		}
+	}
+
+	return
+}
@@ -0,0 +1,641 @@
+package model
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/metal-toolbox/ironlib/utils"
+	"github.com/metal-toolbox/vogelkop/internal/command"
+)
+
+// nvmeNamePattern matches the kernel names of NVMe controllers, namespaces
+// and their partitions, such as nvme0, nvme0n1 and nvme0n1p2.
+var nvmeNamePattern = regexp.MustCompile(`^(nvme\d+)(n\d+(p\d+)?)?$`)
+
+// nvmeCreatedPattern matches what nvme create-ns prints on success, such as
+// "create-ns: Success, created nsid:1".
+var nvmeCreatedPattern = regexp.MustCompile(`created nsid:\s*(\d+)`)
+
+// oacsNamespaceManagement is the bit of the Optional Admin Command Support
+// field of a controller that reports support for namespace management.
+const oacsNamespaceManagement = 1 << 3
+
+// nvmeNamespaceSizeTolerance absorbs the allocation granularity controllers
+// round namespace sizes up to.
+const nvmeNamespaceSizeTolerance = GiB
+
+// nvmeCommonNamespaceID addresses the capabilities common to all namespaces
+// of a controller.
+const nvmeCommonNamespaceID = "0xffffffff"
+
+// NVMeController is an NVMe controller and the namespaces it has allocated.
+type NVMeController struct {
+	// File is the character device of the controller, such as /dev/nvme0.
+	File   string `json:"file"`
+	ID     uint   `json:"controller_id"`
+	Model  string `json:"model"`
+	Serial string `json:"serial"`
+	// TotalCapacity and UnallocatedCapacity are in bytes. Controllers
+	// without namespace management report neither.
+	TotalCapacity       uint64 `json:"total_capacity"`
+	UnallocatedCapacity uint64 `json:"unallocated_capacity"`
+	MaxNamespaces       uint   `json:"max_namespaces"`
+	NamespaceManagement bool   `json:"namespace_management"`
+	// Formats are the LBA formats new namespaces can be created with, as
+	// the common namespace reports them.
+	Formats []NVMeLBAFormat `json:"lba_formats"`
+	// Namespaces are sorted by ID.
+	Namespaces []*NVMeNamespace `json:"namespaces"`
+}
+
+// NVMeNamespace is a namespace allocated on an NVMe controller.
+type NVMeNamespace struct {
+	ID uint `json:"id"`
+	// File is the block device of an attached namespace.
+	File     string `json:"file,omitempty"`
+	Attached bool   `json:"attached"`
+	// Size is in bytes.
+	Size      uint64          `json:"size"`
+	BlockSize uint64          `json:"block_size"`
+	LBAFormat uint            `json:"lba_format"`
+	Formats   []NVMeLBAFormat `json:"lba_formats"`
+}
+
+// NVMeLBAFormat is an LBA format a namespace can be formatted with.
+type NVMeLBAFormat struct {
+	Index        uint   `json:"index"`
+	DataSize     uint64 `json:"data_size"`
+	MetadataSize uint   `json:"metadata_size"`
+	// RelativePerformance is 0 for the best performance and 3 for the
+	// worst.
+	RelativePerformance uint `json:"relative_performance"`
+}
+
+// FormatFor returns the index of the LBA format with the given block size
+// and no metadata that performs best.
+func (ns *NVMeNamespace) FormatFor(blockSize uint64) (index uint, ok bool) {
+	for _, f := range ns.Formats {
+		if f.DataSize != blockSize || f.MetadataSize != 0 {
+			continue
+		}
+
+		if !ok || f.RelativePerformance < ns.Formats[index].RelativePerformance {
+			index, ok = f.Index, true
+		}
+	}
+
+	return
+}
+
+// NVMeRequirements are the requirements of managing NVMe namespaces.
+func NVMeRequirements() Requirements {
+	return tools(false, "nvme")
+}
+
+// NVMeControllerFile returns the character device of the NVMe controller of
+// a device file or selector of the controller, one of its namespaces or a
+// partition on one.
+func NVMeControllerFile(device string) (file string, err error) {
+	file, err = ResolveSelector(device)
+	if err != nil {
+		return
+	}
+
+	if resolved, linkErr := filepath.EvalSymlinks(file); linkErr == nil {
+		file = resolved
+	}
+
+	m := nvmeNamePattern.FindStringSubmatch(filepath.Base(file))
+	if m == nil {
+		return "", NotNVMeError(device)
+	}
+
+	return filepath.Join(devRoot, m[1]), nil
+}
+
+// ListNVMeControllers returns the character devices of every NVMe
+// controller.
+func ListNVMeControllers() (files []string, err error) {
+	entries, err := os.ReadDir(filepath.Join(sysfsRoot, "class", "nvme"))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+
+	for _, e := range entries {
+		files = append(files, filepath.Join(devRoot, e.Name()))
+	}
+
+	return
+}
+
+// ReadNVMeController identifies the NVMe controller and the namespaces it
+// has allocated.
+func ReadNVMeController(ctx context.Context, file string) (c *NVMeController, err error) {
+	out, err := command.Output(ctx, "nvme", "id-ctrl", "--output-format=json", file)
+	if err != nil {
+		return
+	}
+
+	if c, err = parseNVMeIDCtrl(file, []byte(out)); err != nil {
+		return
+	}
+
+	// Controllers without namespace management only report the namespaces
+	// attached to them.
+	listArgs := []string{"list-ns", "--output-format=json", file}
+	if c.NamespaceManagement {
+		listArgs = append(listArgs, "--all")
+	}
+
+	if c.NamespaceManagement {
+		// The common namespace identifies the formats of namespaces that
+		// do not exist yet.
+		if out, err = command.Output(ctx, "nvme", "id-ns", "--output-format=json", file, "--namespace-id="+nvmeCommonNamespaceID); err != nil {
+			return
+		}
+
+		var ns *NVMeNamespace
+		if ns, err = parseNVMeIDNS(file, []byte(out)); err != nil {
+			return
+		}
+
+		c.Formats = ns.Formats
+	}
+
+	if out, err = command.Output(ctx, "nvme", listArgs...); err != nil {
+		return
+	}
+
+	allocated, err := parseNVMeNamespaceList([]byte(out))
+	if err != nil {
+		return
+	}
+
+	attached := nvmeAttachedNamespaces(filepath.Base(file))
+
+	for _, id := range allocated {
+		args := []string{"id-ns", "--output-format=json", file, "--namespace-id=" + strconv.FormatUint(uint64(id), 10)}
+
+		nsFile, isAttached := attached[id]
+		if !isAttached {
+			// Identify namespaces that are allocated but not attached.
+			args = append(args, "--force")
+		}
+
+		if out, err = command.Output(ctx, "nvme", args...); err != nil {
+			return
+		}
+
+		var ns *NVMeNamespace
+		if ns, err = parseNVMeIDNS(file, []byte(out)); err != nil {
+			return
+		}
+
+		ns.ID, ns.File, ns.Attached = id, nsFile, isAttached
+		c.Namespaces = append(c.Namespaces, ns)
+	}
+
+	return
+}
+
+// nvmeAttachedNamespaces returns the block devices of the namespaces
+// attached to a controller, by namespace ID.
+func nvmeAttachedNamespaces(controller string) map[uint]string {
+	attached := make(map[uint]string)
+
+	entries, _ := os.ReadDir(filepath.Join(sysfsRoot, "class", "block"))
+	for _, e := range entries {
+		if m := nvmeNamePattern.FindStringSubmatch(e.Name()); m == nil || m[1] != controller || m[2] == "" || m[3] != "" {
+			continue
+		}
+
+		if id, err := strconv.ParseUint(readSysfsAttribute(filepath.Join("class", "block", e.Name(), "nsid")), 10, 32); err == nil {
+			attached[uint(id)] = filepath.Join(devRoot, e.Name())
+		}
+	}
+
+	return attached
+}
+
+// Namespace returns the namespace with the given ID.
+func (c *NVMeController) Namespace(id uint) (*NVMeNamespace, error) {
+	for _, ns := range c.Namespaces {
+		if ns.ID == id {
+			return ns, nil
+		}
+	}
+
+	return nil, DeviceNotFoundError(fmt.Sprintf("%s namespace %d", c.File, id))
+}
+
+// largestBlockSize returns the largest block size namespaces of the
+// controller can be created with. Without the formats of the common
+// namespace it falls back to those of the existing namespaces, or 512.
+func (c *NVMeController) largestBlockSize() (size uint64) {
+	size = 512
+
+	for _, f := range c.Formats {
+		size = max(size, f.DataSize)
+	}
+
+	for _, ns := range c.Namespaces {
+		for _, f := range ns.Formats {
+			size = max(size, f.DataSize)
+		}
+	}
+
+	return
+}
+
+// CreateNamespace allocates a namespace of size bytes, rounded down to whole
+// blocks, or of all unallocated capacity if size is 0. It returns the ID of
+// the new namespace, which is not attached yet.
+func (c *NVMeController) CreateNamespace(ctx context.Context, size, blockSize uint64) (id uint, err error) {
+	if !c.NamespaceManagement {
+		return 0, NoNamespaceManagementError(c.File)
+	}
+
+	if blockSize == 0 {
+		blockSize = c.largestBlockSize()
+	}
+
+	if size == 0 {
+		size = c.UnallocatedCapacity
+	}
+
+	switch {
+	case size > c.UnallocatedCapacity:
+		return 0, InvalidArgumentError(fmt.Sprintf("namespace size %s is over the unallocated capacity %s of %s", FormatSize(size), FormatSize(c.UnallocatedCapacity), c.File))
+	case size < blockSize:
+		return 0, InvalidSizeError(strconv.FormatUint(size, 10))
+	}
+
+	blocks := strconv.FormatUint(size/blockSize, 10)
+
+	out, err := command.Output(ctx, "nvme", "create-ns", c.File, "--dps=0", "--nsze="+blocks, "--ncap="+blocks, "--blocksize="+strconv.FormatUint(blockSize, 10))
+	if err != nil {
+		return
+	}
+
+	return parseNVMeCreatedNamespace([]byte(out))
+}
+
+// AttachNamespace attaches a namespace to the controller and waits for its
+// block device to be created.
+func (c *NVMeController) AttachNamespace(ctx context.Context, id uint) (err error) {
+	if _, err = command.Call(ctx, "nvme", "attach-ns", c.File, c.controllersArg(), c.namespaceArg(id)); err != nil {
+		return
+	}
+
+	return c.rescan(ctx)
+}
+
+// DetachNamespace detaches a namespace from the controller. Its data is
+// kept.
+func (c *NVMeController) DetachNamespace(ctx context.Context, id uint) (err error) {
+	if _, err = command.Call(ctx, "nvme", "detach-ns", c.File, c.controllersArg(), c.namespaceArg(id)); err != nil {
+		return
+	}
+
+	return c.rescan(ctx)
+}
+
+// DeleteNamespace deletes a namespace and all data on it.
+func (c *NVMeController) DeleteNamespace(ctx context.Context, id uint) (err error) {
+	if !c.NamespaceManagement {
+		return NoNamespaceManagementError(c.File)
+	}
+
+	if _, err = command.Call(ctx, "nvme", "delete-ns", c.File, c.namespaceArg(id)); err != nil {
+		return
+	}
+
+	return c.rescan(ctx)
+}
+
+// Reset deletes every namespace and creates a single one of the full
+// capacity with the largest block size supported, the way drives ship.
+func (c *NVMeController) Reset(ctx context.Context) error {
+	if !c.NamespaceManagement {
+		return NoNamespaceManagementError(c.File)
+	}
+
+	return resetNVMeNamespaces(ctx, c.File)
+}
+
+func resetNVMeNamespaces(ctx context.Context, file string) error {
+	return utils.NewNvmeCmd(false).ResetNS(ctx, file)
+}
+
+func (c *NVMeController) controllersArg() string {
+	return "--controllers=" + strconv.FormatUint(uint64(c.ID), 10)
+}
+
+func (c *NVMeController) namespaceArg(id uint) string {
+	return "--namespace-id=" + strconv.FormatUint(uint64(id), 10)
+}
+
+// rescan makes the kernel pick up attached and detached namespaces and
+// waits for udev to process them.
+func (c *NVMeController) rescan(ctx context.Context) (err error) {
+	if _, err = command.Call(ctx, "nvme", "ns-rescan", c.File); err != nil {
+		return
+	}
+
+	return settleUdev(ctx)
+}
+
+// FormatNVMeNamespace formats an attached namespace with an LBA format of
+// the namespace, erasing all data on it. ses is the secure erase setting: 0
+// for none, 1 to erase user data or 2 to erase cryptographically.
+func FormatNVMeNamespace(ctx context.Context, ns *NVMeNamespace, lbaf, ses uint) (err error) {
+	if !ns.Attached {
+		return DeviceNotFoundError(fmt.Sprintf("attached namespace %d", ns.ID))
+	}
+
+	if !slices.ContainsFunc(ns.Formats, func(f NVMeLBAFormat) bool { return f.Index == lbaf }) {
+		return InvalidArgumentError(fmt.Sprintf("%s has no LBA format %d", ns.File, lbaf))
+	}
+
+	if ses > 2 {
+		return InvalidArgumentError(fmt.Sprintf("secure erase setting %d", ses))
+	}
+
+	_, err = command.Call(ctx, "nvme", "format", ns.File, "--lbaf="+strconv.FormatUint(uint64(lbaf), 10), "--ses="+strconv.FormatUint(uint64(ses), 10), "--force")
+
+	return
+}
+
+// nvmeIDCtrl is the part of nvme id-ctrl --output-format=json read by
+// ReadNVMeController.
+type nvmeIDCtrl struct {
+	CNTLID  uint   `json:"cntlid"`
+	SN      string `json:"sn"`
+	MN      string `json:"mn"`
+	OACS    uint   `json:"oacs"`
+	NN      uint   `json:"nn"`
+	TNVMCAP uint64 `json:"tnvmcap"`
+	UNVMCAP uint64 `json:"unvmcap"`
+}
+
+func parseNVMeIDCtrl(file string, out []byte) (c *NVMeController, err error) {
+	var id nvmeIDCtrl
+	if err = json.Unmarshal(out, &id); err != nil {
+		return nil, FailedNVMeReadError(file, err)
+	}
+
+	c = &NVMeController{
+		File:                file,
+		ID:                  id.CNTLID,
+		Model:               strings.TrimSpace(id.MN),
+		Serial:              strings.TrimSpace(id.SN),
+		TotalCapacity:       id.TNVMCAP,
+		UnallocatedCapacity: id.UNVMCAP,
+		MaxNamespaces:       id.NN,
+		NamespaceManagement: id.OACS&oacsNamespaceManagement != 0,
+		Namespaces:          []*NVMeNamespace{},
+	}
+
+	return
+}
+
+// parseNVMeNamespaceList parses nvme list-ns --output-format=json. The IDs
+// are returned sorted.
+func parseNVMeNamespaceList(out []byte) (ids []uint, err error) {
+	var list struct {
+		Namespaces []struct {
+			ID uint `json:"nsid"`
+		} `json:"nsid_list"`
+	}
+
+	if err = json.Unmarshal(out, &list); err != nil {
+		return nil, FailedNVMeReadError("namespace list", err)
+	}
+
+	for _, ns := range list.Namespaces {
+		ids = append(ids, ns.ID)
+	}
+
+	slices.Sort(ids)
+
+	return
+}
+
+// parseNVMeIDNS parses nvme id-ns --output-format=json.
+func parseNVMeIDNS(file string, out []byte) (ns *NVMeNamespace, err error) {
+	var id struct {
+		NSZE  uint64 `json:"nsze"`
+		FLBAS uint   `json:"flbas"`
+		LBAFS []struct {
+			MS uint `json:"ms"`
+			DS uint `json:"ds"`
+			RP uint `json:"rp"`
+		} `json:"lbafs"`
+	}
+
+	if err = json.Unmarshal(out, &id); err != nil {
+		return nil, FailedNVMeReadError(file, err)
+	}
+
+	// Bits 3:0 of flbas are the low and bits 6:5 the high bits of the
+	// index of the format in use.
+	ns = &NVMeNamespace{LBAFormat: id.FLBAS&0xf | (id.FLBAS>>5&0x3)<<4, Formats: []NVMeLBAFormat{}}
+
+	for i, f := range id.LBAFS {
+		// ds is the block size as a power of two, 0 for unused formats.
+		if f.DS == 0 {
+			continue
+		}
+
+		format := NVMeLBAFormat{Index: uint(i), DataSize: 1 << f.DS, MetadataSize: f.MS, RelativePerformance: f.RP}
+		ns.Formats = append(ns.Formats, format)
+
+		if format.Index == ns.LBAFormat {
+			ns.BlockSize = format.DataSize
+		}
+	}
+
+	ns.Size = id.NSZE * ns.BlockSize
+
+	return
+}
+
+func parseNVMeCreatedNamespace(out []byte) (id uint, err error) {
+	m := nvmeCreatedPattern.FindSubmatch(out)
+	if m == nil {
+		return 0, FailedNVMeReadError("create-ns", fmt.Errorf("unexpected output %q", strings.TrimSpace(string(out)))) // nolint:goerr113
+	}
+
+	n, err := strconv.ParseUint(string(m[1]), 10, 32)
+
+	return uint(n), err
+}
+
+// refresh reads the unallocated capacity of the controller again.
+func (c *NVMeController) refresh(ctx context.Context) (err error) {
+	out, err := command.Output(ctx, "nvme", "id-ctrl", "--output-format=json", c.File)
+	if err != nil {
+		return
+	}
+
+	fresh, err := parseNVMeIDCtrl(c.File, []byte(out))
+	if err != nil {
+		return
+	}
+
+	c.UnallocatedCapacity = fresh.UnallocatedCapacity
+
+	return
+}
+
+// NVMeNamespaceLayout divides the capacity of an NVMe controller into
+// namespaces. It is applied before anything else, so that block devices of
+// the layout can refer to the namespaces it creates.
+type NVMeNamespaceLayout struct {
+	// Controller is the device file of the controller, such as /dev/nvme0,
+	// or a device file or selector of one of its namespaces.
+	Controller string               `json:"controller"`
+	Namespaces []*NVMeNamespaceSpec `json:"namespaces"`
+}
+
+// NVMeNamespaceSpec is one namespace of an NVMeNamespaceLayout. Namespaces
+// are created in the order listed, so on a controller without other
+// namespaces the first gets ID 1, the second ID 2 and so on.
+type NVMeNamespaceSpec struct {
+	// Size is a size such as "800G", see ParseSize. Without a size the
+	// namespace takes the capacity left over by the others.
+	Size string `json:"size,omitempty"`
+	// BlockSize is the logical block size in bytes, such as 512 or 4096.
+	// Without a block size the largest one the controller supports is used.
+	BlockSize uint64 `json:"block_size,omitempty"`
+}
+
+// Check compares the namespaces of the controller with the layout, in order
+// of their IDs. Namespaces without a size match any size.
+func (l *NVMeNamespaceLayout) Check(ctx context.Context) (c *NVMeController, differences []Difference, err error) {
+	file, err := NVMeControllerFile(l.Controller)
+	if err != nil {
+		return
+	}
+
+	if c, err = ReadNVMeController(ctx, file); err != nil {
+		return
+	}
+
+	if len(c.Namespaces) != len(l.Namespaces) {
+		differences = append(differences, Difference{Field: "namespaces", Want: strconv.Itoa(len(l.Namespaces)), Have: strconv.Itoa(len(c.Namespaces))})
+		return
+	}
+
+	largest := c.largestBlockSize()
+
+	for i, spec := range l.Namespaces {
+		ns := c.Namespaces[i]
+		field := "namespace " + strconv.FormatUint(uint64(ns.ID), 10)
+
+		blockSize := cmp.Or(spec.BlockSize, largest)
+		if ns.BlockSize != blockSize {
+			differences = append(differences, Difference{Field: field + " block size", Want: strconv.FormatUint(blockSize, 10), Have: strconv.FormatUint(ns.BlockSize, 10)})
+		}
+
+		if want, sized := spec.size(blockSize); sized && (ns.Size < want || ns.Size-want > nvmeNamespaceSizeTolerance) {
+			differences = append(differences, Difference{Field: field + " size", Want: FormatSize(want), Have: FormatSize(ns.Size)})
+		}
+
+		if !ns.Attached {
+			differences = append(differences, Difference{Field: field + " attached", Want: "true", Have: "false"})
+		}
+	}
+
+	return
+}
+
+// size returns the size of the namespace in bytes, rounded down to whole
+// blocks, and whether it has one.
+func (s *NVMeNamespaceSpec) size(blockSize uint64) (size uint64, ok bool) {
+	size, err := ParseSize(s.Size)
+	if err != nil || size == 0 {
+		return 0, false
+	}
+
+	return size / blockSize * blockSize, true
+}
+
+// Ensure converges the namespaces of the controller towards the layout. A
+// controller without namespaces is divided up right away. Namespaces that
+// only need attaching are attached. Otherwise every namespace is deleted and
+// the layout created from scratch, which is refused unless force is set.
+// It returns whether anything was changed.
+func (l *NVMeNamespaceLayout) Ensure(ctx context.Context, force bool) (changed bool, err error) {
+	c, differences, err := l.Check(ctx)
+	if err != nil || len(differences) == 0 {
+		return
+	}
+
+	return l.ensure(ctx, c, differences, force)
+}
+
+func (l *NVMeNamespaceLayout) ensure(ctx context.Context, c *NVMeController, differences []Difference, force bool) (changed bool, err error) {
+	onlyDetached := !slices.ContainsFunc(differences, func(d Difference) bool {
+		return !strings.HasSuffix(d.Field, " attached")
+	})
+
+	if onlyDetached {
+		for _, ns := range c.Namespaces {
+			if !ns.Attached {
+				if err = c.AttachNamespace(ctx, ns.ID); err != nil {
+					return
+				}
+
+				changed = true
+			}
+		}
+
+		return
+	}
+
+	if len(c.Namespaces) > 0 && !force {
+		return false, StateConflictError(c.File, differences)
+	}
+
+	largest := c.largestBlockSize()
+
+	for _, ns := range c.Namespaces {
+		if err = c.DeleteNamespace(ctx, ns.ID); err != nil {
+			return
+		}
+
+		changed = true
+	}
+
+	for _, spec := range l.Namespaces {
+		if err = c.refresh(ctx); err != nil {
+			return
+		}
+
+		blockSize := cmp.Or(spec.BlockSize, largest)
+		size, _ := spec.size(blockSize)
+
+		var id uint
+
+		if id, err = c.CreateNamespace(ctx, size, blockSize); err != nil {
+			return
+		}
+
+		changed = true
+
+		if err = c.AttachNamespace(ctx, id); err != nil {
+			return
+		}
+	}
+
+	return
+}
@@ -0,0 +1,185 @@
+package model
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+const (
+	idCtrlNamespaceManagement = `{
+  "vid": 5197, "ssvid": 5197, "sn": "S4YNNE0N123456      ", "mn": "SAMSUNG MZQL23T8HCLS-00A07             ",
+  "cntlid": 6, "oacs": 95, "nn": 32, "tnvmcap": 3840755982336, "unvmcap": 1920377991168
+}`
+
+	idCtrlNoManagement = `{"sn": "PHLJ1234", "mn": "INTEL SSDPE2KX010T8", "cntlid": 0, "oacs": 6, "nn": 1, "tnvmcap": 0, "unvmcap": 0}`
+
+	listNSAll = `{"nsid_list": [{"nsid": 3}, {"nsid": 1}]}`
+
+	// idNS512 is a namespace of 512 byte blocks that can also be
+	// formatted with 4096 byte blocks, with or without metadata.
+	idNS512 = `{
+  "nsze": 3750748848, "ncap": 3750748848, "nuse": 3750748848, "nlbaf": 4, "flbas": 0,
+  "lbafs": [
+    {"ms": 0, "ds": 9, "rp": 2},
+    {"ms": 8, "ds": 9, "rp": 3},
+    {"ms": 0, "ds": 12, "rp": 0},
+    {"ms": 8, "ds": 12, "rp": 1},
+    {"ms": 64, "ds": 12, "rp": 3}
+  ]
+}`
+
+	// idNSExtended uses format 18, which is only reachable with the
+	// high bits of flbas.
+	idNSExtended = `{
+  "nsze": 1000, "flbas": 34,
+  "lbafs": [
+    {"ms": 0, "ds": 9, "rp": 0}, {"ms": 0, "ds": 0, "rp": 0}, {"ms": 0, "ds": 0, "rp": 0}, {"ms": 0, "ds": 0, "rp": 0},
+    {"ms": 0, "ds": 0, "rp": 0}, {"ms": 0, "ds": 0, "rp": 0}, {"ms": 0, "ds": 0, "rp": 0}, {"ms": 0, "ds": 0, "rp": 0},
+    {"ms": 0, "ds": 0, "rp": 0}, {"ms": 0, "ds": 0, "rp": 0}, {"ms": 0, "ds": 0, "rp": 0}, {"ms": 0, "ds": 0, "rp": 0},
+    {"ms": 0, "ds": 0, "rp": 0}, {"ms": 0, "ds": 0, "rp": 0}, {"ms": 0, "ds": 0, "rp": 0}, {"ms": 0, "ds": 0, "rp": 0},
+    {"ms": 0, "ds": 0, "rp": 0}, {"ms": 0, "ds": 0, "rp": 0}, {"ms": 0, "ds": 12, "rp": 0}
+  ]
+}`
+)
+
+func TestParseNVMeIDCtrl(t *testing.T) {
+	tests := []struct {
+		name string
+		out  string
+		want *NVMeController
+	}{
+		{
+			name: "namespace management",
+			out:  idCtrlNamespaceManagement,
+			want: &NVMeController{
+				File: "/dev/nvme0", ID: 6, Model: "SAMSUNG MZQL23T8HCLS-00A07", Serial: "S4YNNE0N123456",
+				TotalCapacity: 3840755982336, UnallocatedCapacity: 1920377991168, MaxNamespaces: 32,
+				NamespaceManagement: true, Namespaces: []*NVMeNamespace{},
+			},
+		},
+		{
+			name: "no namespace management",
+			out:  idCtrlNoManagement,
+			want: &NVMeController{
+				File: "/dev/nvme0", Model: "INTEL SSDPE2KX010T8", Serial: "PHLJ1234", MaxNamespaces: 1, Namespaces: []*NVMeNamespace{},
+			},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := parseNVMeIDCtrl("/dev/nvme0", []byte(tc.out))
+			if err != nil {
+				t.Fatalf("parseNVMeIDCtrl returned error: %v", err)
+			}
+
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("parseNVMeIDCtrl = %+v, want %+v", got, tc.want)
+			}
+		})
+	}
+
+	if _, err := parseNVMeIDCtrl("/dev/nvme0", []byte("NVMe status: INVALID_FIELD")); !errors.Is(err, ErrFailedNVMeRead) {
+		t.Errorf("parseNVMeIDCtrl of garbage returned %v, want ErrFailedNVMeRead", err)
+	}
+}
+
+func TestParseNVMeNamespaceList(t *testing.T) {
+	got, err := parseNVMeNamespaceList([]byte(listNSAll))
+	if err != nil {
+		t.Fatalf("parseNVMeNamespaceList returned error: %v", err)
+	}
+
+	if want := []uint{1, 3}; !reflect.DeepEqual(got, want) {
+		t.Errorf("parseNVMeNamespaceList = %v, want %v", got, want)
+	}
+
+	if got, err := parseNVMeNamespaceList([]byte(`{"nsid_list": []}`)); err != nil || len(got) != 0 {
+		t.Errorf("parseNVMeNamespaceList of no namespaces = %v, %v", got, err)
+	}
+}
+
+func TestParseNVMeIDNS(t *testing.T) {
+	ns, err := parseNVMeIDNS("/dev/nvme0", []byte(idNS512))
+	if err != nil {
+		t.Fatalf("parseNVMeIDNS returned error: %v", err)
+	}
+
+	if ns.LBAFormat != 0 || ns.BlockSize != 512 || ns.Size != 3750748848*512 || len(ns.Formats) != 5 {
+		t.Errorf("parseNVMeIDNS = %+v", ns)
+	}
+
+	for _, tc := range []struct {
+		blockSize uint64
+		want      uint
+		ok        bool
+	}{
+		{512, 0, true},
+		{4096, 2, true},
+		{8192, 0, false},
+	} {
+		if got, ok := ns.FormatFor(tc.blockSize); got != tc.want || ok != tc.ok {
+			t.Errorf("FormatFor(%d) = %d, %t, want %d, %t", tc.blockSize, got, ok, tc.want, tc.ok)
+		}
+	}
+
+	ns, err = parseNVMeIDNS("/dev/nvme0", []byte(idNSExtended))
+	if err != nil {
+		t.Fatalf("parseNVMeIDNS returned error: %v", err)
+	}
+
+	if ns.LBAFormat != 18 || ns.BlockSize != 4096 || ns.Size != 1000*4096 || len(ns.Formats) != 2 {
+		t.Errorf("parseNVMeIDNS of extended format = %+v", ns)
+	}
+}
+
+func TestNVMeControllerLargestBlockSize(t *testing.T) {
+	common, err := parseNVMeIDNS("/dev/nvme0", []byte(idNS512))
+	if err != nil {
+		t.Fatalf("parseNVMeIDNS returned error: %v", err)
+	}
+
+	// A controller without namespaces takes the formats of the common
+	// namespace.
+	if got := (&NVMeController{Formats: common.Formats}).largestBlockSize(); got != 4096 {
+		t.Errorf("largestBlockSize without namespaces = %d, want 4096", got)
+	}
+
+	if got := (&NVMeController{Namespaces: []*NVMeNamespace{common}}).largestBlockSize(); got != 4096 {
+		t.Errorf("largestBlockSize without common formats = %d, want 4096", got)
+	}
+
+	if got := (&NVMeController{}).largestBlockSize(); got != 512 {
+		t.Errorf("largestBlockSize without formats = %d, want 512", got)
+	}
+}
+
+func TestParseNVMeCreatedNamespace(t *testing.T) {
+	if id, err := parseNVMeCreatedNamespace([]byte("create-ns: Success, created nsid:2\n")); err != nil || id != 2 {
+		t.Errorf("parseNVMeCreatedNamespace = %d, %v, want 2", id, err)
+	}
+
+	if _, err := parseNVMeCreatedNamespace([]byte("NVMe status: NS_INSUFFICIENT_CAPACITY")); !errors.Is(err, ErrFailedNVMeRead) {
+		t.Errorf("parseNVMeCreatedNamespace of a failure returned %v, want ErrFailedNVMeRead", err)
+	}
+}
+
+func TestNVMeNamespaceSpecSize(t *testing.T) {
+	for _, tc := range []struct {
+		size      string
+		blockSize uint64
+		want      uint64
+		ok        bool
+	}{
+		{"", 4096, 0, false},
+		{"0", 4096, 0, false},
+		{"1G", 4096, GiB, true},
+		{"1000001", 4096, 999424, true},
+	} {
+		got, ok := (&NVMeNamespaceSpec{Size: tc.size}).size(tc.blockSize)
+		if got != tc.want || ok != tc.ok {
+			t.Errorf("size of %q = %d, %t, want %d, %t", tc.size, got, ok, tc.want, tc.ok)
+		}
+	}
+}
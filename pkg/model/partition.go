@@ -2,6 +2,9 @@ package model
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"os"
 	"path/filepath"
 	"strconv"
 	"strings"
@@ -75,13 +78,23 @@ func (p *Partition) Format(ctx context.Context) (out string, err error) {
 }
 
 func (p *Partition) GetUUID(ctx context.Context) (string, error) {
-	uuid, err := command.Call(ctx, "blkid", "-s", "UUID", "-o", "value", p.BlockDevice.File)
+	uuid, err := command.Output(ctx, "blkid", "-s", "UUID", "-o", "value", p.BlockDevice.File)
 	return strings.TrimRight(uuid, "\n"), err
 }
 
+// Create adds the partition to the partition table of its BlockDevice. It
+// starts at the first free sector aligned to the larger of 1MiB and the
+// optimal I/O size of the disk, see BlockDevice.PartitionAlignment.
 func (p *Partition) Create(ctx context.Context) (out string, err error) {
+	alignment, offset := alignmentSectors(p.BlockDevice.File)
+	if offset != 0 {
+		contextLogger(ctx).Warnw("disk has an alignment offset, partitions will not be aligned to its physical sectors",
+			"device", p.BlockDevice.File, "alignment_offset", offset)
+	}
+
 	position := strconv.FormatInt(int64(p.Position), 10)
 	out, err = command.Call(ctx, "sgdisk",
+		"-a", strconv.FormatUint(alignment, 10),
 		"-n", position+":0:"+p.Size,
 		"-c", position+":"+p.Name,
 		"-t", position+":"+p.Type,
@@ -91,18 +104,277 @@ func (p *Partition) Create(ctx context.Context) (out string, err error) {
 	return
 }
 
-func (p *Partition) GetBlockDevice(device string) (systemDevice string) {
+// Delete removes the partition from the partition table of its BlockDevice.
+func (p *Partition) Delete(ctx context.Context) (out string, err error) {
 	position := strconv.FormatInt(int64(p.Position), 10)
+	out, err = command.Call(ctx, "sgdisk", "-d", position, p.BlockDevice.File)
+
+	return
+}
+
+// Ensure converges the partition table of the BlockDevice towards the
+// Partition. A partition that already matches is left alone, a missing one is
+// created and one that differs is reported as a StateConflictError unless
+// force is set, in which case it is deleted and recreated.
+// It returns the tool output, whether anything changed and an error.
+func (p *Partition) Ensure(ctx context.Context, force bool) (out string, changed bool, err error) {
+	exists, differences, err := p.Check(ctx)
+	if err != nil {
+		return
+	}
+
+	if exists {
+		if len(differences) == 0 {
+			return
+		}
+
+		if !force {
+			err = StateConflictError(p.description(), differences)
+			return
+		}
+
+		if out, err = p.Delete(ctx); err != nil {
+			return
+		}
+	}
+
+	out, err = p.Create(ctx)
+	changed = err == nil
 
-	if strings.Contains(device, "loop") {
-		systemDevice = p.GetLoopBlockDevice()
-	} else {
-		systemDevice = device + position
+	return
+}
+
+// Check compares the Partition against the partition table of its
+// BlockDevice without changing anything.
+// It returns whether a partition exists at the same position and how it
+// differs from the Partition.
+func (p *Partition) Check(ctx context.Context) (exists bool, differences []Difference, err error) {
+	table, err := readPartitionTable(ctx, p.BlockDevice.File)
+	if err != nil {
+		return
+	}
+
+	entry := table.Entry(p.Position)
+	if entry == nil {
+		return
 	}
 
+	exists = true
+	differences, err = p.diff(ctx, table, entry)
+
 	return
 }
 
+// diff compares the Partition against the entry currently present in the
+// partition table at the same position.
+func (p *Partition) diff(ctx context.Context, table *partitionTable, entry *partitionTableEntry) (differences []Difference, err error) {
+	if p.Name != entry.Name {
+		differences = append(differences, Difference{Field: "name", Want: p.Name, Have: entry.Name})
+	}
+
+	switch ptype := strings.ToUpper(p.Type); len(ptype) {
+	case 0:
+	case len(entry.Code):
+		if ptype != entry.Code {
+			differences = append(differences, Difference{Field: "type", Want: ptype, Have: entry.Code})
+		}
+	default:
+		var guid string
+
+		guid, err = partitionTypeGUID(ctx, p.BlockDevice.File, p.Position)
+		if err != nil {
+			return
+		}
+
+		if ptype != guid {
+			differences = append(differences, Difference{Field: "type", Want: ptype, Have: guid})
+		}
+	}
+
+	if d := p.sizeDifference(table.LogicalSectorSize, entry); d != nil {
+		differences = append(differences, *d)
+	}
+
+	return
+}
+
+// sizeDifference compares the size specification of the Partition with the
+// sectors used by the existing entry. Sizes relative to the end of the disk
+// ("-1G") or filling the remaining space ("0") are not compared. A tolerance
+// of 1MiB absorbs the alignment sgdisk applies.
+func (p *Partition) sizeDifference(sectorSize uint64, entry *partitionTableEntry) *Difference {
+	spec := strings.TrimSpace(p.Size)
+	if spec == "" || spec == "0" || strings.HasPrefix(spec, "-") {
+		return nil
+	}
+
+	want, err := ParseSize(spec)
+	if err != nil {
+		return nil
+	}
+
+	// sgdisk interprets values without a unit as sectors
+	if last := spec[len(spec)-1]; last >= '0' && last <= '9' {
+		want *= sectorSize
+	}
+
+	have := (entry.EndSector + 1) * sectorSize
+	if strings.HasPrefix(spec, "+") {
+		have = (entry.EndSector - entry.StartSector + 1) * sectorSize
+	}
+
+	delta := max(want, have) - min(want, have)
+	if delta <= MiB {
+		return nil
+	}
+
+	field := "end"
+	if strings.HasPrefix(spec, "+") {
+		field = "size"
+	}
+
+	return &Difference{Field: field, Want: FormatSize(want), Have: FormatSize(have)}
+}
+
+// partitionTypeGUID returns the partition type GUID of the partition at the
+// given position as reported by sgdisk -i.
+func partitionTypeGUID(ctx context.Context, file string, position uint) (guid string, err error) {
+	out, err := command.Output(ctx, "sgdisk", "-i", strconv.FormatUint(uint64(position), 10), file)
+	if err != nil {
+		return
+	}
+
+	for _, line := range strings.Split(out, "\n") {
+		if value, found := strings.CutPrefix(strings.TrimSpace(line), "Partition GUID code:"); found {
+			fields := strings.Fields(value)
+			if len(fields) > 0 {
+				guid = strings.ToUpper(fields[0])
+			}
+
+			return
+		}
+	}
+
+	return
+}
+
+// CurrentFileSystem returns the type of the filesystem currently present on
+// the BlockDevice of the Partition, or an empty string if there is none.
+func (p *Partition) CurrentFileSystem(ctx context.Context) (fsType string, err error) {
+	out, err := command.Output(ctx, "blkid", "-p", "-o", "export", p.BlockDevice.File)
+	if err != nil {
+		// blkid exits with status 2 when no signature was found
+		var execErr *command.ExecutionError
+		if errors.As(err, &execErr) && execErr.ExitCode == 2 {
+			err = nil
+		}
+
+		return
+	}
+
+	fsType = parseKeyValueExport(out)["TYPE"]
+
+	return
+}
+
+// EnsureFormat formats the Partition unless it already carries the requested
+// filesystem. A different existing filesystem is reported as a
+// StateConflictError unless force is set, in which case it is overwritten.
+// It returns the tool output, whether anything changed and an error.
+func (p *Partition) EnsureFormat(ctx context.Context, force bool) (out string, changed bool, err error) {
+	current, err := p.CurrentFileSystem(ctx)
+	if err != nil {
+		return
+	}
+
+	switch {
+	case current == p.FileSystem:
+		return
+	case current != "" && !force:
+		err = StateConflictError(p.description(), []Difference{{Field: "file_system", Want: p.FileSystem, Have: current}})
+		return
+	}
+
+	out, err = p.Format(ctx)
+	changed = err == nil
+
+	return
+}
+
+func (p *Partition) description() string {
+	if p.BlockDevice == nil {
+		return fmt.Sprintf("partition %d (%s)", p.Position, p.Name)
+	}
+
+	return fmt.Sprintf("partition %d (%s) on %s", p.Position, p.Name, p.BlockDevice.File)
+}
+
+// GetBlockDevice returns the device file of the partition at the position of
+// the Partition on the given device, which may be a symlink such as
+// /dev/disk/by-id/*. The partition is looked up in sysfs, first among the
+// kernel partitions of the device (sda1, nvme0n1p1, mmcblk0p1, md127p1,
+// loop0p1) and then among the device-mapper partition mappings kpartx
+// creates on top of it (/dev/mapper/loop0p1). If neither exists yet the
+// kernel naming convention for the device is used.
+func (p *Partition) GetBlockDevice(device string) (systemDevice string, err error) {
+	name, err := kernelName(device)
+	if err != nil {
+		return
+	}
+
+	position := strconv.FormatInt(int64(p.Position), 10)
+
+	for _, child := range kernelPartitions(name) {
+		if readSysfsAttribute(filepath.Join("class", "block", child, "partition")) == position {
+			systemDevice = filepath.Join(devRoot, child)
+			return
+		}
+	}
+
+	holders, _ := os.ReadDir(filepath.Join(sysfsRoot, "class", "block", name, "holders"))
+	for _, h := range holders {
+		dmUUID := readSysfsAttribute(filepath.Join("class", "block", h.Name(), "dm", "uuid"))
+		if strings.HasPrefix(dmUUID, "part"+position+"-") {
+			systemDevice = filepath.Join(devRoot, "mapper", readSysfsAttribute(filepath.Join("class", "block", h.Name(), "dm", "name")))
+			return
+		}
+	}
+
+	systemDevice = partitionDeviceName(name, position)
+
+	return
+}
+
+// partitionDeviceName returns the device file the partition at position on
+// the device with the given kernel name is expected to get. Device-mapper
+// devices and loop devices without partition scanning get their partitions
+// from kpartx below /dev/mapper.
+func partitionDeviceName(name, position string) string {
+	if strings.HasPrefix(name, "dm-") {
+		if dmName := readSysfsAttribute(filepath.Join("class", "block", name, "dm", "name")); dmName != "" {
+			return filepath.Join(devRoot, "mapper", dmName+partitionSeparator(dmName)+position)
+		}
+	}
+
+	if strings.HasPrefix(name, "loop") && readSysfsAttribute(filepath.Join("class", "block", name, "loop", "partscan")) != "1" {
+		return filepath.Join(devRoot, "mapper", name+partitionSeparator(name)+position)
+	}
+
+	return filepath.Join(devRoot, name+partitionSeparator(name)+position)
+}
+
+// partitionSeparator returns "p" for device names ending in a digit, which
+// the kernel and kpartx separate from the partition number.
+func partitionSeparator(name string) string {
+	if last := name[len(name)-1]; last >= '0' && last <= '9' {
+		return "p"
+	}
+
+	return ""
+}
+
+// GetLoopBlockDevice returns the kpartx mapping of the partition on a loop
+// BlockDevice. GetBlockDevice handles loop devices as well.
 func (p *Partition) GetLoopBlockDevice() (systemDevice string) {
 	position := strconv.FormatInt(int64(p.Position), 10)
 	deviceFile := filepath.Base(p.BlockDevice.File)
@@ -0,0 +1,74 @@
+package model
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestGetBlockDevice(t *testing.T) {
+	root := t.TempDir()
+	fakeTree(t, root, map[string]string{
+		"sys/class/block/nvme0n1/nvme0n1p1/partition": "1\n",
+		"sys/class/block/nvme0n1/nvme0n1p2/partition": "2\n",
+		"sys/class/block/mmcblk0/mmcblk0p2/partition": "2\n",
+		"sys/class/block/md127/md127p1/partition":     "1\n",
+		"sys/class/block/sda/sda3/partition":          "3\n",
+		"sys/class/block/loop0/loop/partscan":         "0\n",
+		"sys/class/block/loop0/holders/dm-1/":         "",
+		"sys/class/block/dm-1/dm/uuid":                "part1-devnode_7:0_Wh5pYvM\n",
+		"sys/class/block/dm-1/dm/name":                "loop0p1\n",
+		"sys/class/block/loop1/loop/partscan":         "1\n",
+		"sys/class/block/dm-2/dm/name":                "mpatha\n",
+		"dev/nvme0n1":                                 "",
+		"dev/mmcblk0":                                 "",
+		"dev/md127":                                   "",
+		"dev/sda":                                     "",
+		"dev/sdb":                                     "",
+		"dev/loop0":                                   "",
+		"dev/loop1":                                   "",
+		"dev/dm-2":                                    "",
+		"dev/md/ROOT":                                 "->../md127",
+		"dev/disk/by-id/nvme-EXAMPLE_1234":            "->../../nvme0n1",
+	})
+
+	oldSysfs, oldDev := sysfsRoot, devRoot
+	sysfsRoot, devRoot = filepath.Join(root, "sys"), filepath.Join(root, "dev")
+
+	t.Cleanup(func() {
+		sysfsRoot, devRoot = oldSysfs, oldDev
+	})
+
+	dev := func(name string) string { return filepath.Join(root, "dev", name) }
+
+	tests := []struct {
+		device   string
+		position uint
+		want     string
+	}{
+		{device: dev("nvme0n1"), position: 2, want: dev("nvme0n1p2")},
+		{device: dev("disk/by-id/nvme-EXAMPLE_1234"), position: 1, want: dev("nvme0n1p1")},
+		{device: dev("nvme0n1"), position: 3, want: dev("nvme0n1p3")},
+		{device: dev("mmcblk0"), position: 2, want: dev("mmcblk0p2")},
+		{device: dev("md/ROOT"), position: 1, want: dev("md127p1")},
+		{device: dev("sda"), position: 3, want: dev("sda3")},
+		{device: dev("sdb"), position: 1, want: dev("sdb1")},
+		{device: dev("loop0"), position: 1, want: dev("mapper/loop0p1")},
+		{device: dev("loop0"), position: 2, want: dev("mapper/loop0p2")},
+		{device: dev("loop1"), position: 2, want: dev("loop1p2")},
+		{device: dev("dm-2"), position: 1, want: dev("mapper/mpatha1")},
+	}
+
+	for _, tc := range tests {
+		p := &Partition{Position: tc.position}
+
+		got, err := p.GetBlockDevice(tc.device)
+		if err != nil {
+			t.Errorf("GetBlockDevice(%s, %d) returned error: %v", tc.device, tc.position, err)
+			continue
+		}
+
+		if got != tc.want {
+			t.Errorf("GetBlockDevice(%s, %d) = %s, want %s", tc.device, tc.position, got, tc.want)
+		}
+	}
+}
@@ -2,14 +2,20 @@ package model
 
 import (
 	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
 	"strconv"
+	"strings"
 
 	common "github.com/metal-toolbox/bmc-common"
 	"github.com/metal-toolbox/ironlib"
 	"github.com/metal-toolbox/ironlib/actions"
 	"github.com/metal-toolbox/ironlib/model"
 	"github.com/metal-toolbox/vogelkop/internal/command"
-	"github.com/sirupsen/logrus"
+	"github.com/metal-toolbox/vogelkop/internal/logging"
 )
 
 type RaidArray struct {
@@ -17,6 +23,23 @@ type RaidArray struct {
 	Level                   string         `json:"level"`
 	Devices                 []*BlockDevice `json:"devices"`
 	ControllerVirtualDiskID int            `json:"controller_virtual_disk_id"`
+	// RaidType is linuxsw (the default) or hardware. It is only consulted
+	// when the array is part of a StorageLayout.
+	RaidType string `json:"raid_type,omitempty"`
+	// PartitionName adds the partitions with this name on every block device
+	// of a StorageLayout to Devices.
+	PartitionName string `json:"partition_name,omitempty"`
+	// VirtualDisk holds the settings of a hardware array.
+	VirtualDisk *VirtualDiskOptions `json:"virtual_disk,omitempty"`
+}
+
+// GetRaidType returns the RaidType of the array, defaulting to linuxsw.
+func (a *RaidArray) GetRaidType() string {
+	if a.RaidType == "" {
+		return common.SlugRAIDImplLinuxSoftware
+	}
+
+	return a.RaidType
 }
 
 // GetDeviceFiles returns a slice of strings with all the device files
@@ -78,46 +101,370 @@ func (a *RaidArray) Delete(ctx context.Context, raidType string) (out string, er
 }
 
 func (a *RaidArray) CreateLinux(ctx context.Context) (err error) {
+	return a.createLinux(ctx, false)
+}
+
+func (a *RaidArray) createLinux(ctx context.Context, force bool) (err error) {
 	deviceFiles, err := a.GetDeviceFiles()
 	if err != nil {
 		return
 	}
 
-	cmdArgs := []string{
-		"--create", "/dev/md/" + a.Name,
-		"--force", "--run", "--level", a.Level, "--raid-devices",
-		strconv.Itoa(len(a.Devices)),
+	cmdArgs := []string{"--create", "/dev/md/" + a.Name}
+	if force {
+		cmdArgs = append(cmdArgs, "--force")
 	}
+
+	cmdArgs = append(cmdArgs, "--run", "--level", a.Level, "--raid-devices", strconv.Itoa(len(a.Devices)))
 	cmdArgs = append(cmdArgs, deviceFiles...)
 	_, err = command.Call(ctx, "mdadm", cmdArgs...)
 
 	return
 }
 
-func (a *RaidArray) CreateHardware(ctx context.Context) (err error) {
-	hardware, err := getIronlibInventory(ctx)
+// Ensure converges the system towards the RaidArray. An existing array with
+// the same name, level and members is left alone and a missing one is
+// created. An array that differs, or members that already belong to another
+// array, are reported as a StateConflictError unless force is set, in which
+// case the existing array is removed and recreated.
+// It returns whether anything changed and an error.
+func (a *RaidArray) Ensure(ctx context.Context, raidType string, force bool) (changed bool, err error) {
+	if !a.ValidateDevices() {
+		err = ArrayDeviceFailedValidationError(a)
+		return
+	}
 
-	options := &model.CreateVirtualDiskOptions{
-		RaidMode:        a.Level,
-		PhysicalDiskIDs: []uint{0, 1},
-		Name:            a.Name,
-		BlockSize:       64,
+	switch raidType {
+	case common.SlugRAIDImplLinuxSoftware:
+		return a.ensureLinux(ctx, force)
+	case common.SlugRAIDImplHardware:
+		return a.ensureHardware(ctx, force)
+	default:
+		err = InvalidRaidTypeError(raidType)
+		return
 	}
+}
 
-	// TODO(splaspood) We should pass the storage controller down here vs assuming
-	for _, sc := range hardware.StorageControllers {
-		if sc.Vendor == common.VendorMarvell {
-			var sca *actions.StorageControllerAction
-			sca, err = getStorageControllerAction(ctx)
-			if err != nil {
-				return err
+// Check compares the RaidArray against the system without changing anything.
+// It returns whether an array of the same name exists and how the system
+// differs from the RaidArray.
+func (a *RaidArray) Check(ctx context.Context, raidType string) (exists bool, differences []Difference, err error) {
+	switch raidType {
+	case common.SlugRAIDImplLinuxSoftware:
+		return a.diffLinux(ctx)
+	case common.SlugRAIDImplHardware:
+		var vd *common.VirtualDisk
+
+		vd, err = a.findVirtualDisk(ctx)
+		if err != nil || vd == nil {
+			return
+		}
+
+		exists = true
+		differences, err = a.diffVirtualDisk(ctx, vd)
+
+		return
+	default:
+		err = InvalidRaidTypeError(raidType)
+		return
+	}
+}
+
+func (a *RaidArray) ensureLinux(ctx context.Context, force bool) (changed bool, err error) {
+	exists, differences, err := a.diffLinux(ctx)
+	if err != nil {
+		return
+	}
+
+	if len(differences) > 0 {
+		if !force {
+			err = StateConflictError(a.description(), differences)
+			return
+		}
+
+		if exists {
+			if _, err = a.DeleteLinux(ctx); err != nil {
+				return
+			}
+		}
+	} else if exists {
+		return
+	}
+
+	err = a.createLinux(ctx, force)
+	changed = err == nil
+
+	return
+}
+
+// diffLinux compares the RaidArray against the md array of the same name. If
+// no such array exists the members are checked for superblocks of other
+// arrays instead.
+func (a *RaidArray) diffLinux(ctx context.Context) (exists bool, differences []Difference, err error) {
+	arrayFile := "/dev/md/" + a.Name
+
+	if _, statErr := os.Stat(arrayFile); statErr != nil {
+		for _, bd := range a.Devices {
+			out, examineErr := command.Output(ctx, "mdadm", "--examine", "--export", bd.File)
+			if examineErr != nil {
+				// mdadm exits non-zero when no superblock is present
+				continue
 			}
 
-			err = sca.CreateVirtualDisk(ctx, sc, options)
+			if name := parseKeyValueExport(out)["MD_NAME"]; name != "" {
+				differences = append(differences, Difference{Field: "member " + bd.File, Want: "unused", Have: "member of " + name})
+			}
+		}
+
+		return
+	}
+
+	exists = true
+
+	out, err := command.Output(ctx, "mdadm", "--detail", "--export", arrayFile)
+	if err != nil {
+		return
+	}
+
+	detail := parseKeyValueExport(out)
+
+	if have := normalizeRaidLevel(detail["MD_LEVEL"]); have != normalizeRaidLevel(a.Level) {
+		differences = append(differences, Difference{Field: "level", Want: a.Level, Have: detail["MD_LEVEL"]})
+	}
+
+	var want, have []string
+
+	for _, bd := range a.Devices {
+		want = append(want, resolveDeviceFile(bd.File))
+	}
+
+	for key, value := range detail {
+		if strings.HasPrefix(key, "MD_DEVICE_") && strings.HasSuffix(key, "_DEV") {
+			have = append(have, resolveDeviceFile(value))
 		}
 	}
 
-	return err
+	slices.Sort(want)
+	slices.Sort(have)
+
+	if !slices.Equal(want, have) {
+		differences = append(differences, Difference{Field: "devices", Want: strings.Join(want, ","), Have: strings.Join(have, ",")})
+	}
+
+	return
+}
+
+func (a *RaidArray) ensureHardware(ctx context.Context, force bool) (changed bool, err error) {
+	vd, err := a.findVirtualDisk(ctx)
+	if err != nil {
+		return
+	}
+
+	if vd != nil {
+		var differences []Difference
+		if differences, err = a.diffVirtualDisk(ctx, vd); err != nil || len(differences) == 0 {
+			return
+		}
+
+		if !force {
+			err = StateConflictError(a.description(), differences)
+			return
+		}
+	}
+
+	// Options the controller cannot honour fail before the existing virtual
+	// disk is deleted.
+	sc, err := a.hardwareController(ctx)
+	if err != nil {
+		return
+	}
+
+	if vd != nil {
+		existing := &RaidArray{Name: vd.Name}
+		existing.ControllerVirtualDiskID, _ = strconv.Atoi(vd.ID)
+
+		if err = existing.DeleteHardware(ctx); err != nil {
+			return
+		}
+	}
+
+	err = a.createVirtualDisk(ctx, sc)
+	changed = err == nil
+
+	return
+}
+
+// listVirtualDisks lists the hardware virtual disks. Tests replace it.
+var listVirtualDisks = listVirtualDisksHardware
+
+// findVirtualDisk returns the hardware virtual disk with the name of the
+// RaidArray or nil if there is none.
+func (a *RaidArray) findVirtualDisk(ctx context.Context) (*common.VirtualDisk, error) {
+	virtualDisks, err := listVirtualDisks(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, vd := range virtualDisks {
+		if vd.Name == a.Name {
+			return vd, nil
+		}
+	}
+
+	return nil, nil
+}
+
+// virtualDiskOptions reads the settings of a hardware virtual disk. Tests
+// replace it.
+var virtualDiskOptions = readVirtualDiskOptions
+
+// readVirtualDiskOptions returns the settings the controller driver reports
+// of the virtual disk. Settings it does not report are empty; mvcli only
+// reports the stripe size.
+func readVirtualDiskOptions(ctx context.Context, vd *common.VirtualDisk) (reported VirtualDiskOptions, err error) {
+	details, err := readMvcliVirtualDisks(ctx)
+	if err != nil {
+		return
+	}
+
+	for _, d := range details {
+		if d.ID == vd.ID && d.StripeSize > 0 {
+			reported.StripeSize = FormatSize(d.StripeSize)
+		}
+	}
+
+	return
+}
+
+// diffVirtualDisk compares the level, members and settings of an existing
+// virtual disk with the RaidArray. Members are compared by controller
+// physical disk ID. Settings the controller does not report are logged as
+// not verified.
+func (a *RaidArray) diffVirtualDisk(ctx context.Context, vd *common.VirtualDisk) (differences []Difference, err error) {
+	if normalizeRaidLevel(vd.RaidType) != normalizeRaidLevel(a.Level) {
+		differences = append(differences, Difference{Field: "level", Want: a.Level, Have: vd.RaidType})
+	}
+
+	want := make([]int, 0, len(a.Devices))
+	for _, bd := range a.Devices {
+		want = append(want, bd.ControllerPhysicalDeviceID)
+	}
+
+	have := make([]int, 0, len(vd.PhysicalDrives))
+	for _, drive := range vd.PhysicalDrives {
+		have = append(have, drive.StorageControllerDriveID)
+	}
+
+	slices.Sort(want)
+	slices.Sort(have)
+
+	if !slices.Equal(want, have) {
+		differences = append(differences, Difference{Field: "members", Want: joinInts(want), Have: joinInts(have)})
+	}
+
+	if a.VirtualDisk == nil || *a.VirtualDisk == (VirtualDiskOptions{}) {
+		return
+	}
+
+	reported, err := virtualDiskOptions(ctx, vd)
+	if err != nil {
+		return
+	}
+
+	var unverified []string
+
+	for _, option := range []struct {
+		name, want, have string
+	}{
+		{"stripe size", normalizeStripeSize(a.VirtualDisk.StripeSize), reported.StripeSize},
+		{"read cache", a.VirtualDisk.ReadCache, reported.ReadCache},
+		{"write cache", a.VirtualDisk.WriteCache, reported.WriteCache},
+		{"disk cache", a.VirtualDisk.DiskCache, reported.DiskCache},
+		{"init", a.VirtualDisk.Init, reported.Init},
+	} {
+		switch {
+		case option.want == "":
+		case option.have == "":
+			unverified = append(unverified, option.name)
+		case option.want != option.have:
+			differences = append(differences, Difference{Field: option.name, Want: option.want, Have: option.have})
+		}
+	}
+
+	if len(unverified) > 0 {
+		contextLogger(ctx).Warnw("the controller does not report these virtual disk settings, they were not verified", "array", a.Name, "settings", unverified)
+	}
+
+	return
+}
+
+// normalizeStripeSize formats a stripe size the way FormatSize does, so that
+// 64K and 65536 compare equal.
+func normalizeStripeSize(size string) string {
+	if parsed, err := ParseSize(size); err == nil && parsed > 0 {
+		return FormatSize(parsed)
+	}
+
+	return size
+}
+
+// joinInts joins IDs with commas.
+func joinInts(ids []int) string {
+	s := make([]string, 0, len(ids))
+	for _, id := range ids {
+		s = append(s, strconv.Itoa(id))
+	}
+
+	return strings.Join(s, ",")
+}
+
+func (a *RaidArray) description() string {
+	return fmt.Sprintf("raid array %s (level %s)", a.Name, a.Level)
+}
+
+// resolveDeviceFile returns the target of a device file symlink, or the file
+// itself if it cannot be resolved.
+func resolveDeviceFile(file string) string {
+	if resolved, err := filepath.EvalSymlinks(file); err == nil {
+		return resolved
+	}
+
+	return file
+}
+
+// CreateHardware creates the RaidArray as a virtual disk of the hardware
+// RAID controller. Options the controller cannot honour are rejected before
+// anything is created.
+func (a *RaidArray) CreateHardware(ctx context.Context) (err error) {
+	sc, err := a.hardwareController(ctx)
+	if err != nil {
+		return
+	}
+
+	return a.createVirtualDisk(ctx, sc)
+}
+
+// hardwareController returns the RAID controller the virtual disk of the
+// RaidArray is created on, the first one vogelkop can manage, after checking
+// that it supports the options of the RaidArray.
+func (a *RaidArray) hardwareController(ctx context.Context) (*common.StorageController, error) {
+	controllers, err := hardwareControllers(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(controllers) == 0 {
+		return nil, DeviceNotFoundError("supported raid controller")
+	}
+
+	sc := controllers[0]
+	capabilities, _ := CapabilitiesOf(sc)
+
+	if err := capabilities.Check(sc.Vendor+" "+sc.Model, a.VirtualDisk); err != nil {
+		return nil, err
+	}
+
+	return sc, nil
 }
 
 func (a *RaidArray) DeleteHardware(ctx context.Context) error {
@@ -148,8 +495,14 @@ func (a *RaidArray) DeleteHardware(ctx context.Context) error {
 				continue
 			}
 
+			// The virtual disk may have been matched by name only.
+			var id int
+			if id, err = strconv.Atoi(vd.ID); err != nil {
+				return VirtualDiskNotFoundError(a)
+			}
+
 			options := &model.DestroyVirtualDiskOptions{
-				VirtualDiskID: a.ControllerVirtualDiskID,
+				VirtualDiskID: id,
 			}
 
 			var sca *actions.StorageControllerAction
@@ -224,6 +577,24 @@ func listVirtualDisksHardware(ctx context.Context) (virtualDisks []*common.Virtu
 		if err != nil {
 			return
 		}
+
+		// ironlib does not read the members of virtual disks.
+		var details []*mvcliVirtualDisk
+		if details, err = readMvcliVirtualDisks(ctx); err != nil {
+			return
+		}
+
+		for _, vd := range virtualDisks {
+			for _, d := range details {
+				if d.ID != vd.ID {
+					continue
+				}
+
+				for _, id := range d.Members {
+					vd.PhysicalDrives = append(vd.PhysicalDrives, &common.Drive{ID: strconv.Itoa(id), StorageControllerDriveID: id})
+				}
+			}
+		}
 	}
 
 	return
@@ -245,12 +616,7 @@ func listPhysicalDisksHardware(ctx context.Context) (physicalDisks []*common.Dri
 }
 
 func getIronlibInventory(ctx context.Context) (hardware *common.Device, err error) {
-	logrusLogger, err := command.ZapToLogrus(ctx)
-	if err != nil {
-		return
-	}
-
-	device, err := ironlib.New(logrusLogger)
+	device, err := ironlib.New(logging.Logrus(contextLogger(ctx)))
 	if err != nil {
 		return
 	}
@@ -264,12 +630,211 @@ func getIronlibInventory(ctx context.Context) (hardware *common.Device, err erro
 }
 
 func getStorageControllerAction(ctx context.Context) (sca *actions.StorageControllerAction, err error) {
-	var logrusLogger *logrus.Logger
-	logrusLogger, err = command.ZapToLogrus(ctx)
+	sca = actions.NewStorageControllerAction(logging.Logrus(contextLogger(ctx)))
+	return
+}
+
+// physicalDiskController returns the hardware RAID controller whose physical
+// disks and foreign configurations are managed, which vogelkop does through
+// storcli on MegaRAID controllers. Other controllers fail with an
+// UnsupportedOptionError for the operation.
+func physicalDiskController(ctx context.Context, operation string) (*storcliController, error) {
+	if _, err := exec.LookPath(storcliUtility()); err == nil {
+		return readStorcliController(ctx)
+	}
+
+	controllers, err := hardwareControllers(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(controllers) > 0 {
+		return nil, UnsupportedOptionError(controllers[0].Vendor+" "+controllers[0].Model, operation, nil)
+	}
+
+	return nil, DeviceNotFoundError("supported raid controller")
+}
+
+// SetPhysicalDiskState converts the physical disk with the controller ID to
+// JBOD or unconfigured good. Disks that are members or hot spares of virtual
+// disks are reported as a StateConflictError. Force makes the controller
+// convert JBOD disks that carry an operating system's partitions.
+// It returns whether the state changed and an error.
+func SetPhysicalDiskState(ctx context.Context, id int, state string, force bool) (changed bool, err error) {
+	c, err := physicalDiskController(ctx, "physical disk state "+state)
+	if err != nil {
+		return
+	}
+
+	if !slices.Contains(c.Capabilities.PhysicalDiskStates, state) {
+		err = UnsupportedOptionError(c.Model, "physical disk state "+state, c.Capabilities.PhysicalDiskStates)
+		return
+	}
+
+	d, err := c.drive(ctx, id)
+	if err != nil {
+		return
+	}
+
+	want := map[string]string{PhysicalDiskJBOD: storcliStateJBOD, PhysicalDiskUnconfiguredGood: storcliStateUnconfiguredGood}[state]
+
+	switch d.State {
+	case want:
+		return
+	case storcliStateJBOD, storcliStateUnconfiguredGood:
+	default:
+		err = StateConflictError("physical disk "+strconv.Itoa(id), []Difference{{Field: "state", Want: storcliStateJBOD + " or " + storcliStateUnconfiguredGood, Have: d.State}})
+		return
+	}
+
+	args := []string{d.path(), "set", "jbod"}
+	if state == PhysicalDiskUnconfiguredGood {
+		args = []string{d.path(), "set", "good"}
+		if force {
+			args = append(args, "force")
+		}
+	}
+
+	err = c.run(ctx, nil, args...)
+	changed = err == nil
+
+	return
+}
+
+// PhysicalDiskBlockDevice returns the block device of the JBOD physical disk
+// with the controller ID, matched by WWN or serial number. Disks in other
+// states are hidden from the operating system and have none, so nil is
+// returned for them. A JBOD disk no block device matches is reported as a
+// DeviceNotFoundError.
+func PhysicalDiskBlockDevice(ctx context.Context, id int) (bd *BlockDevice, err error) {
+	c, err := physicalDiskController(ctx, "physical disk state")
+	if err != nil {
+		return
+	}
+
+	d, err := c.drive(ctx, id)
+	if err != nil || d.State != storcliStateJBOD {
+		return
+	}
+
+	attributes, err := c.attributes(ctx, d)
+	if err != nil {
+		return
+	}
+
+	blockDevices, err := DiscoverBlockDevices()
+	if err != nil {
+		return
+	}
+
+	for _, candidate := range blockDevices {
+		if attributes.matches(candidate) {
+			return candidate, nil
+		}
+	}
+
+	return nil, DeviceNotFoundError("block device of physical disk " + strconv.Itoa(id))
+}
+
+// AddHotSpare makes the unconfigured good physical disk with the controller
+// ID a hot spare, dedicated to the virtual disks with the given names or a
+// global one if there are none. A hot spare of the other kind is reported as
+// a StateConflictError.
+// It returns whether anything changed and an error.
+func AddHotSpare(ctx context.Context, id int, arrays []string) (changed bool, err error) {
+	kind, want := HotSpareGlobal, storcliStateGlobalHotSpare
+	if len(arrays) > 0 {
+		kind, want = HotSpareDedicated, storcliStateDedicatedHotSpare
+	}
+
+	c, err := physicalDiskController(ctx, kind+" hot spares")
+	if err != nil {
+		return
+	}
+
+	if !slices.Contains(c.Capabilities.HotSpares, kind) {
+		err = UnsupportedOptionError(c.Model, kind+" hot spares", c.Capabilities.HotSpares)
+		return
+	}
+
+	d, err := c.drive(ctx, id)
+	if err != nil {
+		return
+	}
+
+	switch d.State {
+	case want:
+		return
+	case storcliStateUnconfiguredGood:
+	default:
+		err = StateConflictError("physical disk "+strconv.Itoa(id), []Difference{{Field: "state", Want: storcliStateUnconfiguredGood, Have: d.State}})
+		return
+	}
+
+	args := []string{d.path(), "add", "hotsparedrive"}
+
+	if len(arrays) > 0 {
+		var groups []string
+
+		if groups, err = c.driveGroups(ctx, arrays); err != nil {
+			return
+		}
+
+		args = append(args, "dgs="+strings.Join(groups, ","))
+	}
+
+	err = c.run(ctx, nil, args...)
+	changed = err == nil
+
+	return
+}
+
+// RemoveHotSpare makes the hot spare with the controller ID an unconfigured
+// good physical disk again.
+// It returns whether anything changed and an error.
+func RemoveHotSpare(ctx context.Context, id int) (changed bool, err error) {
+	c, err := physicalDiskController(ctx, "hot spares")
 	if err != nil {
 		return
 	}
 
-	sca = actions.NewStorageControllerAction(logrusLogger)
+	d, err := c.drive(ctx, id)
+	if err != nil {
+		return
+	}
+
+	if d.State != storcliStateGlobalHotSpare && d.State != storcliStateDedicatedHotSpare {
+		return
+	}
+
+	err = c.run(ctx, nil, d.path(), "delete", "hotsparedrive")
+	changed = err == nil
+
 	return
 }
+
+// ClearForeignConfig deletes all foreign configurations of the controller,
+// the virtual disks other controllers left on its physical disks, so that
+// the disks can be reused. The data of those virtual disks is lost.
+func ClearForeignConfig(ctx context.Context) error {
+	return foreignConfig(ctx, "delete")
+}
+
+// ImportForeignConfig imports all foreign configurations of the controller,
+// making the virtual disks of another controller available on this one.
+func ImportForeignConfig(ctx context.Context) error {
+	return foreignConfig(ctx, "import")
+}
+
+func foreignConfig(ctx context.Context, action string) error {
+	c, err := physicalDiskController(ctx, "foreign configurations")
+	if err != nil {
+		return err
+	}
+
+	if !c.Capabilities.ForeignConfig {
+		return UnsupportedOptionError(c.Model, "foreign configurations", nil)
+	}
+
+	return c.run(ctx, nil, "/fall", action)
+}
@@ -0,0 +1,352 @@
+package model
+
+import (
+	"bufio"
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/bmc-toolbox/common"
+	"github.com/metal-toolbox/vogelkop/internal/command"
+	"golang.org/x/sys/unix"
+)
+
+// Kinds of Requirement.
+const (
+	RequirementTool   = "tool"
+	RequirementModule = "module"
+)
+
+// States of a kernel module in a RequirementCheck.
+const (
+	ModuleLoaded   = "loaded"
+	ModuleBuiltin  = "builtin"
+	ModuleLoadable = "loadable"
+	// ModuleUnknown is the state of modules that are not loaded on systems
+	// without a module list for the running kernel.
+	ModuleUnknown = "unknown"
+)
+
+// modulesRoot holds the modules of the running kernel, under its release.
+var modulesRoot = "/lib/modules"
+
+// versionArgs are the arguments that make a tool print its version.
+var versionArgs = map[string][]string{
+	"blkdiscard": {"--version"},
+	"blkid":      {"--version"},
+	"cryptsetup": {"--version"},
+	"hdparm":     {"-V"},
+	"lvcreate":   {"--version"},
+	"lvremove":   {"--version"},
+	"mdadm":      {"--version"},
+	"mkswap":     {"--version"},
+	"nvme":       {"version"},
+	"partx":      {"--version"},
+	"pvcreate":   {"--version"},
+	"pvremove":   {"--version"},
+	"pvs":        {"--version"},
+	"sgdisk":     {"--version"},
+	"smartctl":   {"--version"},
+	"udevadm":    {"--version"},
+	"vgcreate":   {"--version"},
+	"vgremove":   {"--version"},
+	"wipefs":     {"--version"},
+}
+
+// Requirement is an external tool or kernel module an operation needs.
+// Optional requirements are only needed for some devices, such as kpartx for
+// device-mapper devices, or have a fallback.
+type Requirement struct {
+	Kind     string `json:"kind"`
+	Name     string `json:"name"`
+	Optional bool   `json:"optional,omitempty"`
+}
+
+// Requirements are the requirements of an operation.
+type Requirements []Requirement
+
+// RequirementCheck is the result of checking a Requirement.
+type RequirementCheck struct {
+	Requirement
+	Found bool `json:"found"`
+	// Path is the resolved path of a tool.
+	Path string `json:"path,omitempty"`
+	// Version is the first line a tool prints about its version.
+	Version string `json:"version,omitempty"`
+	// State is ModuleLoaded, ModuleBuiltin, ModuleLoadable or ModuleUnknown
+	// for a found kernel module.
+	State string `json:"state,omitempty"`
+}
+
+func tools(optional bool, names ...string) (r Requirements) {
+	for _, name := range names {
+		r = append(r, Requirement{Kind: RequirementTool, Name: name, Optional: optional})
+	}
+
+	return
+}
+
+func modules(optional bool, names ...string) (r Requirements) {
+	for _, name := range names {
+		r = append(r, Requirement{Kind: RequirementModule, Name: name, Optional: optional})
+	}
+
+	return
+}
+
+// Merge returns the requirements of r and others without duplicates. A
+// requirement is only optional if it is optional everywhere.
+func (r Requirements) Merge(others ...Requirements) (merged Requirements) {
+	for _, reqs := range append([]Requirements{r}, others...) {
+		for _, req := range reqs {
+			i := slices.IndexFunc(merged, func(m Requirement) bool { return m.Kind == req.Kind && m.Name == req.Name })
+			if i < 0 {
+				merged = append(merged, req)
+				continue
+			}
+
+			merged[i].Optional = merged[i].Optional && req.Optional
+		}
+	}
+
+	return
+}
+
+// PartitionRequirements are the requirements of partitioning a disk.
+func PartitionRequirements() Requirements {
+	return tools(false, "sgdisk").Merge(tools(true, "partx", "kpartx", "udevadm"))
+}
+
+// FormatRequirements are the requirements of creating a filesystem.
+func FormatRequirements(fileSystem string) Requirements {
+	mkfs := "mkfs." + fileSystem
+	if fileSystem == "swap" {
+		mkfs = "mkswap"
+	}
+
+	return tools(false, "blkid", mkfs)
+}
+
+// RaidRequirements are the requirements of creating or deleting a raid
+// array. Hardware raid arrays are managed through ironlib, which finds the
+// controller tool itself.
+func RaidRequirements(raidType, level string) Requirements {
+	if raidType != common.SlugRAIDImplLinuxSoftware {
+		return nil
+	}
+
+	r := tools(false, "mdadm").Merge(modules(false, "md_mod"))
+
+	switch level {
+	case "0", "1", "10":
+		r = r.Merge(modules(false, "raid"+level))
+	case "4", "5", "6":
+		r = r.Merge(modules(false, "raid456"))
+	}
+
+	return r
+}
+
+// EncryptionRequirements are the requirements of creating and opening
+// encrypted volumes.
+func EncryptionRequirements() Requirements {
+	return tools(false, "blkid", "cryptsetup").Merge(modules(false, "dm_mod", "dm_crypt"))
+}
+
+// LVMRequirements are the requirements of creating volume groups and
+// logical volumes.
+func LVMRequirements() Requirements {
+	return tools(false, "pvcreate", "vgcreate", "lvcreate", "pvs").Merge(modules(false, "dm_mod"))
+}
+
+// WipeRequirements are the requirements of wiping drives. The wipe method
+// depends on the drive, and drives no tool can wipe are overwritten with
+// zeros instead.
+func WipeRequirements() Requirements {
+	return tools(true, "nvme", "hdparm", "blkdiscard")
+}
+
+// Requirements returns what applying the StorageLayout needs.
+func (l *StorageLayout) Requirements() (r Requirements) {
+	if len(l.NVMeNamespaces) > 0 {
+		r = r.Merge(NVMeRequirements())
+	}
+
+	for _, array := range l.RaidArrays {
+		r = r.Merge(RaidRequirements(array.GetRaidType(), array.Level))
+	}
+
+	for _, bd := range l.BlockDevices {
+		if len(bd.Partitions) > 0 {
+			r = r.Merge(PartitionRequirements())
+		}
+
+		for _, p := range bd.Partitions {
+			if p.FileSystem != "" {
+				r = r.Merge(FormatRequirements(p.FileSystem))
+			}
+		}
+	}
+
+	if len(l.EncryptedVolumes) > 0 {
+		r = r.Merge(EncryptionRequirements())
+	}
+
+	if len(l.VolumeGroups) > 0 {
+		r = r.Merge(LVMRequirements())
+	}
+
+	for _, fs := range l.FileSystems {
+		r = r.Merge(FormatRequirements(fs.Format))
+	}
+
+	return
+}
+
+// Requirements returns what rolling back the Journal needs.
+func (j *Journal) Requirements() (r Requirements) {
+	for _, s := range j.Steps {
+		switch s.Kind {
+		case StepPartition:
+			r = r.Merge(PartitionRequirements())
+		case StepRaidArray:
+			r = r.Merge(RaidRequirements(s.RaidType, ""))
+		case StepEncryptedVolume:
+			r = r.Merge(tools(false, "cryptsetup", "wipefs"))
+		case StepVolumeGroup:
+			r = r.Merge(tools(false, "vgremove", "pvremove"))
+		case StepLogicalVolume:
+			r = r.Merge(tools(false, "lvremove"))
+		case StepFileSystem:
+			r = r.Merge(tools(false, "wipefs"))
+		case StepNVMeNamespaces:
+			r = r.Merge(NVMeRequirements())
+		}
+	}
+
+	return
+}
+
+// Check checks every requirement. Versions are only collected if versions
+// is set, as that runs every tool found. The error is a
+// MissingRequirementsError if a requirement that is not optional is
+// missing.
+func (r Requirements) Check(ctx context.Context, versions bool) (checks []RequirementCheck, err error) {
+	var missing []string
+
+	for _, req := range r {
+		check := RequirementCheck{Requirement: req}
+
+		switch req.Kind {
+		case RequirementTool:
+			check.Path, _ = exec.LookPath(req.Name)
+			check.Found = check.Path != ""
+
+			if check.Found && versions {
+				check.Version = toolVersion(ctx, req.Name)
+			}
+		case RequirementModule:
+			check.State = moduleState(req.Name)
+			check.Found = check.State != ""
+		}
+
+		if !check.Found && !req.Optional {
+			missing = append(missing, req.Kind+" "+req.Name)
+		}
+
+		checks = append(checks, check)
+	}
+
+	if len(missing) > 0 {
+		err = MissingRequirementsError(missing)
+	}
+
+	return
+}
+
+// Preflight checks the requirements of an operation before it changes
+// anything.
+func (r Requirements) Preflight(ctx context.Context) error {
+	_, err := r.Check(ctx, false)
+	return err
+}
+
+func toolVersion(ctx context.Context, name string) string {
+	args, ok := versionArgs[name]
+	if !ok && strings.HasPrefix(name, "mkfs.") {
+		args, ok = []string{"-V"}, true
+	}
+
+	if !ok {
+		return ""
+	}
+
+	// Some tools print their version on stderr or exit non-zero after
+	// printing it.
+	out, _ := command.Call(ctx, name, args...)
+	for _, line := range strings.Split(out, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			return line
+		}
+	}
+
+	return ""
+}
+
+// moduleState returns whether a kernel module is loaded, built into the
+// kernel or can be loaded, or an empty string if it is not available.
+// Without a module list it can only tell loaded modules apart.
+func moduleState(name string) string {
+	if _, err := os.Stat(filepath.Join(sysfsRoot, "module", name)); err == nil {
+		return ModuleLoaded
+	}
+
+	var uname unix.Utsname
+	if err := unix.Uname(&uname); err != nil {
+		return ""
+	}
+
+	dir := filepath.Join(modulesRoot, unix.ByteSliceToString(uname.Release[:]))
+	if _, err := os.Stat(dir); err != nil {
+		return ModuleUnknown
+	}
+
+	if moduleListed(filepath.Join(dir, "modules.builtin"), name) {
+		return ModuleBuiltin
+	}
+
+	if moduleListed(filepath.Join(dir, "modules.dep"), name) {
+		return ModuleLoadable
+	}
+
+	return ""
+}
+
+// moduleListed reports whether a modules.builtin or modules.dep file lists
+// the module. Module names use underscores where file names may use dashes.
+func moduleListed(file, name string) bool {
+	f, err := os.Open(file)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		path, _, _ := strings.Cut(scanner.Text(), ":")
+
+		base := filepath.Base(path)
+		for _, ext := range []string{".xz", ".zst", ".gz", ".ko"} {
+			base = strings.TrimSuffix(base, ext)
+		}
+
+		if strings.ReplaceAll(base, "-", "_") == name {
+			return true
+		}
+	}
+
+	return false
+}
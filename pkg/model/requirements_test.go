@@ -0,0 +1,90 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/bmc-toolbox/common"
+	"golang.org/x/sys/unix"
+)
+
+func TestLayoutRequirements(t *testing.T) {
+	layout := &StorageLayout{
+		RaidArrays:       []*RaidArray{{Name: "root", Level: "1", RaidType: common.SlugRAIDImplLinuxSoftware}},
+		BlockDevices:     []*BlockDevice{{File: "/dev/sda", Partitions: []*Partition{{Position: 1, FileSystem: "swap"}}}},
+		EncryptedVolumes: []*EncryptedVolume{{Name: "data", Device: "root"}},
+		FileSystems:      []*FileSystem{{Name: "data", Format: "xfs"}},
+	}
+
+	want := map[string]bool{
+		"tool mdadm": false, "module md_mod": false, "module raid1": false,
+		"tool sgdisk": false, "tool partx": true, "tool kpartx": true, "tool udevadm": true,
+		"tool blkid": false, "tool mkswap": false,
+		"tool cryptsetup": false, "module dm_mod": false, "module dm_crypt": false,
+		"tool mkfs.xfs": false,
+	}
+
+	got := layout.Requirements()
+	if len(got) != len(want) {
+		t.Errorf("got %d requirements, want %d: %v", len(got), len(want), got)
+	}
+
+	for _, req := range got {
+		optional, ok := want[req.Kind+" "+req.Name]
+		if !ok || optional != req.Optional {
+			t.Errorf("unexpected requirement %+v", req)
+		}
+	}
+}
+
+func TestRequirementsCheck(t *testing.T) {
+	var uname unix.Utsname
+	if err := unix.Uname(&uname); err != nil {
+		t.Fatal(err)
+	}
+
+	root := t.TempDir()
+	oldSysfs, oldModules := sysfsRoot, modulesRoot
+	sysfsRoot, modulesRoot = filepath.Join(root, "sys"), filepath.Join(root, "lib", "modules")
+
+	t.Cleanup(func() { sysfsRoot, modulesRoot = oldSysfs, oldModules })
+
+	release := filepath.Join(modulesRoot, unix.ByteSliceToString(uname.Release[:]))
+	for file, content := range map[string]string{
+		filepath.Join(sysfsRoot, "module", "md_mod", "refcnt"): "0\n",
+		filepath.Join(release, "modules.builtin"):              "kernel/drivers/md/dm-mod.ko\n",
+		filepath.Join(release, "modules.dep"):                  "kernel/drivers/md/dm-crypt.ko.zst: kernel/drivers/md/dm-mod.ko\n",
+	} {
+		if err := os.MkdirAll(filepath.Dir(file), 0o755); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := os.WriteFile(file, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	reqs := tools(false, "sh").Merge(
+		modules(false, "md_mod", "dm_mod", "dm_crypt"),
+		tools(true, "vogelkop-optional-tool"),
+	)
+
+	checks, err := reqs.Check(context.Background(), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, state := range []string{"", ModuleLoaded, ModuleBuiltin, ModuleLoadable, ""} {
+		if checks[i].State != state || checks[i].Found != (i < 4) {
+			t.Errorf("unexpected check %+v", checks[i])
+		}
+	}
+
+	err = reqs.Merge(tools(false, "vogelkop-missing-tool"), modules(false, "raid456")).Preflight(context.Background())
+	if !errors.Is(err, ErrMissingRequirements) || ErrorClass(err) != ErrorClassToolMissing {
+		t.Errorf("expected missing requirements, got %v", err)
+	}
+}
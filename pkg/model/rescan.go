@@ -0,0 +1,131 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/metal-toolbox/vogelkop/internal/command"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// partitionPollInterval is how often WaitForPartitions checks for device nodes.
+	partitionPollInterval = 100 * time.Millisecond
+
+	// blkrpart is the BLKRPART ioctl, _IO(0x12, 95) in linux/fs.h.
+	blkrpart = 0x125f
+)
+
+// Rescan asks the kernel to re-read the partition table of the BlockDevice.
+// The BLKRPART ioctl is tried first. It fails while any partition is in use,
+// in which case partx updates the partitions individually. Device-mapper
+// devices and loop devices without partition scanning get their partition
+// mappings from kpartx instead.
+func (b *BlockDevice) Rescan(ctx context.Context) (err error) {
+	name, err := kernelName(b.File)
+	if err != nil {
+		return
+	}
+
+	if strings.HasPrefix(name, "dm-") ||
+		(strings.HasPrefix(name, "loop") && readSysfsAttribute(filepath.Join("class", "block", name, "loop", "partscan")) != "1") {
+		_, err = command.Call(ctx, "kpartx", "-a", b.File)
+		return
+	}
+
+	if err = rereadPartitionTable(b.File); err == nil {
+		return
+	}
+
+	_, err = command.Call(ctx, "partx", "-u", b.File)
+
+	return
+}
+
+func rereadPartitionTable(file string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return unix.IoctlSetInt(int(f.Fd()), blkrpart, 0)
+}
+
+// WaitForPartitions waits until the device nodes of the partitions at the
+// given positions exist and udev has finished processing its event queue, or
+// until timeout has passed.
+func (b *BlockDevice) WaitForPartitions(ctx context.Context, positions []uint, timeout time.Duration) (err error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	ticker := time.NewTicker(partitionPollInterval)
+	defer ticker.Stop()
+
+	for {
+		var missing []string
+
+		missing, err = b.missingPartitions(positions)
+		if err != nil {
+			return
+		}
+
+		if len(missing) == 0 {
+			return settleUdev(ctx)
+		}
+
+		select {
+		case <-ctx.Done():
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				return PartitionsNotReadyError(b.File, missing)
+			}
+
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// missingPartitions returns the device files of the partitions at the given
+// positions that do not exist yet.
+func (b *BlockDevice) missingPartitions(positions []uint) (missing []string, err error) {
+	for _, position := range positions {
+		p := &Partition{Position: position, BlockDevice: b}
+
+		var file string
+
+		file, err = p.GetBlockDevice(b.File)
+		if err != nil {
+			return
+		}
+
+		if fi, statErr := os.Stat(file); statErr != nil || fi.Mode()&os.ModeDevice == 0 {
+			missing = append(missing, file)
+		}
+	}
+
+	return
+}
+
+// settleUdev waits for udev to process all queued events. Systems without
+// udev, such as minimal containers, are not waited for.
+func settleUdev(ctx context.Context) (err error) {
+	if _, lookErr := exec.LookPath("udevadm"); lookErr != nil {
+		return
+	}
+
+	timeout := time.Minute
+	if deadline, ok := ctx.Deadline(); ok {
+		timeout = max(time.Until(deadline), time.Second)
+	}
+
+	_, err = command.Call(ctx, "udevadm", "settle", "--timeout="+strconv.Itoa(int(timeout.Seconds())))
+
+	return
+}
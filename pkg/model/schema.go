@@ -0,0 +1,230 @@
+package model
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+const (
+	schemaDialect = "https://json-schema.org/draft/2020-12/schema"
+	schemaBaseID  = "https://github.com/metal-toolbox/vogelkop/schema/"
+)
+
+// StorageLayoutSchema returns the JSON Schema of StorageLayout documents,
+// generated from the Go types and their json tags.
+func StorageLayoutSchema() map[string]any {
+	return generateSchema(reflect.TypeOf(StorageLayout{}), "storage-layout.schema.json")
+}
+
+// LayoutTemplateSchema returns the JSON Schema of LayoutTemplate documents.
+func LayoutTemplateSchema() map[string]any {
+	return generateSchema(reflect.TypeOf(LayoutTemplate{}), "layout-template.schema.json")
+}
+
+func generateSchema(t reflect.Type, id string) map[string]any {
+	defs := make(map[string]any)
+	root := typeSchema(t, defs)
+
+	schema := map[string]any{
+		"$schema": schemaDialect,
+		"$id":     schemaBaseID + id,
+		"title":   t.Name(),
+		"$ref":    root["$ref"],
+		"$defs":   defs,
+	}
+
+	return schema
+}
+
+// typeSchema returns the schema for a Go type. Structs are placed in defs and
+// referenced, which also takes care of the recursion between BlockDevice and
+// Partition. Pointers and slices may be null, as encoding/json produces for
+// nil values.
+func typeSchema(t reflect.Type, defs map[string]any) map[string]any {
+	switch t.Kind() {
+	case reflect.Pointer:
+		return map[string]any{"anyOf": []any{map[string]any{"type": "null"}, typeSchema(t.Elem(), defs)}}
+	case reflect.Slice:
+		return map[string]any{"type": []any{"array", "null"}, "items": typeSchema(t.Elem(), defs)}
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return map[string]any{"type": "integer"}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer", "minimum": 0}
+	case reflect.Struct:
+		ref := map[string]any{"$ref": "#/$defs/" + t.Name()}
+		if _, ok := defs[t.Name()]; ok {
+			return ref
+		}
+
+		properties := make(map[string]any)
+		object := map[string]any{"type": "object", "properties": properties, "additionalProperties": false}
+		defs[t.Name()] = object
+
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if name := jsonFieldName(f); name != "" {
+				properties[name] = typeSchema(f.Type, defs)
+			}
+		}
+
+		return ref
+	}
+
+	return map[string]any{}
+}
+
+func jsonFieldName(f reflect.StructField) string {
+	if !f.IsExported() {
+		return ""
+	}
+
+	name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+
+	switch name {
+	case "-":
+		return ""
+	case "":
+		return f.Name
+	}
+
+	return name
+}
+
+// ValidateLayoutDocument decodes a StorageLayout document, checking it
+// against StorageLayoutSchema and StorageLayout.Validate.
+// It returns the layout, the problems found and an error if the document is
+// not JSON at all.
+func ValidateLayoutDocument(data []byte) (layout *StorageLayout, problems []LayoutProblem, err error) {
+	var document any
+
+	if err = json.Unmarshal(data, &document); err != nil {
+		return
+	}
+
+	schema := StorageLayoutSchema()
+	if problems = validateSchema(schema, schema, document, ""); len(problems) > 0 {
+		return
+	}
+
+	layout = &StorageLayout{}
+	if err = json.Unmarshal(data, layout); err != nil {
+		return
+	}
+
+	problems = layout.Validate()
+
+	return
+}
+
+// validateSchema checks a decoded JSON document against the subset of JSON
+// Schema produced by generateSchema.
+func validateSchema(root, schema map[string]any, value any, pointer string) (problems []LayoutProblem) {
+	if ref, ok := schema["$ref"].(string); ok {
+		name := strings.TrimPrefix(ref, "#/$defs/")
+		return validateSchema(root, root["$defs"].(map[string]any)[name].(map[string]any), value, pointer)
+	}
+
+	if anyOf, ok := schema["anyOf"].([]any); ok {
+		for _, s := range anyOf {
+			if problems = validateSchema(root, s.(map[string]any), value, pointer); len(problems) == 0 {
+				return nil
+			}
+		}
+
+		return
+	}
+
+	problem := func(format string, args ...any) []LayoutProblem {
+		return []LayoutProblem{{Pointer: pointer, Message: fmt.Sprintf(format, args...)}}
+	}
+
+	types := schemaTypes(schema["type"])
+	if len(types) > 0 && !slices.Contains(types, jsonType(value)) {
+		return problem("expected %s, got %s", strings.Join(types, " or "), jsonType(value))
+	}
+
+	switch v := value.(type) {
+	case map[string]any:
+		properties, _ := schema["properties"].(map[string]any)
+
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+
+		slices.Sort(keys)
+
+		for _, k := range keys {
+			property, ok := properties[k].(map[string]any)
+			if !ok {
+				problems = append(problems, LayoutProblem{Pointer: pointer + "/" + escapePointer(k), Message: "unknown field " + k})
+				continue
+			}
+
+			problems = append(problems, validateSchema(root, property, v[k], pointer+"/"+escapePointer(k))...)
+		}
+	case []any:
+		if items, ok := schema["items"].(map[string]any); ok {
+			for i, item := range v {
+				problems = append(problems, validateSchema(root, items, item, pointer+"/"+strconv.Itoa(i))...)
+			}
+		}
+	case float64:
+		if minimum, ok := schema["minimum"].(int); ok && v < float64(minimum) {
+			return problem("must be at least %d", minimum)
+		}
+	}
+
+	return
+}
+
+func schemaTypes(t any) (types []string) {
+	switch t := t.(type) {
+	case string:
+		types = []string{t}
+	case []any:
+		for _, s := range t {
+			types = append(types, s.(string))
+		}
+	}
+
+	return
+}
+
+// jsonType returns the JSON Schema type name of a decoded JSON value.
+func jsonType(value any) string {
+	switch v := value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case string:
+		return "string"
+	case []any:
+		return "array"
+	case map[string]any:
+		return "object"
+	case float64:
+		if v == math.Trunc(v) {
+			return "integer"
+		}
+
+		return "number"
+	}
+
+	return "unknown"
+}
+
+// escapePointer escapes a JSON pointer reference token (RFC 6901).
+func escapePointer(token string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(token, "~", "~0"), "/", "~1")
+}
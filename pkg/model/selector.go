@@ -0,0 +1,208 @@
+package model
+
+import (
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Selector terms understood by ResolveSelector.
+const (
+	SelectorWWN    = "wwn"
+	SelectorSerial = "serial"
+	SelectorByID   = "by-id"
+	SelectorByPath = "by-path"
+	SelectorModel  = "model"
+	SelectorSize   = "size"
+)
+
+// sizeSelectorTolerance is the relative difference allowed between the size
+// given in a selector and the capacity of a disk.
+const sizeSelectorTolerance = 0.02
+
+// IsSelector reports whether s is a selector rather than a device file.
+func IsSelector(s string) bool {
+	return s != "" && !strings.HasPrefix(s, "/")
+}
+
+// ResolveSelector resolves a block device selector to the kernel device file
+// (/dev/sdX) it currently refers to. A selector is either a device file,
+// whose symlinks are followed, or one or more key:value terms joined by "&":
+//
+//	wwn:0x5000c500a1b2c3d4
+//	serial:S3Z9NB0K123456
+//	by-id:nvme-SAMSUNG_MZ7LH480HAHQ-00005_S45PNA0M123456
+//	by-path:pci-0000:00:17.0-ata-1
+//	model:SAMSUNG MZ7LH480*&size:480G
+//
+// Model values are shell patterns, sizes match either their decimal or
+// binary interpretation within 2%.
+// It returns an error unless exactly one device matches.
+func ResolveSelector(selector string) (file string, err error) {
+	if !IsSelector(selector) {
+		return resolveLink(selector, selector)
+	}
+
+	terms, err := parseSelector(selector)
+	if err != nil {
+		return
+	}
+
+	if v, ok := terms[SelectorByID]; ok {
+		return resolveLink(filepath.Join(devRoot, "disk", "by-id", v), selector)
+	}
+
+	if v, ok := terms[SelectorByPath]; ok {
+		return resolveLink(filepath.Join(devRoot, "disk", "by-path", v), selector)
+	}
+
+	blockDevices, err := DiscoverBlockDevices()
+	if err != nil {
+		return
+	}
+
+	var matches []string
+
+	for _, bd := range blockDevices {
+		if bd.matchesSelector(terms) {
+			matches = append(matches, bd.File)
+		}
+	}
+
+	switch len(matches) {
+	case 0:
+		err = DeviceNotFoundError(selector)
+	case 1:
+		file = matches[0]
+	default:
+		err = AmbiguousSelectorError(selector, matches)
+	}
+
+	return
+}
+
+func parseSelector(selector string) (terms map[string]string, err error) {
+	terms = make(map[string]string)
+
+	for _, term := range strings.Split(selector, "&") {
+		key, value, found := strings.Cut(term, ":")
+		if !found || value == "" {
+			err = InvalidSelectorError(selector)
+			return
+		}
+
+		switch key = strings.ToLower(strings.TrimSpace(key)); key {
+		case SelectorWWN, SelectorSerial, SelectorByID, SelectorByPath, SelectorModel, SelectorSize:
+			terms[key] = strings.TrimSpace(value)
+		default:
+			err = InvalidSelectorError(selector)
+			return
+		}
+	}
+
+	if _, _, err = selectorSize(terms); err != nil {
+		err = InvalidSelectorError(selector)
+	}
+
+	return
+}
+
+func selectorSize(terms map[string]string) (binary, decimal uint64, err error) {
+	if v, ok := terms[SelectorSize]; ok {
+		if binary, err = ParseSize(v); err != nil {
+			return
+		}
+
+		decimal, err = ParseDecimalSize(v)
+	}
+
+	return
+}
+
+// matchesSelector reports whether the BlockDevice satisfies every term.
+func (b *BlockDevice) matchesSelector(terms map[string]string) bool {
+	if v, ok := terms[SelectorWWN]; ok && normalizeWWN(v) != normalizeWWN(b.WWN) {
+		return false
+	}
+
+	if v, ok := terms[SelectorSerial]; ok && !strings.EqualFold(v, b.Serial) {
+		return false
+	}
+
+	if v, ok := terms[SelectorModel]; ok {
+		if matched, _ := path.Match(normalizeModel(v), normalizeModel(b.Model)); !matched {
+			return false
+		}
+	}
+
+	if _, ok := terms[SelectorSize]; ok {
+		binary, decimal, _ := selectorSize(terms)
+		if !sizeMatches(b.Size, binary) && !sizeMatches(b.Size, decimal) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// sizeMatches reports whether a capacity is within sizeSelectorTolerance of
+// the given size.
+func sizeMatches(capacity, size uint64) bool {
+	delta := max(capacity, size) - min(capacity, size)
+	return float64(delta) <= float64(size)*sizeSelectorTolerance
+}
+
+func normalizeWWN(wwn string) string {
+	w := strings.ToLower(strings.TrimSpace(wwn))
+	for _, prefix := range []string{"wwn-", "0x", "naa.", "eui.", "t10."} {
+		w = strings.TrimPrefix(w, prefix)
+	}
+
+	return w
+}
+
+func normalizeModel(model string) string {
+	return strings.ToLower(strings.Join(strings.Fields(strings.ReplaceAll(model, "_", " ")), " "))
+}
+
+// resolveLink follows the symlinks of a device file and returns the kernel
+// device file it points at.
+func resolveLink(file, selector string) (string, error) {
+	resolved, err := filepath.EvalSymlinks(file)
+	if err != nil {
+		return "", DeviceNotFoundError(selector)
+	}
+
+	return resolved, nil
+}
+
+// Resolve resolves the Selector of the BlockDevice, or its WWN or Serial if
+// no File was given, to the current kernel device file and stores it in File.
+// Device files below /dev/disk are resolved to their kernel name as well.
+func (b *BlockDevice) Resolve() (err error) {
+	selector := b.Selector
+
+	switch {
+	case selector != "":
+	case IsSelector(b.File):
+		selector = b.File
+	case strings.HasPrefix(b.File, filepath.Join(devRoot, "disk")+"/"):
+		selector = b.File
+	case b.File == "" && b.WWN != "":
+		selector = SelectorWWN + ":" + b.WWN
+	case b.File == "" && b.Serial != "":
+		selector = SelectorSerial + ":" + b.Serial
+	default:
+		return
+	}
+
+	file, err := ResolveSelector(selector)
+	if err != nil {
+		return
+	}
+
+	b.Selector = selector
+	b.File = file
+
+	return
+}
@@ -0,0 +1,49 @@
+package model
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+func TestResolveSelector(t *testing.T) {
+	root := fakeDisks(t)
+	dev := func(name string) string { return filepath.Join(root, "dev", name) }
+
+	tests := []struct {
+		selector string
+		want     string
+		err      error
+	}{
+		{selector: "wwn:0x5002538e40a1b2c4", want: dev("sdb")},
+		{selector: "wwn:5002538E40A1B2C4", want: dev("sdb")},
+		{selector: "wwn:0025388b91b2c3d4", want: dev("nvme0n1")},
+		{selector: "serial:S45PNA0M100001", want: dev("sda")},
+		{selector: "by-id:wwn-0x5002538e40a1b2c3", want: dev("sda")},
+		{selector: "model:SAMSUNG_MZQL2*", want: dev("nvme0n1")},
+		{selector: "model:samsung mzql2*&size:1.92T", want: dev("nvme0n1")},
+		{selector: "model:SAMSUNG MZ7LH480*&size:480G", err: ErrAmbiguousSelector},
+		{selector: "size:480G&serial:S45PNA0M100002", want: dev("sdb")},
+		{selector: "serial:DOESNOTEXIST", err: ErrDeviceNotFound},
+		{selector: "by-path:pci-0000:00:17.0-ata-9", err: ErrDeviceNotFound},
+		{selector: "color:blue", err: ErrInvalidSelector},
+		{selector: "size:lots", err: ErrInvalidSelector},
+		{selector: dev("disk/by-id/wwn-0x5002538e40a1b2c3"), want: dev("sda")},
+	}
+
+	for _, tc := range tests {
+		got, err := ResolveSelector(tc.selector)
+
+		if tc.err != nil {
+			if !errors.Is(err, tc.err) {
+				t.Errorf("ResolveSelector(%q) error = %v, want %v", tc.selector, err, tc.err)
+			}
+
+			continue
+		}
+
+		if err != nil || got != tc.want {
+			t.Errorf("ResolveSelector(%q) = %q, %v, want %q", tc.selector, got, err, tc.want)
+		}
+	}
+}
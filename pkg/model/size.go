@@ -0,0 +1,73 @@
+package model
+
+import (
+	"math"
+	"strconv"
+	"strings"
+)
+
+const (
+	KiB uint64 = 1 << (10 * (iota + 1))
+	MiB
+	GiB
+	TiB
+	PiB
+)
+
+// ParseSize parses a human readable size such as "512M", "+2GB" or "1TiB"
+// into a number of bytes. Suffixes are interpreted as binary multiples, the
+// same way sgdisk interprets them. A leading "+" is ignored.
+// It returns the size in bytes and an error if the string could not be parsed.
+func ParseSize(s string) (size uint64, err error) {
+	return parseSize(s, 1024)
+}
+
+// ParseDecimalSize is like ParseSize but interprets suffixes as decimal
+// multiples, the way drive vendors state capacities.
+func ParseDecimalSize(s string) (size uint64, err error) {
+	return parseSize(s, 1000)
+}
+
+func parseSize(s string, base float64) (size uint64, err error) {
+	value := strings.TrimPrefix(strings.TrimSpace(s), "+")
+	value = strings.TrimSuffix(strings.TrimSuffix(strings.ToUpper(value), "B"), "I")
+
+	exponent := 0
+
+	if n := len(value); n > 0 {
+		exponent = strings.IndexByte("KMGTP", value[n-1]) + 1
+		if exponent > 0 {
+			value = value[:n-1]
+		}
+	}
+
+	number, err := strconv.ParseFloat(value, 64)
+	if err != nil || number < 0 {
+		err = InvalidSizeError(s)
+		return
+	}
+
+	size = uint64(number * math.Pow(base, float64(exponent)))
+
+	return
+}
+
+// FormatSize returns a human readable representation of size using the
+// largest binary unit that keeps the value at or above one.
+func FormatSize(size uint64) string {
+	units := []struct {
+		suffix string
+		bytes  uint64
+	}{
+		{"P", PiB}, {"T", TiB}, {"G", GiB}, {"M", MiB}, {"K", KiB},
+	}
+
+	for _, u := range units {
+		if size >= u.bytes {
+			value := strconv.FormatFloat(float64(size)/float64(u.bytes), 'f', 1, 64)
+			return strings.TrimSuffix(value, ".0") + u.suffix
+		}
+	}
+
+	return strconv.FormatUint(size, 10)
+}
@@ -0,0 +1,138 @@
+package model
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/metal-toolbox/vogelkop/internal/command"
+)
+
+// Difference describes a single attribute whose current value on the system
+// does not match the value requested by the specification.
+type Difference struct {
+	Field string
+	Want  string
+	Have  string
+}
+
+func (d Difference) String() string {
+	return fmt.Sprintf("%s: want %q, have %q", d.Field, d.Want, d.Have)
+}
+
+// partitionTableEntry is a single row of the table printed by sgdisk -p.
+type partitionTableEntry struct {
+	Position    uint
+	StartSector uint64
+	EndSector   uint64
+	Code        string
+	Name        string
+}
+
+// partitionTable is the parsed output of sgdisk -p.
+type partitionTable struct {
+	LogicalSectorSize uint64
+	Entries           []*partitionTableEntry
+}
+
+var (
+	sgdiskSectorSizeRe = regexp.MustCompile(`(?i)(?:logical sector size|sector size \(logical(?:/physical)?\)):\s*(\d+)`)
+	sgdiskEntryRe      = regexp.MustCompile(`^\s*(\d+)\s+(\d+)\s+(\d+)\s+\S+\s+\S+\s+([0-9A-Fa-f]{4})\s*(.*)$`)
+)
+
+// readPartitionTable reads the GPT of the given device file with sgdisk.
+func readPartitionTable(ctx context.Context, file string) (table *partitionTable, err error) {
+	out, err := command.Output(ctx, "sgdisk", "-p", file)
+	if err != nil {
+		return
+	}
+
+	table = parsePartitionTable(out)
+
+	return
+}
+
+func parsePartitionTable(out string) (table *partitionTable) {
+	table = &partitionTable{LogicalSectorSize: 512}
+
+	scanner := bufio.NewScanner(strings.NewReader(out))
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		if m := sgdiskSectorSizeRe.FindStringSubmatch(line); m != nil {
+			if size, err := strconv.ParseUint(m[1], 10, 64); err == nil && size > 0 {
+				table.LogicalSectorSize = size
+			}
+
+			continue
+		}
+
+		m := sgdiskEntryRe.FindStringSubmatch(line)
+		if m == nil {
+			continue
+		}
+
+		position, _ := strconv.ParseUint(m[1], 10, 32)
+		start, _ := strconv.ParseUint(m[2], 10, 64)
+		end, _ := strconv.ParseUint(m[3], 10, 64)
+
+		table.Entries = append(table.Entries, &partitionTableEntry{
+			Position:    uint(position),
+			StartSector: start,
+			EndSector:   end,
+			Code:        strings.ToUpper(m[4]),
+			Name:        strings.TrimSpace(m[5]),
+		})
+	}
+
+	return
+}
+
+// Entry returns the entry at the given position or nil if there is none.
+func (t *partitionTable) Entry(position uint) *partitionTableEntry {
+	for _, e := range t.Entries {
+		if e.Position == position {
+			return e
+		}
+	}
+
+	return nil
+}
+
+// parseKeyValueExport parses KEY=VALUE lines as printed by
+// "blkid -o export" and "mdadm --export".
+func parseKeyValueExport(out string) map[string]string {
+	values := make(map[string]string)
+
+	scanner := bufio.NewScanner(strings.NewReader(out))
+	for scanner.Scan() {
+		key, value, found := strings.Cut(strings.TrimSpace(scanner.Text()), "=")
+		if !found {
+			continue
+		}
+
+		values[key] = strings.Trim(value, `"`)
+	}
+
+	return values
+}
+
+// normalizeRaidLevel turns the different spellings of a RAID level
+// ("1", "raid1", "RAID-1", "mirror") into the bare level ("1").
+func normalizeRaidLevel(level string) string {
+	l := strings.ToLower(strings.TrimSpace(level))
+	l = strings.TrimPrefix(l, "raid")
+	l = strings.TrimPrefix(l, "-")
+
+	switch l {
+	case "mirror":
+		return "1"
+	case "stripe":
+		return "0"
+	}
+
+	return l
+}
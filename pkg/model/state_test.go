@@ -0,0 +1,104 @@
+package model
+
+import (
+	"testing"
+)
+
+const sgdiskPrintOutput = `Disk /dev/loop0: 204800 sectors, 100.0 MiB
+Sector size (logical/physical): 512/512 bytes
+Disk identifier (GUID): 5B0AF0D4-5C2B-4B0B-9B1A-4E0A6E0E1F11
+Partition table holds up to 128 entries
+Main partition table begins at sector 2 and ends at sector 33
+First usable sector is 34, last usable sector is 204766
+Partitions will be aligned on 2048-sector boundaries
+Total free space is 6077 sectors (3.0 MiB)
+
+Number  Start (sector)    End (sector)  Size       Code  Name
+   1            2048           20479   9.0 MiB     EF00  BOOT
+   2           20480           32767   6.0 MiB     8200  linux swap
+   3           32768          122879   44.0 MiB    8300  ROOT
+`
+
+func TestParsePartitionTable(t *testing.T) {
+	table := parsePartitionTable(sgdiskPrintOutput)
+
+	if table.LogicalSectorSize != 512 {
+		t.Errorf("LogicalSectorSize = %d, want 512", table.LogicalSectorSize)
+	}
+
+	if len(table.Entries) != 3 {
+		t.Fatalf("got %d entries, want 3", len(table.Entries))
+	}
+
+	swap := table.Entry(2)
+	if swap == nil || swap.Name != "linux swap" || swap.Code != "8200" || swap.StartSector != 20480 {
+		t.Errorf("unexpected entry 2: %+v", swap)
+	}
+
+	if table.Entry(4) != nil {
+		t.Error("expected no entry at position 4")
+	}
+}
+
+func TestPartitionSizeDifference(t *testing.T) {
+	entry := &partitionTableEntry{Position: 3, StartSector: 32768, EndSector: 122879}
+
+	tests := []struct {
+		size     string
+		conflict bool
+	}{
+		{size: "60M", conflict: false},
+		{size: "+44M", conflict: false},
+		{size: "+45M", conflict: false},
+		{size: "+100M", conflict: true},
+		{size: "30M", conflict: true},
+		{size: "122879", conflict: false},
+		{size: "0", conflict: false},
+		{size: "-1G", conflict: false},
+	}
+
+	for _, tc := range tests {
+		p := &Partition{Position: 3, Size: tc.size}
+		if d := p.sizeDifference(512, entry); (d != nil) != tc.conflict {
+			t.Errorf("size %q: got difference %v, want conflict %v", tc.size, d, tc.conflict)
+		}
+	}
+}
+
+func TestParseSize(t *testing.T) {
+	tests := map[string]uint64{
+		"512":   512,
+		"10M":   10 * MiB,
+		"+2GB":  2 * GiB,
+		"1TiB":  TiB,
+		"1.5G":  GiB + GiB/2,
+		" 4k ":  4 * KiB,
+		"+512M": 512 * MiB,
+	}
+
+	for in, want := range tests {
+		got, err := ParseSize(in)
+		if err != nil {
+			t.Errorf("ParseSize(%q) returned error: %v", in, err)
+			continue
+		}
+
+		if got != want {
+			t.Errorf("ParseSize(%q) = %d, want %d", in, got, want)
+		}
+	}
+
+	for _, in := range []string{"", "abc", "-1G", "G"} {
+		if _, err := ParseSize(in); err == nil {
+			t.Errorf("ParseSize(%q) expected an error", in)
+		}
+	}
+}
+
+func TestNormalizeRaidLevel(t *testing.T) {
+	for _, level := range []string{"1", "raid1", "RAID1", "RAID-1", "mirror"} {
+		if got := normalizeRaidLevel(level); got != "1" {
+			t.Errorf("normalizeRaidLevel(%q) = %q, want \"1\"", level, got)
+		}
+	}
+}
@@ -0,0 +1,303 @@
+package model
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/metal-toolbox/ironlib/utils"
+	"github.com/metal-toolbox/vogelkop/internal/command"
+)
+
+// defaultStorcliUtility is where storcli is installed, the same path ironlib
+// inventories MegaRAID controllers with.
+const defaultStorcliUtility = "/opt/MegaRAID/storcli/storcli64"
+
+// storcli states of physical disks.
+const (
+	storcliStateJBOD              = "JBOD"
+	storcliStateUnconfiguredGood  = "UGood"
+	storcliStateGlobalHotSpare    = "GHS"
+	storcliStateDedicatedHotSpare = "DHS"
+)
+
+// storcliUtility returns the storcli binary, which ironlib's environment
+// variable overrides.
+func storcliUtility() string {
+	if utility := os.Getenv(utils.EnvStorecliUtility); utility != "" {
+		return utility
+	}
+
+	return defaultStorcliUtility
+}
+
+// PhysicalDiskRequirements are the requirements of managing the physical
+// disks and foreign configurations of hardware RAID controllers. storcli is
+// only needed for MegaRAID controllers, which are the only ones supported.
+func PhysicalDiskRequirements() Requirements {
+	return tools(true, storcliUtility())
+}
+
+// storcliController is a MegaRAID controller managed through storcli.
+type storcliController struct {
+	// Path addresses the controller in storcli commands.
+	Path         string
+	Model        string
+	Capabilities ControllerCapabilities
+}
+
+// storcliDrive is a physical disk as storcli shows it.
+type storcliDrive struct {
+	EnclosureSlot string `json:"EID:Slt"`
+	ID            int    `json:"DID"`
+	State         string `json:"State"`
+}
+
+// storcliDriveAttributes identify a physical disk to the operating system.
+type storcliDriveAttributes struct {
+	Serial string `json:"SN"`
+	WWN    string `json:"WWN"`
+}
+
+// storcliVirtualDrive is a virtual disk as storcli shows it.
+type storcliVirtualDrive struct {
+	DriveGroup string `json:"DG/VD"`
+	Name       string `json:"Name"`
+}
+
+// readStorcliController reads the MegaRAID controller and what it supports.
+// Hosts with more than one controller are refused, as physical disk IDs and
+// foreign configurations would be ambiguous. Only controllers with JBOD
+// enabled can convert disks to JBOD.
+func readStorcliController(ctx context.Context) (c *storcliController, err error) {
+	out, err := command.Output(ctx, storcliUtility(), "/call", "show", "J")
+	if err != nil {
+		return
+	}
+
+	count, err := countStorcliControllers([]byte(out))
+	if err != nil {
+		return
+	}
+
+	if count > 1 {
+		return nil, UnsupportedOptionError(strconv.Itoa(count)+" MegaRAID controllers", "managing physical disks", []string{"a single controller"})
+	}
+
+	c = &storcliController{Path: "/c0"}
+
+	var show struct {
+		Model string `json:"Product Name"`
+	}
+
+	if err = c.run(ctx, &show, "show"); err != nil {
+		return
+	}
+
+	var jbod struct {
+		Properties []struct {
+			Name  string `json:"Ctrl_Prop"`
+			Value string `json:"Value"`
+		} `json:"Controller Properties"`
+	}
+
+	if err = c.run(ctx, &jbod, "show", "jbod"); err != nil {
+		return
+	}
+
+	c.Model = show.Model
+	c.Capabilities = ControllerCapabilities{
+		PhysicalDiskStates: []string{PhysicalDiskUnconfiguredGood},
+		HotSpares:          []string{HotSpareGlobal, HotSpareDedicated},
+		ForeignConfig:      true,
+	}
+
+	for _, p := range jbod.Properties {
+		if p.Name == "JBOD" && p.Value == "ON" {
+			c.Capabilities.PhysicalDiskStates = append(c.Capabilities.PhysicalDiskStates, PhysicalDiskJBOD)
+		}
+	}
+
+	return
+}
+
+// countStorcliControllers returns how many controllers storcli /call show
+// answered for.
+func countStorcliControllers(out []byte) (int, error) {
+	var response struct {
+		Controllers []json.RawMessage `json:"Controllers"`
+	}
+
+	if err := json.Unmarshal(out, &response); err != nil {
+		return 0, FailedControllerCommandError("/call show", err.Error())
+	}
+
+	return len(response.Controllers), nil
+}
+
+// run runs storcli on the object of the controller, such as /c0 or
+// /c0/e252/s1 for a path of "/e252/s1", and decodes the response data of the
+// controller into data if it is not nil.
+func (c *storcliController) run(ctx context.Context, data any, args ...string) error {
+	object := c.Path
+	if len(args) > 0 && strings.HasPrefix(args[0], "/") {
+		object, args = object+args[0], args[1:]
+	}
+
+	args = append(append([]string{object}, args...), "J")
+	description := strings.Join(args, " ")
+
+	out, err := command.Output(ctx, storcliUtility(), args...)
+	if err != nil {
+		return err
+	}
+
+	return parseStorcliResponse([]byte(out), description, data)
+}
+
+// parseStorcliResponse decodes the JSON output of a storcli command into
+// data, failing if the command did not succeed on the controller.
+func parseStorcliResponse(out []byte, description string, data any) error {
+	var response struct {
+		Controllers []struct {
+			Status struct {
+				Status      string `json:"Status"`
+				Description string `json:"Description"`
+			} `json:"Command Status"`
+			Data json.RawMessage `json:"Response Data"`
+		} `json:"Controllers"`
+	}
+
+	if err := json.Unmarshal(out, &response); err != nil {
+		return FailedControllerCommandError(description, err.Error())
+	}
+
+	if len(response.Controllers) == 0 {
+		return FailedControllerCommandError(description, "no controller in output")
+	}
+
+	controller := response.Controllers[0]
+	if controller.Status.Status != "Success" {
+		return FailedControllerCommandError(description, controller.Status.Description)
+	}
+
+	if data == nil || len(controller.Data) == 0 {
+		return nil
+	}
+
+	if err := json.Unmarshal(controller.Data, data); err != nil {
+		return FailedControllerCommandError(description, err.Error())
+	}
+
+	return nil
+}
+
+// drive returns the physical disk with the controller ID. Drives in an
+// enclosure and drives outside of one are listed separately; storcli fails
+// to list either kind when the controller has none of it.
+func (c *storcliController) drive(ctx context.Context, id int) (*storcliDrive, error) {
+	var (
+		listErr error
+		listed  bool
+	)
+
+	for _, object := range []string{"/eall/sall", "/sall"} {
+		var data struct {
+			Drives []*storcliDrive `json:"Drive Information"`
+		}
+
+		if err := c.run(ctx, &data, object, "show"); err != nil {
+			listErr = cmp.Or(listErr, err)
+			continue
+		}
+
+		listed = true
+
+		for _, d := range data.Drives {
+			if d.ID == id {
+				return d, nil
+			}
+		}
+	}
+
+	if !listed {
+		return nil, listErr
+	}
+
+	return nil, DeviceNotFoundError("physical disk " + strconv.Itoa(id))
+}
+
+// attributes reads the serial number and WWN of the drive.
+func (c *storcliController) attributes(ctx context.Context, d *storcliDrive) (attributes storcliDriveAttributes, err error) {
+	var data map[string]json.RawMessage
+
+	if err = c.run(ctx, &data, d.path(), "show", "all"); err != nil {
+		return
+	}
+
+	// storcli keys the details by the full path of the drive.
+	drive := "Drive " + c.Path + d.path()
+	description := c.Path + d.path() + " show all"
+
+	var detailed map[string]json.RawMessage
+	if err = json.Unmarshal(data[drive+" - Detailed Information"], &detailed); err != nil {
+		return attributes, FailedControllerCommandError(description, "no detailed information in output")
+	}
+
+	if err = json.Unmarshal(detailed[drive+" Device attributes"], &attributes); err != nil {
+		return attributes, FailedControllerCommandError(description, "no device attributes in output")
+	}
+
+	attributes.Serial, attributes.WWN = strings.TrimSpace(attributes.Serial), normalizeWWN(attributes.WWN)
+
+	return
+}
+
+// matches returns whether the block device is the drive with the attributes.
+func (a storcliDriveAttributes) matches(bd *BlockDevice) bool {
+	return (a.WWN != "" && normalizeWWN(bd.WWN) == a.WWN) || (a.Serial != "" && strings.TrimSpace(bd.Serial) == a.Serial)
+}
+
+// path returns how storcli addresses the drive below its controller. Drives
+// that are not in an enclosure only have a slot.
+func (d *storcliDrive) path() string {
+	enclosure, slot, _ := strings.Cut(d.EnclosureSlot, ":")
+	if enclosure = strings.TrimSpace(enclosure); enclosure == "" {
+		return "/s" + slot
+	}
+
+	return "/e" + enclosure + "/s" + slot
+}
+
+// driveGroups returns the drive groups of the virtual disks with the names.
+func (c *storcliController) driveGroups(ctx context.Context, names []string) (groups []string, err error) {
+	var data struct {
+		VirtualDrives []*storcliVirtualDrive `json:"Virtual Drives"`
+	}
+
+	if err = c.run(ctx, &data, "/vall", "show"); err != nil {
+		return
+	}
+
+	for _, name := range names {
+		found := false
+
+		for _, vd := range data.VirtualDrives {
+			if vd.Name == name {
+				group, _, _ := strings.Cut(vd.DriveGroup, "/")
+				groups, found = append(groups, group), true
+
+				break
+			}
+		}
+
+		if !found {
+			return nil, VirtualDiskNotFoundError(&RaidArray{Name: name})
+		}
+	}
+
+	return
+}
@@ -0,0 +1,255 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/metal-toolbox/ironlib/utils"
+)
+
+const storcliDrives = `{"Controllers": [{
+	"Command Status": {"Controller": 0, "Status": "Success", "Description": "Show Drive Information Succeeded."},
+	"Response Data": {"Drive Information": [
+		{"EID:Slt": "252:0", "DID": 8, "State": "Onln", "DG": 0, "Size": "893.750 GB"},
+		{"EID:Slt": "252:1", "DID": 9, "State": "UGood", "DG": "-", "Size": "893.750 GB"},
+		{"EID:Slt": "252:2", "DID": 10, "State": "JBOD", "DG": "-", "Size": "893.750 GB"},
+		{"EID:Slt": " :3", "DID": 11, "State": "GHS", "DG": "-", "Size": "893.750 GB"}
+	]}
+}]}`
+
+const storcliVirtualDrives = `{"Controllers": [{
+	"Command Status": {"Controller": 0, "Status": "Success", "Description": "None"},
+	"Response Data": {"Virtual Drives": [
+		{"DG/VD": "0/0", "TYPE": "RAID1", "State": "Optl", "Name": "BOOT"},
+		{"DG/VD": "1/1", "TYPE": "RAID5", "State": "Optl", "Name": "DATA"}
+	]}
+}]}`
+
+const storcliDirectDrives = `{"Controllers": [{
+	"Command Status": {"Controller": 0, "Status": "Success", "Description": "Show Drive Information Succeeded."},
+	"Response Data": {"Drive Information": [
+		{"EID:Slt": " :4", "DID": 12, "State": "UGood", "DG": "-", "Size": "893.750 GB"}
+	]}
+}]}`
+
+const storcliDriveDetails = `{"Controllers": [{
+	"Command Status": {"Controller": 0, "Status": "Success", "Description": "Show Drive Information Succeeded."},
+	"Response Data": {
+		"Drive /c0/e252/s2": [{"EID:Slt": "252:2", "DID": 10, "State": "JBOD", "DG": "-", "Size": "893.750 GB"}],
+		"Drive /c0/e252/s2 - Detailed Information": {
+			"Drive /c0/e252/s2 Device attributes": {"SN": "  S45PNA0M100002", "WWN": "5002538E40A1B2C4"}
+		}
+	}
+}]}`
+
+// fakeStorcli installs a storcli that answers show commands from canned
+// output, succeeds for everything else and logs its arguments.
+// It returns the file the arguments are logged to.
+func fakeStorcli(t *testing.T, jbod string) string {
+	t.Helper()
+
+	dir := t.TempDir()
+	log := filepath.Join(dir, "args")
+
+	files := map[string]string{
+		"show":      `{"Controllers": [{"Command Status": {"Status": "Success"}, "Response Data": {"Product Name": "AVAGO MegaRAID SAS 9361-8i"}}]}`,
+		"jbod":      `{"Controllers": [{"Command Status": {"Status": "Success"}, "Response Data": {"Controller Properties": [{"Ctrl_Prop": "JBOD", "Value": "` + jbod + `"}]}}]}`,
+		"drives":    storcliDrives,
+		"vds":       storcliVirtualDrives,
+		"details":   storcliDriveDetails,
+		"direct":    storcliDirectDrives,
+		"call":      `{"Controllers": [{"Command Status": {"Controller": 0, "Status": "Success"}}]}`,
+		"succeeded": `{"Controllers": [{"Command Status": {"Status": "Success", "Description": "Succeeded"}}]}`,
+	}
+
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	script := `#!/bin/sh
+case "$*" in
+"/call show J") cat ` + dir + `/call ;;
+"/c0/sall show J") cat ` + dir + `/direct ;;
+"/c0 show J") cat ` + dir + `/show ;;
+"/c0 show jbod J") cat ` + dir + `/jbod ;;
+"/c0/eall/sall show J") cat ` + dir + `/drives ;;
+"/c0/vall show J") cat ` + dir + `/vds ;;
+"/c0/e252/s2 show all J") cat ` + dir + `/details ;;
+*) echo "$*" >> ` + log + `; cat ` + dir + `/succeeded ;;
+esac
+`
+
+	storcli := filepath.Join(dir, "storcli64")
+	if err := os.WriteFile(storcli, []byte(script), 0o700); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv(utils.EnvStorecliUtility, storcli)
+
+	return log
+}
+
+func loggedArgs(t *testing.T, log string) []string {
+	t.Helper()
+
+	data, err := os.ReadFile(log)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		t.Fatal(err)
+	}
+
+	return strings.Split(strings.TrimSpace(string(data)), "\n")
+}
+
+func TestSetPhysicalDiskState(t *testing.T) {
+	tests := []struct {
+		name        string
+		jbod        string
+		id          int
+		state       string
+		force       bool
+		wantChanged bool
+		wantArgs    []string
+		wantErr     error
+	}{
+		{name: "to jbod", jbod: "ON", id: 9, state: PhysicalDiskJBOD, wantChanged: true, wantArgs: []string{"/c0/e252/s1 set jbod J"}},
+		{name: "already jbod", jbod: "ON", id: 10, state: PhysicalDiskJBOD},
+		{name: "to unconfigured good", jbod: "ON", id: 10, state: PhysicalDiskUnconfiguredGood, force: true, wantChanged: true, wantArgs: []string{"/c0/e252/s2 set good force J"}},
+		{name: "jbod disabled", jbod: "OFF", id: 9, state: PhysicalDiskJBOD, wantErr: ErrUnsupportedOption},
+		{name: "array member", jbod: "ON", id: 8, state: PhysicalDiskJBOD, wantErr: ErrStateConflict},
+		{name: "outside an enclosure", jbod: "ON", id: 12, state: PhysicalDiskJBOD, wantChanged: true, wantArgs: []string{"/c0/s4 set jbod J"}},
+		{name: "unknown disk", jbod: "ON", id: 42, state: PhysicalDiskJBOD, wantErr: ErrDeviceNotFound},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			log := fakeStorcli(t, tc.jbod)
+
+			changed, err := SetPhysicalDiskState(context.Background(), tc.id, tc.state, tc.force)
+			if !errors.Is(err, tc.wantErr) || (err != nil) != (tc.wantErr != nil) {
+				t.Fatalf("SetPhysicalDiskState error = %v, want %v", err, tc.wantErr)
+			}
+
+			if changed != tc.wantChanged {
+				t.Errorf("SetPhysicalDiskState changed = %t, want %t", changed, tc.wantChanged)
+			}
+
+			if args := loggedArgs(t, log); !slices.Equal(args, tc.wantArgs) {
+				t.Errorf("storcli ran %q, want %q", args, tc.wantArgs)
+			}
+		})
+	}
+}
+
+func TestSetPhysicalDiskStateMultipleControllers(t *testing.T) {
+	log := fakeStorcli(t, "ON")
+
+	two := `{"Controllers": [{"Command Status": {"Controller": 0, "Status": "Success"}}, {"Command Status": {"Controller": 1, "Status": "Success"}}]}`
+	if err := os.WriteFile(filepath.Join(filepath.Dir(log), "call"), []byte(two), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := SetPhysicalDiskState(context.Background(), 9, PhysicalDiskJBOD, false); !errors.Is(err, ErrUnsupportedOption) {
+		t.Errorf("SetPhysicalDiskState with two controllers = %v, want %v", err, ErrUnsupportedOption)
+	}
+
+	if args := loggedArgs(t, log); len(args) != 0 {
+		t.Errorf("storcli ran %q with two controllers", args)
+	}
+}
+
+func TestPhysicalDiskBlockDevice(t *testing.T) {
+	root := fakeDisks(t)
+	fakeStorcli(t, "ON")
+	ctx := context.Background()
+
+	bd, err := PhysicalDiskBlockDevice(ctx, 10)
+	if err != nil || bd == nil || bd.File != filepath.Join(root, "dev", "sdb") {
+		t.Errorf("PhysicalDiskBlockDevice of a JBOD disk = %+v, %v, want %s", bd, err, filepath.Join(root, "dev", "sdb"))
+	}
+
+	if bd, err := PhysicalDiskBlockDevice(ctx, 9); err != nil || bd != nil {
+		t.Errorf("PhysicalDiskBlockDevice of an unconfigured good disk = %+v, %v, want nil", bd, err)
+	}
+
+	if got := (storcliDriveAttributes{Serial: "S45PNA0M100001"}).matches(&BlockDevice{Serial: "S45PNA0M100001 "}); !got {
+		t.Error("attributes do not match a block device with the same serial number")
+	}
+}
+
+func TestHotSpares(t *testing.T) {
+	log := fakeStorcli(t, "OFF")
+	ctx := context.Background()
+
+	if changed, err := AddHotSpare(ctx, 9, []string{"DATA", "BOOT"}); err != nil || !changed {
+		t.Errorf("AddHotSpare = %t, %v, want true, nil", changed, err)
+	}
+
+	if changed, err := AddHotSpare(ctx, 11, nil); err != nil || changed {
+		t.Errorf("AddHotSpare of a global hot spare = %t, %v, want false, nil", changed, err)
+	}
+
+	if _, err := AddHotSpare(ctx, 9, []string{"MISSING"}); !errors.Is(err, ErrVirtualDiskNotFound) {
+		t.Errorf("AddHotSpare for an unknown array = %v, want %v", err, ErrVirtualDiskNotFound)
+	}
+
+	if _, err := AddHotSpare(ctx, 10, nil); !errors.Is(err, ErrStateConflict) {
+		t.Errorf("AddHotSpare of a JBOD disk = %v, want %v", err, ErrStateConflict)
+	}
+
+	if changed, err := RemoveHotSpare(ctx, 11); err != nil || !changed {
+		t.Errorf("RemoveHotSpare = %t, %v, want true, nil", changed, err)
+	}
+
+	if changed, err := RemoveHotSpare(ctx, 9); err != nil || changed {
+		t.Errorf("RemoveHotSpare of a disk that is no hot spare = %t, %v, want false, nil", changed, err)
+	}
+
+	if err := ClearForeignConfig(ctx); err != nil {
+		t.Errorf("ClearForeignConfig = %v", err)
+	}
+
+	want := []string{"/c0/e252/s1 add hotsparedrive dgs=1,0 J", "/c0/s3 delete hotsparedrive J", "/c0/fall delete J"}
+	if args := loggedArgs(t, log); !slices.Equal(args, want) {
+		t.Errorf("storcli ran %q, want %q", args, want)
+	}
+}
+
+func TestParseStorcliResponse(t *testing.T) {
+	failed := `{"Controllers": [{"Command Status": {"Controller": 0, "Status": "Failure", "Description": "Set Drive Good Failed.",
+		"Detailed Status": [{"Drive": "/c0/e252/s2", "Status": "Failure", "ErrMsg": "Drive has OS partitions"}]}}]}`
+
+	if err := parseStorcliResponse([]byte(failed), "/c0/e252/s2 set good", nil); !errors.Is(err, ErrFailedControllerCommand) ||
+		!strings.Contains(err.Error(), "Set Drive Good Failed.") {
+		t.Errorf("parseStorcliResponse of a failure = %v, want %v", err, ErrFailedControllerCommand)
+	}
+
+	if err := parseStorcliResponse([]byte("storcli: command not supported"), "/c0 show", nil); !errors.Is(err, ErrFailedControllerCommand) {
+		t.Errorf("parseStorcliResponse of text = %v, want %v", err, ErrFailedControllerCommand)
+	}
+
+	var data struct {
+		Drives []*storcliDrive `json:"Drive Information"`
+	}
+
+	if err := parseStorcliResponse([]byte(storcliDrives), "/c0/eall/sall show", &data); err != nil {
+		t.Fatalf("parseStorcliResponse = %v", err)
+	}
+
+	var paths []string
+	for _, d := range data.Drives {
+		paths = append(paths, d.path())
+	}
+
+	if want := []string{"/e252/s0", "/e252/s1", "/e252/s2", "/s3"}; !slices.Equal(paths, want) {
+		t.Errorf("drive paths = %q, want %q", paths, want)
+	}
+}
@@ -0,0 +1,280 @@
+package model
+
+import (
+	"cmp"
+	"encoding/json"
+	"io"
+	"path"
+	"slices"
+	"strings"
+)
+
+// Sort orders for the block devices matched by a DeviceGroup.
+const (
+	SortBySize     = "size"
+	SortBySizeDesc = "-size"
+	SortByFile     = "file"
+)
+
+// LayoutTemplate describes a StorageLayout in terms of rules matching block
+// devices rather than concrete device files, so that one template can serve
+// many hardware models. Render turns it into a StorageLayout for the block
+// devices of a system.
+type LayoutTemplate struct {
+	Name         string               `json:"name"`
+	DeviceGroups []*DeviceGroup       `json:"device_groups"`
+	RaidArrays   []*RaidArrayTemplate `json:"raid_arrays"`
+	FileSystems  []*FileSystem        `json:"file_systems"`
+}
+
+// DeviceGroup selects block devices and lays out the same partitions on each
+// of them. A block device is claimed by the first group it matches.
+type DeviceGroup struct {
+	Name  string     `json:"name"`
+	Match DeviceRule `json:"match"`
+	// SortBy orders the matches before Count is applied: size (smallest
+	// first, the default), -size (largest first) or file.
+	SortBy string `json:"sort_by"`
+	// Count is the exact number of devices the group takes. Zero takes every
+	// matching device, but at least one.
+	Count      int          `json:"count"`
+	Partitions []*Partition `json:"partitions"`
+}
+
+// DeviceRule matches block devices. Empty fields match anything.
+type DeviceRule struct {
+	// MediaTypes are hdd, ssd, nvme or virtual. Virtual devices (loop, md,
+	// dm) only match when listed explicitly.
+	MediaTypes []string `json:"media_types"`
+	Transports []string `json:"transports"`
+	MinSize    string   `json:"min_size"`
+	MaxSize    string   `json:"max_size"`
+	// Model is a shell pattern, matched case insensitively.
+	Model  string `json:"model"`
+	Vendor string `json:"vendor"`
+}
+
+// RaidArrayTemplate builds a RAID array from the partition with the given name
+// on every block device of a DeviceGroup.
+type RaidArrayTemplate struct {
+	Name      string `json:"name"`
+	Level     string `json:"level"`
+	RaidType  string `json:"raid_type"`
+	Group     string `json:"group"`
+	Partition string `json:"partition"`
+}
+
+// DecodeLayoutTemplate reads a JSON encoded LayoutTemplate, rejecting
+// documents that do not conform to LayoutTemplateSchema.
+func DecodeLayoutTemplate(r io.Reader) (template *LayoutTemplate, err error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return
+	}
+
+	var document any
+
+	if err = json.Unmarshal(data, &document); err != nil {
+		return
+	}
+
+	schema := LayoutTemplateSchema()
+	if problems := validateSchema(schema, schema, document, ""); len(problems) > 0 {
+		messages := make([]string, 0, len(problems))
+		for _, p := range problems {
+			messages = append(messages, p.String())
+		}
+
+		return nil, InvalidTemplateError(strings.Join(messages, "; "))
+	}
+
+	template = &LayoutTemplate{}
+	err = json.Unmarshal(data, template)
+
+	return
+}
+
+// Render returns the StorageLayout the template describes for the given block
+// devices, usually those returned by DiscoverBlockDevices. Matched devices are
+// referenced by WWN or serial number where known so the layout stays valid
+// when kernel names change.
+func (t *LayoutTemplate) Render(blockDevices []*BlockDevice) (layout *StorageLayout, err error) {
+	layout = &StorageLayout{Name: t.Name, FileSystems: t.FileSystems}
+	claimed := make(map[*BlockDevice]bool)
+	groups := make(map[string][]*BlockDevice)
+
+	for _, g := range t.DeviceGroups {
+		var matches []*BlockDevice
+
+		if matches, err = g.selectDevices(blockDevices, claimed); err != nil {
+			return nil, err
+		}
+
+		for _, bd := range matches {
+			claimed[bd] = true
+			layout.BlockDevices = append(layout.BlockDevices, renderBlockDevice(bd, g.Partitions))
+		}
+
+		groups[g.Name] = matches
+	}
+
+	for _, at := range t.RaidArrays {
+		if err = t.validateRaidArray(at, groups); err != nil {
+			return nil, err
+		}
+
+		layout.RaidArrays = append(layout.RaidArrays, &RaidArray{
+			Name:                    at.Name,
+			Level:                   at.Level,
+			RaidType:                at.RaidType,
+			PartitionName:           at.Partition,
+			ControllerVirtualDiskID: -1,
+		})
+	}
+
+	if problems := layout.Validate(); len(problems) > 0 {
+		return nil, InvalidLayoutError(problems)
+	}
+
+	return
+}
+
+// validateRaidArray makes sure the partition an array is built from exists in
+// its group and in no other group, since StorageLayout.ArrayMembers collects
+// partitions by name across all block devices.
+func (t *LayoutTemplate) validateRaidArray(at *RaidArrayTemplate, groups map[string][]*BlockDevice) error {
+	if _, ok := groups[at.Group]; !ok {
+		return InvalidTemplateError("raid array " + at.Name + " refers to unknown device group " + at.Group)
+	}
+
+	for _, g := range t.DeviceGroups {
+		hasPartition := slices.ContainsFunc(g.Partitions, func(p *Partition) bool { return p.Name == at.Partition })
+
+		switch {
+		case g.Name == at.Group && !hasPartition:
+			return InvalidTemplateError("device group " + g.Name + " has no partition " + at.Partition)
+		case g.Name != at.Group && hasPartition:
+			return InvalidTemplateError("partition " + at.Partition + " of raid array " + at.Name + " is also used by device group " + g.Name)
+		}
+	}
+
+	return nil
+}
+
+// selectDevices returns the unclaimed block devices matching the group.
+func (g *DeviceGroup) selectDevices(blockDevices []*BlockDevice, claimed map[*BlockDevice]bool) (matches []*BlockDevice, err error) {
+	for _, bd := range blockDevices {
+		var ok bool
+
+		if ok, err = g.Match.Matches(bd); err != nil {
+			return
+		}
+
+		if ok && !claimed[bd] {
+			matches = append(matches, bd)
+		}
+	}
+
+	switch g.SortBy {
+	case "", SortBySize:
+		slices.SortStableFunc(matches, func(a, b *BlockDevice) int { return cmp.Compare(a.Size, b.Size) })
+	case SortBySizeDesc:
+		slices.SortStableFunc(matches, func(a, b *BlockDevice) int { return cmp.Compare(b.Size, a.Size) })
+	case SortByFile:
+		slices.SortStableFunc(matches, func(a, b *BlockDevice) int { return cmp.Compare(a.File, b.File) })
+	default:
+		return nil, InvalidTemplateError("device group " + g.Name + " has invalid sort_by " + g.SortBy)
+	}
+
+	want := max(g.Count, 1)
+	if len(matches) < want {
+		return nil, TemplateNotSatisfiedError(g.Name, want, len(matches))
+	}
+
+	if g.Count > 0 {
+		matches = matches[:g.Count]
+	}
+
+	return
+}
+
+// Matches reports whether the BlockDevice satisfies the rule.
+func (r *DeviceRule) Matches(bd *BlockDevice) (bool, error) {
+	if len(r.MediaTypes) == 0 && bd.MediaType == "virtual" {
+		return false, nil
+	}
+
+	if len(r.MediaTypes) > 0 && !containsFold(r.MediaTypes, bd.MediaType) {
+		return false, nil
+	}
+
+	if len(r.Transports) > 0 && !containsFold(r.Transports, bd.Transport) {
+		return false, nil
+	}
+
+	if r.MinSize != "" {
+		size, err := ParseSize(r.MinSize)
+		if err != nil {
+			return false, err
+		}
+
+		if bd.Size < size {
+			return false, nil
+		}
+	}
+
+	if r.MaxSize != "" {
+		size, err := ParseSize(r.MaxSize)
+		if err != nil {
+			return false, err
+		}
+
+		if bd.Size > size {
+			return false, nil
+		}
+	}
+
+	if r.Model != "" {
+		if matched, err := path.Match(normalizeModel(r.Model), normalizeModel(bd.Model)); err != nil || !matched {
+			return false, err
+		}
+	}
+
+	if r.Vendor != "" && !strings.EqualFold(r.Vendor, strings.TrimSpace(bd.Vendor)) {
+		return false, nil
+	}
+
+	return true, nil
+}
+
+// renderBlockDevice returns the layout entry for a matched block device.
+func renderBlockDevice(bd *BlockDevice, partitions []*Partition) *BlockDevice {
+	rendered := &BlockDevice{
+		ControllerPhysicalDeviceID: -1,
+		File:                       bd.File,
+		WWN:                        bd.WWN,
+		Serial:                     bd.Serial,
+		Model:                      bd.Model,
+		Size:                       bd.Size,
+		MediaType:                  bd.MediaType,
+	}
+
+	switch {
+	case bd.WWN != "":
+		rendered.Selector = SelectorWWN + ":" + bd.WWN
+	case bd.Serial != "":
+		rendered.Selector = SelectorSerial + ":" + bd.Serial
+	}
+
+	for _, p := range partitions {
+		rp := *p
+		rp.BlockDevice = nil
+		rendered.Partitions = append(rendered.Partitions, &rp)
+	}
+
+	return rendered
+}
+
+func containsFold(values []string, s string) bool {
+	return slices.ContainsFunc(values, func(v string) bool { return strings.EqualFold(v, s) })
+}
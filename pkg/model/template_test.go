@@ -0,0 +1,84 @@
+package model
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestLayoutTemplateRender(t *testing.T) {
+	blockDevices := []*BlockDevice{
+		{File: "/dev/sda", MediaType: "ssd", Size: 960 * GiB, WWN: "0x5000000000000a"},
+		{File: "/dev/sdb", MediaType: "ssd", Size: 480 * GiB, WWN: "0x5000000000000b"},
+		{File: "/dev/sdc", MediaType: "ssd", Size: 480 * GiB, Serial: "SERIALC"},
+		{File: "/dev/sdd", MediaType: "hdd", Size: 8 * TiB, Rotational: true},
+		{File: "/dev/nvme0n1", MediaType: "nvme", Size: 3840 * GiB},
+		{File: "/dev/nvme1n1", MediaType: "nvme", Size: 512 * GiB},
+		{File: "/dev/loop0", MediaType: "virtual", Size: 1 * TiB},
+	}
+
+	template := &LayoutTemplate{
+		Name: "test",
+		DeviceGroups: []*DeviceGroup{
+			{
+				Name:  "boot",
+				Match: DeviceRule{MediaTypes: []string{"ssd"}},
+				Count: 2,
+				Partitions: []*Partition{
+					{Name: "BIOS", Position: 1, Size: "4M", Type: "ef02"},
+					{Name: "ROOT", Position: 2, Size: "0", Type: "fd00"},
+				},
+			},
+			{
+				Name:       "data",
+				Match:      DeviceRule{MediaTypes: []string{"nvme"}, MinSize: "1T"},
+				Partitions: []*Partition{{Name: "DATA", Position: 1, Size: "0", Type: "8300"}},
+			},
+		},
+		RaidArrays: []*RaidArrayTemplate{{Name: "ROOT", Level: "1", Group: "boot", Partition: "ROOT"}},
+	}
+
+	layout, err := template.Render(blockDevices)
+	if err != nil {
+		t.Fatalf("Render returned error: %v", err)
+	}
+
+	want := []struct{ file, selector string }{
+		{"/dev/sdb", "wwn:0x5000000000000b"},
+		{"/dev/sdc", "serial:SERIALC"},
+		{"/dev/nvme0n1", ""},
+	}
+
+	if len(layout.BlockDevices) != len(want) {
+		t.Fatalf("Render returned %d block devices, want %d", len(layout.BlockDevices), len(want))
+	}
+
+	for i, w := range want {
+		bd := layout.BlockDevices[i]
+		if bd.File != w.file || bd.Selector != w.selector {
+			t.Errorf("block device %d = %s (%s), want %s (%s)", i, bd.File, bd.Selector, w.file, w.selector)
+		}
+	}
+
+	if n := len(layout.BlockDevices[0].Partitions); n != 2 {
+		t.Errorf("boot device has %d partitions, want 2", n)
+	}
+
+	if layout.BlockDevices[0].Partitions[0] == layout.BlockDevices[1].Partitions[0] {
+		t.Error("partitions are shared between block devices")
+	}
+
+	if len(layout.RaidArrays) != 1 || layout.RaidArrays[0].PartitionName != "ROOT" {
+		t.Errorf("unexpected raid arrays %+v", layout.RaidArrays)
+	}
+
+	template.DeviceGroups[0].Count = 4
+	if _, err := template.Render(blockDevices); !errors.Is(err, ErrTemplateNotSatisfied) {
+		t.Errorf("Render with too few devices returned %v, want %v", err, ErrTemplateNotSatisfied)
+	}
+
+	template.DeviceGroups[0].Count = 2
+	template.DeviceGroups[1].Partitions[0].Name = "ROOT"
+	if _, err := template.Render(blockDevices); !errors.Is(err, ErrInvalidTemplate) {
+		t.Errorf("Render with shared partition name returned %v, want %v", err, ErrInvalidTemplate)
+	}
+}
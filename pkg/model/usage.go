@@ -0,0 +1,286 @@
+package model
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/metal-toolbox/vogelkop/internal/command"
+)
+
+var (
+	sysfsRoot  = "/sys"
+	procfsRoot = "/proc"
+)
+
+// Usage describes one reason why a block device is in use.
+type Usage struct {
+	// Device is the kernel name of the device that is in use, which is either
+	// the BlockDevice itself or one of its partitions.
+	Device string `json:"device"`
+	// Kind is one of mount, root, swap, md, dm, lvm or crypt.
+	Kind string `json:"kind"`
+	// Holder is the kernel name of the device stacked on top of Device, if any.
+	Holder string `json:"holder,omitempty"`
+	Detail string `json:"detail"`
+}
+
+func (u Usage) String() string {
+	return fmt.Sprintf("%s: %s (%s)", u.Device, u.Kind, u.Detail)
+}
+
+// InUse reports every reason the BlockDevice, or any of its partitions, is in
+// use: mounted filesystems (including the running root filesystem), active
+// swap, membership of md arrays, device-mapper, LVM or dm-crypt holders and
+// LVM physical volumes of inactive volume groups. Usage of devices stacked on
+// top is attributed to the device underneath.
+// It returns an empty slice if the device is not in use.
+func (b *BlockDevice) InUse(ctx context.Context) (usages []Usage, err error) {
+	name, err := kernelName(b.File)
+	if err != nil {
+		return
+	}
+
+	mounts, err := readMounts()
+	if err != nil {
+		return
+	}
+
+	swaps, err := readSwaps()
+	if err != nil {
+		return
+	}
+
+	names := append([]string{name}, kernelPartitions(name)...)
+	for _, n := range names {
+		usages = append(usages, deviceUsage(n, n, mounts, swaps, map[string]bool{})...)
+	}
+
+	usages = append(usages, lvmPhysicalVolumeUsage(ctx, names)...)
+
+	return
+}
+
+// CheckNotInUse returns a DeviceInUseError if InUse reports any usage.
+func (b *BlockDevice) CheckNotInUse(ctx context.Context) error {
+	usages, err := b.InUse(ctx)
+	if err != nil {
+		return err
+	}
+
+	if len(usages) > 0 {
+		return DeviceInUseError(b.File, usages)
+	}
+
+	return nil
+}
+
+// deviceUsage collects the usage of the device with the given kernel name and
+// of everything stacked on top of it, attributing it to owner.
+func deviceUsage(owner, name string, mounts map[string][]string, swaps map[string]bool, seen map[string]bool) (usages []Usage) {
+	if seen[name] {
+		return
+	}
+
+	seen[name] = true
+
+	via := ""
+	if owner != name {
+		via = " via " + name
+	}
+
+	for _, mountPoint := range mounts[name] {
+		kind := "mount"
+		if mountPoint == "/" {
+			kind = "root"
+		}
+
+		usages = append(usages, Usage{Device: owner, Kind: kind, Detail: "mounted on " + mountPoint + via})
+	}
+
+	if swaps[name] {
+		usages = append(usages, Usage{Device: owner, Kind: "swap", Detail: "active swap" + via})
+	}
+
+	holders, _ := os.ReadDir(filepath.Join(sysfsRoot, "class", "block", name, "holders"))
+	for _, h := range holders {
+		holder := h.Name()
+
+		usage := Usage{Device: owner, Holder: holder}
+
+		switch {
+		case strings.HasPrefix(holder, "md"):
+			usage.Kind = "md"
+			usage.Detail = "member of /dev/" + holder
+		case strings.HasPrefix(holder, "dm-"):
+			dmName := readSysfsAttribute(filepath.Join("class", "block", holder, "dm", "name"))
+			dmUUID := readSysfsAttribute(filepath.Join("class", "block", holder, "dm", "uuid"))
+
+			switch {
+			case strings.HasPrefix(dmUUID, "LVM-"):
+				usage.Kind = "lvm"
+				usage.Detail = "backs logical volume " + dmName
+			case strings.HasPrefix(dmUUID, "CRYPT-"):
+				usage.Kind = "crypt"
+				usage.Detail = "backs dm-crypt mapping " + dmName
+			default:
+				usage.Kind = "dm"
+				usage.Detail = "backs device-mapper device " + dmName
+			}
+		default:
+			usage.Kind = "holder"
+			usage.Detail = "held by " + holder
+		}
+
+		usage.Detail += via
+		usages = append(usages, usage)
+		usages = append(usages, deviceUsage(owner, holder, mounts, swaps, seen)...)
+	}
+
+	return
+}
+
+// lvmPhysicalVolumeUsage reports devices that are LVM physical volumes. This
+// catches volume groups that are not active and therefore have no holders.
+// Nothing is reported if the LVM tools are not installed.
+func lvmPhysicalVolumeUsage(ctx context.Context, names []string) (usages []Usage) {
+	if _, err := exec.LookPath("pvs"); err != nil {
+		return
+	}
+
+	for _, name := range names {
+		out, err := command.Output(ctx, "pvs", "--noheadings", "-o", "vg_name", "/dev/"+name)
+		if err != nil {
+			continue
+		}
+
+		if vg := strings.TrimSpace(out); vg != "" {
+			usages = append(usages, Usage{Device: name, Kind: "lvm", Detail: "physical volume of volume group " + vg})
+		}
+	}
+
+	return
+}
+
+// kernelName returns the kernel name (sda, nvme0n1p1, dm-0) of a device file,
+// following symlinks such as /dev/disk/by-id/* and /dev/mapper/*.
+func kernelName(file string) (name string, err error) {
+	resolved, err := filepath.EvalSymlinks(file)
+	if err != nil {
+		return
+	}
+
+	name = filepath.Base(resolved)
+
+	return
+}
+
+// kernelPartitions returns the kernel names of the partitions of the device
+// with the given kernel name.
+func kernelPartitions(name string) (partitions []string) {
+	entries, _ := os.ReadDir(filepath.Join(sysfsRoot, "class", "block", name))
+	for _, e := range entries {
+		if _, err := os.Stat(filepath.Join(sysfsRoot, "class", "block", name, e.Name(), "partition")); err == nil {
+			partitions = append(partitions, e.Name())
+		}
+	}
+
+	return
+}
+
+// readSysfsAttribute returns the trimmed contents of a file below sysfsRoot
+// or an empty string if it cannot be read.
+func readSysfsAttribute(path string) string {
+	b, err := os.ReadFile(filepath.Join(sysfsRoot, path))
+	if err != nil {
+		return ""
+	}
+
+	return strings.TrimSpace(string(b))
+}
+
+// kernelNameFromDevNumber maps a "major:minor" pair to a kernel name.
+func kernelNameFromDevNumber(devNumber string) string {
+	target, err := os.Readlink(filepath.Join(sysfsRoot, "dev", "block", devNumber))
+	if err != nil {
+		return ""
+	}
+
+	return filepath.Base(target)
+}
+
+// readMounts returns the mount points of every mounted block device keyed by
+// kernel name.
+func readMounts() (mounts map[string][]string, err error) {
+	f, err := os.Open(filepath.Join(procfsRoot, "self", "mountinfo"))
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	mounts = make(map[string][]string)
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// 36 35 98:0 /mnt1 /mnt2 rw,noatime master:1 - ext3 /dev/root rw,errors=continue
+		pre, post, found := strings.Cut(scanner.Text(), " - ")
+		fields := strings.Fields(pre)
+
+		if !found || len(fields) < 5 {
+			continue
+		}
+
+		name := kernelNameFromDevNumber(fields[2])
+
+		// Filesystems such as btrfs report an anonymous device number, the
+		// mount source identifies the underlying device instead.
+		if postFields := strings.Fields(post); name == "" && len(postFields) > 1 && strings.HasPrefix(postFields[1], "/dev/") {
+			name, _ = kernelName(postFields[1])
+		}
+
+		if name != "" {
+			mounts[name] = append(mounts[name], unescapeMountField(fields[4]))
+		}
+	}
+
+	err = scanner.Err()
+
+	return
+}
+
+// readSwaps returns the kernel names of all active swap devices.
+func readSwaps() (swaps map[string]bool, err error) {
+	f, err := os.Open(filepath.Join(procfsRoot, "swaps"))
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	swaps = make(map[string]bool)
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || !strings.HasPrefix(fields[0], "/dev/") {
+			continue
+		}
+
+		if name, nameErr := kernelName(unescapeMountField(fields[0])); nameErr == nil {
+			swaps[name] = true
+		}
+	}
+
+	err = scanner.Err()
+
+	return
+}
+
+// unescapeMountField reverts the octal escaping of whitespace used in
+// /proc/self/mountinfo and /proc/swaps.
+func unescapeMountField(s string) string {
+	return strings.NewReplacer(`\040`, " ", `\011`, "\t", `\012`, "\n", `\134`, `\`).Replace(s)
+}
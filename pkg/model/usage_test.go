@@ -0,0 +1,94 @@
+package model
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// fakeTree creates files below root. Entries ending in "/" become
+// directories, entries starting with "->" in their content become symlinks.
+func fakeTree(t *testing.T, root string, files map[string]string) {
+	t.Helper()
+
+	for path, content := range files {
+		full := filepath.Join(root, path)
+
+		if err := os.MkdirAll(filepath.Dir(full), 0o755); err != nil {
+			t.Fatal(err)
+		}
+
+		switch {
+		case path[len(path)-1] == '/':
+			if err := os.MkdirAll(full, 0o755); err != nil {
+				t.Fatal(err)
+			}
+		case len(content) > 2 && content[:2] == "->":
+			if err := os.Symlink(content[2:], full); err != nil {
+				t.Fatal(err)
+			}
+		default:
+			if err := os.WriteFile(full, []byte(content), 0o600); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+}
+
+func TestDeviceUsage(t *testing.T) {
+	root := t.TempDir()
+	fakeTree(t, root, map[string]string{
+		"sys/class/block/sda/sda1/partition":  "1\n",
+		"sys/class/block/sda/sda2/partition":  "2\n",
+		"sys/class/block/sda/sda3/partition":  "3\n",
+		"sys/class/block/sda1/holders/md127/": "",
+		"sys/class/block/sda2/holders/dm-0/":  "",
+		"sys/class/block/sda3/holders/":       "",
+		"sys/class/block/md127/holders/":      "",
+		"sys/class/block/dm-0/dm/uuid":        "LVM-Zm9vYmFy\n",
+		"sys/class/block/dm-0/dm/name":        "vg0-data\n",
+		"sys/dev/block/9:127":                 "->../../devices/virtual/block/md127",
+		"sys/dev/block/253:0":                 "->../../devices/virtual/block/dm-0",
+		"proc/self/mountinfo":                 "22 1 9:127 / / rw,relatime shared:1 - ext4 /dev/md127 rw\n30 22 253:0 / /srv/data rw shared:2 - xfs /dev/mapper/vg0-data rw\n",
+		"proc/swaps":                          "Filename\tType\tSize\tUsed\tPriority\n",
+	})
+
+	oldSysfs, oldProcfs := sysfsRoot, procfsRoot
+	sysfsRoot, procfsRoot = filepath.Join(root, "sys"), filepath.Join(root, "proc")
+
+	t.Cleanup(func() {
+		sysfsRoot, procfsRoot = oldSysfs, oldProcfs
+	})
+
+	partitions := kernelPartitions("sda")
+	if !slices.Equal(partitions, []string{"sda1", "sda2", "sda3"}) {
+		t.Fatalf("kernelPartitions = %v", partitions)
+	}
+
+	mounts, err := readMounts()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	kinds := func(usages []Usage) (k []string) {
+		for _, u := range usages {
+			k = append(k, u.Kind)
+		}
+
+		return
+	}
+
+	tests := map[string][]string{
+		"sda1": {"md", "root"},
+		"sda2": {"lvm", "mount"},
+		"sda3": nil,
+	}
+
+	for name, want := range tests {
+		got := kinds(deviceUsage(name, name, mounts, map[string]bool{}, map[string]bool{}))
+		if !slices.Equal(got, want) {
+			t.Errorf("deviceUsage(%s) kinds = %v, want %v", name, got, want)
+		}
+	}
+}
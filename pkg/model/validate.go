@@ -0,0 +1,474 @@
+package model
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/bmc-toolbox/common"
+)
+
+// minimumRaidMembers is the smallest number of members mdadm and the RAID
+// controllers accept for each RAID level.
+var minimumRaidMembers = map[string]int{
+	"0":  2,
+	"1":  2,
+	"4":  3,
+	"5":  3,
+	"6":  4,
+	"10": 2,
+}
+
+var partitionTypePattern = regexp.MustCompile(`^([0-9a-fA-F]{4}|[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12})$`)
+
+// LayoutProblem is a problem found in a StorageLayout document. Pointer is
+// the JSON pointer (RFC 6901) of the offending value.
+type LayoutProblem struct {
+	Pointer string `json:"pointer"`
+	Message string `json:"message"`
+}
+
+func (p LayoutProblem) String() string {
+	return p.Pointer + ": " + p.Message
+}
+
+// layoutValidator collects the problems of a StorageLayout.
+type layoutValidator struct {
+	layout   *StorageLayout
+	problems []LayoutProblem
+}
+
+func (v *layoutValidator) add(pointer, format string, args ...any) {
+	v.problems = append(v.problems, LayoutProblem{Pointer: pointer, Message: fmt.Sprintf(format, args...)})
+}
+
+// Validate checks the StorageLayout for problems that would otherwise only
+// surface halfway through Apply, such as duplicate partition positions, RAID
+// arrays without enough members or colliding mount points. It does not look
+// at the system the layout is applied to.
+// It returns the problems found, or nil if the layout is valid.
+func (l *StorageLayout) Validate() []LayoutProblem {
+	v := &layoutValidator{layout: l}
+
+	devices := make(map[string]string)
+
+	for i, bd := range l.BlockDevices {
+		pointer := "/block_devices/" + strconv.Itoa(i)
+
+		if bd == nil {
+			v.add(pointer, "block device must not be null")
+			continue
+		}
+
+		id := firstNonEmpty(bd.Selector, bd.File, prefixed(SelectorWWN, bd.WWN), prefixed(SelectorSerial, bd.Serial))
+		if id == "" {
+			v.add(pointer, "one of file, selector, wwn or serial is required")
+		} else if other, ok := devices[id]; ok {
+			v.add(pointer, "block device %s is already listed at %s", id, other)
+		} else {
+			devices[id] = pointer
+		}
+
+		v.validatePartitions(pointer, bd)
+	}
+
+	arrays := make(map[string]string)
+
+	for i, a := range l.RaidArrays {
+		pointer := "/raid_arrays/" + strconv.Itoa(i)
+
+		if a == nil {
+			v.add(pointer, "raid array must not be null")
+			continue
+		}
+
+		if a.Name == "" {
+			v.add(pointer+"/name", "name is required")
+		} else if other, ok := arrays[a.Name]; ok {
+			v.add(pointer+"/name", "raid array %s is already defined at %s", a.Name, other)
+		} else {
+			arrays[a.Name] = pointer
+		}
+
+		v.validateRaidArray(pointer, a)
+	}
+
+	v.validateNVMeNamespaces()
+	v.validateEncryptedVolumes()
+	v.validateVolumeGroups()
+	v.validateFileSystems()
+
+	return v.problems
+}
+
+func (v *layoutValidator) validatePartitions(pointer string, bd *BlockDevice) {
+	positions := make(map[uint]string)
+
+	for j, p := range bd.Partitions {
+		pp := pointer + "/partitions/" + strconv.Itoa(j)
+
+		if p == nil {
+			v.add(pp, "partition must not be null")
+			continue
+		}
+
+		if p.Position < 1 || p.Position > 128 {
+			v.add(pp+"/position", "position %d is outside 1-128", p.Position)
+		} else if other, ok := positions[p.Position]; ok {
+			v.add(pp+"/position", "position %d is already used at %s", p.Position, other)
+		} else {
+			positions[p.Position] = pp
+		}
+
+		if p.Size == "" {
+			v.add(pp+"/size", "size is required")
+		} else if _, err := ParseSize(strings.TrimPrefix(p.Size, "-")); err != nil {
+			v.add(pp+"/size", "invalid size %q", p.Size)
+		}
+
+		if p.Type != "" && !partitionTypePattern.MatchString(p.Type) {
+			v.add(pp+"/type", "type %q is neither a 4 digit type code nor a GUID", p.Type)
+		}
+
+		if p.FileSystem != "" && p.Name != "" && v.isRaidMember(p.Name) {
+			v.add(pp+"/file_system", "partition %s is a raid array member and cannot carry a filesystem", p.Name)
+		}
+	}
+}
+
+func (v *layoutValidator) validateRaidArray(pointer string, a *RaidArray) {
+	raidType := a.GetRaidType()
+	if raidType != common.SlugRAIDImplLinuxSoftware && raidType != common.SlugRAIDImplHardware {
+		v.add(pointer+"/raid_type", "invalid raid type %q", a.RaidType)
+	}
+
+	level := normalizeRaidLevel(a.Level)
+
+	minimum, ok := minimumRaidMembers[level]
+	if !ok {
+		v.add(pointer+"/level", "invalid raid level %q", a.Level)
+	}
+
+	// Controllers export single disks as one member RAID 0 arrays.
+	if raidType == common.SlugRAIDImplHardware && level == "0" {
+		minimum = 1
+	}
+
+	members := len(a.Devices)
+
+	if a.PartitionName != "" {
+		if raidType == common.SlugRAIDImplHardware {
+			v.add(pointer+"/partition_name", "hardware raid arrays cannot be built from partitions")
+		}
+
+		partitions := v.partitionsNamed(a.PartitionName)
+		if len(partitions) == 0 {
+			v.add(pointer+"/partition_name", "no partition is named %s", a.PartitionName)
+		}
+
+		members += len(partitions)
+	}
+
+	if ok && members < minimum {
+		v.add(pointer, "raid level %s needs at least %d members, has %d", a.Level, minimum, members)
+	}
+
+	if a.VirtualDisk == nil {
+		return
+	}
+
+	if raidType != common.SlugRAIDImplHardware {
+		v.add(pointer+"/virtual_disk", "virtual disk options only apply to hardware raid arrays")
+	}
+
+	problems := a.VirtualDisk.Problems()
+
+	fields := make([]string, 0, len(problems))
+	for field := range problems {
+		fields = append(fields, field)
+	}
+
+	slices.Sort(fields)
+
+	for _, field := range fields {
+		v.add(pointer+"/virtual_disk/"+field, "%s", problems[field])
+	}
+}
+
+func (v *layoutValidator) validateFileSystems() {
+	mountPoints := make(map[string]string)
+
+	addMountPoint := func(pointer, mountPoint string) {
+		switch mountPoint {
+		case "", "none", "swap":
+			return
+		}
+
+		if !path.IsAbs(mountPoint) {
+			v.add(pointer, "mount point %s is not an absolute path", mountPoint)
+			return
+		}
+
+		mountPoint = path.Clean(mountPoint)
+		if other, ok := mountPoints[mountPoint]; ok {
+			v.add(pointer, "mount point %s is already used at %s", mountPoint, other)
+			return
+		}
+
+		mountPoints[mountPoint] = pointer
+	}
+
+	for i, bd := range v.layout.BlockDevices {
+		if bd == nil {
+			continue
+		}
+
+		for j, p := range bd.Partitions {
+			if p != nil {
+				addMountPoint(fmt.Sprintf("/block_devices/%d/partitions/%d/mount_point", i, j), p.MountPoint)
+			}
+		}
+	}
+
+	for i, fs := range v.layout.FileSystems {
+		pointer := "/file_systems/" + strconv.Itoa(i)
+
+		if fs == nil {
+			v.add(pointer, "filesystem must not be null")
+			continue
+		}
+
+		if fs.Format == "" {
+			v.add(pointer+"/format", "format is required")
+		}
+
+		addMountPoint(pointer+"/mount_point", fs.MountPoint)
+		v.validateReference(pointer+"/name", fs.Name)
+	}
+}
+
+func (v *layoutValidator) validateNVMeNamespaces() {
+	controllers := make(map[string]string)
+
+	for i, n := range v.layout.NVMeNamespaces {
+		pointer := "/nvme_namespaces/" + strconv.Itoa(i)
+
+		if n == nil {
+			v.add(pointer, "nvme namespace layout must not be null")
+			continue
+		}
+
+		if n.Controller == "" {
+			v.add(pointer+"/controller", "controller is required")
+		} else if other, ok := controllers[n.Controller]; ok {
+			v.add(pointer+"/controller", "controller %s is already divided at %s", n.Controller, other)
+		} else {
+			controllers[n.Controller] = pointer
+		}
+
+		if len(n.Namespaces) == 0 {
+			v.add(pointer+"/namespaces", "at least one namespace is required")
+		}
+
+		for j, ns := range n.Namespaces {
+			np := pointer + "/namespaces/" + strconv.Itoa(j)
+
+			if ns == nil {
+				v.add(np, "namespace must not be null")
+				continue
+			}
+
+			if size, err := ParseSize(ns.Size); ns.Size != "" && err != nil {
+				v.add(np+"/size", "invalid size %q", ns.Size)
+			} else if size == 0 && j < len(n.Namespaces)-1 {
+				v.add(np+"/size", "only the last namespace can take the capacity left over")
+			}
+
+			if bs := ns.BlockSize; bs != 0 && (bs < 512 || bs&(bs-1) != 0) {
+				v.add(np+"/block_size", "block size %d is not a power of two of at least 512", bs)
+			}
+		}
+	}
+}
+
+func (v *layoutValidator) validateEncryptedVolumes() {
+	names := make(map[string]string)
+
+	for i, e := range v.layout.EncryptedVolumes {
+		pointer := "/encrypted_volumes/" + strconv.Itoa(i)
+
+		if e == nil {
+			v.add(pointer, "encrypted volume must not be null")
+			continue
+		}
+
+		if e.Name == "" {
+			v.add(pointer+"/name", "name is required")
+		} else if other, ok := names[e.Name]; ok {
+			v.add(pointer+"/name", "encrypted volume %s is already defined at %s", e.Name, other)
+		} else {
+			names[e.Name] = pointer
+		}
+
+		if t := e.GetType(); t != "luks1" && t != "luks2" {
+			v.add(pointer+"/type", "type %q is neither luks1 nor luks2", e.Type)
+		}
+
+		v.validateReference(pointer+"/device", e.Device)
+	}
+}
+
+func (v *layoutValidator) validateVolumeGroups() {
+	names := make(map[string]string)
+
+	for i, g := range v.layout.VolumeGroups {
+		pointer := "/volume_groups/" + strconv.Itoa(i)
+
+		if g == nil {
+			v.add(pointer, "volume group must not be null")
+			continue
+		}
+
+		if g.Name == "" {
+			v.add(pointer+"/name", "name is required")
+		} else if other, ok := names[g.Name]; ok {
+			v.add(pointer+"/name", "volume group %s is already defined at %s", g.Name, other)
+		} else {
+			names[g.Name] = pointer
+		}
+
+		if len(g.PhysicalVolumes) == 0 {
+			v.add(pointer+"/physical_volumes", "at least one physical volume is required")
+		}
+
+		for j, pv := range g.PhysicalVolumes {
+			v.validateReference(pointer+"/physical_volumes/"+strconv.Itoa(j), pv)
+		}
+
+		lvNames := make(map[string]bool)
+
+		for j, lv := range g.LogicalVolumes {
+			lp := pointer + "/logical_volumes/" + strconv.Itoa(j)
+
+			switch {
+			case lv == nil:
+				v.add(lp, "logical volume must not be null")
+				continue
+			case lv.Name == "":
+				v.add(lp+"/name", "name is required")
+			case lvNames[lv.Name]:
+				v.add(lp+"/name", "logical volume %s is defined twice", lv.Name)
+			}
+
+			lvNames[lv.Name] = true
+
+			switch lv.Size {
+			case "", "0", "100%FREE":
+			default:
+				if _, err := ParseSize(lv.Size); err != nil {
+					v.add(lp+"/size", "invalid size %q", lv.Size)
+				}
+			}
+		}
+	}
+}
+
+// validateReference checks that a name resolves the way
+// StorageLayout.DeviceFile resolves it.
+func (v *layoutValidator) validateReference(pointer, name string) {
+	if name == "" {
+		v.add(pointer, "name is required")
+		return
+	}
+
+	if strings.HasPrefix(name, "/") || v.isSoftwareRaidArray(name) || v.isEncryptedVolume(name) || v.isLogicalVolume(name) {
+		return
+	}
+
+	switch n := len(v.partitionsNamed(name)); {
+	case n == 0:
+		v.add(pointer, "nothing in the layout is named %s", name)
+	case n > 1:
+		v.add(pointer, "%d partitions are named %s", n, name)
+	case v.isRaidMember(name):
+		v.add(pointer, "partition %s is a raid array member", name)
+	}
+}
+
+func (v *layoutValidator) isEncryptedVolume(name string) bool {
+	for _, e := range v.layout.EncryptedVolumes {
+		if e != nil && e.Name == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (v *layoutValidator) isLogicalVolume(name string) bool {
+	vgName, lvName, found := strings.Cut(name, "/")
+	if !found {
+		return false
+	}
+
+	for _, g := range v.layout.VolumeGroups {
+		if g == nil || g.Name != vgName {
+			continue
+		}
+
+		for _, lv := range g.LogicalVolumes {
+			if lv != nil && lv.Name == lvName {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func (v *layoutValidator) partitionsNamed(name string) (partitions []*Partition) {
+	for _, bd := range v.layout.BlockDevices {
+		if bd == nil {
+			continue
+		}
+
+		for _, p := range bd.Partitions {
+			if p != nil && p.Name == name {
+				partitions = append(partitions, p)
+			}
+		}
+	}
+
+	return
+}
+
+func (v *layoutValidator) isRaidMember(partitionName string) bool {
+	for _, a := range v.layout.RaidArrays {
+		if a != nil && a.PartitionName == partitionName {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (v *layoutValidator) isSoftwareRaidArray(name string) bool {
+	for _, a := range v.layout.RaidArrays {
+		if a != nil && a.Name == name && a.GetRaidType() == common.SlugRAIDImplLinuxSoftware {
+			return true
+		}
+	}
+
+	return false
+}
+
+func prefixed(prefix, value string) string {
+	if value == "" {
+		return ""
+	}
+
+	return prefix + ":" + value
+}
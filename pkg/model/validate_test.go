@@ -0,0 +1,174 @@
+package model
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"slices"
+	"testing"
+)
+
+func TestValidateLayoutDocument(t *testing.T) {
+	tests := []struct {
+		name     string
+		document string
+		want     []string
+	}{
+		{
+			name: "valid",
+			document: `{
+				"name": "valid",
+				"block_devices": [
+					{"file": "/dev/sda", "partitions": [
+						{"name": "BIOS", "position": 1, "size": "+4M", "type": "ef02"},
+						{"name": "ROOT", "position": 2, "size": "0", "type": "fd00"}
+					]},
+					{"selector": "wwn:0x5000c500a1b2c3d4", "partitions": [
+						{"name": "ROOT", "position": 2, "size": "0", "type": "A19D880F-05FC-4D3B-A006-743F0F84911E"}
+					]}
+				],
+				"raid_arrays": [{"name": "ROOT", "level": "raid1", "partition_name": "ROOT", "devices": null}],
+				"file_systems": [{"name": "ROOT", "format": "ext4", "mount_point": "/"}]
+			}`,
+		},
+		{
+			name:     "unknown field and wrong type",
+			document: `{"block_devices": [{"file": "/dev/sda", "partitons": [], "size": "1T"}]}`,
+			want:     []string{"/block_devices/0/partitons", "/block_devices/0/size"},
+		},
+		{
+			name: "duplicate positions and bad sizes",
+			document: `{"block_devices": [{"file": "/dev/sda", "partitions": [
+				{"name": "A", "position": 1, "size": "1G"},
+				{"name": "B", "position": 1, "size": "lots", "type": "linux"},
+				{"name": "C", "position": 200, "size": ""}
+			]}]}`,
+			want: []string{
+				"/block_devices/0/partitions/1/position",
+				"/block_devices/0/partitions/1/size",
+				"/block_devices/0/partitions/1/type",
+				"/block_devices/0/partitions/2/position",
+				"/block_devices/0/partitions/2/size",
+			},
+		},
+		{
+			name: "raid arrays",
+			document: `{
+				"block_devices": [{"file": "/dev/sda", "partitions": [{"name": "ROOT", "position": 1, "size": "0", "file_system": "xfs"}]}],
+				"raid_arrays": [
+					{"name": "ROOT", "level": "1", "partition_name": "ROOT"},
+					{"name": "DATA", "level": "5", "partition_name": "DATA"},
+					{"name": "DATA", "level": "7", "devices": [{"file": "/dev/sdb"}]},
+					{"name": "VD0", "level": "0", "raid_type": "hardware", "devices": [{"controller_physical_device_id": 0}]}
+				]
+			}`,
+			want: []string{
+				"/block_devices/0/partitions/0/file_system",
+				"/raid_arrays/0",
+				"/raid_arrays/1/partition_name",
+				"/raid_arrays/1",
+				"/raid_arrays/2/name",
+				"/raid_arrays/2/level",
+			},
+		},
+		{
+			name: "virtual disk options",
+			document: `{
+				"raid_arrays": [
+					{"name": "VD0", "level": "1", "raid_type": "hardware", "devices": [{"controller_physical_device_id": 0}, {"controller_physical_device_id": 1}],
+						"virtual_disk": {"stripe_size": "64K", "write_cache": "write-back", "init": "fast"}},
+					{"name": "VD1", "level": "0", "raid_type": "hardware", "devices": [{"controller_physical_device_id": 2}],
+						"virtual_disk": {"stripe_size": "48K", "read_cache": "adaptive", "init": "slow"}},
+					{"name": "ROOT", "level": "1", "devices": [{"file": "/dev/sda"}, {"file": "/dev/sdb"}], "virtual_disk": {"init": "fast"}}
+				]
+			}`,
+			want: []string{
+				"/raid_arrays/1/virtual_disk/init",
+				"/raid_arrays/1/virtual_disk/read_cache",
+				"/raid_arrays/1/virtual_disk/stripe_size",
+				"/raid_arrays/2/virtual_disk",
+			},
+		},
+		{
+			name: "filesystems",
+			document: `{
+				"block_devices": [
+					{"file": "/dev/sda", "partitions": [{"name": "DATA", "position": 1, "size": "0", "mount_point": "/srv"}]},
+					{"file": "/dev/sdb", "partitions": [{"name": "DATA", "position": 1, "size": "0"}]},
+					{"file": "/dev/sda"}
+				],
+				"file_systems": [
+					{"name": "DATA", "format": "xfs", "mount_point": "/srv/"},
+					{"name": "MISSING", "mount_point": "srv"}
+				]
+			}`,
+			want: []string{
+				"/block_devices/2",
+				"/file_systems/0/mount_point",
+				"/file_systems/0/name",
+				"/file_systems/1/format",
+				"/file_systems/1/mount_point",
+				"/file_systems/1/name",
+			},
+		},
+		{
+			name: "nvme namespaces",
+			document: `{
+				"nvme_namespaces": [
+					{"controller": "/dev/nvme0", "namespaces": [{"size": "800G", "block_size": 4096}, {}]},
+					{"controller": "/dev/nvme0", "namespaces": [{}, {"size": "lots"}, {"block_size": 1000}]},
+					{"controller": ""}
+				]
+			}`,
+			want: []string{
+				"/nvme_namespaces/1/controller",
+				"/nvme_namespaces/1/namespaces/0/size",
+				"/nvme_namespaces/1/namespaces/1/size",
+				"/nvme_namespaces/1/namespaces/2/block_size",
+				"/nvme_namespaces/2/controller",
+				"/nvme_namespaces/2/namespaces",
+			},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			_, problems, err := ValidateLayoutDocument([]byte(tc.document))
+			if err != nil {
+				t.Fatalf("ValidateLayoutDocument returned error: %v", err)
+			}
+
+			var pointers []string
+			for _, p := range problems {
+				pointers = append(pointers, p.Pointer)
+			}
+
+			if !slices.Equal(pointers, tc.want) {
+				t.Errorf("ValidateLayoutDocument problems = %v, want pointers %v", problems, tc.want)
+			}
+		})
+	}
+}
+
+func TestPublishedSchemas(t *testing.T) {
+	schemas := map[string]map[string]any{
+		"../../schema/storage-layout.schema.json":  StorageLayoutSchema(),
+		"../../schema/layout-template.schema.json": LayoutTemplateSchema(),
+	}
+
+	for file, schema := range schemas {
+		published, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		generated, err := json.MarshalIndent(schema, "", "  ")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !bytes.Equal(bytes.TrimSpace(published), generated) {
+			t.Errorf("%s is out of date, regenerate it with vogelkop layout schema", file)
+		}
+	}
+}
@@ -0,0 +1,230 @@
+package model
+
+import (
+	"context"
+	"slices"
+	"strconv"
+	"strings"
+
+	common "github.com/metal-toolbox/bmc-common"
+	"github.com/metal-toolbox/ironlib/utils"
+)
+
+// Read cache policies of hardware RAID virtual disks.
+const (
+	ReadCacheAhead   = "read-ahead"
+	ReadCacheNoAhead = "no-read-ahead"
+)
+
+// Write cache policies of hardware RAID virtual disks. Always write back
+// keeps the cache on even when the controller's battery or capacitor fails.
+const (
+	WriteCacheBack       = "write-back"
+	WriteCacheThrough    = "write-through"
+	WriteCacheAlwaysBack = "always-write-back"
+)
+
+// Settings of the caches of the drives of hardware RAID virtual disks.
+const (
+	DiskCacheOn      = "on"
+	DiskCacheOff     = "off"
+	DiskCacheDefault = "default"
+)
+
+// Initialisations of hardware RAID virtual disks. Fast clears the start
+// and end of the virtual disk, full all of it before the virtual disk can be
+// used and background all of it while it is in use.
+const (
+	InitNone       = "none"
+	InitFast       = "fast"
+	InitFull       = "full"
+	InitBackground = "background"
+)
+
+// States physical disks of hardware RAID controllers can be set to.
+const (
+	PhysicalDiskJBOD             = "jbod"
+	PhysicalDiskUnconfiguredGood = "unconfigured-good"
+)
+
+// Kinds of hot spares. Global hot spares replace failed members of any
+// virtual disk, dedicated ones only of the virtual disks they are assigned to.
+const (
+	HotSpareGlobal    = "global"
+	HotSpareDedicated = "dedicated"
+)
+
+var (
+	readCachePolicies  = []string{ReadCacheAhead, ReadCacheNoAhead}
+	writeCachePolicies = []string{WriteCacheBack, WriteCacheThrough, WriteCacheAlwaysBack}
+	diskCachePolicies  = []string{DiskCacheOn, DiskCacheOff, DiskCacheDefault}
+	initModes          = []string{InitNone, InitFast, InitFull, InitBackground}
+)
+
+// VirtualDiskOptions are the settings of a hardware RAID virtual disk. Empty
+// fields are left to the defaults of the controller. They only apply when
+// the virtual disk is created; an existing virtual disk is not changed to
+// match them.
+type VirtualDiskOptions struct {
+	// StripeSize is the size of the strip on each member, such as 64K.
+	StripeSize string `json:"stripe_size,omitempty"`
+	// ReadCache is read-ahead or no-read-ahead.
+	ReadCache string `json:"read_cache,omitempty"`
+	// WriteCache is write-back, write-through or always-write-back.
+	WriteCache string `json:"write_cache,omitempty"`
+	// DiskCache turns the caches of the member drives on or off, or leaves
+	// them at the default of the drives.
+	DiskCache string `json:"disk_cache,omitempty"`
+	// Init is none, fast, full or background.
+	Init string `json:"init,omitempty"`
+}
+
+// Problems returns what is wrong with the options regardless of the
+// controller, as the JSON field names and what is wrong with them.
+func (o *VirtualDiskOptions) Problems() (problems map[string]string) {
+	problems = make(map[string]string)
+
+	if o.StripeSize != "" {
+		if size, err := ParseSize(o.StripeSize); err != nil || size == 0 || size&(size-1) != 0 {
+			problems["stripe_size"] = "stripe size " + strconv.Quote(o.StripeSize) + " is not a power of two"
+		}
+	}
+
+	oneOf := func(field, value string, valid []string) {
+		if value != "" && !slices.Contains(valid, value) {
+			problems[field] = strconv.Quote(value) + " is not one of " + strings.Join(valid, ", ")
+		}
+	}
+
+	oneOf("read_cache", o.ReadCache, readCachePolicies)
+	oneOf("write_cache", o.WriteCache, writeCachePolicies)
+	oneOf("disk_cache", o.DiskCache, diskCachePolicies)
+	oneOf("init", o.Init, initModes)
+
+	return
+}
+
+// ControllerCapabilities are the virtual disk options the driver of a
+// hardware RAID controller can set and what it can do with physical disks.
+// Options without supported values cannot be set at all.
+type ControllerCapabilities struct {
+	// StripeSizes are in bytes.
+	StripeSizes []uint64 `json:"stripe_sizes"`
+	ReadCache   []string `json:"read_cache"`
+	WriteCache  []string `json:"write_cache"`
+	DiskCache   []string `json:"disk_cache"`
+	Init        []string `json:"init"`
+	// DefaultStripeSize is used when the options name none.
+	DefaultStripeSize uint64 `json:"default_stripe_size"`
+	// PhysicalDiskStates are the states physical disks can be set to.
+	PhysicalDiskStates []string `json:"physical_disk_states"`
+	HotSpares          []string `json:"hot_spares"`
+	// ForeignConfig is whether foreign configurations can be cleared and
+	// imported.
+	ForeignConfig bool `json:"foreign_config"`
+}
+
+// controllerCapabilities holds the capabilities of the controller drivers by
+// vendor. mvcli creates virtual disks with a stripe size only; ironlib does
+// not pass it an initialisation, it has no cache policies and does not
+// manage physical disks.
+var controllerCapabilities = map[string]ControllerCapabilities{
+	common.VendorMarvell: {
+		StripeSizes:       []uint64{16 * KiB, 32 * KiB, 64 * KiB, 128 * KiB},
+		DefaultStripeSize: 64 * KiB,
+	},
+}
+
+// CapabilitiesOf returns the virtual disk options the driver of the
+// controller supports and whether vogelkop can manage the controller at all.
+func CapabilitiesOf(sc *common.StorageController) (capabilities ControllerCapabilities, ok bool) {
+	capabilities, ok = controllerCapabilities[sc.Vendor]
+	return
+}
+
+// Check returns an UnsupportedOptionError for the first option the
+// controller cannot honour.
+func (c ControllerCapabilities) Check(controller string, o *VirtualDiskOptions) error {
+	if o == nil {
+		return nil
+	}
+
+	if o.StripeSize != "" {
+		size, err := ParseSize(o.StripeSize)
+		if err != nil {
+			return err
+		}
+
+		if !slices.Contains(c.StripeSizes, size) {
+			supported := make([]string, 0, len(c.StripeSizes))
+			for _, s := range c.StripeSizes {
+				supported = append(supported, FormatSize(s))
+			}
+
+			return UnsupportedOptionError(controller, "stripe size "+o.StripeSize, supported)
+		}
+	}
+
+	for _, option := range []struct {
+		name, value string
+		supported   []string
+	}{
+		{"read cache", o.ReadCache, c.ReadCache},
+		{"write cache", o.WriteCache, c.WriteCache},
+		{"disk cache", o.DiskCache, c.DiskCache},
+		{"init", o.Init, c.Init},
+	} {
+		if option.value != "" && !slices.Contains(option.supported, option.value) {
+			return UnsupportedOptionError(controller, option.name+" "+option.value, option.supported)
+		}
+	}
+
+	return nil
+}
+
+// hardwareControllers returns the RAID controllers vogelkop can manage.
+func hardwareControllers(ctx context.Context) (controllers []*common.StorageController, err error) {
+	hardware, err := getIronlibInventory(ctx)
+	if err != nil {
+		return
+	}
+
+	for _, sc := range hardware.StorageControllers {
+		if _, ok := CapabilitiesOf(sc); ok {
+			controllers = append(controllers, sc)
+		}
+	}
+
+	return
+}
+
+// createVirtualDisk creates the RaidArray as a virtual disk on the
+// controller, which has to support its options.
+func (a *RaidArray) createVirtualDisk(ctx context.Context, sc *common.StorageController) (err error) {
+	capabilities, _ := CapabilitiesOf(sc)
+
+	o := a.VirtualDisk
+	if o == nil {
+		o = &VirtualDiskOptions{}
+	}
+
+	stripeSize := capabilities.DefaultStripeSize
+	if o.StripeSize != "" {
+		if stripeSize, err = ParseSize(o.StripeSize); err != nil {
+			return
+		}
+	}
+
+	physicalDiskIDs := make([]uint, 0, len(a.Devices))
+	for _, bd := range a.Devices {
+		physicalDiskIDs = append(physicalDiskIDs, uint(bd.ControllerPhysicalDeviceID))
+	}
+
+	switch sc.Vendor {
+	case common.VendorMarvell:
+		// ironlib validates the initialisation but does not pass it on.
+		return utils.NewMvcliCmd(false).Create(ctx, physicalDiskIDs, a.Level, a.Name, uint(stripeSize/KiB), false, "quick")
+	default:
+		return UnsupportedControllerError(sc.Vendor + " " + sc.Model)
+	}
+}
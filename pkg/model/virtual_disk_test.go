@@ -0,0 +1,195 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	common "github.com/metal-toolbox/bmc-common"
+	"github.com/metal-toolbox/ironlib/utils"
+)
+
+func TestControllerCapabilitiesCheck(t *testing.T) {
+	marvell, ok := CapabilitiesOf(&common.StorageController{Common: common.Common{Vendor: common.VendorMarvell}})
+	if !ok {
+		t.Fatal("CapabilitiesOf returned no capabilities for Marvell controllers")
+	}
+
+	if _, ok := CapabilitiesOf(&common.StorageController{Common: common.Common{Vendor: "acme"}}); ok {
+		t.Error("CapabilitiesOf returned capabilities for an unknown vendor")
+	}
+
+	tests := []struct {
+		name    string
+		options *VirtualDiskOptions
+		wantErr error
+	}{
+		{name: "no options"},
+		{name: "empty options", options: &VirtualDiskOptions{}},
+		{name: "supported", options: &VirtualDiskOptions{StripeSize: "128K"}},
+		{name: "stripe size", options: &VirtualDiskOptions{StripeSize: "256K"}, wantErr: ErrUnsupportedOption},
+		{name: "invalid stripe size", options: &VirtualDiskOptions{StripeSize: "big"}, wantErr: ErrInvalidSize},
+		{name: "write cache", options: &VirtualDiskOptions{WriteCache: WriteCacheBack}, wantErr: ErrUnsupportedOption},
+		{name: "disk cache", options: &VirtualDiskOptions{DiskCache: DiskCacheOff}, wantErr: ErrUnsupportedOption},
+		{name: "init", options: &VirtualDiskOptions{Init: InitBackground}, wantErr: ErrUnsupportedOption},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			err := marvell.Check("Marvell 88SE9230", tc.options)
+			if !errors.Is(err, tc.wantErr) || (err != nil) != (tc.wantErr != nil) {
+				t.Errorf("Check = %v, want %v", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestCreateVirtualDiskMarvell(t *testing.T) {
+	dir := t.TempDir()
+	log := filepath.Join(dir, "args")
+
+	mvcli := filepath.Join(dir, "mvcli")
+	script := "#!/bin/sh\necho \"$*\" >> " + log + "\necho 'SG driver version 4.0.0.1542'\n"
+
+	if err := os.WriteFile(mvcli, []byte(script), 0o700); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv(utils.EnvMvcliUtility, mvcli)
+
+	sc := &common.StorageController{Common: common.Common{Vendor: common.VendorMarvell}}
+	a := &RaidArray{
+		Name:        "BOOT",
+		Level:       "1",
+		Devices:     []*BlockDevice{{ControllerPhysicalDeviceID: 0}, {ControllerPhysicalDeviceID: 1}},
+		VirtualDisk: &VirtualDiskOptions{StripeSize: "128K"},
+	}
+
+	if err := a.createVirtualDisk(context.Background(), sc); err != nil {
+		t.Fatalf("createVirtualDisk returned error: %v", err)
+	}
+
+	want := []string{"create -o vd -r 1 -d 0,1 -n BOOT -b 128"}
+	if got := loggedArgs(t, log); !slices.Equal(got, want) {
+		t.Errorf("mvcli called with %q, want %q", got, want)
+	}
+}
+
+const mvcliVirtualDisks = `SG driver version 3.5.36.
+
+Virtual Disk Information
+-------------------------
+id:                  0
+name:                BOOT
+status:              functional
+Stripe size:         64
+RAID mode:           RAID1
+Cache mode:          Not Support
+size:                228872 M
+BGA status:          not running
+Block ids:           0 4 
+# of PDs:            2
+PD RAID setup:       0 1 
+Running OS:          no
+
+Total # of VD:       1
+`
+
+func TestParseMvcliVirtualDisks(t *testing.T) {
+	virtualDisks := parseMvcliVirtualDisks(mvcliVirtualDisks)
+	if len(virtualDisks) != 1 || virtualDisks[0].ID != "0" || !slices.Equal(virtualDisks[0].Members, []int{0, 1}) ||
+		virtualDisks[0].StripeSize != 64*KiB {
+		t.Errorf("parseMvcliVirtualDisks = %+v", virtualDisks)
+	}
+}
+
+// stubVirtualDisks makes the hardware virtual disk listing return the
+// virtual disks.
+func stubVirtualDisks(t *testing.T, virtualDisks ...*common.VirtualDisk) {
+	t.Helper()
+
+	old := listVirtualDisks
+	listVirtualDisks = func(context.Context) ([]*common.VirtualDisk, error) {
+		return virtualDisks, nil
+	}
+
+	t.Cleanup(func() {
+		listVirtualDisks = old
+	})
+}
+
+// stubVirtualDiskOptions makes the controller report the settings for every
+// virtual disk.
+func stubVirtualDiskOptions(t *testing.T, reported VirtualDiskOptions) {
+	t.Helper()
+
+	old := virtualDiskOptions
+	virtualDiskOptions = func(context.Context, *common.VirtualDisk) (VirtualDiskOptions, error) {
+		return reported, nil
+	}
+
+	t.Cleanup(func() {
+		virtualDiskOptions = old
+	})
+}
+
+func TestHardwareRaidArrayCheck(t *testing.T) {
+	stubVirtualDisks(t, &common.VirtualDisk{
+		ID:             "0",
+		Name:           "BOOT",
+		RaidType:       "RAID1",
+		PhysicalDrives: []*common.Drive{{StorageControllerDriveID: 1}, {StorageControllerDriveID: 0}},
+	})
+
+	ctx := context.Background()
+	array := func(ids ...int) *RaidArray {
+		a := &RaidArray{Name: "BOOT", Level: "1"}
+		for _, id := range ids {
+			a.Devices = append(a.Devices, &BlockDevice{ControllerPhysicalDeviceID: id})
+		}
+
+		return a
+	}
+
+	exists, differences, err := array(0, 1).Check(ctx, common.SlugRAIDImplHardware)
+	if err != nil || !exists || len(differences) != 0 {
+		t.Errorf("Check of the same members = %t, %v, %v, want true, no differences", exists, differences, err)
+	}
+
+	_, differences, err = array(0, 2).Check(ctx, common.SlugRAIDImplHardware)
+	if want := []Difference{{Field: "members", Want: "0,2", Have: "0,1"}}; err != nil || !slices.Equal(differences, want) {
+		t.Errorf("Check of other members = %v, %v, want %v", differences, err, want)
+	}
+
+	if _, err := array(0, 2).Ensure(ctx, common.SlugRAIDImplHardware, false); !errors.Is(err, ErrStateConflict) {
+		t.Errorf("Ensure of other members = %v, want %v", err, ErrStateConflict)
+	}
+}
+
+func TestHardwareRaidArrayCheckOptions(t *testing.T) {
+	stubVirtualDisks(t, &common.VirtualDisk{ID: "0", Name: "BOOT", RaidType: "RAID1", PhysicalDrives: []*common.Drive{{StorageControllerDriveID: 0}}})
+	stubVirtualDiskOptions(t, VirtualDiskOptions{StripeSize: "64K"})
+
+	ctx := context.Background()
+	a := &RaidArray{Name: "BOOT", Level: "1", Devices: []*BlockDevice{{ControllerPhysicalDeviceID: 0}}}
+
+	// Settings the controller does not report are not compared.
+	a.VirtualDisk = &VirtualDiskOptions{StripeSize: "65536", WriteCache: WriteCacheThrough}
+	if _, differences, err := a.Check(ctx, common.SlugRAIDImplHardware); err != nil || len(differences) != 0 {
+		t.Errorf("Check of the same stripe size = %v, %v, want no differences", differences, err)
+	}
+
+	a.VirtualDisk = &VirtualDiskOptions{StripeSize: "256K", WriteCache: WriteCacheThrough}
+
+	_, differences, err := a.Check(ctx, common.SlugRAIDImplHardware)
+	if want := []Difference{{Field: "stripe size", Want: "256K", Have: "64K"}}; err != nil || !slices.Equal(differences, want) {
+		t.Errorf("Check of another stripe size = %v, %v, want %v", differences, err, want)
+	}
+
+	if _, err := a.Ensure(ctx, common.SlugRAIDImplHardware, false); !errors.Is(err, ErrStateConflict) {
+		t.Errorf("Ensure of another stripe size = %v, want %v", err, ErrStateConflict)
+	}
+}
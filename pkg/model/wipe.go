@@ -0,0 +1,227 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	common "github.com/metal-toolbox/bmc-common"
+	"github.com/metal-toolbox/ironlib/actions"
+	"github.com/metal-toolbox/ironlib/utils"
+	"github.com/metal-toolbox/vogelkop/internal/command"
+	"github.com/metal-toolbox/vogelkop/internal/logging"
+)
+
+// WipeResult describes how a drive was wiped.
+type WipeResult struct {
+	Disk        string `json:"disk"`
+	Action      string `json:"action"`
+	Method      string `json:"method"`
+	ElapsedTime int    `json:"elapsed_time"`
+	Result      string `json:"result"`
+	Error       string `json:"error,omitempty"`
+
+	err error
+}
+
+// FailedWipeResult returns the result of a drive that could not be wiped.
+func FailedWipeResult(disk string, err error) *WipeResult {
+	return &WipeResult{Disk: disk, Result: "failure", Error: err.Error(), err: err}
+}
+
+// Err returns why the drive could not be wiped, or nil.
+func (r *WipeResult) Err() error {
+	return r.err
+}
+
+// WipeError returns the errors of the failed results, as a partial success
+// if some drives were wiped. It returns nil if every drive was wiped.
+func WipeError(results []*WipeResult) error {
+	var errs []error
+
+	for _, r := range results {
+		if r.err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", r.Disk, r.err))
+		}
+	}
+
+	err := errors.Join(errs...)
+	if succeeded := len(results) - len(errs); err != nil && succeeded > 0 {
+		return PartialSuccessError(succeeded, len(errs), err)
+	}
+
+	return err
+}
+
+// WipeDrives wipes the given drives in parallel, using the inventory the
+// collector reports for them. Each drive logs to the logger of the context
+// with its device as a field. Drives whose wipe was interrupted by the
+// context are marked indeterminate in the Recorder of the context.
+// It returns a WipeResult per drive and an error if the inventory could not
+// be collected, in which case there are no results, or if some drives could
+// not be wiped, see WipeError.
+func WipeDrives(ctx context.Context, drives []string, collector actions.DeviceManager, verbose bool) (results []*WipeResult, err error) {
+	inventory, err := collector.GetInventory(ctx, actions.WithDynamicCollection())
+	if err != nil {
+		return
+	}
+
+	var (
+		mu sync.Mutex
+		wg sync.WaitGroup
+	)
+
+	wg.Add(len(drives))
+
+	for _, drive := range drives {
+		go func() {
+			defer wg.Done()
+
+			l := contextLogger(ctx).With("device", drive)
+			ctx := command.NewContextWithLogger(ctx, l)
+			wi := &WipeResult{
+				Disk:   drive,
+				Result: "success",
+			}
+
+			startTime := time.Now()
+			if wipeErr := WipeDrive(ctx, inventory, wi, verbose); wipeErr != nil {
+				wi.Result = "failure"
+				wi.Error = wipeErr.Error()
+				wi.err = wipeErr
+				l.Errorw("failed to wipe drive", "err", wipeErr)
+
+				if ctx.Err() != nil {
+					command.RecorderValueFromContext(ctx).MarkIndeterminate(drive)
+				}
+			} else {
+				l.Infow("wiped drive", "method", wi.Method, "action", wi.Action)
+			}
+
+			wi.ElapsedTime = int(time.Since(startTime).Round(time.Second).Seconds())
+
+			mu.Lock()
+			results = append(results, wi)
+			mu.Unlock()
+		}()
+	}
+
+	wg.Wait()
+
+	return results, WipeError(results)
+}
+
+// WipeDrive wipes the drive named by wi.Disk with the best method its
+// capabilities in the inventory allow, and records the method and action in wi.
+//
+// nolint:gocyclo // easier to read in one big function I think
+func WipeDrive(ctx context.Context, inventory *common.Device, wi *WipeResult, verbose bool) error {
+	var drive *common.Drive
+	for _, d := range inventory.Drives {
+		if d.LogicalName == wi.Disk {
+			drive = d
+			break
+		}
+	}
+
+	if drive == nil {
+		return ErrDriveNotExist
+	}
+
+	// Pick the most appropriate wipe based on the disk type and/or features supported
+	var wiper actions.DriveWiper
+	switch drive.Protocol {
+	case "nvme":
+		var ber bool
+		var cer bool
+		var cese bool
+		for _, cap := range drive.Capabilities {
+			switch cap.Name {
+			case "ber":
+				ber = cap.Enabled
+			case "cer":
+				cer = cap.Enabled
+			case "cese":
+				cese = cap.Enabled
+			}
+		}
+		switch {
+		case cer:
+			wi.Method = "sanitize"
+			wi.Action = "CryptoErase"
+		case ber:
+			wi.Method = "sanitize"
+			wi.Action = "BlockErase"
+		case cese:
+			wi.Method = "format"
+			wi.Action = "CryptographicErase"
+		default:
+			wi.Method = "format"
+			wi.Action = "UserDataErase"
+		}
+		wiper = utils.NewNvmeCmd(verbose)
+	case "sata", "sas":
+		// Lets figure out the drive capabilities in an easier format
+		var sanitize bool
+		var esee bool
+		var trim bool
+		var eseu bool
+		var bee bool
+		var cse bool
+		for _, cap := range drive.Capabilities {
+			switch {
+			case cap.Description == "encryption supports enhanced erase":
+				esee = cap.Enabled
+			case cap.Description == "SANITIZE feature":
+				sanitize = cap.Enabled
+			case strings.HasPrefix(cap.Description, "Data Set Management TRIM supported"):
+				trim = cap.Enabled
+			case cap.Description == "BLOCK ERASE EXT":
+				bee = cap.Enabled
+			case cap.Description == "CRYPTO SCRAMBLE EXT":
+				cse = cap.Enabled
+			case strings.HasPrefix(cap.Description, "erase time:"):
+				eseu = strings.Contains(cap.Description, "enhanced")
+			}
+		}
+
+		switch {
+		case sanitize || esee:
+			// It is better if ironlib util can export an API to provide cap info, or
+			// WipeDrive can return methods/actions it uses:
+			// https://github.com/metal-toolbox/ironlib/blob/main/utils/hdparm.go#L217-L237
+			switch {
+			case sanitize && cse:
+				wi.Method = "sanitize"
+				wi.Action = "sanitize-crypto-scramble"
+			case sanitize && bee:
+				wi.Method = "sanitize"
+				wi.Action = "sanitize-block-erase"
+			case esee && eseu:
+				wi.Method = "security-erase-enhanced"
+			}
+			// Drive supports Sanitize or Enhanced Erase, so we use hdparm
+			wiper = utils.NewHdparmCmd(verbose)
+		case trim:
+			// Drive supports TRIM, so we use blkdiscard
+			wi.Method = "blkdiscard"
+			wiper = utils.NewBlkdiscardCmd(verbose)
+		default:
+			// Drive does not support any preferred wipe method so we fall back to filling it up with zeros
+			wi.Method = "fillzero"
+			wiper = utils.NewFillZeroCmd(verbose)
+		}
+	}
+
+	if wiper == nil {
+		return fmt.Errorf("capabilities: %v, protocol: %v: %w", drive.Capabilities, drive.Protocol, ErrDriveWiperNotFound)
+	}
+
+	if err := wiper.WipeDrive(ctx, logging.Logrus(contextLogger(ctx)), drive); err != nil {
+		return fmt.Errorf("wiper.WipeDrive() failed to wipe drive: %w", err)
+	}
+	return nil
+}